@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// contentCache keeps the content of the files read by extract, so that
+// extract --watch only reads the files that changed since the previous run.
+// A cached content is only used while the size and modification time of its
+// file are unchanged. It is safe for concurrent use, and a nil cache caches
+// nothing.
+type contentCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedContent // Keyed by absolute path
+}
+
+// cachedContent is the content of a file along with the state it was read in.
+type cachedContent struct {
+	size    int64
+	modTime time.Time
+	content []byte
+	used    bool // Looked up or stored since the last sweep
+}
+
+func newContentCache() *contentCache {
+	return &contentCache{entries: map[string]*cachedContent{}}
+}
+
+// get returns the cached content of the file at absPath, if it was read
+// when it had the given size and modification time.
+func (c *contentCache) get(absPath string, size int64, modTime time.Time) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[absPath]
+	if !ok || entry.size != size || !entry.modTime.Equal(modTime) {
+		return nil, false
+	}
+	entry.used = true
+	return entry.content, true
+}
+
+// put caches content, read from the file at absPath when it had the given
+// size and modification time, replacing any previous content.
+func (c *contentCache) put(absPath string, size int64, modTime time.Time, content []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[absPath] = &cachedContent{size: size, modTime: modTime, content: content, used: true}
+}
+
+// sweep drops the content of the files that were neither looked up nor
+// stored since the previous sweep, such as deleted files, so that the cache
+// only holds the files of the last run.
+func (c *contentCache) sweep() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for absPath, entry := range c.entries {
+		if !entry.used {
+			delete(c.entries, absPath)
+		}
+		entry.used = false
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContentCache(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		path    string
+		size    int64
+		modTime time.Time
+		want    string
+		wantOK  bool
+	}{
+		{name: "unchanged", path: "/a", size: 5, modTime: modTime, want: "hello", wantOK: true},
+		{name: "size changed", path: "/a", size: 6, modTime: modTime},
+		{name: "mtime changed", path: "/a", size: 5, modTime: modTime.Add(time.Nanosecond)},
+		{name: "other file", path: "/b", size: 5, modTime: modTime},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newContentCache()
+			c.put("/a", 5, modTime, []byte("hello"))
+			got, ok := c.get(tt.path, tt.size, tt.modTime)
+			if ok != tt.wantOK || string(got) != tt.want {
+				t.Errorf("get(%q, %d, %v) = %q, %v, want %q, %v", tt.path, tt.size, tt.modTime, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNilContentCache(t *testing.T) {
+	var c *contentCache
+	c.put("/a", 1, time.Time{}, []byte("a"))
+	if _, ok := c.get("/a", 1, time.Time{}); ok {
+		t.Error("a nil cache returned content")
+	}
+	c.sweep()
+}
+
+func TestContentCacheSweep(t *testing.T) {
+	c := newContentCache()
+	c.put("/kept", 1, time.Time{}, []byte("k"))
+	c.put("/dropped", 1, time.Time{}, []byte("d"))
+	c.sweep() // Both were just stored
+
+	if _, ok := c.get("/kept", 1, time.Time{}); !ok {
+		t.Fatal("get() missed a file stored before the first sweep")
+	}
+	c.sweep()
+	if _, ok := c.get("/kept", 1, time.Time{}); !ok {
+		t.Error("sweep() dropped a file looked up since the previous sweep")
+	}
+	if _, ok := c.get("/dropped", 1, time.Time{}); ok {
+		t.Error("sweep() kept a file unused since the previous sweep")
+	}
+}
+
+// TestExtractCacheInvalidation rewrites a file behind the back of the cache:
+// while its size and modification time are unchanged, the cached content is
+// still extracted, and once either changes, the file is read again.
+func TestExtractCacheInvalidation(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "one\n", "b.txt": "bee\n"})
+	aPath := filepath.Join(dir, "a.txt")
+	info, err := os.Stat(aPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modTime := info.ModTime()
+
+	opts := extractOptions{extensions: []string{".txt"}, maxDepth: -1, cache: newContentCache(), logger: quietLogger()}
+	extract := func() string {
+		t.Helper()
+		out, err := extractFileContent(context.Background(), dir, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	write := func(content string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(aPath, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(aPath, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if out := extract(); !strings.Contains(out, "one\n") {
+		t.Fatalf("first extraction = %q, want the content of a.txt", out)
+	}
+
+	steps := []struct {
+		name    string
+		content string
+		modTime time.Time
+		want    string
+	}{
+		{name: "same size and mtime", content: "two\n", modTime: modTime, want: "one\n"},
+		{name: "mtime changed", content: "two\n", modTime: modTime.Add(time.Second), want: "two\n"},
+		{name: "size changed", content: "three\n", modTime: modTime.Add(time.Second), want: "three\n"},
+	}
+	for _, step := range steps {
+		write(step.content, step.modTime)
+		out := extract()
+		if !strings.Contains(out, step.want) {
+			t.Errorf("%s: extraction = %q, want a.txt to hold %q", step.name, out, step.want)
+		}
+		if !strings.Contains(out, "bee\n") {
+			t.Errorf("%s: extraction = %q, want b.txt served from the cache", step.name, out)
+		}
+	}
+}
+
+// BenchmarkExtractCache compares repeated extractions of an unchanged tree
+// with and without a cache, reporting how many files each one reads.
+func BenchmarkExtractCache(b *testing.B) {
+	dir := b.TempDir()
+	writeFixtureTree(b, dir, 500)
+	for _, cached := range []bool{false, true} {
+		b.Run(fmt.Sprintf("cache=%v", cached), func(b *testing.B) {
+			opts := extractOptions{extensions: []string{".go"}, jobs: 1, maxDepth: -1, logger: quietLogger()}
+			if cached {
+				opts.cache = newContentCache()
+			}
+			reads := 0
+			for b.Loop() {
+				if !cached {
+					if _, err := extractFileContent(context.Background(), dir, opts); err != nil {
+						b.Fatal(err)
+					}
+					reads += 500
+					continue
+				}
+				before := maps.Clone(opts.cache.entries)
+				if _, err := extractFileContent(context.Background(), dir, opts); err != nil {
+					b.Fatal(err)
+				}
+				for absPath, entry := range opts.cache.entries {
+					if before[absPath] != entry {
+						reads++ // put() replaced the entry after reading the file
+					}
+				}
+			}
+			b.ReportMetric(float64(reads)/float64(b.N), "reads/op")
+		})
+	}
+}
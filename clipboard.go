@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// clipboardCommands returns the commands that can copy their standard input
+// to the system clipboard on this platform, in order of preference.
+func clipboardCommands() [][]string {
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{{"pbcopy"}}
+	case "windows":
+		return [][]string{{"clip"}}
+	}
+	commands := [][]string{
+		{"xclip", "-selection", "clipboard"},
+		{"xsel", "--clipboard", "--input"},
+	}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		commands = append([][]string{{"wl-copy"}}, commands...)
+	}
+	return commands
+}
+
+// copyToClipboard copies content to the system clipboard with the first of
+// clipboardCommands found in the PATH.
+func copyToClipboard(content []byte) error {
+	var names []string
+	for _, command := range clipboardCommands() {
+		names = append(names, command[0])
+		if _, err := exec.LookPath(command[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Stdin = bytes.NewReader(content)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return fmt.Errorf("running %s: %w: %s", command[0], err, msg)
+			}
+			return fmt.Errorf("running %s: %w", command[0], err)
+		}
+		return nil
+	}
+	return errors.New("no clipboard available, install one of: " + strings.Join(names, ", "))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// stubClipboard replaces the clipboard commands of the platform with a
+// script running body, by making its directory the only one in the PATH.
+// The script gets the file to write the clipboard content to in
+// $CLIPBOARD_OUT, whose path is returned.
+func stubClipboard(t *testing.T, body string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("clipboard stubs are shell scripts")
+	}
+	binDir := t.TempDir()
+	script := "#!/bin/sh\nPATH=/usr/bin:/bin\n" + body + "\n"
+	if body != "" {
+		for _, command := range clipboardCommands() {
+			if err := os.WriteFile(filepath.Join(binDir, command[0]), []byte(script), 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	outPath := filepath.Join(t.TempDir(), "clipboard")
+	t.Setenv("PATH", binDir)
+	t.Setenv("CLIPBOARD_OUT", outPath)
+	return outPath
+}
+
+func TestCopyToClipboard(t *testing.T) {
+	tests := []struct {
+		name    string
+		stub    string // Script body, or empty for no clipboard command at all
+		want    string
+		wantErr string
+	}{
+		{name: "copies the content", stub: `cat > "$CLIPBOARD_OUT"`, want: "some\ncontent\n"},
+		{name: "no clipboard", wantErr: "no clipboard available, install one of: "},
+		{name: "failing command", stub: "echo 'cannot open display' >&2\nexit 1", wantErr: "cannot open display"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outPath := stubClipboard(t, tt.stub)
+			err := copyToClipboard([]byte("some\ncontent\n"))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("copyToClipboard() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readFile(t, outPath); got != tt.want {
+				t.Errorf("clipboard = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractClipboard(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"main.go": "package main\n"})
+	want, stderr, code := runCopilot(t, dir, "", "extract", ".", ".go")
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+
+	outPath := stubClipboard(t, `cat > "$CLIPBOARD_OUT"`)
+	stdout, stderr, code := runCopilot(t, dir, "", "extract", "--clipboard", "-o", "out.txt", ".", ".go")
+	if code != 0 {
+		t.Fatalf("--clipboard: exit code = %d, stderr: %s", code, stderr)
+	}
+	if stdout != "" {
+		t.Errorf("--clipboard: stdout = %q, want the output copied instead", stdout)
+	}
+	if got := readFile(t, outPath); got != want {
+		t.Errorf("clipboard = %q, want %q", got, want)
+	}
+	if got := readFile(t, filepath.Join(dir, "out.txt")); got != want {
+		t.Errorf("--output = %q, want %q", got, want)
+	}
+
+	stubClipboard(t, "")
+	if _, stderr, code := runCopilot(t, dir, "", "extract", "--clipboard", ".", ".go"); code == 0 || !strings.Contains(stderr, "no clipboard available") {
+		t.Errorf("without clipboard: exit code %d, stderr %q, want an error", code, stderr)
+	}
+}
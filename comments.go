@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+)
+
+// quoteSyntax describes a string literal delimiter of a language.
+type quoteSyntax struct {
+	delim     string
+	escapes   bool // Backslash escapes the next character
+	multiline bool // The literal may span several lines
+}
+
+// commentSyntax describes the comments and string literals of a language,
+// as needed by stripComments.
+type commentSyntax struct {
+	lineComment   string
+	blockStart    string
+	blockEnd      string
+	quotes        []quoteSyntax // Longer delimiters first, e.g. """ before "
+	wordStartOnly bool          // Line comments only start at the beginning of a word, as in shell
+	keepShebang   bool          // Keep a leading #! line
+}
+
+var (
+	cStyleQuotes = []quoteSyntax{
+		{delim: `"`, escapes: true},
+		{delim: `'`, escapes: true},
+	}
+	goSyntax = &commentSyntax{
+		lineComment: "//",
+		blockStart:  "/*",
+		blockEnd:    "*/",
+		quotes:      append([]quoteSyntax{{delim: "`", multiline: true}}, cStyleQuotes...),
+	}
+	jsSyntax = &commentSyntax{
+		lineComment: "//",
+		blockStart:  "/*",
+		blockEnd:    "*/",
+		quotes:      append([]quoteSyntax{{delim: "`", escapes: true, multiline: true}}, cStyleQuotes...),
+		keepShebang: true,
+	}
+	cSyntax = &commentSyntax{
+		lineComment: "//",
+		blockStart:  "/*",
+		blockEnd:    "*/",
+		quotes:      cStyleQuotes,
+	}
+	pythonSyntax = &commentSyntax{
+		lineComment: "#",
+		quotes: []quoteSyntax{
+			{delim: `"""`, escapes: true, multiline: true},
+			{delim: `'''`, escapes: true, multiline: true},
+			{delim: `"`, escapes: true},
+			{delim: `'`, escapes: true},
+		},
+		keepShebang: true,
+	}
+	shellSyntax = &commentSyntax{
+		lineComment: "#",
+		quotes: []quoteSyntax{
+			{delim: `"`, escapes: true, multiline: true},
+			{delim: `'`, multiline: true},
+		},
+		wordStartOnly: true,
+		keepShebang:   true,
+	}
+)
+
+// commentSyntaxes maps the file extensions supported by --strip-comments to
+// their syntax.
+var commentSyntaxes = map[string]*commentSyntax{
+	".go":   goSyntax,
+	".js":   jsSyntax,
+	".jsx":  jsSyntax,
+	".mjs":  jsSyntax,
+	".cjs":  jsSyntax,
+	".ts":   jsSyntax,
+	".tsx":  jsSyntax,
+	".c":    cSyntax,
+	".h":    cSyntax,
+	".cc":   cSyntax,
+	".cpp":  cSyntax,
+	".cxx":  cSyntax,
+	".hh":   cSyntax,
+	".hpp":  cSyntax,
+	".py":   pythonSyntax,
+	".sh":   shellSyntax,
+	".bash": shellSyntax,
+	".zsh":  shellSyntax,
+}
+
+// stripFileComments removes the comments of content according to the
+// extension of filePath. Content of unsupported files is returned unchanged.
+func stripFileComments(filePath, content string) string {
+	syntax, ok := commentSyntaxes[filepath.Ext(filePath)]
+	if !ok {
+		return content
+	}
+	return stripComments(content, syntax)
+}
+
+// stripComments removes the line and block comments of content, leaving
+// string literals untouched. Trailing whitespace left by a removed comment is
+// trimmed, and lines holding only comments are dropped altogether.
+func stripComments(content string, syntax *commentSyntax) string {
+	var out []byte
+	lineStart := 0      // Offset of the current line in out
+	hadComment := false // Whether a comment was removed from the current line
+
+	// endLine cleans up the current line once a comment was removed from it,
+	// and reports whether the line must be dropped.
+	endLine := func() bool {
+		if !hadComment {
+			return false
+		}
+		hadComment = false
+		line := bytes.TrimRight(out[lineStart:], "\r")
+		if len(bytes.TrimSpace(line)) == 0 {
+			out = out[:lineStart]
+			return true
+		}
+		cr := len(out[lineStart:]) > len(line)
+		out = append(out[:lineStart], bytes.TrimRight(line, " \t")...)
+		if cr {
+			out = append(out, '\r')
+		}
+		return false
+	}
+
+	i := 0
+	if syntax.keepShebang && strings.HasPrefix(content, "#!") {
+		i = strings.IndexByte(content, '\n') + 1
+		if i == 0 {
+			i = len(content)
+		}
+		out = append(out, content[:i]...)
+		lineStart = len(out)
+	}
+
+	for i < len(content) {
+		rest := content[i:]
+		switch {
+		case content[i] == '\n':
+			if !endLine() {
+				out = append(out, '\n')
+			}
+			lineStart = len(out)
+			i++
+
+		case syntax.lineComment != "" && strings.HasPrefix(rest, syntax.lineComment) &&
+			(!syntax.wordStartOnly || i == 0 || strings.IndexByte(" \t\n;|&(", content[i-1]) >= 0):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			} else if end > 0 && rest[end-1] == '\r' {
+				end--
+			}
+			i += end
+			hadComment = true
+
+		case syntax.blockStart != "" && strings.HasPrefix(rest, syntax.blockStart):
+			end := strings.Index(rest[len(syntax.blockStart):], syntax.blockEnd)
+			if end < 0 {
+				i = len(content)
+			} else {
+				i += len(syntax.blockStart) + end + len(syntax.blockEnd)
+			}
+			hadComment = true
+			// Keep tokens around the comment apart, as in a/**/b
+			if len(out) > lineStart && !isSpaceByte(out[len(out)-1]) && i < len(content) && !isSpaceByte(content[i]) {
+				out = append(out, ' ')
+			}
+
+		default:
+			n := quotedLen(rest, syntax.quotes)
+			if n == 0 {
+				n = 1
+			}
+			out = append(out, rest[:n]...)
+			i += n
+		}
+	}
+	endLine()
+	return string(out)
+}
+
+// quotedLen returns the length of the string literal starting s, or 0 if s
+// does not start with one of quotes. An unterminated literal extends to the
+// end of its line, or of s for multiline literals.
+func quotedLen(s string, quotes []quoteSyntax) int {
+	for _, quote := range quotes {
+		if !strings.HasPrefix(s, quote.delim) {
+			continue
+		}
+		for i := len(quote.delim); i < len(s); i++ {
+			switch {
+			case quote.escapes && s[i] == '\\':
+				i++
+			case strings.HasPrefix(s[i:], quote.delim):
+				return i + len(quote.delim)
+			case s[i] == '\n' && !quote.multiline:
+				return i
+			}
+		}
+		return len(s)
+	}
+	return 0
+}
+
+// isSpaceByte reports whether b is an ASCII whitespace character.
+func isSpaceByte(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
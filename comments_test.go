@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+func TestStripFileComments(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{
+			name:    "go line and block comments",
+			file:    "main.go",
+			content: "// Package main\npackage main\n\n/* block\n   comment */\nfunc main() { // trailing\n\tx := a/**/b\n}\n",
+			want:    "package main\n\nfunc main() {\n\tx := a b\n}\n",
+		},
+		{
+			name:    "go strings",
+			file:    "main.go",
+			content: "var s = \"// not a comment\"\nvar r = `/* raw\n// string */`\nvar c = '/' // slash\nvar e = \"\\\"//\"\n",
+			want:    "var s = \"// not a comment\"\nvar r = `/* raw\n// string */`\nvar c = '/'\nvar e = \"\\\"//\"\n",
+		},
+		{
+			name:    "javascript",
+			file:    "app.js",
+			content: "#!/usr/bin/env node\n// comment\nconst url = 'http://example.com'; /* c */\nconst t = `${a} // kept`;\n",
+			want:    "#!/usr/bin/env node\nconst url = 'http://example.com';\nconst t = `${a} // kept`;\n",
+		},
+		{
+			name:    "typescript",
+			file:    "app.ts",
+			content: "let x: number = 1; // one\n/** doc */\nlet s = \"/* kept */\";\n",
+			want:    "let x: number = 1;\nlet s = \"/* kept */\";\n",
+		},
+		{
+			name:    "c",
+			file:    "main.c",
+			content: "#include <stdio.h> /* io */\n// line\nint main() { printf(\"%s // %s\\n\", a, b); }\n",
+			want:    "#include <stdio.h>\nint main() { printf(\"%s // %s\\n\", a, b); }\n",
+		},
+		{
+			name:    "c++",
+			file:    "main.cpp",
+			content: "auto c = '\"'; // quote\nauto s = \"/*\"; /* real */ auto e = \"*/\";\n",
+			want:    "auto c = '\"';\nauto s = \"/*\";  auto e = \"*/\";\n",
+		},
+		{
+			name:    "python",
+			file:    "script.py",
+			content: "#!/usr/bin/env python3\n# comment\nx = \"# not a comment\"  # trailing\ndoc = \"\"\"\n# kept in docstring\n\"\"\"\ny = '#'\n",
+			want:    "#!/usr/bin/env python3\nx = \"# not a comment\"\ndoc = \"\"\"\n# kept in docstring\n\"\"\"\ny = '#'\n",
+		},
+		{
+			name:    "shell",
+			file:    "run.sh",
+			content: "#!/bin/sh\n# comment\necho \"# kept\" '# kept' # removed\necho ${#var} a#b\n",
+			want:    "#!/bin/sh\necho \"# kept\" '# kept'\necho ${#var} a#b\n",
+		},
+		{
+			name:    "windows line endings",
+			file:    "main.go",
+			content: "a := 1 // one\r\n// only\r\nb := 2\r\n",
+			want:    "a := 1\r\nb := 2\r\n",
+		},
+		{
+			name:    "unsupported extension left untouched",
+			file:    "notes.txt",
+			content: "// not stripped\n# neither\n",
+			want:    "// not stripped\n# neither\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripFileComments(tt.file, tt.content); got != tt.want {
+				t.Errorf("stripFileComments() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// configFileName is the config file read from the current directory when
+// --config is not given.
+const configFileName = ".copilot.json"
+
+// config holds default option values read from a config file. It maps each
+// command name to its options, keyed by flag name, e.g.
+//
+//	{"extract": {"exclude": ["vendor/**"], "format": "json"}, "apply": {"backup": true}}
+//
+// Values are strings, numbers or booleans, or arrays of them for repeatable
+// flags.
+type config map[string]map[string]any
+
+// loadConfig reads the config file at filePath. When filePath is empty,
+// configFileName is read from the current directory if it exists.
+func loadConfig(filePath string) (config, error) {
+	explicit := filePath != ""
+	if !explicit {
+		filePath = configFileName
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if !explicit && errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading config file '%s': %w", filePath, err)
+	}
+	var c config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parsing config file '%s': %w", filePath, err)
+	}
+	return c, nil
+}
+
+// string returns the string value of the option name of command, or an
+// empty string if it is not set. It is meant for options that are not flags,
+// such as the extensions of extract.
+func (c config) string(command, name string) string {
+	value, _ := c[command][name].(string)
+	return value
+}
+
+// apply sets the flags of fs, named after their command, that were not given
+// on the command line to their value in c. Options listed in positional are
+// not flags and are left to the caller.
+func (c config) apply(fs *flag.FlagSet, positional ...string) error {
+	options := c[fs.Name()]
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if slices.Contains(positional, name) || flagWasSet(fs, name) {
+			continue
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown %s option '%s'", fs.Name(), name)
+		}
+		values, ok := options[name].([]any)
+		if !ok {
+			values = []any{options[name]}
+		}
+		for _, value := range values {
+			var str string
+			switch v := value.(type) {
+			case string:
+				str = v
+			case bool:
+				str = strconv.FormatBool(v)
+			case float64:
+				str = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				return fmt.Errorf("invalid value for %s option '%s': %v", fs.Name(), name, value)
+			}
+			if err := fs.Set(name, str); err != nil {
+				return fmt.Errorf("invalid value for %s option '%s': %w", fs.Name(), name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"custom.json":  `{"extract": {"format": "json"}}`,
+		"invalid.json": `{"extract": `,
+	})
+	t.Chdir(dir)
+
+	if c, err := loadConfig(""); err != nil || c != nil {
+		t.Errorf("loadConfig(\"\") without %s = %v, %v, want no config and no error", configFileName, c, err)
+	}
+	if _, err := loadConfig("missing.json"); err == nil {
+		t.Error("loadConfig() returned no error for a missing explicit config file")
+	}
+	if _, err := loadConfig("invalid.json"); err == nil || !strings.Contains(err.Error(), "parsing config file") {
+		t.Errorf("loadConfig() error = %v, want a parsing error", err)
+	}
+	c, err := loadConfig("custom.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.string("extract", "format"); got != "json" {
+		t.Errorf("format = %q, want json", got)
+	}
+
+	writeFiles(t, dir, map[string]string{configFileName: `{"apply": {"backup": true}}`})
+	if c, err = loadConfig(""); err != nil {
+		t.Fatal(err)
+	}
+	if got := c["apply"]["backup"]; got != true {
+		t.Errorf("backup = %v from %s in the current directory, want true", got, configFileName)
+	}
+}
+
+func TestConfigApply(t *testing.T) {
+	c := config{"extract": {
+		"format":     "json",
+		"max-tokens": 1000.0, // JSON numbers decode as float64
+		"dedup":      true,
+		"exclude":    []any{"vendor/**", "*.pb.go"},
+		"extensions": ".go,.md",
+	}}
+	tests := []struct {
+		name        string
+		args        []string
+		wantFormat  string
+		wantTokens  int
+		wantDedup   bool
+		wantExclude []string
+	}{
+		{
+			name:        "flags fall back to the config",
+			wantFormat:  "json",
+			wantTokens:  1000,
+			wantDedup:   true,
+			wantExclude: []string{"vendor/**", "*.pb.go"},
+		},
+		{
+			name:        "flags given on the command line win",
+			args:        []string{"--format", "xml", "--max-tokens", "5", "--dedup=false", "--exclude", "testdata/**"},
+			wantFormat:  "xml",
+			wantTokens:  5,
+			wantDedup:   false,
+			wantExclude: []string{"testdata/**"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("extract", flag.ContinueOnError)
+			format := fs.String("format", "text", "")
+			maxTokens := fs.Int("max-tokens", 0, "")
+			dedup := fs.Bool("dedup", false, "")
+			var excludes stringListFlag
+			fs.Var(&excludes, "exclude", "")
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.apply(fs, "extensions"); err != nil {
+				t.Fatal(err)
+			}
+			if *format != tt.wantFormat || *maxTokens != tt.wantTokens || *dedup != tt.wantDedup || !slices.Equal(excludes, tt.wantExclude) {
+				t.Errorf("format=%q max-tokens=%d dedup=%v exclude=%q, want %q %d %v %q",
+					*format, *maxTokens, *dedup, excludes, tt.wantFormat, tt.wantTokens, tt.wantDedup, tt.wantExclude)
+			}
+		})
+	}
+}
+
+func TestConfigApplyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		c    config
+		err  string
+	}{
+		{"unknown option", config{"extract": {"no-such-flag": true}}, "unknown extract option 'no-such-flag'"},
+		{"invalid type", config{"extract": {"format": map[string]any{}}}, "invalid value for extract option 'format'"},
+		{"invalid value", config{"extract": {"max-tokens": "many"}}, "invalid value for extract option 'max-tokens'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("extract", flag.ContinueOnError)
+			fs.String("format", "text", "")
+			fs.Int("max-tokens", 0, "")
+			err := tt.c.apply(fs)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("apply() error = %v, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestExtractWithConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.go":        "package main\n",
+		"README.md":      "# Readme\n",
+		"vendor/dep.go":  "package dep\n",
+		configFileName:   `{"extract": {"extensions": ".go,.md", "exclude": ["vendor/**"], "list": true}}`,
+		"other/cfg.json": `{"extract": {"extensions": ".md", "list": true}}`,
+	})
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"config from the current directory", []string{"extract", "."}, "README.md\nmain.go\n"},
+		{"extensions given on the command line", []string{"extract", ".", ".go"}, "main.go\n"},
+		{"explicit config file", []string{"--config", filepath.Join("other", "cfg.json"), "extract", "."}, "README.md\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runCopilot(t, dir, "", tt.args...)
+			if code != 0 {
+				t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+			}
+			if stdout != tt.want {
+				t.Errorf("stdout = %q, want %q", stdout, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyWithConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"existing.txt": "original\n",
+		configFileName: `{"apply": {"backup": true, "backup-suffix": ".orig"}}`,
+	})
+	changeset := `{"changes": [{"file_path": "existing.txt", "content": "modified\n"}]}`
+	if _, stderr, code := runCopilot(t, dir, changeset, "apply", "-"); code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	want := []string{configFileName + "=" + `{"apply": {"backup": true, "backup-suffix": ".orig"}}`, "existing.txt=modified\n", "existing.txt.orig=original\n"}
+	if got := fileContents(t, dir); !slices.Equal(got, want) {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// diffContextLines is the number of unchanged lines shown around each change.
+const diffContextLines = 3
+
+// diffOp is a single line of an edit script.
+type diffOp struct {
+	kind   byte   // ' ' for an unchanged line, '-' for a removed one, '+' for an added one
+	line   string // Line content, including its trailing newline if any
+	oldPos int    // 0-based index of the line in the old content before this op
+	newPos int    // 0-based index of the line in the new content before this op
+}
+
+// splitLines splits s into lines, keeping the trailing newline of each line.
+// The last line has no newline if s does not end with one.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// maxDiffEdits bounds the number of changed lines diffLines looks for a
+// shortest edit script with, as the search keeps a trace whose size grows
+// with its square.
+const maxDiffEdits = 1000
+
+// diffLines computes an edit script turning oldLines into newLines.
+func diffLines(oldLines, newLines []string) []diffOp {
+	// Common prefix and suffix are kept out of the search
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix &&
+		oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+	a := oldLines[prefix : len(oldLines)-suffix]
+	b := newLines[prefix : len(newLines)-suffix]
+
+	var ops []diffOp
+	oldPos, newPos := 0, 0
+	emit := func(kind byte, line string) {
+		ops = append(ops, diffOp{kind: kind, line: line, oldPos: oldPos, newPos: newPos})
+		if kind != '+' {
+			oldPos++
+		}
+		if kind != '-' {
+			newPos++
+		}
+	}
+
+	for _, line := range oldLines[:prefix] {
+		emit(' ', line)
+	}
+	for _, op := range shortestEdit(a, b) {
+		emit(op.kind, op.line)
+	}
+	for _, line := range oldLines[len(oldLines)-suffix:] {
+		emit(' ', line)
+	}
+	return ops
+}
+
+// shortestEdit returns the kind and line of the ops of a shortest edit
+// script turning a into b, found with Myers' algorithm in O((n+m)·d) time, d
+// being the number of changed lines. Within a change, removed lines come
+// before added ones. When d exceeds maxDiffEdits, all of a is removed and all
+// of b added instead.
+func shortestEdit(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	// trace[d][k+d] is the furthest x reached on diagonal k = x-y with d
+	// changes, or -1 if that diagonal cannot be reached within the grid
+	var trace [][]int
+	// from returns where a path with d changes enters diagonal k, as the
+	// x reached before following equal lines, and the diagonal it comes from:
+	// k+1 after adding b[y-1], k-1 after removing a[x-1].
+	from := func(k, d int) (x, prevK int) {
+		prev := trace[d-1] // Diagonals -(d-1) to d-1
+		down, right := -1, -1
+		if k+1 <= d-1 {
+			if x := prev[k+1+d-1]; x >= 0 && x-k <= m {
+				down = x
+			}
+		}
+		if k-1 >= -(d - 1) {
+			if x := prev[k-1+d-1]; x >= 0 && x < n {
+				right = x + 1
+			}
+		}
+		if right > down {
+			return right, k - 1
+		}
+		return down, k + 1
+	}
+
+search:
+	for d := 0; ; d++ {
+		if d > maxDiffEdits {
+			return replaceLines(a, b)
+		}
+		v := make([]int, 2*d+1)
+		for k := -d; k <= d; k += 2 {
+			x := 0
+			if d > 0 {
+				if x, _ = from(k, d); x < 0 {
+					v[k+d] = -1
+					continue
+				}
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[k+d] = x
+			if x == n && y == m {
+				trace = append(trace, v)
+				break search
+			}
+		}
+		trace = append(trace, v)
+	}
+
+	// Walk back from the end, collecting ops in reverse
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		k := x - y
+		startX, prevK := from(k, d)
+		for x > startX {
+			x--
+			y--
+			ops = append(ops, diffOp{kind: ' ', line: a[x]})
+		}
+		if prevK == k+1 {
+			y--
+			ops = append(ops, diffOp{kind: '+', line: b[y]})
+		} else {
+			x--
+			ops = append(ops, diffOp{kind: '-', line: a[x]})
+		}
+	}
+	for x > 0 {
+		x--
+		ops = append(ops, diffOp{kind: ' ', line: a[x]})
+	}
+	slices.Reverse(ops)
+
+	// Put removed lines first within each change
+	for start := 0; start < len(ops); {
+		end := start
+		for end < len(ops) && ops[end].kind != ' ' {
+			end++
+		}
+		slices.SortStableFunc(ops[start:end], func(p, q diffOp) int {
+			return cmp.Compare(q.kind, p.kind) // '-' sorts after '+' in ASCII
+		})
+		start = end + 1
+	}
+	return ops
+}
+
+// replaceLines returns the ops removing all of a, then adding all of b.
+func replaceLines(a, b []string) []diffOp {
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a {
+		ops = append(ops, diffOp{kind: '-', line: line})
+	}
+	for _, line := range b {
+		ops = append(ops, diffOp{kind: '+', line: line})
+	}
+	return ops
+}
+
+// unifiedDiff returns a unified diff turning oldContent into newContent, with
+// oldName and newName used in the file headers. It returns an empty string
+// when both contents are equal.
+func unifiedDiff(oldName, newName, oldContent, newContent string) string {
+	if oldContent == newContent {
+		return ""
+	}
+
+	ops := diffLines(splitLines(oldContent), splitLines(newContent))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+
+	for start := 0; start < len(ops); {
+		// Find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+
+		// Extend the hunk while changes are close enough to share context
+		end := start
+		for k := start; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				end = k + 1
+			} else if k-end >= 2*diffContextLines {
+				break
+			}
+		}
+
+		from := max(start-diffContextLines, 0)
+		to := min(end+diffContextLines, len(ops))
+		writeHunk(&out, ops[from:to])
+		start = to
+	}
+	return out.String()
+}
+
+// writeHunk writes a single hunk made of ops, with its @@ header.
+func writeHunk(out *strings.Builder, ops []diffOp) {
+	oldCount, newCount := 0, 0
+	for _, op := range ops {
+		if op.kind != '+' {
+			oldCount++
+		}
+		if op.kind != '-' {
+			newCount++
+		}
+	}
+	// An empty range is numbered after the line preceding it
+	oldStart, newStart := ops[0].oldPos, ops[0].newPos
+	if oldCount > 0 {
+		oldStart++
+	}
+	if newCount > 0 {
+		newStart++
+	}
+
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+	for _, op := range ops {
+		out.WriteByte(op.kind)
+		out.WriteString(op.line)
+		if !strings.HasSuffix(op.line, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats a hunk range, omitting the count when it is 1.
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
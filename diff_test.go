@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name       string
+		oldContent string
+		newContent string
+		want       string
+	}{
+		{
+			name:       "unchanged",
+			oldContent: "a\nb\n",
+			newContent: "a\nb\n",
+			want:       "",
+		},
+		{
+			name:       "new file",
+			oldContent: "",
+			newContent: "a\nb\n",
+			want:       "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:       "deleted file",
+			oldContent: "a\n",
+			newContent: "",
+			want:       "--- old\n+++ new\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name:       "modified line",
+			oldContent: "a\nb\nc\n",
+			newContent: "a\nB\nc\n",
+			want:       "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name:       "missing trailing newline",
+			oldContent: "a\nb",
+			newContent: "a\nb\n",
+			want:       "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name:       "context is limited to three lines",
+			oldContent: "1\n2\n3\n4\n5\n6\n7\n8\n",
+			newContent: "1\n2\n3\n4\n5\n6\n7\neight\n",
+			want:       "--- old\n+++ new\n@@ -5,4 +5,4 @@\n 5\n 6\n 7\n-8\n+eight\n",
+		},
+		{
+			name:       "distant changes give separate hunks",
+			oldContent: "a\n1\n2\n3\n4\n5\n6\n7\nb\n",
+			newContent: "A\n1\n2\n3\n4\n5\n6\n7\nB\n",
+			want:       "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n@@ -6,4 +6,4 @@\n 5\n 6\n 7\n-b\n+B\n",
+		},
+		{
+			name:       "close changes share a hunk",
+			oldContent: "a\n1\n2\nb\n",
+			newContent: "A\n1\n2\nB\n",
+			want:       "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n-b\n+B\n",
+		},
+		{
+			name:       "inserted lines",
+			oldContent: "a\nc\n",
+			newContent: "a\nb1\nb2\nc\n",
+			want:       "--- old\n+++ new\n@@ -1,2 +1,4 @@\n a\n+b1\n+b2\n c\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("old", "new", tt.oldContent, tt.newContent); got != tt.want {
+				t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesLarge(t *testing.T) {
+	// countOps returns the number of unchanged, removed and added lines
+	countOps := func(ops []diffOp) (kept, removed, added int) {
+		for _, op := range ops {
+			switch op.kind {
+			case ' ':
+				kept++
+			case '-':
+				removed++
+			case '+':
+				added++
+			}
+		}
+		return kept, removed, added
+	}
+
+	t.Run("scattered changes", func(t *testing.T) {
+		// Far too many lines for a table of n·m common subsequence lengths
+		const n = 100000
+		var oldLines, newLines []string
+		for i := range n {
+			line := fmt.Sprintf("line %d\n", i)
+			oldLines = append(oldLines, line)
+			if i%1000 == 500 {
+				line = "changed\n"
+			}
+			newLines = append(newLines, line)
+		}
+		kept, removed, added := countOps(diffLines(oldLines, newLines))
+		if kept != n-100 || removed != 100 || added != 100 {
+			t.Errorf("diffLines() kept %d, removed %d and added %d line(s), want %d, 100 and 100", kept, removed, added, n-100)
+		}
+	})
+
+	t.Run("over maxDiffEdits", func(t *testing.T) {
+		oldLines := []string{"head\n"}
+		newLines := []string{"head\n"}
+		for i := range maxDiffEdits {
+			oldLines = append(oldLines, fmt.Sprintf("old %d\n", i), "same\n")
+			newLines = append(newLines, fmt.Sprintf("new %d\n", i), "same\n")
+		}
+		oldLines = append(oldLines, "tail\n")
+		newLines = append(newLines, "tail\n")
+
+		// Everything between the common prefix and suffix is replaced
+		ops := diffLines(oldLines, newLines)
+		kept, removed, added := countOps(ops)
+		if kept != 3 || removed != 2*maxDiffEdits-1 || added != 2*maxDiffEdits-1 {
+			t.Errorf("diffLines() kept %d, removed %d and added %d line(s), want 3, %d and %d", kept, removed, added, 2*maxDiffEdits-1, 2*maxDiffEdits-1)
+		}
+		if ops[1].kind != '-' || ops[len(ops)-3].kind != '+' {
+			t.Errorf("diffLines() did not remove all the old lines before adding the new ones")
+		}
+	})
+}
+
+func TestPrintChangeDiff(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"modified.txt":  "old\n",
+		"unchanged.txt": "same\n",
+	})
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		deleted bool
+		want    string
+	}{
+		{
+			name:    "modified",
+			file:    "modified.txt",
+			content: "new\n",
+			want:    "--- a/modified.txt\n+++ b/modified.txt\n@@ -1 +1 @@\n-old\n+new\n",
+		},
+		{
+			name:    "new",
+			file:    "new.txt",
+			content: "one\ntwo\n",
+			want:    "--- /dev/null\n+++ b/new.txt\n@@ -0,0 +1,2 @@\n+one\n+two\n",
+		},
+		{
+			name:    "unchanged",
+			file:    "unchanged.txt",
+			content: "same\n",
+			want:    "",
+		},
+		{
+			name:    "deleted",
+			file:    "modified.txt",
+			deleted: true,
+			want:    "--- a/modified.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-old\n",
+		},
+	}
+	t.Chdir(dir)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := captureApplyOut(t)
+			if err := printChangeDiff(filepath.FromSlash(tt.file), []byte(tt.content), tt.deleted); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("printChangeDiff() printed\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+
+	out := captureApplyOut(t)
+	if err := printChangeDiff("bin.dat", []byte("\x00\x01"), false); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); !strings.HasPrefix(got, "Binary files /dev/null and b/bin.dat differ") {
+		t.Errorf("printChangeDiff() of binary content printed %q", got)
+	}
+}
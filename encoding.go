@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Byte order marks recognized by normalizeEncoding.
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// normalizeEncoding converts text content to UTF-8 without a byte order
+// mark, for extract --normalize-encoding. UTF-8 and UTF-16 are recognized by
+// their byte order mark, and content that is neither valid UTF-8 nor binary
+// is read as Latin-1. It returns false when content is not text in one of
+// these encodings, e.g. UTF-16 without a byte order mark, or binary data.
+func normalizeEncoding(content []byte) ([]byte, bool) {
+	switch {
+	case bytes.HasPrefix(content, bomUTF8):
+		content = content[len(bomUTF8):]
+	case bytes.HasPrefix(content, bomUTF16LE):
+		return decodeUTF16(content[len(bomUTF16LE):], binary.LittleEndian)
+	case bytes.HasPrefix(content, bomUTF16BE):
+		return decodeUTF16(content[len(bomUTF16BE):], binary.BigEndian)
+	}
+	if isBinary(content) {
+		return nil, false
+	}
+	if utf8.Valid(content) {
+		return content, true
+	}
+	return decodeLatin1(content)
+}
+
+// decodeUTF16 converts UTF-16 content in the given byte order to UTF-8.
+// Unpaired surrogates are replaced with U+FFFD.
+func decodeUTF16(content []byte, order binary.ByteOrder) ([]byte, bool) {
+	if len(content)%2 != 0 {
+		return nil, false
+	}
+	units := make([]uint16, len(content)/2)
+	for i := range units {
+		units[i] = order.Uint16(content[2*i:])
+	}
+	decoded := []byte(string(utf16.Decode(units)))
+	if isBinary(decoded) {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// decodeLatin1 converts Latin-1 (ISO 8859-1) content to UTF-8. Since every
+// byte is valid Latin-1, content holding control characters other than the
+// usual whitespace is rejected as binary instead.
+func decodeLatin1(content []byte) ([]byte, bool) {
+	var decoded strings.Builder
+	decoded.Grow(len(content) * 2)
+	for _, b := range content {
+		if isControlByte(b) {
+			return nil, false
+		}
+		decoded.WriteRune(rune(b))
+	}
+	return []byte(decoded.String()), true
+}
+
+// isControlByte reports whether the Latin-1 character b is a C0 or C1 control
+// character other than tab, newline, vertical tab, form feed or carriage
+// return.
+func isControlByte(b byte) bool {
+	switch b {
+	case '\t', '\n', '\v', '\f', '\r':
+		return false
+	}
+	return b < 0x20 || b >= 0x7F && b < 0xA0
+}
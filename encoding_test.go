@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNormalizeEncoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		ok      bool
+	}{
+		{"plain UTF-8", "héllo\n", "héllo\n", true},
+		{"UTF-8 with a byte order mark", "\xEF\xBB\xBFhéllo\n", "héllo\n", true},
+		{"UTF-16LE", "\xFF\xFEh\x00\xE9\x00\n\x00", "hé\n", true},
+		{"UTF-16BE", "\xFE\xFF\x00h\x00\xE9\x00\n", "hé\n", true},
+		{"UTF-16LE surrogate pair", "\xFF\xFE\x3D\xD8\x00\xDE", "😀", true},
+		{"UTF-16LE unpaired surrogate", "\xFF\xFE\x3D\xD8a\x00", "�a", true},
+		{"UTF-16 odd length", "\xFF\xFEh\x00x", "", false},
+		{"UTF-16 binary", "\xFF\xFEa\x00\x00\x00", "", false},
+		{"Latin-1", "caf\xE9 cr\xE8me\r\n", "café crème\r\n", true},
+		{"Latin-1 with control characters", "caf\xE9\x01", "", false},
+		{"binary", "\x89PNG\r\n\x1a\n\x00\x00", "", false},
+		{"empty", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := normalizeEncoding([]byte(tt.content))
+			if ok != tt.ok {
+				t.Fatalf("normalizeEncoding(%q) ok = %v, want %v", tt.content, ok, tt.ok)
+			}
+			if ok && string(got) != tt.want {
+				t.Errorf("normalizeEncoding(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+			if ok && !utf8.Valid(got) {
+				t.Errorf("normalizeEncoding(%q) = %q, which is not valid UTF-8", tt.content, got)
+			}
+		})
+	}
+}
+
+func TestExtractNormalizeEncoding(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"bom.txt":    "\xEF\xBB\xBFfirst line\n",
+		"utf16.txt":  "\xFF\xFEn\x00a\x00\xEF\x00v\x00e\x00\n\x00",
+		"latin1.txt": "na\xEFve\n",
+		"binary.txt": "\x00\x01\x02",
+	})
+	tests := []struct {
+		normalize bool
+		want      []FileChange
+	}{
+		{
+			normalize: true,
+			want: []FileChange{
+				{FilePath: "bom.txt", Content: "first line\n"},
+				{FilePath: "latin1.txt", Content: "naïve\n"},
+				{FilePath: "utf16.txt", Content: "naïve\n"},
+			},
+		},
+		{
+			// Without the flag, content is kept as-is, with invalid UTF-8
+			// replaced by the JSON encoder, and UTF-16 is skipped as binary
+			normalize: false,
+			want: []FileChange{
+				{FilePath: "bom.txt", Content: "\uFEFFfirst line\n"},
+				{FilePath: "latin1.txt", Content: "na\uFFFDve\n"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		out, err := extractFileContent(context.Background(), dir, extractOptions{
+			extensions:        []string{".txt"},
+			format:            "json",
+			normalizeEncoding: tt.normalize,
+			maxDepth:          -1,
+			logger:            quietLogger(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var changeset MdiffJSON
+		if err := json.Unmarshal([]byte(out), &changeset); err != nil {
+			t.Fatal(err)
+		}
+		if len(changeset.Changes) != len(tt.want) {
+			t.Fatalf("normalize=%v: extracted %+v, want %+v", tt.normalize, changeset.Changes, tt.want)
+		}
+		for i, want := range tt.want {
+			if got := changeset.Changes[i]; got.FilePath != want.FilePath || got.Content != want.Content {
+				t.Errorf("normalize=%v: change %d = %q: %q, want %q: %q", tt.normalize, i, got.FilePath, got.Content, want.FilePath, want.Content)
+			}
+		}
+	}
+}
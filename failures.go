@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// extractFailures applies the --on-error policy of extract to the errors
+// met while selecting and reading files, such as unreadable files. It is
+// safe for concurrent use, and a nil extractFailures skips every failed file.
+type extractFailures struct {
+	policy string // "skip" (default), "stop" or "collect"
+
+	mu   sync.Mutex
+	errs []error // Collected with the "collect" policy
+}
+
+// handle deals with err, met on a single file, according to the policy. It
+// returns err when extraction must stop, or nil when the file is to be
+// skipped, after a warning through log.
+func (f *extractFailures) handle(err error, log *logger) error {
+	if f != nil && f.policy == "stop" {
+		return err
+	}
+	log.warnf("%v. Skipping.", err)
+	if f != nil && f.policy == "collect" {
+		f.mu.Lock()
+		f.errs = append(f.errs, err)
+		f.mu.Unlock()
+	}
+	return nil
+}
+
+// err returns the errors collected with the "collect" policy as a
+// *collectedErrors, or nil if there are none.
+func (f *extractFailures) err() error {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.errs) == 0 {
+		return nil
+	}
+	return &collectedErrors{errs: f.errs}
+}
+
+// collectedErrors sums up the errors met by extract --on-error collect. The
+// output is still complete but for the failed files.
+type collectedErrors struct {
+	errs []error
+}
+
+func (e *collectedErrors) Error() string {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "%d file(s) could not be extracted:", len(e.errs))
+	for _, err := range e.errs {
+		fmt.Fprintf(&msg, "\n  %v", err)
+	}
+	return msg.String()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// stubReadFailures makes the files named failing fail to be read by extract
+// with a permission error, for the rest of the test.
+func stubReadFailures(t *testing.T, failing ...string) {
+	t.Helper()
+	previous := readExtractedFile
+	readExtractedFile = func(filePath string) ([]byte, error) {
+		if slices.Contains(failing, filepath.Base(filePath)) {
+			return nil, &fs.PathError{Op: "open", Path: filePath, Err: fs.ErrPermission}
+		}
+		return previous(filePath)
+	}
+	t.Cleanup(func() { readExtractedFile = previous })
+}
+
+func TestExtractOnError(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a\n", "b.txt": "b\n", "c.txt": "c\n", "d.txt": "d\n"})
+	tests := []struct {
+		policy    string
+		wantFiles []string // Files in the output
+		wantErr   string
+		wantLogs  int // Number of warnings
+	}{
+		{policy: "skip", wantFiles: []string{"a.txt", "c.txt"}, wantLogs: 2},
+		{policy: "stop", wantErr: "failed to read file " + filepath.Join(dir, "b.txt")},
+		{policy: "collect", wantFiles: []string{"a.txt", "c.txt"}, wantErr: "2 file(s) could not be extracted:\n  failed to read file " + filepath.Join(dir, "b.txt"), wantLogs: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			stubReadFailures(t, "b.txt", "d.txt")
+			var logs bytes.Buffer
+			out, err := extractFileContent(context.Background(), dir, extractOptions{
+				extensions: []string{".txt"},
+				onError:    tt.policy,
+				jobs:       1,
+				maxDepth:   -1,
+				logger:     &logger{out: &logs, level: levelWarn},
+			})
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("extractFileContent() error = %v, want %q", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, fs.ErrPermission) && tt.policy == "stop" {
+				t.Errorf("extractFileContent() error = %v, want it to wrap the read error", err)
+			}
+			var collected *collectedErrors
+			if got := errors.As(err, &collected); got != (tt.policy == "collect") {
+				t.Errorf("error is a *collectedErrors: %v, want %v", got, tt.policy == "collect")
+			}
+			if collected != nil && len(collected.errs) != 2 {
+				t.Errorf("collected %d error(s), want 2", len(collected.errs))
+			}
+			for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+				want := slices.Contains(tt.wantFiles, name)
+				if got := strings.Contains(out, "<file_path>"+name+"</file_path>"); got != want {
+					t.Errorf("%s in the output: %v, want %v", name, got, want)
+				}
+			}
+			if got := strings.Count(logs.String(), ". Skipping."); got != tt.wantLogs {
+				t.Errorf("logged %d warning(s), want %d:\n%s", got, tt.wantLogs, logs.String())
+			}
+		})
+	}
+}
+
+func TestExtractFailuresHandle(t *testing.T) {
+	readErr := errors.New("cannot read x")
+	tests := []struct {
+		name        string
+		failures    *extractFailures
+		wantErr     bool
+		wantCollect bool
+	}{
+		{name: "nil skips", failures: nil},
+		{name: "skip", failures: &extractFailures{policy: "skip"}},
+		{name: "stop", failures: &extractFailures{policy: "stop"}, wantErr: true},
+		{name: "collect", failures: &extractFailures{policy: "collect"}, wantCollect: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			err := tt.failures.handle(readErr, &logger{out: &logs, level: levelWarn})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("handle() error = %v, want error %v", err, tt.wantErr)
+			}
+			if wantLog := !tt.wantErr; strings.Contains(logs.String(), "Warning: cannot read x. Skipping.") != wantLog {
+				t.Errorf("logs = %q, want a warning: %v", logs.String(), wantLog)
+			}
+			if got := tt.failures.err() != nil; got != tt.wantCollect {
+				t.Errorf("err() returned an error: %v, want %v", got, tt.wantCollect)
+			}
+		})
+	}
+}
+
+func TestExtractOnErrorFlag(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a\n"})
+	_, stderr, code := runCopilot(t, dir, "", "extract", "--on-error", "retry", ".", ".txt")
+	if code != 1 || !strings.Contains(stderr, "Unknown --on-error policy 'retry'") {
+		t.Errorf("exit code %d, stderr %q, want an unknown policy error", code, stderr)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/moul-dev/copilot/pkg/ignore"
+)
+
+// changeFilter selects the changes apply writes, from the globs of --only
+// and --skip. Globs use the .gitignore syntax, relative to the base
+// directory.
+type changeFilter struct {
+	only *ignore.Matcher // Selects every change when nil
+	skip *ignore.Matcher // Skips no change when nil, takes precedence over only
+}
+
+// newChangeFilter returns a filter selecting the changes with a path
+// matching one of only, or every change if only is empty, except those with
+// a path matching one of skip. Globs are relative to baseDir.
+func newChangeFilter(only, skip []string, baseDir string) changeFilter {
+	var f changeFilter
+	if len(only) > 0 {
+		f.only = ignore.FromPatterns(only, baseDir)
+	}
+	if len(skip) > 0 {
+		f.skip = ignore.FromPatterns(skip, baseDir)
+	}
+	return f
+}
+
+// excludedBy returns the flag excluding change, "--skip" or "--only", or an
+// empty string if it should be applied. Renames are skipped when either their
+// source or destination matches --skip, and selected by --only when either
+// matches it. Paths of change are relative to the base directory of the
+// filter, unless absolute.
+func (f changeFilter) excludedBy(change FileChange) string {
+	paths := []string{change.FilePath}
+	if change.isRename() {
+		paths = []string{change.From, change.To}
+	}
+	if f.skip != nil && f.matchesAny(f.skip, paths) {
+		return "--skip"
+	}
+	if f.only != nil && !f.matchesAny(f.only, paths) {
+		return "--only"
+	}
+	return ""
+}
+
+// matchesAny reports whether one of paths matches one of the globs of m.
+func (f changeFilter) matchesAny(m *ignore.Matcher, paths []string) bool {
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(m.Root(), p)
+		}
+		if matched, err := m.IsIgnored(p, false); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the changes selected by f, logging the others through log.
+func (f changeFilter) filter(changes []FileChange, log *logger) []FileChange {
+	if f.only == nil && f.skip == nil {
+		return changes
+	}
+	var selected []FileChange
+	for _, change := range changes {
+		if flag := f.excludedBy(change); flag != "" {
+			log.debugf("Skipping %s, excluded by %s", changeTarget(change), flag)
+			continue
+		}
+		selected = append(selected, change)
+	}
+	if skipped := len(changes) - len(selected); skipped > 0 {
+		log.infof("Skipped %d of %d change(s) excluded by --only or --skip.", skipped, len(changes))
+	}
+	return selected
+}
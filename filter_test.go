@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// filterChangeset is the changeset the --only and --skip tests filter.
+var filterChangeset = []FileChange{
+	{FilePath: "main.go", Content: "main"},
+	{FilePath: "README.md", Content: "readme"},
+	{FilePath: "src/app.go", Content: "app"},
+	{FilePath: "src/app_test.go", Content: "test"},
+	{FilePath: "src/ui/view.js", Content: "view"},
+	{FilePath: "docs/guide.md", Content: "guide"},
+	{From: "old/name.go", To: "src/name.go"},
+}
+
+// filteredTargets returns the targets of the changes of filterChangeset
+// selected by a filter built from only and skip.
+func filteredTargets(t *testing.T, only, skip []string) []string {
+	t.Helper()
+	var targets []string
+	for _, change := range newChangeFilter(only, skip, t.TempDir()).filter(filterChangeset, quietLogger()) {
+		targets = append(targets, changeTarget(change))
+	}
+	return targets
+}
+
+func TestChangeFilterOnly(t *testing.T) {
+	tests := []struct {
+		name string
+		only []string
+		want []string
+	}{
+		{
+			name: "no filter",
+			want: []string{"main.go", "README.md", "src/app.go", "src/app_test.go", "src/ui/view.js", "docs/guide.md", "old/name.go -> src/name.go"},
+		},
+		{
+			name: "extension in any directory",
+			only: []string{"*.md"},
+			want: []string{"README.md", "docs/guide.md"},
+		},
+		{
+			name: "directory",
+			only: []string{"src/"},
+			want: []string{"src/app.go", "src/app_test.go", "src/ui/view.js", "old/name.go -> src/name.go"},
+		},
+		{
+			name: "anchored glob",
+			only: []string{"/*.go"},
+			want: []string{"main.go"},
+		},
+		{
+			name: "any of several globs",
+			only: []string{"*.js", "docs/**", "old/name.go"},
+			want: []string{"src/ui/view.js", "docs/guide.md", "old/name.go -> src/name.go"},
+		},
+		{
+			name: "no match",
+			only: []string{"*.rs"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filteredTargets(t, tt.only, nil); !slices.Equal(got, tt.want) {
+				t.Errorf("selected %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangeFilterNote(t *testing.T) {
+	var logs bytes.Buffer
+	log := &logger{out: &logs, level: levelInfo}
+	newChangeFilter([]string{"*.md"}, nil, t.TempDir()).filter(filterChangeset, log)
+	if want := "Skipped 5 of 7 change(s) excluded by --only or --skip."; !strings.Contains(logs.String(), want) {
+		t.Errorf("logs = %q, want %q", logs.String(), want)
+	}
+}
+
+func TestApplyOnly(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"changes.json": `{"changes": [
+		{"file_path": "main.go", "content": "main"},
+		{"file_path": "src/app.go", "content": "app"},
+		{"file_path": "src/ui/view.js", "content": "view"},
+		{"file_path": "docs/guide.md", "content": "guide"}
+	]}`})
+
+	_, stderr, code := runCopilot(t, dir, "", "apply", "--only", "src/**", "--only", "*.md", "changes.json")
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	want := []string{"changes.json", "docs/guide.md", "src/app.go", "src/ui/view.js"}
+	if got := listFiles(t, dir); !slices.Equal(got, want) {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+	if !strings.Contains(stderr, "Skipped 1 of 4 change(s) excluded by --only or --skip.") {
+		t.Errorf("stderr = %q, want a note about the skipped change", stderr)
+	}
+}
+
+func TestChangeFilterSkip(t *testing.T) {
+	tests := []struct {
+		name string
+		only []string
+		skip []string
+		want []string
+	}{
+		{
+			name: "skip tests",
+			skip: []string{"*_test.go"},
+			want: []string{"main.go", "README.md", "src/app.go", "src/ui/view.js", "docs/guide.md", "old/name.go -> src/name.go"},
+		},
+		{
+			name: "skip several globs",
+			skip: []string{"*.md", "src/ui/"},
+			want: []string{"main.go", "src/app.go", "src/app_test.go", "old/name.go -> src/name.go"},
+		},
+		{
+			name: "renames are skipped when either path matches",
+			skip: []string{"old/"},
+			want: []string{"main.go", "README.md", "src/app.go", "src/app_test.go", "src/ui/view.js", "docs/guide.md"},
+		},
+		{
+			name: "skip takes precedence over only",
+			only: []string{"src/**"},
+			skip: []string{"*_test.go", "src/name.go"},
+			want: []string{"src/app.go", "src/ui/view.js"},
+		},
+		{
+			name: "the same glob in both skips",
+			only: []string{"*.md"},
+			skip: []string{"*.md"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filteredTargets(t, tt.only, tt.skip); !slices.Equal(got, tt.want) {
+				t.Errorf("selected %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangeFilterExcludedBy(t *testing.T) {
+	f := newChangeFilter([]string{"src/**"}, []string{"*_test.go"}, t.TempDir())
+	tests := []struct {
+		change FileChange
+		want   string
+	}{
+		{FileChange{FilePath: "src/app.go"}, ""},
+		{FileChange{FilePath: "src/app_test.go"}, "--skip"},
+		{FileChange{FilePath: "main_test.go"}, "--skip"},
+		{FileChange{FilePath: "main.go"}, "--only"},
+		{FileChange{From: "main.go", To: "src/main.go"}, ""},
+	}
+	for _, tt := range tests {
+		if got := f.excludedBy(tt.change); got != tt.want {
+			t.Errorf("excludedBy(%s) = %q, want %q", changeTarget(tt.change), got, tt.want)
+		}
+	}
+}
+
+func TestApplySkip(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"changes.json": `{"changes": [
+		{"file_path": "src/app.go", "content": "app"},
+		{"file_path": "src/app_test.go", "content": "test"},
+		{"file_path": "docs/guide.md", "content": "guide"}
+	]}`})
+
+	_, stderr, code := runCopilot(t, dir, "", "apply", "--only", "src/**", "--skip", "*_test.go", "changes.json")
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	want := []string{"changes.json", "src/app.go"}
+	if got := listFiles(t, dir); !slices.Equal(got, want) {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gitTrackedPaths returns the absolute paths of the files tracked by Git
+// under dirAbs, along with the directories holding them, for extract
+// --git-tracked-only. dirAbs must be inside a Git work tree.
+func gitTrackedPaths(dirAbs string) (map[string]bool, error) {
+	out, err := runGit(dirAbs, "ls-files", "-z")
+	if err != nil {
+		return nil, err
+	}
+	return gitPathSet(dirAbs, out), nil
+}
+
+// gitChangedPaths returns the absolute paths of the files under dirAbs that
+// differ between ref and the work tree, as listed by git diff, along with
+// the directories holding them, for extract --changed-since. Deleted files
+// are included, even though they cannot be found anymore.
+func gitChangedPaths(dirAbs, ref string) (map[string]bool, error) {
+	if _, err := runGit(dirAbs, "rev-parse", "--verify", "--quiet", ref+"^{commit}"); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(errors.Unwrap(err), &exitErr) {
+			return nil, fmt.Errorf("unknown Git ref '%s'", ref)
+		}
+		return nil, err
+	}
+	out, err := runGit(dirAbs, "diff", "--name-only", "-z", "--relative", ref, "--")
+	if err != nil {
+		return nil, err
+	}
+	return gitPathSet(dirAbs, out), nil
+}
+
+// gitPathSet returns the absolute paths of the NUL-separated paths out,
+// relative to dirAbs, along with all their parent directories up to dirAbs.
+func gitPathSet(dirAbs string, out []byte) map[string]bool {
+	paths := map[string]bool{dirAbs: true}
+	for _, relPath := range strings.Split(string(out), "\x00") {
+		if relPath == "" {
+			continue
+		}
+		absPath := filepath.Join(dirAbs, filepath.FromSlash(relPath))
+		for p := absPath; !paths[p]; p = filepath.Dir(p) {
+			paths[p] = true
+		}
+	}
+	return paths
+}
+
+// runGit runs git with args in dirAbs and returns its standard output. Its
+// error tells apart a missing git command, a directory outside of any Git
+// repository, and other failures, which carry the message of git.
+func runGit(dirAbs string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dirAbs}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, errors.New("running git: git is not installed or not in the PATH")
+	}
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if strings.Contains(msg, "not a git repository") {
+			return nil, fmt.Errorf("'%s' is not inside a Git repository", dirAbs)
+		}
+		if msg != "" {
+			return nil, fmt.Errorf("running git %s: %s", args[0], strings.TrimPrefix(msg, "fatal: "))
+		}
+		return nil, fmt.Errorf("running git %s: %w", args[0], err)
+	}
+	return out, nil
+}
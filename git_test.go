@@ -0,0 +1,207 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// git runs git with args in dir, failing the test on error. The user and
+// system configurations are ignored, so that the fixture repositories do not
+// depend on the machine running the tests.
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=Test", "-c", "user.email=test@example.com", "-c", "commit.gpgsign=false", "-c", "init.defaultBranch=main"}, args...)...)
+	cmd.Env = append(cmd.Environ(), "GIT_CONFIG_GLOBAL="+os.DevNull, "GIT_CONFIG_NOSYSTEM=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+// gitFixtureRepo creates a Git repository in a new directory with the files
+// of tracked committed, and the files of untracked left untracked.
+func gitFixtureRepo(t *testing.T, tracked, untracked map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	git(t, dir, "init", "-q")
+	writeFiles(t, dir, tracked)
+	git(t, dir, "add", "-f", ".")
+	git(t, dir, "commit", "-q", "-m", "initial")
+	writeFiles(t, dir, untracked)
+	return dir
+}
+
+func TestGitPathSet(t *testing.T) {
+	dir := filepath.FromSlash("/repo/sub")
+	got := gitPathSet(dir, []byte("a.go\x00pkg/x/b.go\x00pkg/c.go\x00"))
+	var paths []string
+	for p := range got {
+		paths = append(paths, filepath.ToSlash(p))
+	}
+	slices.Sort(paths)
+	want := []string{"/repo/sub", "/repo/sub/a.go", "/repo/sub/pkg", "/repo/sub/pkg/c.go", "/repo/sub/pkg/x", "/repo/sub/pkg/x/b.go"}
+	if !slices.Equal(paths, want) {
+		t.Errorf("gitPathSet() = %q, want %q", paths, want)
+	}
+}
+
+func TestExtractGitTrackedOnly(t *testing.T) {
+	dir := gitFixtureRepo(t,
+		map[string]string{
+			".gitignore":     "*.log\n",
+			"main.go":        "package main\n",
+			"pkg/util.go":    "package pkg\n",
+			"pkg/forced.log": "tracked despite .gitignore\n",
+		},
+		map[string]string{
+			"scratch.go":     "package main\n",
+			"pkg/draft.go":   "package pkg\n",
+			"untracked/x.go": "package untracked\n",
+			"debug.log":      "ignored\n",
+		},
+	)
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "every file", args: []string{"--list", ".", ".go"}, want: "main.go\npkg/draft.go\npkg/util.go\nscratch.go\nuntracked/x.go\n"},
+		{name: "tracked only", args: []string{"--list", "--git-tracked-only", ".", ".go"}, want: "main.go\npkg/util.go\n"},
+		{name: "tracked only, ignore rules still apply", args: []string{"--list", "--git-tracked-only", ".", "*"}, want: ".gitignore\nmain.go\npkg/util.go\n"},
+		{name: "subdirectory", args: []string{"--list", "--git-tracked-only", "pkg", ".go"}, want: "util.go\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runCopilot(t, dir, "", append([]string{"extract"}, tt.args...)...)
+			if code != 0 {
+				t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+			}
+			if stdout != tt.want {
+				t.Errorf("stdout = %q, want %q", stdout, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractGitTrackedOnlyOutsideRepo(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"main.go": "package main\n"})
+	t.Setenv("GIT_CEILING_DIRECTORIES", filepath.Dir(dir))
+	stdout, stderr, code := runCopilot(t, dir, "", "extract", "--git-tracked-only", ".", ".go")
+	if code != 1 || stdout != "" {
+		t.Errorf("exit code = %d, stdout %q, want 1 and no output", code, stdout)
+	}
+	if !strings.Contains(stderr, "is not inside a Git repository") {
+		t.Errorf("stderr = %q, want it to say the directory is not in a Git repository", stderr)
+	}
+}
+
+func TestExtractChangedSince(t *testing.T) {
+	dir := gitFixtureRepo(t,
+		map[string]string{
+			"main.go":      "package main\n",
+			"util.go":      "package main\n",
+			"doomed.go":    "package main\n",
+			"docs/a.md":    "# A\n",
+			"pkg/stale.go": "package pkg\n",
+		},
+		nil,
+	)
+	git(t, dir, "tag", "base")
+	writeFiles(t, dir, map[string]string{"util.go": "package main // committed change\n", "pkg/new.go": "package pkg\n"})
+	git(t, dir, "add", ".")
+	git(t, dir, "commit", "-q", "-m", "second")
+	// Changes in the work tree, on top of the second commit
+	writeFiles(t, dir, map[string]string{
+		"main.go":      "package main // uncommitted change\n",
+		"docs/a.md":    "# A, edited\n",
+		"untracked.go": "package main\n",
+	})
+	git(t, dir, "rm", "-q", "doomed.go")
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "since the tag", args: []string{"--changed-since", "base", ".", ".go"}, want: "main.go\npkg/new.go\nutil.go\n"},
+		{name: "since HEAD", args: []string{"--changed-since", "HEAD", ".", ".go"}, want: "main.go\n"},
+		{name: "extension filter", args: []string{"--changed-since", "base", ".", ".md"}, want: "docs/a.md\n"},
+		{name: "subdirectory", args: []string{"--changed-since", "HEAD~1", "pkg", ".go"}, want: "new.go\n"},
+		{name: "with --git-tracked-only", args: []string{"--changed-since", "base", "--git-tracked-only", ".", "*"}, want: "docs/a.md\nmain.go\npkg/new.go\nutil.go\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runCopilot(t, dir, "", append([]string{"extract", "--list"}, tt.args...)...)
+			if code != 0 {
+				t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+			}
+			if stdout != tt.want {
+				t.Errorf("stdout = %q, want %q", stdout, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractChangedSinceErrors(t *testing.T) {
+	repo := gitFixtureRepo(t, map[string]string{"main.go": "package main\n"}, nil)
+	outside := t.TempDir()
+	t.Setenv("GIT_CEILING_DIRECTORIES", filepath.Dir(outside))
+	tests := []struct {
+		name string
+		dir  string
+		want string
+	}{
+		{name: "unknown ref", dir: repo, want: "Error listing files changed since 'no-such-branch': unknown Git ref 'no-such-branch'"},
+		{name: "not a repository", dir: outside, want: "is not inside a Git repository"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runCopilot(t, tt.dir, "", "extract", "--changed-since", "no-such-branch", ".", ".go")
+			if code != 1 || stdout != "" {
+				t.Errorf("exit code = %d, stdout %q, want 1 and no output", code, stdout)
+			}
+			if !strings.Contains(stderr, tt.want) {
+				t.Errorf("stderr = %q, want %q", stderr, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractGitDirOfRepository(t *testing.T) {
+	dir := gitFixtureRepo(t, map[string]string{"main.go": "package main\n", "README": "readme\n"}, nil)
+	tests := []struct {
+		name    string
+		args    []string
+		wantGit bool
+	}{
+		{name: "default", args: []string{"extract", "--list", ".", "*"}},
+		{name: "--include-git", args: []string{"extract", "--list", "--include-git", ".", "*"}, wantGit: true},
+		{name: "the content, not only the list", args: []string{"extract", ".", "*"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runCopilot(t, dir, "", tt.args...)
+			if code != 0 {
+				t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+			}
+			if got := strings.Contains(stdout, ".git/"); got != tt.wantGit {
+				t.Errorf("output mentions .git/: %v, want %v:\n%s", got, tt.wantGit, stdout)
+			}
+			if !strings.Contains(stdout, "main.go") || !strings.Contains(stdout, "README") {
+				t.Errorf("output = %q, want the tracked files", stdout)
+			}
+		})
+	}
+}
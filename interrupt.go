@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+
+	"github.com/moul-dev/copilot/pkg/apply"
+)
+
+// interruptContext returns a context canceled on the first interrupt signal
+// (Ctrl-C), so that extract and apply can stop cleanly. A second interrupt
+// exits right away, after removing the temporary files of the writes in
+// progress, which deferred cleanups would otherwise leave behind. stop
+// releases the signal handler.
+func interruptContext() (ctx context.Context, stop context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		if _, ok := <-signals; !ok {
+			return
+		}
+		cancel()
+		if _, ok := <-signals; ok {
+			apply.RemovePendingTemps()
+			os.Exit(130)
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		close(signals)
+		cancel()
+	}
+}
+
+// exitIfInterrupted exits with the conventional status of a process killed by
+// SIGINT when err comes from the cancellation of an interruptContext.
+func exitIfInterrupted(err error, log *logger) {
+	if errors.Is(err, context.Canceled) {
+		apply.RemovePendingTemps()
+		log.errorf("Interrupted.")
+		os.Exit(130)
+	}
+}
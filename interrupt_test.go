@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+// cancelWriter cancels a context once it has received after writes, and
+// counts the writes it receives after that.
+type cancelWriter struct {
+	after  int32
+	cancel context.CancelFunc
+	writes atomic.Int32
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	if w.writes.Add(1) == w.after {
+		w.cancel()
+	}
+	return len(p), nil
+}
+
+// late returns the number of writes received after the cancellation.
+func (w *cancelWriter) late() int {
+	return max(int(w.writes.Load()-w.after), 0)
+}
+
+func TestExtractCancel(t *testing.T) {
+	const n = 200
+	dir := t.TempDir()
+	writeFixtureTree(t, dir, n)
+	tests := []struct {
+		name      string
+		jobs      int
+		walk      bool // Cancel during the walk rather than while reading
+		wantReads int  // Maximum number of files read
+	}{
+		{name: "during the walk", jobs: 1, walk: true, wantReads: 0},
+		{name: "while reading", jobs: 1, wantReads: 11},
+		{name: "while reading concurrently", jobs: 4, wantReads: 10 + 2*4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			w := &cancelWriter{after: 10, cancel: cancel}
+			var reads atomic.Int32
+			previous := readExtractedFile
+			readExtractedFile = func(filePath string) ([]byte, error) {
+				if reads.Add(1) == 10 && !tt.walk {
+					cancel()
+				}
+				return previous(filePath)
+			}
+			t.Cleanup(func() { readExtractedFile = previous })
+
+			opts := extractOptions{extensions: []string{".go"}, jobs: tt.jobs, maxDepth: -1, logger: quietLogger()}
+			if tt.walk {
+				// Each walked path is logged, and the tenth cancels the walk
+				opts.ignoreDebug, opts.logger = true, &logger{out: w, level: levelInfo}
+			}
+			out, err := extractFileContent(ctx, dir, opts)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("extractFileContent() error = %v, want %v", err, context.Canceled)
+			}
+			if out != "" {
+				t.Errorf("extractFileContent() returned %d bytes of output along with the error", len(out))
+			}
+			if got := int(reads.Load()); got > tt.wantReads {
+				t.Errorf("read %d file(s), want at most %d", got, tt.wantReads)
+			}
+			if late := w.late(); tt.walk && late > 1 {
+				t.Errorf("walked %d path(s) after the cancellation, want at most 1", late)
+			}
+		})
+	}
+}
+
+func TestExtractCanceledBeforeStart(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtureTree(t, dir, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, listOnly := range []bool{false, true} {
+		out, err := extractFileContent(ctx, dir, extractOptions{extensions: []string{".go"}, listOnly: listOnly, maxDepth: -1, logger: quietLogger()})
+		if !errors.Is(err, context.Canceled) || out != "" {
+			t.Errorf("extractFileContent(listOnly: %v) = %d bytes, %v, want no output and %v", listOnly, len(out), err, context.Canceled)
+		}
+	}
+}
+
+func TestApplyCancel(t *testing.T) {
+	const n = 100
+	var changes []FileChange
+	for i := range n {
+		changes = append(changes, FileChange{FilePath: fmt.Sprintf("dir%d/file%03d.txt", i%5, i), Content: "content"})
+	}
+	tests := []struct {
+		name        string
+		jobs        int
+		atomic      bool
+		wantMax     int // Maximum number of files left written
+		wantNoFiles bool
+	}{
+		{name: "sequential", jobs: 1, wantMax: 10},
+		{name: "concurrent", jobs: 4, wantMax: 10 + 4},
+		{name: "atomic rolls back", jobs: 1, atomic: true, wantNoFiles: true},
+		{name: "atomic concurrent rolls back", jobs: 4, atomic: true, wantNoFiles: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			w := &cancelWriter{after: 10, cancel: cancel}
+			previous := applyOut
+			applyOut = w
+			t.Cleanup(func() { applyOut = previous })
+
+			applied, err := applyChanges(ctx, resolveChangePaths(changes, dir), applyOptions{jobs: tt.jobs, atomic: tt.atomic, logger: quietLogger()})
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("applyChanges() error = %v, want %v", err, context.Canceled)
+			}
+			files := listFiles(t, dir)
+			if applied > tt.wantMax && !tt.wantNoFiles {
+				t.Errorf("applied %d change(s), want at most %d", applied, tt.wantMax)
+			}
+			if tt.wantNoFiles && len(files) > 0 || !tt.wantNoFiles && len(files) > tt.wantMax {
+				t.Errorf("%d file(s) left, want at most %d, or none after a rollback: %q", len(files), tt.wantMax, files)
+			}
+		})
+	}
+}
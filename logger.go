@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// logLevel is the severity of a diagnostic message.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota // Per-file progress, shown with --verbose
+	levelInfo                  // Informational messages, such as token estimates
+	levelWarn                  // Non-fatal problems, hidden by --quiet
+	levelError                 // Fatal problems, always shown
+)
+
+// logger prints diagnostics at or above its level to out. A nil *logger
+// behaves like defaultLogger, so options left unset keep the default
+// behavior of printing everything but debug messages to stderr.
+type logger struct {
+	out   io.Writer
+	level logLevel
+}
+
+// defaultLogger is used by nil loggers.
+var defaultLogger = &logger{out: os.Stderr, level: levelInfo}
+
+func (l *logger) logf(level logLevel, prefix, format string, args ...any) {
+	if l == nil {
+		l = defaultLogger
+	}
+	if level >= l.level {
+		fmt.Fprintf(l.out, prefix+format+"\n", args...)
+	}
+}
+
+// debugf prints a progress message.
+func (l *logger) debugf(format string, args ...any) {
+	l.logf(levelDebug, "", format, args...)
+}
+
+// infof prints an informational message.
+func (l *logger) infof(format string, args ...any) {
+	l.logf(levelInfo, "", format, args...)
+}
+
+// warnf prints a non-fatal problem, prefixed with "Warning: ".
+func (l *logger) warnf(format string, args ...any) {
+	l.logf(levelWarn, "Warning: ", format, args...)
+}
+
+// errorf prints a fatal problem. Error messages come in several forms
+// ("Error: ...", "Error reading ...: ..."), so format must include its own
+// "Error" prefix.
+func (l *logger) errorf(format string, args ...any) {
+	l.logf(levelError, "", format, args...)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLoggerLevels(t *testing.T) {
+	tests := []struct {
+		level logLevel
+		want  string
+	}{
+		{levelDebug, "debug 1\ninfo 2\nWarning: warn 3\nError: error 4\n"},
+		{levelInfo, "info 2\nWarning: warn 3\nError: error 4\n"},
+		{levelWarn, "Warning: warn 3\nError: error 4\n"},
+		{levelError, "Error: error 4\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		l := &logger{out: &buf, level: tt.level}
+		l.debugf("debug %d", 1)
+		l.infof("info %d", 2)
+		l.warnf("warn %d", 3)
+		l.errorf("Error: error %d", 4)
+		if got := buf.String(); got != tt.want {
+			t.Errorf("level %d: output = %q, want %q", tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestNilLoggerUsesDefault(t *testing.T) {
+	var buf bytes.Buffer
+	saved := defaultLogger
+	defaultLogger = &logger{out: &buf, level: levelInfo}
+	t.Cleanup(func() { defaultLogger = saved })
+
+	var l *logger
+	l.debugf("hidden")
+	l.infof("shown")
+	l.warnf("careful")
+	if got, want := buf.String(), "shown\nWarning: careful\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
@@ -2,18 +2,71 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/moul-dev/copilot/pkg/apply"
+	"github.com/moul-dev/copilot/pkg/ignore"
 )
 
 // FileChange represents a single file to be modified.
 type FileChange struct {
 	FilePath string `json:"file_path"`
 	Content  string `json:"content"`
+	Encoding string `json:"encoding,omitempty"` // "base64" if Content is base64-encoded, empty for plain text
+	Delete   bool   `json:"delete,omitempty"`   // Remove the file instead of writing Content
+
+	// A change with From and To renames the file at From to To instead,
+	// ignoring FilePath and Content.
+	From      string `json:"from,omitempty"`
+	To        string `json:"to,omitempty"`
+	Overwrite bool   `json:"overwrite,omitempty"` // Replace To if it already exists
+
+	// Set by extract only, and not part of the JSON format
+	sha256 string // Hex SHA-256 of the file as read from disk
+	size   int64  // Size in bytes of the file as read from disk
+	dupOf  string // FilePath of an earlier file with the same content, see --dedup
+}
+
+// isRename reports whether the change moves a file rather than writing it.
+func (c FileChange) isRename() bool {
+	return c.From != "" || c.To != ""
+}
+
+// decodedContent returns the raw bytes of the change's content, decoding it
+// according to its encoding.
+func (c FileChange) decodedContent() ([]byte, error) {
+	switch c.Encoding {
+	case "":
+		return []byte(c.Content), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(c.Content)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding '%s'", c.Encoding)
+	}
 }
 
 // MdiffJSON is the top-level structure for the JSON input.
@@ -21,449 +74,3331 @@ type MdiffJSON struct {
 	Changes []FileChange `json:"changes"`
 }
 
-// IgnoreMatcher holds gitignore patterns and logic.
-type IgnoreMatcher struct {
-	patterns         []string
-	gitignoreRootAbs string // Absolute path to the directory containing the .gitignore file
+// ignoreFlags holds the ignore-related flags shared by the commands
+// scanning a directory.
+type ignoreFlags struct {
+	fs              *flag.FlagSet
+	gitignorePath   *string
+	extraGitignores stringListFlag
+	noCopilotignore *bool
+	gitExcludes     *bool
+	ignoreCase      *bool
+	fromRoot        *bool
+	debug           *bool
+	includeGit      *bool
 }
 
-// NewIgnoreMatcher creates a new IgnoreMatcher.
-// customGitignorePath is the user-provided path to a .gitignore file (can be empty).
-// scanDirAbs is the absolute path to the root directory being scanned.
-func NewIgnoreMatcher(customGitignorePath, scanDirAbs string) (*IgnoreMatcher, error) {
-	effectiveGitignorePath := customGitignorePath
-	if effectiveGitignorePath == "" {
-		effectiveGitignorePath = filepath.Join(scanDirAbs, ".gitignore")
-	} else {
-		absPath, err := filepath.Abs(effectiveGitignorePath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get absolute path for custom gitignore '%s': %w", effectiveGitignorePath, err)
+// addIgnoreFlags defines the ignore-related flags on fs.
+func addIgnoreFlags(fs *flag.FlagSet) *ignoreFlags {
+	f := &ignoreFlags{fs: fs}
+	f.gitignorePath = fs.String("gitignore", "", "Path to a custom .gitignore file. If not provided,\n.gitignore in <directory_path> is used if it exists.")
+	fs.Var(&f.extraGitignores, "gitignore-extra", "Path to a gitignore-style file whose patterns are added on top of\nthe .gitignore ones (repeatable).")
+	f.noCopilotignore = fs.Bool("no-copilotignore", false, "Do not read the .copilotignore file of <directory_path>.")
+	f.gitExcludes = fs.Bool("git-excludes", false, "Also honor .git/info/exclude and the global Git excludes file.\nDefaults to true when <directory_path> holds a .git directory.")
+	f.ignoreCase = fs.Bool("ignore-case", false, "Match ignore, --exclude and --include patterns case-insensitively.")
+	f.fromRoot = fs.Bool("follow-gitignore-from-root", false, "Also honor the .gitignore files of the parent directories of\n<directory_path>, up to the root of its Git repository.")
+	f.includeGit = fs.Bool("include-git", false, "Also walk .git directories, which are skipped by default.")
+	f.debug = fs.Bool("gitignore-debug", false, "Print, for each walked path, whether it was ignored or excluded\nand by which pattern.")
+	return f
+}
+
+// options returns the ignore.Options selected by the parsed flags for
+// scanning scanDirAbs.
+func (f *ignoreFlags) options(scanDirAbs string) ignore.Options {
+	gitExcludes := *f.gitExcludes
+	if !flagWasSet(f.fs, "git-excludes") {
+		repoRootAbs := scanDirAbs
+		if *f.fromRoot {
+			repoRootAbs = ignore.FindRepoRoot(scanDirAbs)
 		}
-		effectiveGitignorePath = absPath
+		info, err := os.Stat(filepath.Join(repoRootAbs, ".git"))
+		gitExcludes = err == nil && info.IsDir()
 	}
-
-	matcher := &IgnoreMatcher{
-		patterns:         []string{},
-		gitignoreRootAbs: filepath.Dir(effectiveGitignorePath),
+	return ignore.Options{
+		GitignorePath:   *f.gitignorePath,
+		ExtraGitignores: f.extraGitignores,
+		Copilotignore:   !*f.noCopilotignore,
+		GitExcludes:     gitExcludes,
+		IgnoreCase:      *f.ignoreCase,
+		FromRoot:        *f.fromRoot,
 	}
+}
 
-	fileInfo, err := os.Stat(effectiveGitignorePath)
+// newScanMatcher creates the ignore.Matcher used to scan scanDirAbs with
+// opts, reporting malformed patterns through log.
+func newScanMatcher(opts ignore.Options, scanDirAbs string, log *logger) (*ignore.Matcher, error) {
+	matcher, err := ignore.NewScanMatcher(opts, scanDirAbs)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return matcher, nil
+		return nil, err
+	}
+	matcher.Warnf = log.warnf
+	return matcher, nil
+}
+
+// flagWasSet reports whether the flag name was given on the command line,
+// or in the config file once it was applied.
+func flagWasSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// extractOptions controls which files extractFileContent picks up.
+type extractOptions struct {
+	extensions        []string         // File extensions to include, with their leading dot
+	excludeExtensions []string         // File extensions to skip, even if selected otherwise
+	ignoreMatcher     *ignore.Matcher  // Root .gitignore rules (can be nil)
+	excludes          []string         // Extra globs relative to the scan root, see --exclude
+	includes          []string         // Globs selecting files regardless of their extension, see --include
+	format            string           // Output format: "text" (default), "json", "xml", "print0" or "template"
+	template          *outputTemplate  // Renders the files with the "template" format
+	maxTokens         int              // Estimated token budget for the output, 0 for unlimited
+	warnSize          int64            // Output size in bytes above which a warning is logged, 0 for none
+	maxOutputSize     int64            // Output size in bytes the extracted files must fit in, 0 for unlimited
+	binaryMode        string           // How to handle binary files: "skip" (default), "base64" or "raw"
+	jobs              int              // Number of files read concurrently, GOMAXPROCS if not positive
+	sortBy            string           // Output order: "path" (default), "size" or "mtime"
+	keepIgnored       bool             // Keep ignored and excluded files in the walk, marked as ignored
+	fileList          []string         // Files to extract instead of walking, relative to the scan root (see --stdin-files)
+	lineNumbers       bool             // Prefix each line of text content with its 1-based number
+	stripComments     bool             // Remove comments from text files in supported languages
+	trimTrailingSpace bool             // Remove trailing whitespace from each line of text content
+	squeezeBlank      bool             // Collapse runs of blank lines of text content into one
+	normalizeEncoding bool             // Convert text files to UTF-8 and treat other files as binary, see normalizeEncoding
+	logger            *logger          // Receives warnings and progress messages (can be nil)
+	extIgnoreCase     bool             // Compare extensions case-insensitively
+	followSymlinks    bool             // Walk symlinked directories and report symlinked files with their target's info
+	maxDepth          int              // Deepest directory level walked below the scan root, negative for unlimited
+	noHidden          bool             // Skip files and directories whose name starts with a dot
+	relativeTo        string           // Absolute directory output paths are relative to, the scan root if empty
+	prefix            string           // Clean slash-separated path prepended to output paths, see --prefix
+	dedup             bool             // Replace the content of files identical to an earlier one with a reference to it
+	manifest          *extractManifest // Records the hash and size of the extracted files when not nil
+	sinceManifest     *extractManifest // Only output the files changed since this manifest, and the deleted ones, when not nil
+	cache             *contentCache    // Serves the files unchanged since a previous run from memory (can be nil)
+	summary           *extractSummary  // Counts the skipped files (can be nil)
+	onError           string           // What to do with unreadable files: "skip" (default), "stop" or "collect"
+	failures          *extractFailures // Handles unreadable files according to onError (can be nil)
+	listOnly          bool             // Only list the output paths of the selected files, one per line, without reading them
+	mtimeAfter        time.Time        // Only select files modified at or after this time, unless zero
+	mtimeBefore       time.Time        // Only select files modified before this time, unless zero
+	minSize           int64            // Only select files of at least this many bytes
+	maxSize           int64            // Only select files of at most this many bytes, 0 for unlimited
+	gitPaths          map[string]bool  // Absolute paths of the only files and directories walked when not nil, see --git-tracked-only and --changed-since
+	ignoreDebug       bool             // Log the ignore decision of each walked path, see --gitignore-debug
+	includeGit        bool             // Walk .git directories, skipped by default, see --include-git
+}
+
+// hasExtension reports whether filePath has one of opts.extensions, "*"
+// matching any file.
+func (opts extractOptions) hasExtension(filePath string) bool {
+	ext := filepath.Ext(filePath)
+	for _, targetExt := range opts.extensions {
+		if targetExt == "*" || ext == targetExt || opts.extIgnoreCase && strings.EqualFold(ext, targetExt) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludesExtension reports whether filePath has one of
+// opts.excludeExtensions, which rule the file out even if it was selected by
+// opts.extensions or opts.includes.
+func (opts extractOptions) excludesExtension(filePath string) bool {
+	ext := filepath.Ext(filePath)
+	for _, excludedExt := range opts.excludeExtensions {
+		if ext == excludedExt || opts.extIgnoreCase && strings.EqualFold(ext, excludedExt) {
+			return true
+		}
+	}
+	return false
+}
+
+// filteredOut returns why the file described by info is left out by the
+// --mtime-after, --mtime-before, --min-size and --max-size filters of opts, or
+// an empty string if it is not.
+func (opts extractOptions) filteredOut(info os.FileInfo) string {
+	switch {
+	case info.Size() < opts.minSize:
+		return fmt.Sprintf("%d bytes is below --min-size", info.Size())
+	case opts.maxSize > 0 && info.Size() > opts.maxSize:
+		return fmt.Sprintf("%d bytes is above --max-size", info.Size())
+	case !opts.mtimeAfter.IsZero() && info.ModTime().Before(opts.mtimeAfter):
+		return "modified before --mtime-after"
+	case !opts.mtimeBefore.IsZero() && !info.ModTime().Before(opts.mtimeBefore):
+		return "modified after --mtime-before"
+	}
+	return ""
+}
+
+// binarySniffLen is how many leading bytes are inspected to detect binary files.
+const binarySniffLen = 8000
+
+// isBinary reports whether content looks binary, i.e. has a NUL byte within
+// its first binarySniffLen bytes, like Git's own heuristic.
+func isBinary(content []byte) bool {
+	if len(content) > binarySniffLen {
+		content = content[:binarySniffLen]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+// extractFileContent extracts content from files in a directory based on
+// extensions, and returns the output written by extractTo as a string. With
+// opts.onError set to "collect", the output is returned along with a
+// *collectedErrors error when some files could not be read.
+func extractFileContent(ctx context.Context, scanDirAbs string, opts extractOptions) (string, error) {
+	var out strings.Builder
+	if err := extractTo(ctx, &out, scanDirAbs, opts); err != nil {
+		var collected *collectedErrors
+		if !errors.As(err, &collected) {
+			return "", err
 		}
-		return nil, fmt.Errorf("failed to stat gitignore file '%s': %w", effectiveGitignorePath, err)
+		return out.String(), err
 	}
+	return out.String(), nil
+}
 
-	if fileInfo.IsDir() {
-		return nil, fmt.Errorf("gitignore path '%s' is a directory, not a file", effectiveGitignorePath)
+// extractTo extracts content from files in a directory based on extensions,
+// and writes the output to w as files are read, so that it never holds more
+// than a few files in memory.
+// scanDirAbs must be an absolute path to the directory to scan.
+// Besides the patterns of opts.ignoreMatcher, .gitignore files found in
+// subdirectories are honored for their own subtree. Paths matching one of
+// opts.excludes are always skipped, even if a gitignore negation re-includes them.
+// A file is extracted when it has one of opts.extensions or matches one of
+// opts.includes. The output is rendered according to opts.format, and its
+// estimated token count is reported through opts.logger.
+// Unreadable files are handled according to opts.onError. With "collect",
+// a *collectedErrors error is returned once the output is complete when
+// some could not be read. Once ctx is canceled, no new file is selected or
+// read and ctx.Err() is returned. Output written before an error is not
+// taken back.
+func extractTo(ctx context.Context, w io.Writer, scanDirAbs string, opts extractOptions) error {
+	opts.failures = &extractFailures{policy: opts.onError}
+	if opts.listOnly {
+		candidates, err := selectCandidates(ctx, scanDirAbs, opts)
+		if err != nil {
+			return err
+		}
+		for _, candidate := range candidates {
+			if _, err := io.WriteString(w, candidate.relPath+"\n"); err != nil {
+				return err
+			}
+		}
+		return opts.failures.err()
 	}
 
-	file, err := os.Open(effectiveGitignorePath)
+	opts.summary = &extractSummary{}
+	candidates, err := selectCandidates(ctx, scanDirAbs, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open gitignore file '%s': %w", effectiveGitignorePath, err)
+		return err
+	}
+	out, err := newFileWriter(w, opts)
+	if err != nil {
+		return err
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+	budget := &outputBudget{maxTokens: opts.maxTokens, maxSize: opts.maxOutputSize}
+	seen := map[string]bool{}         // Paths read, to find the files deleted since opts.sinceManifest
+	firstPaths := map[string]string{} // First path read for each hash, see --dedup
+	unchanged, extractedCount, extractedBytes := 0, 0, int64(0)
+	err = streamCandidates(ctx, candidates, opts, func(file FileChange) error {
+		seen[file.FilePath] = true
+		if opts.sinceManifest != nil && opts.sinceManifest.unchanged(file) {
+			unchanged++
+			opts.manifest.add(file)
+			return nil
 		}
-		if strings.HasPrefix(line, "!") {
-			continue
+
+		if opts.dedup {
+			if firstPath, ok := firstPaths[file.sha256]; ok {
+				file.dupOf = firstPath
+				file.Content = ""
+			} else {
+				firstPaths[file.sha256] = file.FilePath
+			}
+		}
+
+		if fits, err := budget.fits(file, opts); err != nil || !fits {
+			return err
+		}
+
+		opts.manifest.add(file)
+		extractedCount++
+		extractedBytes += file.size
+		return out.write(file)
+	})
+	if err != nil {
+		return err
+	}
+	if opts.sinceManifest != nil {
+		deleted := opts.sinceManifest.deleted(seen)
+		opts.logger.debugf("%d file(s) unchanged since the manifest", unchanged)
+		for _, file := range deleted {
+			if err := out.write(file); err != nil {
+				return err
+			}
 		}
-		matcher.patterns = append(matcher.patterns, line)
+	}
+	if err := out.close(); err != nil {
+		return err
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("failed to read gitignore file '%s': %w", effectiveGitignorePath, err)
+	if len(budget.overTokens) > 0 {
+		opts.logger.warnf("token budget of %d reached, skipping %d file(s):%s", opts.maxTokens, len(budget.overTokens), listPaths(budget.overTokens))
+	}
+	if len(budget.overSize) > 0 {
+		opts.logger.warnf("output size limit of %d bytes reached, skipping %d file(s):%s", opts.maxOutputSize, len(budget.overSize), listPaths(budget.overSize))
+	}
+	if opts.warnSize > 0 && out.bytes > opts.warnSize {
+		opts.logger.warnf("output is %d bytes, over the --warn-size of %d bytes.", out.bytes, opts.warnSize)
 	}
 
-	return matcher, nil
+	opts.logger.infof("Extracted %d file(s), %d bytes. Estimated tokens: %d", extractedCount, extractedBytes, tokensForRunes(out.runes))
+	if summary := opts.summary.skipped(len(budget.overTokens)+len(budget.overSize), unchanged); summary != "" {
+		opts.logger.infof("Skipped %s.", summary)
+	}
+	return opts.failures.err()
 }
 
-// IsIgnored checks if a given path should be ignored based on the loaded patterns.
-// absItemPath is the absolute path to the item (file or directory).
-// itemIsDir indicates if the item is a directory.
-func (m *IgnoreMatcher) IsIgnored(absItemPath string, itemIsDir bool) (bool, error) {
-	if len(m.patterns) == 0 {
-		return false, nil
+// listPaths formats paths as an indented list, one per line, each preceded
+// by a newline.
+func listPaths(paths []string) string {
+	var list strings.Builder
+	for _, p := range paths {
+		fmt.Fprintf(&list, "\n  %s", p)
 	}
+	return list.String()
+}
 
-	pathRelToGitignoreRoot, err := filepath.Rel(m.gitignoreRootAbs, absItemPath)
+// formatFiles renders files according to opts.format, see fileWriter.
+func formatFiles(files []FileChange, opts extractOptions) (string, error) {
+	var out strings.Builder
+	fw, err := newFileWriter(&out, opts)
 	if err != nil {
-		return false, nil
+		return "", err
 	}
-	pathRelToGitignoreRoot = filepath.ToSlash(pathRelToGitignoreRoot)
-
-	for _, rawPattern := range m.patterns {
-		pattern := rawPattern
-		isDirOnlyPattern := strings.HasSuffix(pattern, "/")
-		pattern = strings.TrimSuffix(pattern, "/")
-
-		if isDirOnlyPattern && !itemIsDir {
-			continue
+	for _, file := range files {
+		if err := fw.write(file); err != nil {
+			return "", err
 		}
+	}
+	if err := fw.close(); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
 
-		cleanPattern := filepath.ToSlash(pattern)
-		var matched bool
-		var matchErr error
+// estimateTokens approximates the number of LLM tokens in s, see
+// tokensForRunes.
+func estimateTokens(s string) int {
+	return tokensForRunes(utf8.RuneCountInString(s))
+}
 
-		// Handle patterns anchored to the root of the .gitignore directory
-		if strings.HasPrefix(rawPattern, "/") {
-			actualPatternToMatch := strings.TrimPrefix(cleanPattern, "/")
-			matched, matchErr = filepath.Match(actualPatternToMatch, pathRelToGitignoreRoot)
-		} else if strings.Contains(cleanPattern, "/") {
-			// Pattern contains a directory separator, match against the full relative path
-			matched, matchErr = filepath.Match(cleanPattern, pathRelToGitignoreRoot)
-		} else {
-			// Pattern does not contain a directory separator, match against any path component
-			matched, matchErr = filepath.Match(cleanPattern, filepath.Base(pathRelToGitignoreRoot))
-		}
+// tokensForRunes approximates the number of LLM tokens in a text of n
+// characters, using the common rule of thumb of about four characters per
+// token.
+func tokensForRunes(n int) int {
+	return (n + 3) / 4
+}
 
-		if matchErr != nil {
-			fmt.Fprintf(os.Stderr, "Warning: malformed gitignore pattern '%s' (processed as '%s'): %v\n", rawPattern, cleanPattern, matchErr)
-			continue
+// streamCandidates reads candidates as readCandidates does, up to opts.jobs
+// files at a time (GOMAXPROCS when not positive), and passes the files to
+// emit in the order of candidates as soon as they are read, so that only the
+// files of the current batch are held in memory. It stops at the first error
+// returned by emit, or with ctx.Err() once ctx is canceled.
+func streamCandidates(ctx context.Context, candidates []fileCandidate, opts extractOptions, emit func(FileChange) error) error {
+	batch := opts.jobs
+	if batch <= 0 {
+		batch = runtime.GOMAXPROCS(0)
+	}
+	for start := 0; start < len(candidates); start += batch {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
+		files, err := readCandidates(candidates[start:min(start+batch, len(candidates))], opts)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := emit(file); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
 
-		if matched {
-			return true, nil
+// selectCandidates returns the files selected by opts, without reading them,
+// from opts.fileList or by walking scanDirAbs. The relPath of each returned
+// candidate is slash-separated and relative to opts.relativeTo, or to
+// scanDirAbs if it is empty, and starts with opts.prefix. It returns
+// ctx.Err() once ctx is canceled.
+func selectCandidates(ctx context.Context, scanDirAbs string, opts extractOptions) ([]fileCandidate, error) {
+	var candidates []fileCandidate
+	var err error
+	if opts.fileList != nil {
+		candidates, err = listCandidates(ctx, scanDirAbs, opts)
+	} else {
+		candidates, err = walkCandidates(ctx, scanDirAbs, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.relativeTo != "" {
+		for i, candidate := range candidates {
+			relPath, relErr := filepath.Rel(opts.relativeTo, candidate.absPath)
+			if relErr != nil || !apply.IsWithin(opts.relativeTo, candidate.absPath) {
+				return nil, fmt.Errorf("'%s' is not inside the --relative-to directory '%s'", candidate.absPath, opts.relativeTo)
+			}
+			candidates[i].relPath = filepath.ToSlash(relPath)
 		}
 	}
-	return false, nil
+	if opts.prefix != "" {
+		for i := range candidates {
+			candidates[i].relPath = path.Join(opts.prefix, candidates[i].relPath)
+		}
+	}
+	return candidates, nil
 }
 
-// extractFileContent extracts content from files in a directory based on extensions.
-// scanDirAbs must be an absolute path to the directory to scan.
-func extractFileContent(scanDirAbs string, extensions []string, ignoreMatcher *IgnoreMatcher) (string, error) {
-	var allContent strings.Builder
+// debugIgnore logs, with opts.ignoreDebug, the ignore decision for the path
+// at pathAbs, shown relative to scanDirAbs with a trailing slash for
+// directories: decision, followed by the rule that made it, if any, along
+// with the ignore file and line it comes from.
+func (opts extractOptions) debugIgnore(scanDirAbs, pathAbs string, isDir bool, decision string, rule *ignore.Rule) {
+	if !opts.ignoreDebug || pathAbs == scanDirAbs {
+		return
+	}
+	relPath := displayPath(scanDirAbs, pathAbs)
+	if isDir {
+		relPath += "/"
+	}
+	switch {
+	case rule == nil:
+		opts.logger.infof("gitignore-debug: %s: %s", relPath, decision)
+	case rule.Source == "":
+		opts.logger.infof("gitignore-debug: %s: %s '%s'", relPath, decision, rule.Pattern)
+	default:
+		opts.logger.infof("gitignore-debug: %s: %s '%s' (%s:%d)", relPath, decision, rule.Pattern, displayPath(scanDirAbs, rule.Source), rule.Line)
+	}
+}
+
+// displayPath returns pathAbs relative to scanDirAbs in slash form, or
+// pathAbs itself when it lies outside scanDirAbs.
+func displayPath(scanDirAbs, pathAbs string) string {
+	relPath, err := filepath.Rel(scanDirAbs, pathAbs)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return pathAbs
+	}
+	return filepath.ToSlash(relPath)
+}
 
-	err := filepath.Walk(scanDirAbs, func(currentPathAbs string, info os.FileInfo, err error) error {
+// walkCandidates walks scanDirAbs and returns the files selected by opts,
+// without reading them, in the order given by opts.sortBy. The walk stops
+// with ctx.Err() once ctx is canceled.
+func walkCandidates(ctx context.Context, scanDirAbs string, opts extractOptions) ([]fileCandidate, error) {
+	var candidates []fileCandidate
+
+	ignoreMatcher := opts.ignoreMatcher
+	excludeMatcher := ignore.FromPatterns(opts.excludes, scanDirAbs)
+	excludeMatcher.Warnf = opts.logger.warnf
+	includeMatcher := ignore.FromPatterns(opts.includes, scanDirAbs)
+	includeMatcher.Warnf = opts.logger.warnf
+	if ignoreMatcher != nil {
+		excludeMatcher.IgnoreCase = ignoreMatcher.IgnoreCase
+		includeMatcher.IgnoreCase = ignoreMatcher.IgnoreCase
+	}
+
+	// Matcher to use for the entries of each visited directory
+	dirMatchers := map[string]*ignore.Matcher{}
+	// Ignored directories still walked because of opts.keepIgnored
+	ignoredDirs := map[string]bool{}
+
+	err := walkFiles(scanDirAbs, opts.followSymlinks, func(currentPathAbs string, info os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: error accessing path %s: %v. Skipping.\n", currentPathAbs, err)
+			if err := opts.failures.handle(fmt.Errorf("error accessing path %s: %w", currentPathAbs, err), opts.logger); err != nil {
+				return err
+			}
 			if info != nil && info.IsDir() {
 				return filepath.SkipDir
 			}
 			return nil // Skip this file/dir entry, continue walk
 		}
 
-		if ignoreMatcher != nil {
-			isIgnored, ignoreErr := ignoreMatcher.IsIgnored(currentPathAbs, info.IsDir())
+		if info.IsDir() && opts.maxDepth >= 0 && currentPathAbs != scanDirAbs {
+			relDir, _ := filepath.Rel(scanDirAbs, currentPathAbs)
+			if strings.Count(relDir, string(filepath.Separator))+1 > opts.maxDepth {
+				opts.logger.debugf("Skipping %s beyond --max-depth", currentPathAbs)
+				return filepath.SkipDir
+			}
+		}
+
+		if opts.noHidden && currentPathAbs != scanDirAbs && strings.HasPrefix(info.Name(), ".") {
+			opts.logger.debugf("Skipping hidden %s", currentPathAbs)
+			opts.summary.skipIgnored()
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Repositories rarely list .git in their .gitignore, and its objects
+		// and config are never wanted in the output. A .git file, as found in
+		// worktrees and submodules, only points to the real directory.
+		if !opts.includeGit && currentPathAbs != scanDirAbs && info.Name() == ".git" {
+			opts.logger.debugf("Skipping %s, see --include-git", currentPathAbs)
+			opts.debugIgnore(scanDirAbs, currentPathAbs, info.IsDir(), "skipped, see --include-git", nil)
+			opts.summary.skipIgnored()
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if opts.gitPaths != nil && !opts.gitPaths[currentPathAbs] {
+			opts.logger.debugf("Skipping %s, not selected by --git-tracked-only or --changed-since", currentPathAbs)
+			opts.summary.skipIgnored()
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		ignored := ignoredDirs[filepath.Dir(currentPathAbs)]
+		excluded, excludedBy, _ := excludeMatcher.IsIgnoredRule(currentPathAbs, info.IsDir())
+		if excluded {
+			opts.debugIgnore(scanDirAbs, currentPathAbs, info.IsDir(), "excluded by --exclude", excludedBy)
+			if !opts.keepIgnored {
+				opts.logger.debugf("Excluding %s", currentPathAbs)
+				opts.summary.skipIgnored()
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil // Excluded file
+			}
+			ignored = true
+		}
+
+		matcher := ignoreMatcher
+		if parentMatcher, ok := dirMatchers[filepath.Dir(currentPathAbs)]; ok {
+			matcher = parentMatcher
+		}
+
+		isIgnored, ignoredBy := false, (*ignore.Rule)(nil)
+		if matcher != nil {
+			var ignoreErr error
+			isIgnored, ignoredBy, ignoreErr = matcher.IsIgnoredRule(currentPathAbs, info.IsDir())
 			if ignoreErr != nil {
 				// Don't fail the whole walk, just log it and potentially skip.
 				// Depending on desired strictness, could return ignoreErr.
-				fmt.Fprintf(os.Stderr, "Warning: error checking ignore status for %s: %v. Proceeding without ignore check for this item.\n", currentPathAbs, ignoreErr)
+				opts.logger.warnf("error checking ignore status for %s: %v. Proceeding without ignore check for this item.", currentPathAbs, ignoreErr)
 			} else if isIgnored {
-				if info.IsDir() {
-					return filepath.SkipDir
+				opts.debugIgnore(scanDirAbs, currentPathAbs, info.IsDir(), "ignored by", ignoredBy)
+				if !opts.keepIgnored {
+					opts.logger.debugf("Ignoring %s", currentPathAbs)
+					opts.summary.skipIgnored()
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil // Ignored file
 				}
-				return nil // Ignored file
+				ignored = true
+			}
+		}
+		if !excluded && !isIgnored {
+			if ignoredBy != nil {
+				opts.debugIgnore(scanDirAbs, currentPathAbs, info.IsDir(), "not ignored, re-included by", ignoredBy)
+			} else {
+				opts.debugIgnore(scanDirAbs, currentPathAbs, info.IsDir(), "not ignored", nil)
 			}
 		}
 
 		if info.IsDir() {
+			if ignored {
+				ignoredDirs[currentPathAbs] = true
+			}
+			if matcher != nil {
+				dirMatchers[currentPathAbs] = matcher
+			}
 			// If it's the root directory itself, don't skip, just proceed.
 			if currentPathAbs == scanDirAbs {
 				return nil
 			}
-			// Add specific directory names to ignore if needed, e.g. ".git", "node_modules"
-			// This is better handled by .gitignore patterns, but as a fallback:
+			if matcher != nil {
+				nestedMatcher, nestedErr := matcher.WithNestedGitignore(currentPathAbs)
+				if nestedErr != nil {
+					opts.logger.warnf("%v. Ignoring nested .gitignore in %s.", nestedErr, currentPathAbs)
+				} else {
+					dirMatchers[currentPathAbs] = nestedMatcher
+				}
+			}
 			return nil // Regular directory, continue walking
 		}
 
 		// File processing
-		ext := filepath.Ext(currentPathAbs)
-		foundExt := false
-		for _, targetExt := range extensions {
-			if ext == targetExt {
-				foundExt = true
-				break
-			}
+		foundExt := opts.hasExtension(currentPathAbs)
+		if !foundExt {
+			foundExt, _ = includeMatcher.IsIgnored(currentPathAbs, false)
+		}
+		if opts.excludesExtension(currentPathAbs) {
+			foundExt = false
 		}
 
 		if foundExt {
-			content, readErr := os.ReadFile(currentPathAbs)
-			if readErr != nil {
-				fmt.Fprintf(os.Stderr, "Warning: failed to read file %s: %v. Skipping.\n", currentPathAbs, readErr)
-				return nil // Skip this file, continue walk
+			if reason := opts.filteredOut(info); reason != "" {
+				opts.logger.debugf("Skipping %s, %s", currentPathAbs, reason)
+				opts.summary.skipFiltered()
+				return nil
 			}
-
 			relPath, relErr := filepath.Rel(scanDirAbs, currentPathAbs)
 			if relErr != nil {
 				// This should ideally not happen if currentPathAbs is under scanDirAbs.
-				fmt.Fprintf(os.Stderr, "Warning: failed to get relative path for %s (base %s): %v. Using absolute path.\n", currentPathAbs, scanDirAbs, relErr)
+				opts.logger.warnf("failed to get relative path for %s (base %s): %v. Using absolute path.", currentPathAbs, scanDirAbs, relErr)
 				relPath = currentPathAbs // Fallback to absolute path
 			}
-
-			allContent.WriteString(fmt.Sprintf("\n<file_path>%s</file_path>\n", filepath.ToSlash(relPath)))
-			allContent.Write(content)
-			allContent.WriteString(fmt.Sprintf("\n<file_path_end>%s</file_path_end>\n", filepath.ToSlash(relPath)))
+			candidates = append(candidates, fileCandidate{
+				absPath: currentPathAbs,
+				relPath: filepath.ToSlash(relPath),
+				size:    info.Size(),
+				modTime: info.ModTime(),
+				ignored: ignored,
+			})
 		}
 		return nil
 	})
 
 	if err != nil {
-		return "", fmt.Errorf("error during directory walk: %w", err)
+		return nil, fmt.Errorf("error during directory walk: %w", err)
 	}
 
-	return allContent.String(), nil
+	if err := sortCandidates(candidates, opts.sortBy); err != nil {
+		return nil, err
+	}
+	return candidates, nil
 }
 
-// writeInPlace safely writes content to a file by using a temporary file
-// and an atomic rename operation. It also preserves original file permissions.
-func writeInPlace(filePath string, content []byte) error {
-	info, err := os.Stat(filePath)
-	var originalMode os.FileMode = 0644 // Default permissions if file doesn't exist
-	if err == nil {
-		originalMode = info.Mode()
-	} else if !os.IsNotExist(err) {
-		return fmt.Errorf("could not stat target file path '%s': %w", filePath, err)
-	}
-	// If file does not exist, os.Stat returns an error. We proceed to create it.
+// listCandidates returns the files of opts.fileList, resolved against
+// scanDirAbs, in the order given by opts.sortBy. Ignore rules do not apply,
+// and the extension filter only does when opts.extensions is not empty.
+// Duplicates are dropped, and missing files or directories are skipped with a
+// warning. It returns ctx.Err() once ctx is canceled.
+func listCandidates(ctx context.Context, scanDirAbs string, opts extractOptions) ([]fileCandidate, error) {
+	var candidates []fileCandidate
+	seen := map[string]bool{}
+	for _, filePath := range opts.fileList {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		absPath := filePath
+		if !filepath.IsAbs(absPath) {
+			absPath = filepath.Join(scanDirAbs, absPath)
+		}
+		absPath = filepath.Clean(absPath)
+		if seen[absPath] {
+			continue
+		}
+		seen[absPath] = true
 
-	// Ensure directory exists
-	dir := filepath.Dir(filePath)
-	if dir != "" && dir != "." {
-		if err := os.MkdirAll(dir, 0755); err != nil { // 0755 for directories
-			return fmt.Errorf("could not create directory %s: %w", dir, err)
+		if len(opts.extensions) > 0 && !opts.hasExtension(absPath) || opts.excludesExtension(absPath) {
+			continue
 		}
-	}
 
-	tempFile, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
-	if err != nil {
-		return fmt.Errorf("could not create temporary file in %s: %w", filepath.Dir(filePath), err)
-	}
-	// Defer removal in case of errors before rename
-	defer func() {
-		if tempFile != nil { // Check if tempFile was successfully created
-			// If rename fails, or an error occurs after creation but before successful rename
-			_, statErr := os.Stat(tempFile.Name())
-			if statErr == nil { // if temp file still exists
-				os.Remove(tempFile.Name())
+		info, err := os.Stat(absPath)
+		if err != nil {
+			if err := opts.failures.handle(fmt.Errorf("error accessing path %s: %w", absPath, err), opts.logger); err != nil {
+				return nil, err
 			}
+			continue
+		}
+		if info.IsDir() {
+			opts.logger.warnf("%s is a directory. Skipping.", absPath)
+			continue
+		}
+		if reason := opts.filteredOut(info); reason != "" {
+			opts.logger.debugf("Skipping %s, %s", absPath, reason)
+			opts.summary.skipFiltered()
+			continue
 		}
-	}()
 
-	if _, err := tempFile.Write(content); err != nil {
-		tempFile.Close() // Close before attempting remove
-		return fmt.Errorf("could not write to temporary file '%s': %w", tempFile.Name(), err)
+		relPath, relErr := filepath.Rel(scanDirAbs, absPath)
+		if relErr != nil {
+			opts.logger.warnf("failed to get relative path for %s (base %s): %v. Using absolute path.", absPath, scanDirAbs, relErr)
+			relPath = absPath // Fallback to absolute path
+		}
+		candidates = append(candidates, fileCandidate{
+			absPath: absPath,
+			relPath: filepath.ToSlash(relPath),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
 	}
 
-	if err := tempFile.Chmod(originalMode); err != nil {
-		tempFile.Close()
-		return fmt.Errorf("could not set permissions on temporary file '%s': %w", tempFile.Name(), err)
+	if err := sortCandidates(candidates, opts.sortBy); err != nil {
+		return nil, err
 	}
+	return candidates, nil
+}
 
-	if err := tempFile.Close(); err != nil { // Close before rename
-		return fmt.Errorf("could not close temporary file '%s': %w", tempFile.Name(), err)
+// readFileList reads newline-separated file paths from r, skipping blank lines.
+func readFileList(r io.Reader) ([]string, error) {
+	fileList := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			fileList = append(fileList, line)
+		}
 	}
-
-	if err := os.Rename(tempFile.Name(), filePath); err != nil {
-		return fmt.Errorf("could not rename temporary file '%s' to '%s': %w", tempFile.Name(), filePath, err)
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
-
-	tempFile = nil // Indicate successful rename, so defer doesn't try to remove it.
-	return nil
+	return fileList, nil
 }
 
-func printMainUsage() {
-	fmt.Println(`
-Usage:
-  copilot <command> [options] <args...>
-
-Commands:
-  apply        Apply changes from a JSON file to target files.
-  extract      Extract content from files in a directory based on extensions.
-
-Run 'copilot <command> --help' for more information on a specific command.
-`)
+// commonDir returns the deepest directory holding all of the absolute file
+// paths filePaths. Paths without a common directory, such as paths on
+// different Windows volumes, yield the root of the first one.
+func commonDir(filePaths []string) string {
+	dir := filepath.Dir(filePaths[0])
+	for _, filePath := range filePaths[1:] {
+		for !apply.IsWithin(dir, filePath) && filepath.Dir(dir) != dir {
+			dir = filepath.Dir(dir)
+		}
+	}
+	return dir
 }
 
-func printApplyUsage(fs *flag.FlagSet) {
-	fmt.Println(`
-Usage:
-  copilot apply <json_file>
-
-Apply file content changes from a JSON file.
-The JSON file should contain an object with a "changes" array,
-where each element specifies a "file_path" and its new "content".
-Each specified file will be overwritten with the content from the JSON file.
-Parent directories for the files will be created if they don't exist.
-Paths in the JSON file are typically relative to the current working directory.
-
-Arguments:
-  <json_file>       Path to the JSON file containing file content changes.
-
-Example:
-  copilot apply ./changes.json
-`)
+// fileCandidate is a file selected by the walk, waiting to be read.
+type fileCandidate struct {
+	absPath string
+	relPath string // Slash-separated, relative to the scan root
+	size    int64
+	modTime time.Time
+	ignored bool // Only set when the walk keeps ignored files
 }
 
-func printExtractUsage(fs *flag.FlagSet) {
-	fmt.Println(`
-Usage:
-  copilot extract [extract_options] <directory_path> <file_extensions>
-
-Extract content from files in a directory based on extensions.
-Respects .gitignore rules found in <directory_path> or specified via --gitignore.
-Outputs a structured format containing file paths and their content.
-
-Arguments:
-  <directory_path>     Path to the directory to scan.
-  <file_extensions>    Comma-separated list of file extensions (e.g., .js,.ts,.md).
+// sortCandidates orders candidates by sortBy: "path" (or empty) sorts by
+// relative path, "size" from smallest to largest and "mtime" from oldest to
+// most recently modified. Ties are broken by relative path so the order is
+// always fully determined.
+func sortCandidates(candidates []fileCandidate, sortBy string) error {
+	var less func(a, b fileCandidate) bool
+	switch sortBy {
+	case "", "path":
+		// Only the relative path tie-breaker below applies
+		less = func(a, b fileCandidate) bool { return false }
+	case "size":
+		less = func(a, b fileCandidate) bool { return a.size < b.size }
+	case "mtime":
+		less = func(a, b fileCandidate) bool { return a.modTime.Before(b.modTime) }
+	default:
+		return fmt.Errorf("unknown sort mode '%s'", sortBy)
+	}
 
-Options:`)
-	fs.PrintDefaults()
-	fmt.Println(`
-Examples:
-  copilot extract ./src .js,.ts,.json > extracted_content.txt
-  copilot extract --gitignore ./.custom_ignore ./project .go,.java > context.txt
-`)
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.relPath < b.relPath
+	})
+	return nil
 }
 
-func main() {
-	if len(os.Args) < 2 || os.Args[1] == "--help" || os.Args[1] == "-h" {
-		printMainUsage()
-		os.Exit(0)
+// readCandidates reads candidates concurrently with up to opts.jobs workers
+// (GOMAXPROCS when not positive) and returns the files in the order of
+// candidates, so the result does not depend on the number of workers.
+// Skipped binary files are left out with a warning, and unreadable ones
+// according to opts.failures: once one stops extraction, no new file is read
+// and the error of the first one is returned.
+func readCandidates(candidates []fileCandidate, opts extractOptions) ([]FileChange, error) {
+	jobs := opts.jobs
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
 	}
+	jobs = min(jobs, len(candidates))
+
+	results := make([]*FileChange, len(candidates))
+	errs := make([]error, len(candidates))
+	var failed atomic.Bool
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if failed.Load() {
+					continue
+				}
+				results[i], errs[i] = readCandidate(candidates[i], opts)
+				if errs[i] != nil {
+					failed.Store(true)
+				}
+			}
+		}()
+	}
+	for i := range candidates {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
 
-	command := os.Args[1]
-
-	switch command {
-	case "apply":
-		applyCmd := flag.NewFlagSet("apply", flag.ExitOnError)
-		applyCmd.Usage = func() { printApplyUsage(applyCmd) }
-
-		err := applyCmd.Parse(os.Args[2:])
+	for _, err := range errs {
 		if err != nil {
-			os.Exit(1)
+			return nil, err
 		}
-
-		if applyCmd.NArg() != 1 {
-			fmt.Fprintln(os.Stderr, "Error: Missing <json_file> argument for apply command.")
-			applyCmd.Usage()
-			os.Exit(1)
+	}
+	files := make([]FileChange, 0, len(results))
+	for _, file := range results {
+		if file != nil {
+			files = append(files, *file)
 		}
-		jsonFilePath := applyCmd.Arg(0)
+	}
+	return files, nil
+}
 
-		jsonFileBytes, err := os.ReadFile(jsonFilePath)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading JSON file '%s': %v\n", jsonFilePath, err)
-			os.Exit(1)
+// readExtractedFile reads the files selected by extract. It is a variable so
+// that read failures can be simulated.
+var readExtractedFile = os.ReadFile
+
+// readCandidate reads a single file, or gets it from opts.cache, applying
+// opts.binaryMode. It returns nil if the file must be skipped, and an error
+// if it cannot be read and opts.failures stops extraction.
+func readCandidate(candidate fileCandidate, opts extractOptions) (*FileChange, error) {
+	content, cached := opts.cache.get(candidate.absPath, candidate.size, candidate.modTime)
+	if !cached {
+		var readErr error
+		content, readErr = readExtractedFile(candidate.absPath)
+		if readErr != nil {
+			if err := opts.failures.handle(fmt.Errorf("failed to read file %s: %w", candidate.absPath, readErr), opts.logger); err != nil {
+				return nil, err
+			}
+			opts.summary.skipUnreadable()
+			return nil, nil // Skip this file
 		}
+		opts.cache.put(candidate.absPath, candidate.size, candidate.modTime, content)
+	}
 
-		var mdiffData MdiffJSON
-		err = json.Unmarshal(jsonFileBytes, &mdiffData)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error parsing JSON from file '%s': %v\n", jsonFilePath, err)
-			os.Exit(1)
+	sum := sha256.Sum256(content)
+	file := &FileChange{FilePath: candidate.relPath, Content: string(content), sha256: hex.EncodeToString(sum[:]), size: int64(len(content))}
+	binaryContent := isBinary(content)
+	if opts.normalizeEncoding {
+		normalized, ok := normalizeEncoding(content)
+		if ok {
+			file.Content = string(normalized)
 		}
-
-		if len(mdiffData.Changes) == 0 {
-			fmt.Fprintln(os.Stderr, "Warning: No changes found in the JSON file.")
-			os.Exit(0)
+		binaryContent = !ok
+	}
+	if binaryContent {
+		switch opts.binaryMode {
+		case "base64":
+			file.Content = base64.StdEncoding.EncodeToString(content)
+			file.Encoding = "base64"
+		case "raw":
+			// Embed the content as-is
+		default:
+			opts.logger.warnf("skipping binary file %s.", file.FilePath)
+			opts.summary.skipBinary()
+			return nil, nil
+		}
+	} else {
+		if opts.stripComments {
+			file.Content = stripFileComments(file.FilePath, file.Content)
+		}
+		if opts.trimTrailingSpace {
+			file.Content = trimTrailingWhitespace(file.Content)
+		}
+		if opts.squeezeBlank {
+			file.Content = squeezeBlankLines(file.Content)
+		}
+		if opts.lineNumbers {
+			file.Content = numberLines(file.Content)
+		}
+	}
+	opts.logger.debugf("Extracted %s (%d bytes)", file.FilePath, len(content))
+	return file, nil
+}
+
+// numberLines prefixes each line of content with its 1-based number, right
+// aligned to the width of the largest one. A missing trailing newline is kept
+// missing.
+func numberLines(content string) string {
+	lines := splitLines(content)
+	width := len(strconv.Itoa(len(lines)))
+	var numbered strings.Builder
+	for i, line := range lines {
+		fmt.Fprintf(&numbered, "%*d | %s", width, i+1, line)
+	}
+	return numbered.String()
+}
+
+// trimTrailingWhitespace removes the whitespace at the end of each line of
+// content, keeping line endings, including "\r\n" ones.
+func trimTrailingWhitespace(content string) string {
+	var trimmed strings.Builder
+	for _, line := range splitLines(content) {
+		ending := ""
+		switch {
+		case strings.HasSuffix(line, "\r\n"):
+			ending = "\r\n"
+		case strings.HasSuffix(line, "\n"):
+			ending = "\n"
+		}
+		trimmed.WriteString(strings.TrimRightFunc(strings.TrimSuffix(line, ending), unicode.IsSpace))
+		trimmed.WriteString(ending)
+	}
+	return trimmed.String()
+}
+
+// squeezeBlankLines collapses each run of blank lines of content, i.e. lines
+// holding only whitespace, into its first line, like cat -s.
+func squeezeBlankLines(content string) string {
+	var squeezed strings.Builder
+	previousBlank := false
+	for _, line := range splitLines(content) {
+		blank := strings.TrimSpace(line) == ""
+		if !blank || !previousBlank {
+			squeezed.WriteString(line)
+		}
+		previousBlank = blank
+	}
+	return squeezed.String()
+}
+
+// formatText renders files with each content wrapped in <file_path> tags.
+// Content lines that could be mistaken for a tag are escaped, see
+// escapeTagLines.
+func formatText(files []FileChange) string {
+	var allContent strings.Builder
+	for _, file := range files {
+		if file.Delete {
+			allContent.WriteString(fmt.Sprintf("\n<file_path_deleted>%s</file_path_deleted>\n", file.FilePath))
+			continue
+		}
+		allContent.WriteString(fmt.Sprintf("\n<file_path>%s</file_path>\n", file.FilePath))
+		if file.dupOf != "" {
+			allContent.WriteString(fmt.Sprintf("<file_path_dup_of>%s</file_path_dup_of>", file.dupOf))
+		} else {
+			allContent.WriteString(escapeTagLines(file.Content))
+		}
+		allContent.WriteString(fmt.Sprintf("\n<file_path_end>%s</file_path_end>\n", file.FilePath))
+	}
+	return allContent.String()
+}
+
+// escapeTagLines prefixes with a backslash the lines of content starting
+// with "<file_path", optionally after backslashes, so that in the text format
+// a line starting with "<file_path" is always a tag. Readers get the original
+// content back by removing one backslash from lines matching `^\\+<file_path`.
+func escapeTagLines(content string) string {
+	if !strings.Contains(content, "<file_path") {
+		return content
+	}
+	var escaped strings.Builder
+	for _, line := range splitLines(content) {
+		if strings.HasPrefix(strings.TrimLeft(line, `\`), "<file_path") {
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteString(line)
+	}
+	return escaped.String()
+}
+
+// writeXMLFile writes the <file> element of the XML format for file to
+// out, holding its path and size as attributes and its content as CDATA.
+func writeXMLFile(out *strings.Builder, file FileChange) {
+	out.WriteString(`<file path="`)
+	xml.EscapeText(out, []byte(file.FilePath))
+	if file.Delete {
+		out.WriteString("\" deleted=\"true\"/>\n")
+		return
+	}
+	fmt.Fprintf(out, `" size="%d"`, file.size)
+	if file.Encoding != "" {
+		fmt.Fprintf(out, ` encoding="%s"`, file.Encoding)
+	}
+	out.WriteString(">")
+	if file.Content != "" {
+		writeCDATA(out, file.Content)
+	}
+	out.WriteString("</file>\n")
+}
+
+// writeCDATA writes s to out as CDATA sections. "]]>" is split across two
+// sections, and characters XML does not allow, such as most control
+// characters or invalid UTF-8, are replaced with U+FFFD.
+func writeCDATA(out *strings.Builder, s string) {
+	out.WriteString("<![CDATA[")
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case strings.HasPrefix(s[i:], "]]>"):
+			out.WriteString("]]]]><![CDATA[>")
+			size = 3
+		case r == utf8.RuneError && size == 1,
+			r < 0x20 && r != '\t' && r != '\n' && r != '\r',
+			r == 0xFFFE || r == 0xFFFF:
+			out.WriteRune(utf8.RuneError)
+		default:
+			out.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	out.WriteString("]]>")
+}
+
+// formatPrint0 renders files as NUL-terminated path and content records, for
+// tools like xargs -0. Deletions cannot be represented and are left out.
+func formatPrint0(files []FileChange) string {
+	var out strings.Builder
+	for _, file := range files {
+		if file.Delete {
+			continue
+		}
+		out.WriteString(file.FilePath)
+		out.WriteByte(0)
+		out.WriteString(file.Content)
+		out.WriteByte(0)
+	}
+	return out.String()
+}
+
+// formatJSON renders files as an MdiffJSON document, which can be fed back
+// to the apply command as-is.
+func formatJSON(files []FileChange) (string, error) {
+	if files == nil {
+		files = []FileChange{}
+	}
+
+	var out strings.Builder
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(MdiffJSON{Changes: files}); err != nil {
+		return "", fmt.Errorf("failed to encode JSON output: %w", err)
+	}
+	return out.String(), nil
+}
+
+// applyOut receives the human-readable messages and diffs printed by apply.
+// It is switched to stderr when a machine-readable report is printed on stdout.
+var applyOut io.Writer = os.Stdout
+
+// resolveChangePaths returns a copy of changes where relative paths are
+// joined to baseDir. Absolute paths are kept as-is.
+func resolveChangePaths(changes []FileChange, baseDir string) []FileChange {
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(baseDir, p)
+	}
+
+	resolved := make([]FileChange, len(changes))
+	for i, change := range changes {
+		change.FilePath = resolve(change.FilePath)
+		change.From = resolve(change.From)
+		change.To = resolve(change.To)
+		resolved[i] = change
+	}
+	return resolved
+}
+
+// validateChanges checks changes before anything is applied and returns one
+// error per problem found: missing paths, content that cannot be decoded, and
+// duplicate paths, i.e. the same file_path written or deleted twice, or the
+// same path renamed from or to twice. Each duplicate path is reported once,
+// with every change using it. A file may still be written and then renamed.
+func validateChanges(changes []FileChange) []error {
+	var errs []error
+	validator := newChangeValidator()
+	for i, change := range changes {
+		for _, err := range validator.check(i, change) {
+			var duplicate *duplicatePathError
+			if !errors.As(err, &duplicate) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return append(errs, validator.duplicates()...)
+}
+
+// changeValidator checks the changes of a changeset one at a time, in
+// order, as validateChanges does.
+type changeValidator struct {
+	uses map[string][]int // Indexes of the changes using each field and path
+	keys []string         // Keys of uses, in the order they were first used
+}
+
+func newChangeValidator() *changeValidator {
+	return &changeValidator{uses: map[string][]int{}}
+}
+
+// check returns the problems of change, the i-th (0-based) of the changeset.
+// A path already used by a previous change yields a *duplicatePathError.
+func (v *changeValidator) check(i int, change FileChange) []error {
+	var errs []error
+	checkDuplicate := func(field, p string) {
+		key := field + "\x00" + filepath.Clean(p)
+		if _, ok := v.uses[key]; !ok {
+			v.keys = append(v.keys, key)
+		}
+		v.uses[key] = append(v.uses[key], i)
+		if len(v.uses[key]) > 1 {
+			errs = append(errs, &duplicatePathError{field: field, path: p, changes: slices.Clone(v.uses[key])})
+		}
+	}
+
+	if change.isRename() {
+		if change.From == "" || change.To == "" {
+			return append(errs, fmt.Errorf("change #%d: rename is missing 'from' or 'to'", i+1))
+		}
+		checkDuplicate("from", change.From)
+		checkDuplicate("to", change.To)
+		return errs
+	}
+	if change.FilePath == "" {
+		return append(errs, fmt.Errorf("change #%d: missing 'file_path'", i+1))
+	}
+	if !change.Delete {
+		if _, err := change.decodedContent(); err != nil {
+			errs = append(errs, fmt.Errorf("change #%d: cannot decode content for '%s': %w", i+1, change.FilePath, err))
+		}
+	}
+	checkDuplicate("file_path", change.FilePath)
+	return errs
+}
+
+// duplicates returns an error for each path used by several of the changes
+// checked so far, in the order the paths were first used.
+func (v *changeValidator) duplicates() []error {
+	var errs []error
+	for _, key := range v.keys {
+		if changes := v.uses[key]; len(changes) > 1 {
+			field, p, _ := strings.Cut(key, "\x00")
+			errs = append(errs, &duplicatePathError{field: field, path: p, changes: changes})
+		}
+	}
+	return errs
+}
+
+// duplicatePathError reports a path used by several changes of a changeset.
+type duplicatePathError struct {
+	field   string // Field holding the path: file_path, from or to
+	path    string
+	changes []int // 0-based indexes of the changes using the path
+}
+
+func (e *duplicatePathError) Error() string {
+	numbers := make([]string, len(e.changes))
+	for i, change := range e.changes {
+		numbers[i] = fmt.Sprintf("#%d", change+1)
+	}
+	list := strings.Join(numbers[:len(numbers)-1], ", ") + " and " + numbers[len(numbers)-1]
+	return fmt.Sprintf("'%s' path '%s' appears %d times, in changes %s", e.field, e.path, len(e.changes), list)
+}
+
+// warnDuplicatePaths prints the *duplicatePathError of errs as warnings, as
+// apply does without --strict, and returns the other errors. The changes
+// using a duplicate path are still applied in order, so the last one wins.
+func warnDuplicatePaths(errs []error, log *logger) []error {
+	var others []error
+	for _, err := range errs {
+		var duplicate *duplicatePathError
+		if errors.As(err, &duplicate) {
+			log.warnf("%v; they are applied in order, so the last one wins.", err)
+			continue
+		}
+		others = append(others, err)
+	}
+	return others
+}
+
+// checkCreateOnly returns one error per content change whose target file,
+// relative to baseDir unless absolute, already exists.
+func checkCreateOnly(changes []FileChange, baseDir string) []error {
+	var errs []error
+	for _, change := range changes {
+		if change.isRename() || change.Delete || change.FilePath == "" {
+			continue
+		}
+		target := change.FilePath
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(baseDir, target)
+		}
+		if _, err := os.Lstat(target); err == nil {
+			errs = append(errs, fmt.Errorf("file '%s' already exists (--create-only)", change.FilePath))
+		}
+	}
+	return errs
+}
+
+// checkChangePaths checks that every path touched by changes, with relative
+// paths taken from baseDir, lies inside baseDir. It returns one error per
+// offending path.
+func checkChangePaths(changes []FileChange, baseDir string) []error {
+	var errs []error
+	for _, change := range changes {
+		for _, p := range []string{change.FilePath, change.From, change.To} {
+			if p == "" {
+				continue
+			}
+			if err := apply.CheckPathWithin(baseDir, p); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+// applyOptions holds the settings of the apply command.
+type applyOptions struct {
+	showDiff bool // Print a unified diff of each change before applying it
+	atomic   bool // Roll back applied changes when one fails
+	backup   bool // Copy files to backupSuffix-suffixed siblings before replacing them
+
+	backupSuffix string
+	createOnly   bool // Skip content changes whose target file already exists
+	append       bool // Append content to target files instead of replacing them
+
+	skipUnchanged bool // Leave files that already hold the content of their change untouched
+
+	// When to restore the modification time of replaced files: "unchanged"
+	// when their content is the same, "always", or "" for never
+	preserveMtime string
+
+	continueOnError bool // Apply the remaining changes after a failure instead of stopping
+
+	report *applyReport  // Records the outcome of each change when not nil
+	prompt *changePrompt // Asks for a confirmation before each change when not nil
+	jobs   int           // Maximum number of changes applied concurrently
+	logger *logger       // Receives warnings and progress messages (can be nil)
+	writer apply.Writer  // Writes the files, with the --fsync and --retries settings
+}
+
+// applyChanges applies changes in order and returns how many were applied.
+// Invalid or inapplicable entries are skipped with a warning. It stops at
+// the first error; with opts.atomic, every file modified so far is first
+// restored to its original state. With opts.continueOnError, failures are
+// reported through opts.logger instead, and summed up in the returned error
+// once every change has been tried. With opts.jobs, independent changes are
+// applied concurrently, see applyChangesConcurrently. Once ctx is canceled,
+// no new change is started, and ctx.Err() is returned as the error of a
+// failed change would be.
+func applyChanges(ctx context.Context, changes []FileChange, opts applyOptions) (int, error) {
+	var journal *applyJournal
+	if opts.atomic {
+		journal = &applyJournal{}
+	}
+
+	if opts.jobs > 1 && opts.prompt == nil {
+		filesAppliedCount, errs := applyChangesConcurrently(ctx, changes, opts, journal)
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+		}
+		if opts.continueOnError && ctx.Err() == nil {
+			for _, err := range errs {
+				opts.logger.errorf("Error %v", err)
+			}
+			return filesAppliedCount, failedChangesError(len(errs), len(changes))
+		}
+		if len(errs) > 0 && journal != nil {
+			journal.rollback(opts.writer, opts.logger)
+			if opts.report != nil {
+				opts.report.RolledBack = true
+			}
+		}
+		return filesAppliedCount, errors.Join(errs...)
+	}
+
+	i := 0
+	next := func() (FileChange, bool, error) {
+		if i == len(changes) {
+			return FileChange{}, false, nil
+		}
+		i++
+		return changes[i-1], true, nil
+	}
+	return applyChangeSequence(ctx, next, len(changes), opts, journal)
+}
+
+// applyChangeSequence applies the changes returned by next, one at a time,
+// until it returns false, as described for applyChanges. total is the number
+// of changes, or -1 when it is not known in advance. An error returned by
+// next, or the cancellation of ctx, stops the sequence, as a failed change
+// would without opts.continueOnError.
+func applyChangeSequence(ctx context.Context, next func() (FileChange, bool, error), total int, opts applyOptions, journal *applyJournal) (int, error) {
+	filesAppliedCount, failures := 0, 0
+	stop := func(err error) (int, error) {
+		if journal != nil {
+			journal.rollback(opts.writer, opts.logger)
+			if opts.report != nil {
+				opts.report.RolledBack = true
+			}
+		}
+		return filesAppliedCount, err
+	}
+	for i := 0; ; i++ {
+		if err := ctx.Err(); err != nil {
+			return stop(err)
+		}
+		change, ok, err := next()
+		if err != nil {
+			return stop(err)
+		}
+		if !ok {
+			return filesAppliedCount, failedChangesError(failures, i)
+		}
+		target := changeTarget(change)
+		if total >= 0 {
+			opts.logger.debugf("Applying change %d/%d: %s", i+1, total, target)
+		} else {
+			opts.logger.debugf("Applying change %d: %s", i+1, target)
+		}
+
+		var existed bool
+		if opts.report != nil {
+			existed = targetExists(change)
+		}
+		if opts.prompt != nil {
+			confirmed, quit, err := opts.prompt.ask(change)
+			if err != nil {
+				return filesAppliedCount, fmt.Errorf("reading answer: %w", err)
+			}
+			if quit {
+				if total >= 0 {
+					opts.logger.infof("Stopped: %d change(s) were not applied.", total-i)
+				} else {
+					opts.logger.infof("Stopped: the remaining changes were not applied.")
+				}
+				return filesAppliedCount, failedChangesError(failures, i)
+			}
+			if !confirmed {
+				opts.logger.debugf("Skipping %s", target)
+				if opts.report != nil {
+					opts.report.add(change, existed, changeSkipped, nil)
+				}
+				continue
+			}
+		}
+		outcome, err := applyChange(change, opts, journal)
+		if opts.report != nil {
+			opts.report.add(change, existed, outcome, err)
+		}
+		if err != nil && opts.continueOnError {
+			opts.logger.errorf("Error %v", err)
+			failures++
+			continue
+		}
+		if err != nil {
+			return stop(err)
+		}
+		if outcome == changeApplied {
+			filesAppliedCount++
+		}
+	}
+}
+
+// failedChangesError summarizes the failures of apply with
+// opts.continueOnError, or returns nil if there are none.
+func failedChangesError(failures, total int) error {
+	if failures == 0 {
+		return nil
+	}
+	return fmt.Errorf("applying %d of %d change(s)", failures, total)
+}
+
+// changeTarget describes the file change applies to in progress messages.
+func changeTarget(change FileChange) string {
+	if change.isRename() {
+		return change.From + " -> " + change.To
+	}
+	return change.FilePath
+}
+
+// changeOutcome is what applyChange did with a change.
+type changeOutcome int
+
+const (
+	changeSkipped   changeOutcome = iota // Not applied, with a warning saying why
+	changeApplied                        // Written, appended, deleted or renamed
+	changeUnchanged                      // Not rewritten, as its file already has its content
+)
+
+// applyChange applies a single change, recording the original state of the
+// touched files in journal when it is not nil, and returns what it did with
+// it. Errors read as the end of a sentence starting with "Error".
+func applyChange(change FileChange, opts applyOptions, journal *applyJournal) (changeOutcome, error) {
+	if change.isRename() {
+		if change.From == "" || change.To == "" {
+			opts.logger.warnf("Skipping a rename entry due to missing 'from' or 'to'.")
+			return changeSkipped, nil
+		}
+		if journal != nil {
+			if err := journal.record(change.From, change.To); err != nil {
+				return changeSkipped, err
+			}
+		}
+		backupNote := ""
+		if change.Overwrite {
+			if _, err := os.Lstat(change.From); err == nil {
+				if backupNote, err = backupBeforeChange(change.To, opts, journal); err != nil {
+					return changeSkipped, err
+				}
+			}
+		}
+		err := opts.writer.RenameFile(change.From, change.To, change.Overwrite)
+		if os.IsNotExist(err) {
+			opts.logger.warnf("cannot rename '%s': file does not exist.", change.From)
+			return changeSkipped, nil
+		}
+		if errors.Is(err, apply.ErrRenameTargetExists) {
+			opts.logger.warnf("not renaming '%s': '%s' already exists (set \"overwrite\": true to replace it).", change.From, change.To)
+			return changeSkipped, nil
+		}
+		if err != nil {
+			return changeSkipped, fmt.Errorf("renaming '%s' to '%s': %w", change.From, change.To, err)
+		}
+		if opts.showDiff {
+			fmt.Fprintf(applyOut, "rename from %s\nrename to %s\n", change.From, change.To)
+		}
+		fmt.Fprintf(applyOut, "Successfully renamed %s to %s%s\n", change.From, change.To, backupNote)
+		return changeApplied, nil
+	}
+
+	if change.FilePath == "" {
+		opts.logger.warnf("Skipping a change entry due to missing 'file_path'.")
+		return changeSkipped, nil
+	}
+
+	if change.Delete {
+		if opts.showDiff {
+			if err := printChangeDiff(change.FilePath, nil, true); err != nil {
+				return changeSkipped, fmt.Errorf("reading file '%s': %w", change.FilePath, err)
+			}
+		}
+		if journal != nil {
+			if err := journal.record(change.FilePath); err != nil {
+				return changeSkipped, err
+			}
+		}
+		backupNote, err := backupBeforeChange(change.FilePath, opts, journal)
+		if err != nil {
+			return changeSkipped, err
+		}
+		err = apply.DeleteFile(change.FilePath)
+		if os.IsNotExist(err) {
+			opts.logger.warnf("cannot delete '%s': file does not exist.", change.FilePath)
+			return changeSkipped, nil
+		}
+		if err != nil {
+			return changeSkipped, fmt.Errorf("deleting file '%s': %w", change.FilePath, err)
+		}
+		fmt.Fprintf(applyOut, "Successfully deleted %s%s\n", change.FilePath, backupNote)
+		return changeApplied, nil
+	}
+
+	if opts.createOnly {
+		if _, err := os.Lstat(change.FilePath); err == nil {
+			opts.logger.warnf("not writing '%s': file already exists (--create-only).", change.FilePath)
+			return changeSkipped, nil
+		}
+	}
+
+	// Content can be empty, meaning the file should be emptied or created empty.
+
+	// filePath from JSON is used as-is. If relative, it's relative to CWD.
+	content, err := change.decodedContent()
+	if err != nil {
+		return changeSkipped, fmt.Errorf("decoding content for '%s': %w", change.FilePath, err)
+	}
+	if opts.skipUnchanged && !opts.append && hasContent(change.FilePath, content) {
+		fmt.Fprintf(applyOut, "Unchanged %s, not rewritten\n", change.FilePath)
+		return changeUnchanged, nil
+	}
+	if opts.showDiff {
+		newContent := content
+		if opts.append {
+			if newContent, err = appendedContent(change.FilePath, content); err != nil {
+				return changeSkipped, fmt.Errorf("reading file '%s': %w", change.FilePath, err)
+			}
+		}
+		if err := printChangeDiff(change.FilePath, newContent, false); err != nil {
+			return changeSkipped, fmt.Errorf("reading file '%s': %w", change.FilePath, err)
+		}
+	}
+	if journal != nil {
+		if err := journal.record(change.FilePath); err != nil {
+			return changeSkipped, err
+		}
+	}
+	backupNote, err := backupBeforeChange(change.FilePath, opts, journal)
+	if err != nil {
+		return changeSkipped, err
+	}
+	if opts.append {
+		if err := opts.writer.AppendFile(change.FilePath, content); err != nil {
+			return changeSkipped, fmt.Errorf("appending to file '%s': %w", change.FilePath, err)
+		}
+		fmt.Fprintf(applyOut, "Successfully appended to %s%s\n", change.FilePath, backupNote)
+		return changeApplied, nil
+	}
+	modTime, restoreModTime := preservedModTime(change.FilePath, content, opts.preserveMtime)
+	if err := opts.writer.WriteInPlace(change.FilePath, content); err != nil {
+		return changeSkipped, fmt.Errorf("writing file '%s': %w", change.FilePath, err)
+	}
+	if restoreModTime {
+		if err := os.Chtimes(change.FilePath, time.Time{}, modTime); err != nil {
+			return changeSkipped, fmt.Errorf("restoring modification time of '%s': %w", change.FilePath, err)
+		}
+	}
+	fmt.Fprintf(applyOut, "Successfully applied changes to %s%s\n", change.FilePath, backupNote)
+	return changeApplied, nil
+}
+
+// preservedModTime returns the modification time of filePath, and whether
+// it should be restored once content is written to it, according to mode
+// (see applyOptions.preserveMtime). New files keep the time they are written
+// at.
+func preservedModTime(filePath string, content []byte, mode string) (time.Time, bool) {
+	if mode == "" {
+		return time.Time{}, false
+	}
+	info, err := os.Stat(filePath)
+	if err != nil || !info.Mode().IsRegular() {
+		return time.Time{}, false
+	}
+	if mode == "unchanged" && !hasContent(filePath, content) {
+		return time.Time{}, false
+	}
+	return info.ModTime(), true
+}
+
+// hasContent reports whether filePath is a regular file holding exactly
+// content. Files of another size are not read.
+func hasContent(filePath string, content []byte) bool {
+	info, err := os.Stat(filePath)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != int64(len(content)) {
+		return false
+	}
+	current, err := os.ReadFile(filePath)
+	return err == nil && bytes.Equal(current, content)
+}
+
+// appendedContent returns the content filePath would have once content is
+// appended to it.
+func appendedContent(filePath string, content []byte) ([]byte, error) {
+	current, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return append(current, content...), nil
+}
+
+// backupFile copies the regular file at filePath to filePath+suffix with w,
+// preserving its permissions, and returns the backup path. It returns an
+// empty path when filePath does not exist, as there is nothing to back up.
+func backupFile(w apply.Writer, filePath, suffix string) (string, error) {
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", nil
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	backupPath := filePath + suffix
+	if err := w.WriteInPlace(backupPath, content); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(backupPath, info.Mode()); err != nil {
+		return "", fmt.Errorf("could not set permissions on backup '%s': %w", backupPath, err)
+	}
+	return backupPath, nil
+}
+
+// backupBeforeChange backs up filePath when opts.backup is set and returns
+// the note to append to the success message. The backup itself is recorded
+// in journal, so that a rollback removes it.
+func backupBeforeChange(filePath string, opts applyOptions, journal *applyJournal) (string, error) {
+	if !opts.backup {
+		return "", nil
+	}
+	if journal != nil {
+		if err := journal.record(filePath + opts.backupSuffix); err != nil {
+			return "", err
+		}
+	}
+	backupPath, err := backupFile(opts.writer, filePath, opts.backupSuffix)
+	if err != nil {
+		return "", fmt.Errorf("backing up '%s': %w", filePath, err)
+	}
+	if backupPath == "" {
+		return "", nil
+	}
+	return fmt.Sprintf(" (backup: %s)", backupPath), nil
+}
+
+// applyJournal keeps a backup of every file apply is about to modify, and
+// the parent directories it is about to create, so that a failed changeset
+// can be rolled back.
+type applyJournal struct {
+	mu      sync.Mutex // Guards entries when changes are applied concurrently
+	entries []journalEntry
+}
+
+// journalEntry is the original state of a single path.
+type journalEntry struct {
+	path    string
+	existed bool
+	content []byte
+	mode    os.FileMode
+	dir     bool // Missing directory, created as the parent of a file
+}
+
+// record backs up the current state of each path, along with its missing
+// parent directories. Paths already recorded keep their first, original
+// state.
+func (j *applyJournal) record(paths ...string) error {
+	for _, filePath := range paths {
+		j.mu.Lock()
+		recorded := j.has(filePath)
+		j.mu.Unlock()
+		if recorded {
+			continue
+		}
+		if err := j.recordMissingDirs(filepath.Dir(filePath)); err != nil {
+			return err
+		}
+
+		entry := journalEntry{path: filePath}
+		info, err := os.Stat(filePath)
+		if err == nil && info.IsDir() {
+			// Directories are never replaced, so there is nothing to restore
+			continue
+		}
+		if err == nil {
+			content, err := os.ReadFile(filePath)
+			if err != nil {
+				return fmt.Errorf("backing up '%s': %w", filePath, err)
+			}
+			entry.existed = true
+			entry.content = content
+			entry.mode = info.Mode()
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("backing up '%s': %w", filePath, err)
+		}
+		j.mu.Lock()
+		j.entries = append(j.entries, entry)
+		j.mu.Unlock()
+	}
+	return nil
+}
+
+// recordMissingDirs records dir and its ancestors that do not exist yet,
+// which writing a file in dir creates. They are recorded from the shallowest
+// one, so that rollback removes them deepest first, after the files written
+// inside.
+func (j *applyJournal) recordMissingDirs(dir string) error {
+	var missing []string
+	for {
+		_, err := os.Lstat(dir)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("backing up '%s': %w", dir, err)
+		}
+		missing = append(missing, dir)
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, dir := range slices.Backward(missing) {
+		if !j.has(dir) {
+			j.entries = append(j.entries, journalEntry{path: dir, dir: true})
+		}
+	}
+	return nil
+}
+
+func (j *applyJournal) has(filePath string) bool {
+	for _, entry := range j.entries {
+		if entry.path == filePath {
+			return true
+		}
+	}
+	return false
+}
+
+// rollback restores every recorded path to its original state, most recent
+// first: existing files get their content and mode back, and new files and
+// the directories created for them are removed. Failures are reported
+// through log and do not stop the rollback. Files are restored with w.
+func (j *applyJournal) rollback(w apply.Writer, log *logger) {
+	restored := 0
+	for i := len(j.entries) - 1; i >= 0; i-- {
+		entry := j.entries[i]
+		if entry.dir {
+			if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+				log.errorf("Error removing directory '%s': %v", entry.path, err)
+			}
+			continue
+		}
+		var err error
+		if entry.existed {
+			err = w.WriteInPlace(entry.path, entry.content)
+			if err == nil {
+				err = os.Chmod(entry.path, entry.mode)
+			}
+		} else if err = os.Remove(entry.path); os.IsNotExist(err) {
+			err = nil
+		}
+
+		if err != nil {
+			log.errorf("Error restoring '%s': %v", entry.path, err)
+			continue
+		}
+		restored++
+	}
+	log.infof("Rolled back changes to %d file(s).", restored)
+}
+
+// printChangeDiff prints a unified diff between the current content of
+// filePath and content to stdout. A missing file is diffed as empty, and
+// so is the new content of a deleted file.
+func printChangeDiff(filePath string, content []byte, deleted bool) error {
+	oldName := "a/" + filepath.ToSlash(filePath)
+	current, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		oldName = "/dev/null"
+	} else if err != nil {
+		return err
+	}
+
+	newName := "b/" + filepath.ToSlash(filePath)
+	if deleted {
+		newName = "/dev/null"
+		content = nil
+	}
+	if isBinary(current) || isBinary(content) {
+		if !bytes.Equal(current, content) {
+			fmt.Fprintf(applyOut, "Binary files %s and %s differ\n", oldName, newName)
+		}
+		return nil
+	}
+	fmt.Fprint(applyOut, unifiedDiff(oldName, newName, string(current), string(content)))
+	return nil
+}
+
+// dryRunChanges reports, for each change, whether the target file would be
+// created or overwritten and how its size would change, without touching
+// the filesystem. With opts.showDiff, each report is followed by a unified
+// diff.
+func dryRunChanges(changes []FileChange, opts applyOptions) {
+	showDiff := opts.showDiff
+	createCount, overwriteCount, deleteCount, renameCount, unchangedCount, skipCount := 0, 0, 0, 0, 0, 0
+	for _, change := range changes {
+		if change.isRename() {
+			if change.From == "" || change.To == "" {
+				opts.logger.warnf("Skipping a rename entry due to missing 'from' or 'to'.")
+				skipCount++
+				continue
+			}
+			if _, err := os.Lstat(change.From); err != nil {
+				if os.IsNotExist(err) {
+					opts.logger.warnf("cannot rename '%s': file does not exist.", change.From)
+				} else {
+					fmt.Fprintf(applyOut, "Would fail on %s: %v\n", change.From, err)
+				}
+				skipCount++
+				continue
+			}
+			if _, err := os.Lstat(change.To); err == nil && !change.Overwrite {
+				opts.logger.warnf("not renaming '%s': '%s' already exists (set \"overwrite\": true to replace it).", change.From, change.To)
+				skipCount++
+				continue
+			}
+			fmt.Fprintf(applyOut, "Would rename %s to %s\n", change.From, change.To)
+			renameCount++
+			continue
+		}
+		if change.FilePath == "" {
+			opts.logger.warnf("Skipping a change entry due to missing 'file_path'.")
+			skipCount++
+			continue
+		}
+		if change.Delete {
+			info, err := os.Stat(change.FilePath)
+			switch {
+			case err == nil && info.IsDir():
+				fmt.Fprintf(applyOut, "Would fail on %s: path is a directory\n", change.FilePath)
+				skipCount++
+				continue
+			case err == nil:
+				fmt.Fprintf(applyOut, "Would delete %s (%d bytes)\n", change.FilePath, info.Size())
+				deleteCount++
+			case os.IsNotExist(err):
+				opts.logger.warnf("cannot delete '%s': file does not exist.", change.FilePath)
+				skipCount++
+				continue
+			default:
+				fmt.Fprintf(applyOut, "Would fail on %s: %v\n", change.FilePath, err)
+				skipCount++
+				continue
+			}
+			if showDiff {
+				if diffErr := printChangeDiff(change.FilePath, nil, true); diffErr != nil {
+					opts.logger.warnf("cannot diff '%s': %v", change.FilePath, diffErr)
+				}
+			}
+			continue
+		}
+		content, err := change.decodedContent()
+		if err != nil {
+			opts.logger.warnf("cannot decode content for '%s': %v. It would not be applied.", change.FilePath, err)
+			skipCount++
+			continue
+		}
+
+		info, err := os.Stat(change.FilePath)
+		switch {
+		case err == nil && info.IsDir():
+			fmt.Fprintf(applyOut, "Would fail on %s: path is a directory\n", change.FilePath)
+			skipCount++
+			continue
+		case err == nil && opts.createOnly:
+			opts.logger.warnf("not writing '%s': file already exists (--create-only).", change.FilePath)
+			skipCount++
+			continue
+		case err == nil && opts.skipUnchanged && !opts.append && hasContent(change.FilePath, content):
+			fmt.Fprintf(applyOut, "Would leave %s unchanged\n", change.FilePath)
+			unchangedCount++
+			continue
+		case err == nil && opts.append:
+			fmt.Fprintf(applyOut, "Would append to %s (%d -> %d bytes, %+d)\n", change.FilePath, info.Size(), info.Size()+int64(len(content)), len(content))
+			overwriteCount++
+		case err == nil:
+			fmt.Fprintf(applyOut, "Would overwrite %s (%d -> %d bytes, %+d)\n", change.FilePath, info.Size(), len(content), int64(len(content))-info.Size())
+			overwriteCount++
+		case os.IsNotExist(err):
+			fmt.Fprintf(applyOut, "Would create %s (%d bytes)\n", change.FilePath, len(content))
+			createCount++
+		default:
+			fmt.Fprintf(applyOut, "Would fail on %s: %v\n", change.FilePath, err)
+			skipCount++
+			continue
+		}
+		if showDiff {
+			newContent := content
+			if opts.append {
+				var appendErr error
+				if newContent, appendErr = appendedContent(change.FilePath, content); appendErr != nil {
+					opts.logger.warnf("cannot diff '%s': %v", change.FilePath, appendErr)
+					continue
+				}
+			}
+			if diffErr := printChangeDiff(change.FilePath, newContent, false); diffErr != nil {
+				opts.logger.warnf("cannot diff '%s': %v", change.FilePath, diffErr)
+			}
+		}
+	}
+	fmt.Fprintf(applyOut, "Dry run: %d file(s) would be created, %d overwritten, %d deleted, %d renamed, %d unchanged, %d skipped.\n", createCount, overwriteCount, deleteCount, renameCount, unchangedCount, skipCount)
+}
+
+// checkChanges reports the changes that are not applied yet, without writing
+// anything, and returns how many there are. A content change is applied when
+// its file holds exactly its content, a deletion when its file does not
+// exist, and a rename when its source does not exist but its destination
+// does. As the changes are applied in order, a path is checked where later
+// renames moved it, and not at all when a later change replaces it.
+func checkChanges(changes []FileChange, opts applyOptions) int {
+	outdated := 0
+	for i, change := range changes {
+		var reason string
+		filePath := change.FilePath
+		switch {
+		case change.isRename():
+			filePath = change.From
+			if _, replaced := laterPath(changes, i, change.From); !replaced {
+				if _, err := os.Lstat(change.From); err == nil {
+					reason = fmt.Sprintf("not renamed to %s", change.To)
+				}
+			}
+			if to, replaced := laterPath(changes, i, change.To); reason == "" && !replaced {
+				if _, err := os.Lstat(to); err != nil {
+					reason = fmt.Sprintf("not renamed to %s", change.To)
+				}
+			}
+		case change.Delete:
+			if _, replaced := laterPath(changes, i, change.FilePath); replaced {
+				continue
+			}
+			if _, err := os.Lstat(change.FilePath); !os.IsNotExist(err) {
+				reason = "not deleted"
+			}
+		default:
+			var replaced bool
+			if filePath, replaced = laterPath(changes, i, change.FilePath); replaced {
+				continue
+			}
+			// Content was checked by validateChanges
+			content, _ := change.decodedContent()
+			current, err := os.ReadFile(filePath)
+			switch {
+			case os.IsNotExist(err):
+				reason = "missing"
+			case err != nil:
+				reason = err.Error()
+			case !bytes.Equal(current, content):
+				reason = "content differs"
+			}
+		}
+		if reason == "" {
+			opts.logger.debugf("Up to date: %s", filePath)
+			continue
+		}
+		fmt.Fprintf(applyOut, "Out of date: %s (%s)\n", filePath, reason)
+		outdated++
+		if opts.showDiff && !change.isRename() {
+			content, _ := change.decodedContent()
+			if diffErr := printChangeDiff(filePath, content, change.Delete); diffErr != nil {
+				opts.logger.warnf("cannot diff '%s': %v", filePath, diffErr)
+			}
+		}
+	}
+	return outdated
+}
+
+// laterPath follows filePath through the changes after the i-th one: it
+// returns where later renames moved the file, and whether a later change
+// writes, deletes or renames another file over it, in which case that change
+// decides what the path holds.
+func laterPath(changes []FileChange, i int, filePath string) (string, bool) {
+	for _, later := range changes[i+1:] {
+		switch {
+		case later.isRename() && filepath.Clean(later.To) == filepath.Clean(filePath):
+			return filePath, true
+		case later.isRename() && filepath.Clean(later.From) == filepath.Clean(filePath):
+			filePath = later.To
+		case !later.isRename() && filepath.Clean(later.FilePath) == filepath.Clean(filePath):
+			return filePath, true
+		}
+	}
+	return filePath, false
+}
+
+// parseExtensions parses a comma-separated list of file extensions, adding
+// the leading dot where missing and dropping empty entries. "*", which
+// selects all files, is kept as-is.
+func parseExtensions(extensionsStr string) []string {
+	var extensions []string
+	for _, ext := range strings.Split(extensionsStr, ",") {
+		trimmedExt := strings.TrimSpace(ext)
+		if trimmedExt != "" {
+			// Ensure extensions start with a dot if not already
+			if trimmedExt != "*" && !strings.HasPrefix(trimmedExt, ".") {
+				trimmedExt = "." + trimmedExt
+			}
+			extensions = append(extensions, trimmedExt)
+		}
+	}
+	return extensions
+}
+
+// parseTimeBound parses the value of a flag such as --mtime-after: either a
+// duration, e.g. 24h, meaning that long before now, a number of days such as
+// 7d, an RFC 3339 timestamp, or a date in the local time zone.
+func parseTimeBound(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time '%s', expected a duration such as 24h or 7d, an RFC 3339 timestamp or a YYYY-MM-DD date", value)
+}
+
+// readTextArg returns the text given to a flag such as --header: value
+// itself, or the content of the file it names after a leading "@".
+func readTextArg(value string) (string, error) {
+	filePath, ok := strings.CutPrefix(value, "@")
+	if !ok {
+		return value, nil
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// writeExtractOutput extracts the files of scanDirAbs selected by opts to
+// w, see extractTo, wrapped with header and footer and compressed with gzip
+// if compress is set. Errors are wrapped with the step that failed.
+func writeExtractOutput(ctx context.Context, w io.Writer, scanDirAbs string, opts extractOptions, header, footer string, compress bool) error {
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(w)
+		w = zw
+	}
+	wrapped, err := newWrappedWriter(w, header, footer)
+	if err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	extractErr := extractTo(ctx, wrapped, scanDirAbs, opts)
+	var collected *collectedErrors
+	if extractErr != nil && !errors.As(extractErr, &collected) {
+		return fmt.Errorf("extracting content: %w", extractErr)
+	}
+	if err := wrapped.Close(); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("compressing output: %w", err)
+		}
+	}
+	if collected != nil {
+		return fmt.Errorf("extracting content: %w", collected)
+	}
+	return nil
+}
+
+// resolveScanDir returns the absolute path of the directory to scan, exiting
+// with an error message if it does not exist or is not a directory.
+func resolveScanDir(directoryPath string, log *logger) string {
+	absScanDir, err := filepath.Abs(directoryPath)
+	if err != nil {
+		log.errorf("Error getting absolute path for directory '%s': %v", directoryPath, err)
+		os.Exit(1)
+	}
+
+	dirInfo, err := os.Stat(absScanDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.errorf("Error: Directory '%s' does not exist.", absScanDir)
+		} else {
+			log.errorf("Error accessing directory '%s': %v", absScanDir, err)
+		}
+		os.Exit(1)
+	}
+	if !dirInfo.IsDir() {
+		log.errorf("Error: Path '%s' is not a directory.", absScanDir)
+		os.Exit(1)
+	}
+	return absScanDir
+}
+
+// loadChangeset reads and parses the changeset at filePath, "-" meaning
+// standard input.
+func loadChangeset(filePath string) (MdiffJSON, error) {
+	var mdiffData MdiffJSON
+	jsonFileBytes, err := readInput(filePath)
+	if err == nil {
+		jsonFileBytes, err = gunzipIfCompressed(jsonFileBytes)
+	}
+	if err != nil {
+		return mdiffData, fmt.Errorf("reading JSON file '%s': %w", filePath, err)
+	}
+	if err := json.Unmarshal(jsonFileBytes, &mdiffData); err != nil {
+		return mdiffData, fmt.Errorf("parsing JSON from file '%s': %w", filePath, err)
+	}
+	return mdiffData, nil
+}
+
+// readInput reads the whole content of the file at filePath, or of standard
+// input when filePath is "-".
+func readInput(filePath string) ([]byte, error) {
+	if filePath == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(filePath)
+}
+
+// gunzipIfCompressed returns data decompressed if it starts with the gzip
+// magic bytes, and as-is otherwise.
+func gunzipIfCompressed(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		return data, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// stdinIsPiped reports whether standard input is redirected from a pipe or
+// a file rather than attached to a terminal.
+func stdinIsPiped() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice == 0
+}
+
+// stdinIsTerminal reports whether standard input is attached to a terminal.
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// stringListFlag is a flag.Value collecting the values of a repeatable flag.
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func printMainUsage() {
+	fmt.Print(`
+Usage:
+  copilot [--quiet | --verbose] <command> [options] <args...>
+  copilot --version
+
+Commands:
+  apply        Apply changes from a JSON file to target files.
+  extract      Extract content from files in a directory based on extensions.
+  merge        Combine several changeset JSON files into one.
+  patch        Apply a unified diff to files.
+  stats        Summarize files per extension: count, bytes and lines.
+  tree         Print the tree of files extract would pick up.
+  version      Print the version, commit and build date.
+
+Global options:
+  --quiet        Only print errors.
+  --verbose      Print progress for each file.
+  --config FILE  Read default options from FILE instead of .copilot.json.
+  -v, --version  Print the version and exit.
+
+Run 'copilot <command> --help' for more information on a specific command.
+`)
+}
+
+func printApplyUsage(fs *flag.FlagSet) {
+	fmt.Print(`
+Usage:
+  copilot apply [apply_options] <json_file>...
+  copilot apply [apply_options] - < changes.json
+  copilot apply [apply_options] --stdin-json < changes.json
+
+Apply file content changes from a JSON file.
+The JSON file should contain an object with a "changes" array,
+where each element specifies a "file_path" and its new "content".
+Each specified file will be overwritten with the content from the JSON file,
+or removed if the element has "delete": true. Elements with "from" and "to"
+instead rename a file, replacing an existing "to" only with "overwrite": true.
+Parent directories for the files will be created if they don't exist.
+Paths in the JSON file are typically relative to the current working directory.
+
+Arguments:
+  <json_file>       Path to the JSON file containing file content changes.
+                    Use "-", or omit it when piping, to read from standard input.
+                    When several files are given, their changes are applied
+                    together, in order; a change to a path overrides the
+                    changes to the same path from the previous files.
+
+Options:`)
+	fs.PrintDefaults()
+	fmt.Print(`
+Examples:
+  copilot apply ./changes.json
+  copilot apply --dry-run ./changes.json
+  some-generator | copilot apply -
+  copilot apply --dry-run --diff ./changes.json
+  copilot apply --check ./changes.json
+  copilot apply ./part1.json ./part2.json
+  copilot apply -i ./changes.json
+  copilot apply --only "src/**" --skip "src/generated/**" ./changes.json
+  copilot apply --atomic ./changes.json
+  copilot apply --backup --backup-suffix .orig ./changes.json
+  copilot apply --safe --base-dir ./project ./untrusted.json
+`)
+}
+
+func printExtractUsage(fs *flag.FlagSet) {
+	fmt.Println(`
+Usage:
+  copilot extract [extract_options] <directory_path> <file_extensions>
+  copilot extract [extract_options] --include <glob> <directory_path> [<file_extensions>]
+  copilot extract [extract_options] --ext <ext> <directory_path> [<file_extensions>]
+  copilot extract [extract_options] --stdin-files [<file_extensions>]
+  copilot extract [extract_options] --files <file,...> [<file_extensions>]
+
+Extract content from files in a directory based on extensions.
+Respects .gitignore rules found in <directory_path> or specified via --gitignore.
+Outputs a structured format containing file paths and their content.
+
+Arguments:
+  <directory_path>     Path to the directory to scan.
+  <file_extensions>    Comma-separated list of file extensions (e.g., .js,.ts,.md),
+                       or '*' for all files.
+                       Optional when --ext, --include, --stdin-files or --files is given.
+
+Options:`)
+	fs.PrintDefaults()
+	fmt.Print(`
+Examples:
+  copilot extract ./src .js,.ts,.json > extracted_content.txt
+  copilot extract --gitignore ./.custom_ignore ./project .go,.java > context.txt
+  copilot extract --exclude "*.min.js" --exclude "vendor/**" ./web .js > context.txt
+  copilot extract --include Dockerfile --include Makefile ./project .go > context.txt
+  copilot extract --ext .go --ext .md ./project > context.txt
+  copilot extract --format json ./project .go > changes.json
+  copilot extract --template '### {{.Path}}{{"\n"}}{{.Content}}{{"\n"}}' ./project .go > context.md
+  copilot extract -o context.txt ./project .go
+  copilot extract --clipboard ./project .go
+  copilot extract --max-tokens 100000 ./project .go,.md > context.txt
+  copilot extract --warn-size 1M --max-output-size 10M ./project '*' > context.txt
+  copilot extract --git-tracked-only ./project '*' > context.txt
+  copilot extract --changed-since main ./project .go > review.txt
+  copilot extract --mtime-after 24h ./project .go > recent.txt
+  copilot extract --min-size 64 --max-size 512K ./project '*' > context.txt
+  git diff --name-only | copilot extract --stdin-files > context.txt
+`)
+}
+
+func printStatsUsage(fs *flag.FlagSet) {
+	fmt.Println(`
+Usage:
+  copilot stats [stats_options] <directory_path> <file_extensions>
+
+Summarize the files extract would consider, per extension: number of files,
+total bytes and total lines, followed by grand totals.
+Respects .gitignore rules like the extract command.
+
+Arguments:
+  <directory_path>     Path to the directory to scan.
+  <file_extensions>    Comma-separated list of file extensions (e.g., .js,.ts,.md).
+
+Options:`)
+	fs.PrintDefaults()
+	fmt.Print(`
+Examples:
+  copilot stats ./src .go,.md
+  copilot stats --format json ./src .js,.ts
+`)
+}
+
+func printTreeUsage(fs *flag.FlagSet) {
+	fmt.Println(`
+Usage:
+  copilot tree [tree_options] <directory_path> <file_extensions>
+
+Print the tree of the files extract would pick up, with their parent
+directories. Respects .gitignore rules like the extract command.
+
+Arguments:
+  <directory_path>     Path to the directory to scan.
+  <file_extensions>    Comma-separated list of file extensions (e.g., .js,.ts,.md).
+
+Options:`)
+	fs.PrintDefaults()
+	fmt.Print(`
+Examples:
+  copilot tree ./src .go,.md
+  copilot tree --all --exclude 'vendor/**' ./src .go
+`)
+}
+
+func printMergeUsage(fs *flag.FlagSet) {
+	fmt.Println(`
+Usage:
+  copilot merge [merge_options] <json_file> <json_file>...
+
+Combine several changeset JSON files, as read by apply, into a single one
+printed on stdout. When several files change the same path, the last one
+wins; identical duplicates are merged silently, while differing ones are
+reported as conflicts on stderr.
+
+Arguments:
+  <json_file>    Path to a changeset JSON file, or - for standard input.
+
+Options:`)
+	fs.PrintDefaults()
+	fmt.Print(`
+Examples:
+  copilot merge frontend.json backend.json > changes.json
+  copilot merge --strict a.json b.json | copilot apply
+`)
+}
+
+func printPatchUsage(fs *flag.FlagSet) {
+	fmt.Println(`
+Usage:
+  copilot patch [patch_options] <patch_file>
+  copilot patch [patch_options] - < changes.diff
+
+Apply a unified diff, as produced by diff -u or git diff, to the files it
+names. Hunks are applied where their context matches exactly, even if the
+lines moved since the diff was made. When a hunk does not apply, the file
+is left untouched and the failure is reported; other files are still
+patched.
+
+Arguments:
+  <patch_file>    Path to the unified diff, or - for standard input.
+
+Options:`)
+	fs.PrintDefaults()
+	fmt.Print(`
+Examples:
+  copilot patch fix.diff
+  git diff | copilot patch --dry-run -
+  copilot patch -p 0 changes.diff
+`)
+}
+
+func main() {
+	globalFlags := flag.NewFlagSet("copilot", flag.ExitOnError)
+	quietFlag := globalFlags.Bool("quiet", false, "Only print errors.")
+	verboseFlag := globalFlags.Bool("verbose", false, "Print progress for each file.")
+	configFlag := globalFlags.String("config", "", "Config file holding default options.")
+	versionFlag := globalFlags.Bool("version", false, "Print the version and exit.")
+	globalFlags.BoolVar(versionFlag, "v", false, "Shorthand for --version.")
+	globalFlags.Usage = printMainUsage
+	globalFlags.Parse(os.Args[1:])
+
+	if *versionFlag {
+		fmt.Println(versionString())
+		os.Exit(0)
+	}
+
+	args := globalFlags.Args()
+	if len(args) < 1 {
+		printMainUsage()
+		os.Exit(0)
+	}
+	log := &logger{out: os.Stderr, level: levelInfo}
+	if *quietFlag && *verboseFlag {
+		log.errorf("Error: --quiet and --verbose cannot be used together.")
+		os.Exit(1)
+	}
+	if *quietFlag {
+		log.level = levelError
+	} else if *verboseFlag {
+		log.level = levelDebug
+	}
+	cfg, err := loadConfig(*configFlag)
+	if err != nil {
+		log.errorf("Error %v", err)
+		os.Exit(1)
+	}
+
+	command := args[0]
+
+	switch command {
+	case "apply":
+		applyCmd := flag.NewFlagSet("apply", flag.ExitOnError)
+		dryRunFlag := applyCmd.Bool("dry-run", false, "Report what would be created or overwritten without writing anything.")
+		atomicFlag := applyCmd.Bool("atomic", false, "Apply all changes or none: if a change fails, restore the files\nmodified so far to their original state.")
+		backupFlag := applyCmd.Bool("backup", false, "Copy each existing file to a backup before overwriting or deleting it.")
+		backupSuffixFlag := applyCmd.String("backup-suffix", ".bak", "Suffix appended to file names to name backups made by --backup.")
+		baseDirFlag := applyCmd.String("base-dir", "", "Directory relative paths in the JSON file are resolved against.\nDefaults to the current working directory.")
+		safeFlag := applyCmd.Bool("safe", false, "Refuse to apply the changeset if any path, once resolved\n(including symlinks), lies outside the base directory.")
+		var onlyFlag, skipFlag stringListFlag
+		applyCmd.Var(&onlyFlag, "only", "Only apply the changes to paths matching this glob, relative to\n--base-dir, e.g. \"src/**\" or \"*.go\" (repeatable). Others are skipped.")
+		applyCmd.Var(&skipFlag, "skip", "Skip the changes to paths matching this glob, relative to --base-dir\n(repeatable). Takes precedence over --only.")
+		skipUnchangedFlag := applyCmd.Bool("skip-unchanged", true, "Leave files that already hold the new content untouched instead of\nrewriting them. Use --skip-unchanged=false to always rewrite them.")
+		preserveMtimeFlag := applyCmd.String("preserve-mtime", "", "Restore the modification time of replaced files: 'unchanged' only\nwhen their content is the same, or 'always'. New files are not affected.")
+		retriesFlag := applyCmd.Int("retries", 0, "Retry writing a file up to this many times, with an exponential\nbackoff, when it fails with a transient error such as a busy or\nlocked file.")
+		fsyncFlag := applyCmd.Bool("fsync", false, "Flush each written file and its directory to disk before moving on,\nso applied changes survive a crash or power loss. Slower.")
+		createOnlyFlag := applyCmd.Bool("create-only", false, "Only create new files: skip content changes whose target already exists.")
+		strictFlag := applyCmd.Bool("strict", false, "Fail without writing anything if a path appears in several\nchanges instead of warning, and with --create-only, if any target\nalready exists instead of skipping it.")
+		appendFlag := applyCmd.Bool("append", false, "Append content to the target files, creating them if needed,\ninstead of replacing them. Appends are done in place, not atomically.")
+		reportFlag := applyCmd.String("report", "", "Print a report of what was done on stdout, in the given format\n(json). Other messages then go to stderr.")
+		diffFlag := applyCmd.Bool("diff", false, "Print a unified diff of each change against the current file.\nCombine with --dry-run to review without writing.")
+		interactiveFlag := applyCmd.Bool("interactive", false, "Ask for a confirmation before each change. Standard input must be\na terminal.")
+		applyCmd.BoolVar(interactiveFlag, "i", false, "Shorthand for --interactive.")
+		jobsFlag := applyCmd.Int("jobs", 1, "Number of changes applied concurrently. Changes to the same file\nare still applied in order.")
+		failFastFlag := applyCmd.Bool("fail-fast", true, "Stop at the first change that fails. With --fail-fast=false, apply\nthe remaining changes and report the failures at the end.")
+		stdinJSONFlag := applyCmd.Bool("stdin-json", false, "Stream the changeset from standard input, applying each change as\nsoon as it is read instead of loading the whole changeset first.")
+		checkFlag := applyCmd.Bool("check", false, "Check that the changeset is already applied without writing\nanything, listing out-of-date files. Exits with an error if any.")
+		expandPathsFlag := applyCmd.Bool("expand-paths", false, "Expand a leading ~ and $VAR or ${VAR} environment variables in\n<json_file> and --base-dir, for quoted or scripted arguments. Paths\nin the changeset are never expanded.")
+		applyCmd.Usage = func() { printApplyUsage(applyCmd) }
+
+		err := applyCmd.Parse(args[1:])
+		if err != nil {
+			os.Exit(1)
+		}
+		if err := cfg.apply(applyCmd); err != nil {
+			log.errorf("Error in config file: %v", err)
+			os.Exit(1)
+		}
+		if *expandPathsFlag {
+			if err := expandPaths(baseDirFlag); err != nil {
+				log.errorf("Error %v", err)
+				os.Exit(1)
+			}
+		}
+
+		if *reportFlag != "" && *reportFlag != "json" {
+			log.errorf("Error: Unknown report format '%s'. Expected 'json'.", *reportFlag)
+			os.Exit(1)
+		}
+		if *reportFlag != "" && *dryRunFlag {
+			log.errorf("Error: --report cannot be used with --dry-run.")
+			os.Exit(1)
+		}
+		if *appendFlag && *createOnlyFlag {
+			log.errorf("Error: --append cannot be used with --create-only.")
+			os.Exit(1)
+		}
+		if !*failFastFlag && *atomicFlag {
+			log.errorf("Error: --fail-fast=false cannot be used with --atomic.")
+			os.Exit(1)
+		}
+		if *preserveMtimeFlag != "" && *preserveMtimeFlag != "unchanged" && *preserveMtimeFlag != "always" {
+			log.errorf("Error: Unknown --preserve-mtime mode '%s'. Expected 'unchanged' or 'always'.", *preserveMtimeFlag)
+			os.Exit(1)
+		}
+		if *preserveMtimeFlag != "" && *appendFlag {
+			log.errorf("Error: --preserve-mtime cannot be used with --append.")
+			os.Exit(1)
+		}
+		if *retriesFlag < 0 {
+			log.errorf("Error: --retries cannot be negative.")
+			os.Exit(1)
+		}
+		if *jobsFlag < 1 {
+			log.errorf("Error: --jobs must be at least 1.")
+			os.Exit(1)
+		}
+		if *jobsFlag > 1 && (*interactiveFlag || *diffFlag) {
+			log.errorf("Error: --jobs cannot be used with --interactive or --diff.")
+			os.Exit(1)
+		}
+		if *checkFlag && (*dryRunFlag || *reportFlag != "" || *appendFlag || *createOnlyFlag) {
+			log.errorf("Error: --check cannot be used with --dry-run, --report, --append or --create-only.")
+			os.Exit(1)
 		}
 
-		filesAppliedCount := 0
-		for _, change := range mdiffData.Changes {
-			if change.FilePath == "" {
-				fmt.Fprintln(os.Stderr, "Warning: Skipping a change entry due to missing 'file_path'.")
-				continue
+		if *stdinJSONFlag && (applyCmd.NArg() > 0 || *dryRunFlag || *checkFlag || *interactiveFlag || *strictFlag || *jobsFlag > 1) {
+			log.errorf("Error: --stdin-json cannot be used with <json_file> arguments, --dry-run, --check, --interactive, --strict or --jobs.")
+			os.Exit(1)
+		}
+
+		opts := applyOptions{
+			showDiff: *diffFlag,
+			atomic:   *atomicFlag,
+			backup:   *backupFlag,
+
+			backupSuffix: *backupSuffixFlag,
+			createOnly:   *createOnlyFlag,
+			append:       *appendFlag,
+			jobs:         *jobsFlag,
+
+			skipUnchanged:   *skipUnchangedFlag,
+			preserveMtime:   *preserveMtimeFlag,
+			continueOnError: !*failFastFlag,
+			logger:          log,
+			writer:          apply.Writer{Sync: *fsyncFlag, Retries: *retriesFlag},
+		}
+		if *reportFlag == "json" {
+			opts.report = &applyReport{Files: []reportEntry{}}
+			applyOut = os.Stderr
+		}
+		// Ctrl-C stops before the next change, rolling back with --atomic
+		ctx, stop := interruptContext()
+		defer stop()
+		// finish reports the outcome of applying the changes, exiting on error.
+		finish := func(filesAppliedCount int, err error) {
+			if opts.report != nil {
+				if reportErr := opts.report.write(os.Stdout); reportErr != nil {
+					log.errorf("Error writing report: %v", reportErr)
+					os.Exit(1)
+				}
+			}
+			exitIfInterrupted(err, log)
+			if err != nil {
+				log.errorf("Error %v", err)
+				os.Exit(1)
+			}
+
+			if filesAppliedCount == 0 {
+				// This case might be hit if all changes had empty file_paths,
+				// or if the changeset was empty.
+				log.warnf("No file changes were actually applied from the JSON file.")
+			} else {
+				fmt.Fprintf(applyOut, "Successfully applied %d file(s).\n", filesAppliedCount)
+			}
+		}
+
+		filterBaseDir := *baseDirFlag
+		if filterBaseDir == "" {
+			filterBaseDir = "."
+		}
+		filter := newChangeFilter(onlyFlag, skipFlag, filterBaseDir)
+
+		if *stdinJSONFlag {
+			safeBaseDir := *baseDirFlag
+			if safeBaseDir == "" {
+				safeBaseDir = "."
+			}
+			validator := newChangeValidator()
+			skipped := 0
+			filesAppliedCount, err := applyChangeStream(ctx, os.Stdin, opts, func(i int, change FileChange) (FileChange, bool, error) {
+				errs := warnDuplicatePaths(validator.check(i, change), log)
+				if *safeFlag {
+					errs = append(errs, checkChangePaths([]FileChange{change}, safeBaseDir)...)
+				}
+				if len(errs) > 0 {
+					return change, false, fmt.Errorf("in changeset: %w", errs[0])
+				}
+				if flag := filter.excludedBy(change); flag != "" {
+					log.debugf("Skipping %s, excluded by %s", changeTarget(change), flag)
+					skipped++
+					return change, false, nil
+				}
+				if *baseDirFlag != "" {
+					change = resolveChangePaths([]FileChange{change}, *baseDirFlag)[0]
+				}
+				return change, true, nil
+			})
+			if skipped > 0 {
+				log.infof("Skipped %d change(s) excluded by --only or --skip.", skipped)
+			}
+			finish(filesAppliedCount, err)
+			return
+		}
+
+		jsonFilePaths := []string{"-"}
+		switch {
+		case applyCmd.NArg() >= 1:
+			jsonFilePaths = slices.Clone(applyCmd.Args())
+			if *expandPathsFlag {
+				for i := range jsonFilePaths {
+					if err := expandPaths(&jsonFilePaths[i]); err != nil {
+						log.errorf("Error %v", err)
+						os.Exit(1)
+					}
+				}
 			}
-			// Content can be empty, meaning the file should be emptied or created empty.
+		case stdinIsPiped():
+			// Read the changeset from standard input
+		default:
+			log.errorf("Error: Missing <json_file> argument for apply command.")
+			applyCmd.Usage()
+			os.Exit(1)
+		}
+
+		if *interactiveFlag && (slices.Contains(jsonFilePaths, "-") || !stdinIsTerminal()) {
+			log.errorf("Error: --interactive requires standard input to be a terminal, and cannot read the changeset from it.")
+			os.Exit(1)
+		}
 
-			// filePath from JSON is used as-is. If relative, it's relative to CWD.
-			err = writeInPlace(change.FilePath, []byte(change.Content))
+		var changesets []namedChangeset
+		for _, jsonFilePath := range jsonFilePaths {
+			changeset, err := loadChangeset(jsonFilePath)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error writing file '%s': %v\n", change.FilePath, err)
-				os.Exit(1) // Or collect errors and report at the end
+				log.errorf("Error %v", err)
+				os.Exit(1)
+			}
+			changesets = append(changesets, namedChangeset{name: jsonFilePath, changes: changeset.Changes})
+		}
+		mdiffData := MdiffJSON{Changes: changesets[0].changes}
+		if len(changesets) > 1 {
+			// Later changesets override earlier ones, as with the merge command
+			var overrides []mergeConflict
+			mdiffData.Changes, overrides = mergeChangesets(changesets)
+			for _, override := range overrides {
+				log.debugf("Change to '%s' from '%s' overridden by '%s'", override.path, override.previous, override.current)
 			}
-			fmt.Fprintf(os.Stdout, "Successfully applied changes to %s\n", change.FilePath)
-			filesAppliedCount++
 		}
 
-		if filesAppliedCount == 0 {
-			// This case might be hit if all changes had empty file_paths,
-			// or if mdiffData.Changes was initially empty (already handled).
-			fmt.Fprintln(os.Stderr, "Warning: No file changes were actually applied from the JSON file.")
-		} else {
-			fmt.Fprintf(os.Stdout, "Successfully applied %d file(s).\n", filesAppliedCount)
+		if len(mdiffData.Changes) == 0 {
+			log.warnf("No changes found in the JSON file.")
+			os.Exit(0)
+		}
+
+		mdiffData.Changes = filter.filter(mdiffData.Changes, log)
+		errs := validateChanges(mdiffData.Changes)
+		if !*strictFlag {
+			errs = warnDuplicatePaths(errs, log)
+		}
+		if *safeFlag {
+			baseDir := *baseDirFlag
+			if baseDir == "" {
+				baseDir = "."
+			}
+			errs = append(errs, checkChangePaths(mdiffData.Changes, baseDir)...)
+		}
+		if *strictFlag && *createOnlyFlag {
+			errs = append(errs, checkCreateOnly(mdiffData.Changes, *baseDirFlag)...)
+		}
+		if len(errs) > 0 {
+			for _, err := range errs {
+				log.errorf("Error: %v", err)
+			}
+			log.errorf("Error: Found %d problem(s) in the changeset; no files were written.", len(errs))
+			os.Exit(1)
+		}
+		if *baseDirFlag != "" {
+			mdiffData.Changes = resolveChangePaths(mdiffData.Changes, *baseDirFlag)
 		}
 
+		if *interactiveFlag {
+			opts.prompt = newChangePrompt(os.Stdin, os.Stderr)
+		}
+		if *dryRunFlag {
+			dryRunChanges(mdiffData.Changes, opts)
+			os.Exit(0)
+		}
+		if *checkFlag {
+			if outdated := checkChanges(mdiffData.Changes, opts); outdated > 0 {
+				log.errorf("Error: %d of %d change(s) are not applied.", outdated, len(mdiffData.Changes))
+				os.Exit(1)
+			}
+			fmt.Fprintf(applyOut, "All %d change(s) are already applied.\n", len(mdiffData.Changes))
+			os.Exit(0)
+		}
+
+		finish(applyChanges(ctx, mdiffData.Changes, opts))
+
 	case "extract":
 		extractCmd := flag.NewFlagSet("extract", flag.ExitOnError)
-		gitignorePathFlag := extractCmd.String("gitignore", "", "Path to a custom .gitignore file. If not provided,\n.gitignore in <directory_path> is used if it exists.")
+		ignoreFlags := addIgnoreFlags(extractCmd)
+		formatFlag := extractCmd.String("format", "text", "Output format: text (tagged file contents), json (a changeset\nthat can be fed back to apply) or xml.")
+		maxTokensFlag := extractCmd.Int("max-tokens", 0, "Stop adding files once the estimated token count (about 4\ncharacters per token) would exceed this budget. 0 means no limit.")
+		var warnSize, maxOutputSize byteSizeFlag
+		extractCmd.Var(&warnSize, "warn-size", "Warn when the output is larger than this size in bytes, with an\noptional K, M or G suffix, e.g. 10M. 0 means no warning.")
+		extractCmd.Var(&maxOutputSize, "max-output-size", "Stop adding files once the output would exceed this size in bytes,\nwith an optional K, M or G suffix. 0 means no limit.")
+		skipBinaryFlag := extractCmd.Bool("skip-binary", true, "Skip binary files (detected by NUL bytes in their first 8KB).\nUse --skip-binary=false to embed them as-is.")
+		binaryFlag := extractCmd.String("binary", "", "How to handle binary files: skip, base64 or raw.\nOverrides --skip-binary when set.")
+		jobsFlag := extractCmd.Int("jobs", runtime.GOMAXPROCS(0), "Number of files read concurrently.")
+		sortFlag := extractCmd.String("sort", "path", "Order of files in the output: path (by relative path), size\n(smallest first) or mtime (least recently modified first).")
+		var outputPath string
+		extractCmd.StringVar(&outputPath, "output", "", "Write the output to this file instead of stdout. The file is\nreplaced atomically once extraction succeeds.")
+		extractCmd.StringVar(&outputPath, "o", "", "Shorthand for --output.")
+		var excludeFlag, includeFlag, extFlag, excludeExtFlag stringListFlag
+		extractCmd.Var(&extFlag, "ext", "File extension to extract, e.g. .go (repeatable, and accepts\ncomma-separated lists). Added to <file_extensions>, which becomes optional.")
+		extractCmd.Var(&excludeExtFlag, "exclude-ext", "File extension to skip, e.g. .lock, even with '*' or --include\n(repeatable, and accepts comma-separated lists).")
+		extractCmd.Var(&excludeFlag, "exclude", "Glob of paths to exclude, relative to <directory_path> (repeatable).\nApplied on top of .gitignore rules and cannot be re-included by them.")
+		extractCmd.Var(&includeFlag, "include", "Glob of file names or paths to extract regardless of extension,\ne.g. Dockerfile or \"*.config.*\" (repeatable). Makes <file_extensions> optional.")
+		stdinFilesFlag := extractCmd.Bool("stdin-files", false, "Read newline-separated file paths from stdin and extract exactly\nthose files instead of scanning <directory_path>. Ignore rules do\nnot apply, and <file_extensions> optionally filters the list.")
+		lineNumbersFlag := extractCmd.Bool("line-numbers", false, "Prefix each line of the extracted files with its 1-based number.\nOnly supported with the text format.")
+		extIgnoreCaseFlag := extractCmd.Bool("ext-ignore-case", false, "Match extensions case-insensitively, so .jpg also selects\nfiles ending in .JPG.")
+		trimTrailingWhitespaceFlag := extractCmd.Bool("trim-trailing-whitespace", false, "Remove trailing whitespace from each line of the extracted files.")
+		squeezeBlankFlag := extractCmd.Bool("squeeze-blank", false, "Collapse runs of blank lines of the extracted files into a single one.")
+		normalizeEncodingFlag := extractCmd.Bool("normalize-encoding", false, "Convert files encoded in UTF-16 or Latin-1 to UTF-8, and remove\nbyte order marks. Files in other encodings are handled as binary files.")
+		stripCommentsFlag := extractCmd.Bool("strip-comments", false, "Remove comments from Go, JavaScript/TypeScript, Python, C/C++\nand shell files to shrink the output.")
+		followSymlinksFlag := extractCmd.Bool("follow-symlinks", false, "Walk symlinked directories, which are otherwise skipped. Symlinks\nleading back to one of their parent directories are skipped.")
+		maxDepthFlag := extractCmd.Int("max-depth", -1, "Only walk directories this many levels below <directory_path>.\n0 extracts the files of <directory_path> only. Negative means no limit.")
+		noHiddenFlag := extractCmd.Bool("no-hidden", false, "Skip files and directories whose name starts with a dot, such as\n.vscode or .env.")
+		relativeToFlag := extractCmd.String("relative-to", "", "Directory the output paths are relative to, which must contain\n<directory_path>. Defaults to <directory_path>.")
+		prefixFlag := extractCmd.String("prefix", "", "Path prepended to the output paths, e.g. services/api, so the\noutput can be applied from a parent directory.")
+		headerFlag := extractCmd.String("header", "", "Text output before the extracted files, e.g. instructions for an\nLLM, or @path to read it from a file.")
+		footerFlag := extractCmd.String("footer", "", "Text output after the extracted files, e.g. a question, or @path\nto read it from a file.")
+		templateFlag := extractCmd.String("template", "", "Go text/template rendering each file instead of the text format,\ne.g. '<doc path=\"{{.Path}}\">{{.Content}}</doc>', or @path to read it\nfrom a file. Fields: .Path, .Content, .Size, .Ext, .Encoding and .Deleted.")
+		documentTemplateFlag := extractCmd.String("document-template", "", "Go text/template wrapping the files rendered with --template, or\n@path to read it from a file. Fields: .Files and .Count.")
+		clipboardFlag := extractCmd.Bool("clipboard", false, "Copy the output to the system clipboard instead of printing it\n(with pbcopy, clip, wl-copy, xclip or xsel). Also written to --output\nwhen given.")
+		gzipFlag := extractCmd.Bool("gzip", false, "Compress the output with gzip. apply reads gzip-compressed\nJSON files as-is.")
+		print0Flag := extractCmd.Bool("print0", false, "Output NUL-terminated records of each file path followed by its\ncontent, for tools like xargs -0. Cannot be used with --format json.")
+		dedupFlag := extractCmd.Bool("dedup", false, "Output the content of identical files only once, later copies\nreferring to the first one. Only supported with the text format.")
+		manifestFlag := extractCmd.String("manifest", "", "Also write a JSON manifest of the extracted files, with the\nSHA-256 and size of each one, to this file.")
+		sinceManifestFlag := extractCmd.String("since-manifest", "", "Only extract the files changed since the --manifest written to\nthis file by a previous run, and list the deleted ones.")
+		filesFlag := extractCmd.String("files", "", "Comma-separated list of files to extract instead of scanning\n<directory_path>, e.g. main.go,docs/intro.md. Output paths are\nrelative to their deepest common directory.")
+		listFlag := extractCmd.Bool("list", false, "Only print the paths of the files that would be extracted, one per\nline, without reading them.")
+		watchFlag := extractCmd.Bool("watch", false, "Keep running, and extract again to --output whenever the selected\nfiles change.")
+		watchIntervalFlag := extractCmd.Duration("watch-interval", time.Second, "How often --watch checks the files for changes.")
+		mtimeAfterFlag := extractCmd.String("mtime-after", "", "Only extract files modified since this time: a duration before now\n(e.g. 24h or 7d), an RFC 3339 timestamp or a YYYY-MM-DD date.")
+		mtimeBeforeFlag := extractCmd.String("mtime-before", "", "Only extract files last modified before this time, in the same\nformats as --mtime-after.")
+		var minSize, maxSize byteSizeFlag
+		extractCmd.Var(&minSize, "min-size", "Skip files smaller than this size in bytes, with an optional K, M\nor G suffix, e.g. 100 to leave out stubs.")
+		extractCmd.Var(&maxSize, "max-size", "Skip files larger than this size in bytes, with an optional K, M\nor G suffix, e.g. 1M to leave out huge blobs. 0 means no limit.")
+		onErrorFlag := extractCmd.String("on-error", "skip", "What to do with files that cannot be read: skip them with a warning,\nstop at the first one, or collect them, failing once the output is written.")
+		gitTrackedOnlyFlag := extractCmd.Bool("git-tracked-only", false, "Only extract the files tracked by Git, skipping untracked ones even\nif they are not ignored. <directory_path> must be in a Git repository.")
+		changedSinceFlag := extractCmd.String("changed-since", "", "Only extract the files changed since this Git ref, e.g. main or\nHEAD~3, as listed by git diff --name-only <ref>.")
+		baseDirFlag := extractCmd.String("base-dir", "", "Directory the --stdin-files paths are relative to, also used for\nthe paths in the output. Defaults to the current directory.")
+		expandPathsFlag := extractCmd.Bool("expand-paths", false, "Expand a leading ~ and $VAR or ${VAR} environment variables in\n<directory_path> and the path flags, for quoted or scripted arguments.")
 
 		extractCmd.Usage = func() { printExtractUsage(extractCmd) }
 
-		err := extractCmd.Parse(os.Args[2:])
+		err := extractCmd.Parse(args[1:])
 		if err != nil {
 			os.Exit(1)
 		}
+		if err := cfg.apply(extractCmd, "extensions"); err != nil {
+			log.errorf("Error in config file: %v", err)
+			os.Exit(1)
+		}
+		// expand expands the given path arguments with --expand-paths
+		expand := func(paths ...*string) {
+			if !*expandPathsFlag {
+				return
+			}
+			if err := expandPaths(paths...); err != nil {
+				log.errorf("Error %v", err)
+				os.Exit(1)
+			}
+		}
+		expand(&outputPath, baseDirFlag, relativeToFlag, manifestFlag, sinceManifestFlag, ignoreFlags.gitignorePath)
+		for i := range ignoreFlags.extraGitignores {
+			expand(&ignoreFlags.extraGitignores[i])
+		}
+		for _, textFlag := range []*string{headerFlag, footerFlag, templateFlag, documentTemplateFlag} {
+			if filePath, ok := strings.CutPrefix(*textFlag, "@"); ok {
+				expand(&filePath)
+				*textFlag = "@" + filePath
+			}
+		}
 
-		if extractCmd.NArg() < 2 {
-			fmt.Fprintln(os.Stderr, "Error: Missing <directory_path> or <file_extensions> for extract command.")
+		var listedFiles []string
+		if *filesFlag != "" {
+			if *stdinFilesFlag {
+				log.errorf("Error: --files and --stdin-files cannot be used together.")
+				os.Exit(1)
+			}
+			for _, filePath := range strings.Split(*filesFlag, ",") {
+				if filePath = strings.TrimSpace(filePath); filePath != "" {
+					listedFiles = append(listedFiles, filePath)
+				}
+			}
+			if len(listedFiles) == 0 {
+				log.errorf("Error: No files provided with --files.")
+				os.Exit(1)
+			}
+		}
+
+		var directoryPath, extensionsStr string
+		if listedFiles != nil {
+			if extractCmd.NArg() > 1 {
+				log.errorf("Error: <directory_path> cannot be used with --files.")
+				extractCmd.Usage()
+				os.Exit(1)
+			}
+			if *baseDirFlag != "" {
+				log.errorf("Error: --base-dir can only be used with --stdin-files.")
+				os.Exit(1)
+			}
+			for i, filePath := range listedFiles {
+				expand(&filePath)
+				if listedFiles[i], err = filepath.Abs(filePath); err != nil {
+					log.errorf("Error getting absolute path for file '%s': %v", filePath, err)
+					os.Exit(1)
+				}
+			}
+			directoryPath = commonDir(listedFiles)
+			extensionsStr = extractCmd.Arg(0)
+		} else if *stdinFilesFlag {
+			if extractCmd.NArg() > 1 {
+				log.errorf("Error: <directory_path> cannot be used with --stdin-files, use --base-dir instead.")
+				extractCmd.Usage()
+				os.Exit(1)
+			}
+			directoryPath = *baseDirFlag
+			if directoryPath == "" {
+				directoryPath = "."
+			}
+			extensionsStr = extractCmd.Arg(0)
+		} else {
+			if *baseDirFlag != "" {
+				log.errorf("Error: --base-dir can only be used with --stdin-files.")
+				os.Exit(1)
+			}
+			if extractCmd.NArg() < 2 && (extractCmd.NArg() < 1 || len(includeFlag) == 0 && len(extFlag) == 0 && cfg.string("extract", "extensions") == "") {
+				log.errorf("Error: Missing <directory_path> or <file_extensions> for extract command.")
+				extractCmd.Usage()
+				os.Exit(1)
+			}
+			directoryPath = extractCmd.Arg(0)
+			extensionsStr = extractCmd.Arg(1)
+			expand(&directoryPath)
+		}
+		if extensionsStr == "" && len(extFlag) == 0 {
+			extensionsStr = cfg.string("extract", "extensions")
+		}
+
+		extensions := parseExtensions(extensionsStr)
+		for _, ext := range extFlag {
+			extensions = append(extensions, parseExtensions(ext)...)
+		}
+		var excludeExtensions []string
+		for _, ext := range excludeExtFlag {
+			excludeExtensions = append(excludeExtensions, parseExtensions(ext)...)
+		}
+		if len(extensions) == 0 && len(includeFlag) == 0 && !*stdinFilesFlag && listedFiles == nil {
+			log.errorf("Error: No valid file extensions provided.")
 			extractCmd.Usage()
 			os.Exit(1)
 		}
+		binaryMode := *binaryFlag
+		if binaryMode == "" {
+			binaryMode = "skip"
+			if !*skipBinaryFlag {
+				binaryMode = "raw"
+			}
+		}
+		if binaryMode != "skip" && binaryMode != "base64" && binaryMode != "raw" {
+			log.errorf("Error: Unknown binary mode '%s'. Expected 'skip', 'base64' or 'raw'.", binaryMode)
+			os.Exit(1)
+		}
+		if *sortFlag != "path" && *sortFlag != "size" && *sortFlag != "mtime" {
+			log.errorf("Error: Unknown sort mode '%s'. Expected 'path', 'size' or 'mtime'.", *sortFlag)
+			os.Exit(1)
+		}
+		if *formatFlag != "text" && *formatFlag != "json" && *formatFlag != "xml" {
+			log.errorf("Error: Unknown format '%s'. Expected 'text', 'json' or 'xml'.", *formatFlag)
+			os.Exit(1)
+		}
+		if *lineNumbersFlag && *formatFlag != "text" {
+			log.errorf("Error: --line-numbers can only be used with the text format.")
+			os.Exit(1)
+		}
+		format := *formatFlag
+		if *print0Flag {
+			if format != "text" {
+				log.errorf("Error: --print0 cannot be used with --format %s.", format)
+				os.Exit(1)
+			}
+			if binaryMode == "raw" {
+				log.errorf("Error: --print0 cannot embed raw binary files, which may contain NUL bytes. Use --binary base64 or skip them.")
+				os.Exit(1)
+			}
+			format = "print0"
+		}
+		var outputTmpl *outputTemplate
+		if *templateFlag != "" {
+			if format != "text" {
+				log.errorf("Error: --template cannot be used with --format %s.", format)
+				os.Exit(1)
+			}
+			templateText, err := readTextArg(*templateFlag)
+			if err != nil {
+				log.errorf("Error reading --template: %v", err)
+				os.Exit(1)
+			}
+			documentText, err := readTextArg(*documentTemplateFlag)
+			if err != nil {
+				log.errorf("Error reading --document-template: %v", err)
+				os.Exit(1)
+			}
+			outputTmpl, err = parseOutputTemplate(templateText, documentText)
+			if err != nil {
+				log.errorf("Error in template: %v", err)
+				os.Exit(1)
+			}
+			format = "template"
+		} else if *documentTemplateFlag != "" {
+			log.errorf("Error: --document-template requires --template.")
+			os.Exit(1)
+		}
+		var mtimeAfter, mtimeBefore time.Time
+		now := time.Now()
+		if *mtimeAfterFlag != "" {
+			if mtimeAfter, err = parseTimeBound(*mtimeAfterFlag, now); err != nil {
+				log.errorf("Error in --mtime-after: %v", err)
+				os.Exit(1)
+			}
+		}
+		if *mtimeBeforeFlag != "" {
+			if mtimeBefore, err = parseTimeBound(*mtimeBeforeFlag, now); err != nil {
+				log.errorf("Error in --mtime-before: %v", err)
+				os.Exit(1)
+			}
+		}
+		if !mtimeAfter.IsZero() && !mtimeBefore.IsZero() && !mtimeAfter.Before(mtimeBefore) {
+			log.errorf("Error: --mtime-after must be earlier than --mtime-before.")
+			os.Exit(1)
+		}
+		if *onErrorFlag != "skip" && *onErrorFlag != "stop" && *onErrorFlag != "collect" {
+			log.errorf("Error: Unknown --on-error policy '%s'. Expected 'skip', 'stop' or 'collect'.", *onErrorFlag)
+			os.Exit(1)
+		}
+		if maxSize > 0 && minSize > maxSize {
+			log.errorf("Error: --min-size must not be larger than --max-size.")
+			os.Exit(1)
+		}
+		if (*gitTrackedOnlyFlag || *changedSinceFlag != "") && (*stdinFilesFlag || *filesFlag != "") {
+			log.errorf("Error: --git-tracked-only and --changed-since cannot be used with --stdin-files or --files.")
+			os.Exit(1)
+		}
+		if *listFlag && (*manifestFlag != "" || *sinceManifestFlag != "") {
+			log.errorf("Error: --list cannot be used with --manifest or --since-manifest, which need the file contents.")
+			os.Exit(1)
+		}
+		if *watchFlag && (outputPath == "" || *stdinFilesFlag) {
+			log.errorf("Error: --watch requires --output, and cannot be used with --stdin-files.")
+			os.Exit(1)
+		}
+		if *clipboardFlag && (*gzipFlag || *listFlag) {
+			log.errorf("Error: --clipboard cannot be used with --gzip or --list.")
+			os.Exit(1)
+		}
+		if *watchIntervalFlag <= 0 {
+			log.errorf("Error: --watch-interval must be positive.")
+			os.Exit(1)
+		}
+		if *dedupFlag && format != "text" {
+			log.errorf("Error: --dedup can only be used with the text format.")
+			os.Exit(1)
+		}
+		if (*headerFlag != "" || *footerFlag != "") && format != "text" && format != "template" {
+			log.errorf("Error: --header and --footer can only be used with the text format or --template.")
+			os.Exit(1)
+		}
+		header, err := readTextArg(*headerFlag)
+		if err != nil {
+			log.errorf("Error reading --header: %v", err)
+			os.Exit(1)
+		}
+		footer, err := readTextArg(*footerFlag)
+		if err != nil {
+			log.errorf("Error reading --footer: %v", err)
+			os.Exit(1)
+		}
+
+		absScanDir := resolveScanDir(directoryPath, log)
+		var relativeTo string
+		if *relativeToFlag != "" {
+			relativeTo = resolveScanDir(*relativeToFlag, log)
+			if !apply.IsWithin(relativeTo, absScanDir) {
+				log.errorf("Error: --relative-to directory '%s' does not contain '%s'.", relativeTo, absScanDir)
+				os.Exit(1)
+			}
+		}
+
+		var prefix string
+		if *prefixFlag != "" {
+			prefix = path.Clean(filepath.ToSlash(*prefixFlag))
+		}
+
+		fileList := listedFiles
+		if *stdinFilesFlag {
+			fileList, err = readFileList(os.Stdin)
+			if err != nil {
+				log.errorf("Error reading file list from stdin: %v", err)
+				os.Exit(1)
+			}
+		}
+
+		ignoreMatcher, err := newScanMatcher(ignoreFlags.options(absScanDir), absScanDir, log)
+		if err != nil {
+			log.errorf("Error initializing gitignore matcher: %v", err)
+			os.Exit(1)
+		}
 
-		directoryPath := extractCmd.Arg(0)
-		extensionsStr := extractCmd.Arg(1)
-		rawExtensions := strings.Split(extensionsStr, ",")
-		var extensions []string
-		for _, ext := range rawExtensions {
-			trimmedExt := strings.TrimSpace(ext)
-			if trimmedExt != "" {
-				// Ensure extensions start with a dot if not already
-				if !strings.HasPrefix(trimmedExt, ".") {
-					trimmedExt = "." + trimmedExt
+		var gitPaths map[string]bool
+		if *gitTrackedOnlyFlag {
+			gitPaths, err = gitTrackedPaths(absScanDir)
+			if err != nil {
+				log.errorf("Error listing Git tracked files: %v", err)
+				os.Exit(1)
+			}
+		}
+		if *changedSinceFlag != "" {
+			changed, err := gitChangedPaths(absScanDir, *changedSinceFlag)
+			if err != nil {
+				log.errorf("Error listing files changed since '%s': %v", *changedSinceFlag, err)
+				os.Exit(1)
+			}
+			if gitPaths != nil {
+				// Both apply: keep the tracked files that changed
+				for p := range gitPaths {
+					if !changed[p] {
+						delete(gitPaths, p)
+					}
 				}
-				extensions = append(extensions, trimmedExt)
+			} else {
+				gitPaths = changed
 			}
 		}
-		if len(extensions) == 0 {
-			fmt.Fprintln(os.Stderr, "Error: No valid file extensions provided.")
-			extractCmd.Usage()
+
+		var manifest, sinceManifest *extractManifest
+		if *manifestFlag != "" {
+			manifest = &extractManifest{}
+		}
+		if *sinceManifestFlag != "" {
+			sinceManifest, err = loadManifest(*sinceManifestFlag)
+			if err != nil {
+				log.errorf("Error %v", err)
+				os.Exit(1)
+			}
+		}
+
+		opts := extractOptions{
+			extensions:        extensions,
+			excludeExtensions: excludeExtensions,
+			ignoreMatcher:     ignoreMatcher,
+			ignoreDebug:       *ignoreFlags.debug,
+			includeGit:        *ignoreFlags.includeGit,
+			excludes:          excludeFlag,
+			includes:          includeFlag,
+			format:            format,
+			template:          outputTmpl,
+			maxTokens:         *maxTokensFlag,
+			warnSize:          int64(warnSize),
+			maxOutputSize:     int64(maxOutputSize),
+			binaryMode:        binaryMode,
+			jobs:              *jobsFlag,
+			sortBy:            *sortFlag,
+			fileList:          fileList,
+			lineNumbers:       *lineNumbersFlag,
+			stripComments:     *stripCommentsFlag,
+			normalizeEncoding: *normalizeEncodingFlag,
+			trimTrailingSpace: *trimTrailingWhitespaceFlag,
+			squeezeBlank:      *squeezeBlankFlag,
+			logger:            log,
+			extIgnoreCase:     *extIgnoreCaseFlag,
+			followSymlinks:    *followSymlinksFlag,
+			maxDepth:          *maxDepthFlag,
+			noHidden:          *noHiddenFlag,
+			relativeTo:        relativeTo,
+			prefix:            prefix,
+			dedup:             *dedupFlag,
+			manifest:          manifest,
+			sinceManifest:     sinceManifest,
+			listOnly:          *listFlag,
+			mtimeAfter:        mtimeAfter,
+			mtimeBefore:       mtimeBefore,
+			minSize:           int64(minSize),
+			maxSize:           int64(maxSize),
+			gitPaths:          gitPaths,
+			onError:           *onErrorFlag,
+		}
+		// Ctrl-C stops the extraction, flushing the output written so far
+		// to stdout, or leaving --output untouched.
+		ctx, stop := interruptContext()
+		defer stop()
+		// runExtract extracts the files and writes the output, along with
+		// the manifest if requested.
+		runExtract := func() error {
+			if manifest != nil {
+				*manifest = extractManifest{}
+			}
+			// The output is streamed to --output or stdout as the files are
+			// read, and only held in memory to copy it to the clipboard.
+			var clipboard bytes.Buffer
+			var output io.Writer = &clipboard
+			var outputFile *apply.PendingFile
+			if !*clipboardFlag {
+				if outputPath != "" {
+					var err error
+					if outputFile, err = apply.Create(outputPath); err != nil {
+						return fmt.Errorf("writing output file '%s': %w", outputPath, err)
+					}
+					output = outputFile
+				} else {
+					stdout := bufio.NewWriter(os.Stdout)
+					defer stdout.Flush()
+					output = stdout
+				}
+			}
+			err := writeExtractOutput(ctx, output, absScanDir, opts, header, footer, *gzipFlag)
+			opts.cache.sweep()
+			// With --on-error collect, the output is written before failing
+			var collected *collectedErrors
+			if err != nil && !errors.As(err, &collected) {
+				if outputFile != nil {
+					outputFile.Abort()
+				}
+				return err
+			}
+			if outputFile != nil {
+				if err := outputFile.Commit(); err != nil {
+					return fmt.Errorf("writing output file '%s': %w", outputPath, err)
+				}
+			}
+			if *clipboardFlag {
+				if outputPath != "" {
+					if err := apply.WriteInPlace(outputPath, clipboard.Bytes()); err != nil {
+						return fmt.Errorf("writing output file '%s': %w", outputPath, err)
+					}
+				}
+				if err := copyToClipboard(clipboard.Bytes()); err != nil {
+					return fmt.Errorf("copying output to the clipboard: %w", err)
+				}
+				log.infof("Copied %d bytes to the clipboard.", clipboard.Len())
+			}
+			if manifest != nil {
+				manifestJSON, err := manifest.marshal()
+				if err == nil {
+					err = apply.WriteInPlace(*manifestFlag, manifestJSON)
+				}
+				if err != nil {
+					return fmt.Errorf("writing manifest file '%s': %w", *manifestFlag, err)
+				}
+			}
+			return err
+		}
+
+		if *watchFlag {
+			opts.cache = newContentCache()
+			outputAbs, _ := filepath.Abs(outputPath)
+			exitIfInterrupted(watchExtract(ctx, absScanDir, opts, *watchIntervalFlag, outputAbs, runExtract), log)
+		}
+		if err := runExtract(); err != nil {
+			exitIfInterrupted(err, log)
+			log.errorf("Error %v", err)
+			os.Exit(1)
+		}
+
+	case "patch":
+		patchCmd := flag.NewFlagSet("patch", flag.ExitOnError)
+		stripFlag := patchCmd.Int("p", 1, "Number of leading path components to strip from file names,\nas for patch -p. The default drops the a/ and b/ prefixes of git diffs.")
+		dryRunFlag := patchCmd.Bool("dry-run", false, "Check that the patch applies without writing anything.")
+		patchCmd.Usage = func() { printPatchUsage(patchCmd) }
+
+		err := patchCmd.Parse(args[1:])
+		if err != nil {
+			os.Exit(1)
+		}
+		if err := cfg.apply(patchCmd); err != nil {
+			log.errorf("Error in config file: %v", err)
+			os.Exit(1)
+		}
+
+		patchFilePath := "-"
+		switch {
+		case patchCmd.NArg() == 1:
+			patchFilePath = patchCmd.Arg(0)
+		case patchCmd.NArg() == 0 && stdinIsPiped():
+			// Read the patch from standard input
+		default:
+			log.errorf("Error: Missing <patch_file> argument for patch command.")
+			patchCmd.Usage()
 			os.Exit(1)
 		}
 
-		absScanDir, err := filepath.Abs(directoryPath)
+		patchBytes, err := readInput(patchFilePath)
+		if err != nil {
+			log.errorf("Error reading patch file '%s': %v", patchFilePath, err)
+			os.Exit(1)
+		}
+		patches, err := parsePatch(string(patchBytes))
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error getting absolute path for directory '%s': %v\n", directoryPath, err)
+			log.errorf("Error parsing patch file '%s': %v", patchFilePath, err)
+			os.Exit(1)
+		}
+		if len(patches) == 0 {
+			log.warnf("No file changes found in the patch.")
+			os.Exit(0)
+		}
+
+		patched, failed := applyPatches(patches, *stripFlag, *dryRunFlag, log)
+		if failed > 0 {
+			log.errorf("Error: %d file(s) could not be patched, %d patched.", failed, patched)
 			os.Exit(1)
 		}
 
-		dirInfo, err := os.Stat(absScanDir)
+	case "merge":
+		mergeCmd := flag.NewFlagSet("merge", flag.ExitOnError)
+		strictFlag := mergeCmd.Bool("strict", false, "Fail if two files change the same path differently.")
+		mergeCmd.Usage = func() { printMergeUsage(mergeCmd) }
+
+		err := mergeCmd.Parse(args[1:])
 		if err != nil {
-			if os.IsNotExist(err) {
-				fmt.Fprintf(os.Stderr, "Error: Directory '%s' does not exist.\n", absScanDir)
+			os.Exit(1)
+		}
+		if err := cfg.apply(mergeCmd); err != nil {
+			log.errorf("Error in config file: %v", err)
+			os.Exit(1)
+		}
+
+		if mergeCmd.NArg() < 1 {
+			log.errorf("Error: Missing <json_file> arguments for merge command.")
+			mergeCmd.Usage()
+			os.Exit(1)
+		}
+
+		var changesets []namedChangeset
+		for _, jsonFilePath := range mergeCmd.Args() {
+			mdiffData, err := loadChangeset(jsonFilePath)
+			if err != nil {
+				log.errorf("Error %v", err)
+				os.Exit(1)
+			}
+			changesets = append(changesets, namedChangeset{name: jsonFilePath, changes: mdiffData.Changes})
+		}
+
+		merged, conflicts := mergeChangesets(changesets)
+		for _, conflict := range conflicts {
+			if *strictFlag {
+				log.errorf("Error: %v", conflict)
 			} else {
-				fmt.Fprintf(os.Stderr, "Error accessing directory '%s': %v\n", absScanDir, err)
+				log.warnf("%v", conflict)
+			}
+		}
+		if *strictFlag && len(conflicts) > 0 {
+			log.errorf("Error: Found %d conflict(s); nothing was merged.", len(conflicts))
+			os.Exit(1)
+		}
+
+		output, err := formatJSON(merged)
+		if err != nil {
+			log.errorf("Error merging changesets: %v", err)
+			os.Exit(1)
+		}
+		fmt.Print(output)
+
+	case "stats":
+		statsCmd := flag.NewFlagSet("stats", flag.ExitOnError)
+		ignoreFlags := addIgnoreFlags(statsCmd)
+		formatFlag := statsCmd.String("format", "text", "Output format: text (a table) or json.")
+		statsCmd.Usage = func() { printStatsUsage(statsCmd) }
+
+		err := statsCmd.Parse(args[1:])
+		if err != nil {
+			os.Exit(1)
+		}
+		if err := cfg.apply(statsCmd); err != nil {
+			log.errorf("Error in config file: %v", err)
+			os.Exit(1)
+		}
+
+		if statsCmd.NArg() < 2 {
+			log.errorf("Error: Missing <directory_path> or <file_extensions> for stats command.")
+			statsCmd.Usage()
+			os.Exit(1)
+		}
+		extensions := parseExtensions(statsCmd.Arg(1))
+		if len(extensions) == 0 {
+			log.errorf("Error: No valid file extensions provided.")
+			statsCmd.Usage()
+			os.Exit(1)
+		}
+		if *formatFlag != "text" && *formatFlag != "json" {
+			log.errorf("Error: Unknown format '%s'. Expected 'text' or 'json'.", *formatFlag)
+			os.Exit(1)
+		}
+
+		absScanDir := resolveScanDir(statsCmd.Arg(0), log)
+
+		ignoreMatcher, err := newScanMatcher(ignoreFlags.options(absScanDir), absScanDir, log)
+		if err != nil {
+			log.errorf("Error initializing gitignore matcher: %v", err)
+			os.Exit(1)
+		}
+
+		report, err := computeStats(absScanDir, extractOptions{
+			extensions:    extensions,
+			ignoreMatcher: ignoreMatcher,
+			ignoreDebug:   *ignoreFlags.debug,
+			includeGit:    *ignoreFlags.includeGit,
+			logger:        log,
+			maxDepth:      -1,
+		})
+		if err != nil {
+			log.errorf("Error computing stats: %v", err)
+			os.Exit(1)
+		}
+
+		if *formatFlag == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(report); err != nil {
+				log.errorf("Error encoding JSON output: %v", err)
+				os.Exit(1)
 			}
+		} else {
+			printStatsTable(report)
+		}
+
+	case "tree":
+		treeCmd := flag.NewFlagSet("tree", flag.ExitOnError)
+		ignoreFlags := addIgnoreFlags(treeCmd)
+		allFlag := treeCmd.Bool("all", false, "Also show ignored and excluded files, marked with [ignored].")
+		extIgnoreCaseFlag := treeCmd.Bool("ext-ignore-case", false, "Match <file_extensions> case-insensitively, as for extract.")
+		var excludes, includes stringListFlag
+		treeCmd.Var(&excludes, "exclude", "Glob of paths to leave out, as for extract. Can be repeated.")
+		treeCmd.Var(&includes, "include", "Glob of paths to show regardless of their extension, as for extract. Can be repeated.")
+		treeCmd.Usage = func() { printTreeUsage(treeCmd) }
+
+		err := treeCmd.Parse(args[1:])
+		if err != nil {
 			os.Exit(1)
 		}
-		if !dirInfo.IsDir() {
-			fmt.Fprintf(os.Stderr, "Error: Path '%s' is not a directory.\n", absScanDir)
+		if err := cfg.apply(treeCmd); err != nil {
+			log.errorf("Error in config file: %v", err)
 			os.Exit(1)
 		}
 
-		ignoreMatcher, err := NewIgnoreMatcher(*gitignorePathFlag, absScanDir)
+		if treeCmd.NArg() < 2 {
+			log.errorf("Error: Missing <directory_path> or <file_extensions> for tree command.")
+			treeCmd.Usage()
+			os.Exit(1)
+		}
+		extensions := parseExtensions(treeCmd.Arg(1))
+		if len(extensions) == 0 {
+			log.errorf("Error: No valid file extensions provided.")
+			treeCmd.Usage()
+			os.Exit(1)
+		}
+
+		absScanDir := resolveScanDir(treeCmd.Arg(0), log)
+
+		ignoreMatcher, err := newScanMatcher(ignoreFlags.options(absScanDir), absScanDir, log)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error initializing gitignore matcher: %v\n", err)
+			log.errorf("Error initializing gitignore matcher: %v", err)
 			os.Exit(1)
 		}
 
-		extractedContent, err := extractFileContent(absScanDir, extensions, ignoreMatcher)
+		candidates, err := walkCandidates(context.Background(), absScanDir, extractOptions{
+			extensions:    extensions,
+			ignoreMatcher: ignoreMatcher,
+			ignoreDebug:   *ignoreFlags.debug,
+			includeGit:    *ignoreFlags.includeGit,
+			excludes:      excludes,
+			includes:      includes,
+			keepIgnored:   *allFlag,
+			logger:        log,
+			extIgnoreCase: *extIgnoreCaseFlag,
+			maxDepth:      -1,
+		})
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error extracting content: %v\n", err)
+			log.errorf("Error walking directory: %v", err)
 			os.Exit(1)
 		}
-		fmt.Print(extractedContent)
+		fmt.Print(renderTree(treeCmd.Arg(0), candidates))
+
+	case "version":
+		fmt.Println(versionString())
 
 	default:
-		fmt.Fprintf(os.Stderr, "Error: Unknown command \"%s\"\n\n", command)
+		log.errorf("Error: Unknown command \"%s\"\n", command)
 		printMainUsage()
 		os.Exit(1)
 	}
@@ -0,0 +1,3138 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/moul-dev/copilot/pkg/ignore"
+)
+
+// binDir holds the copilot binary built for the tests running the command
+// line, see runCopilot.
+var binDir string
+
+func TestMain(m *testing.M) {
+	var err error
+	binDir, err = os.MkdirTemp("", "copilot-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(binDir)
+	os.Exit(code)
+}
+
+// buildCopilot builds the copilot binary into binDir the first time it is
+// called, and returns its path.
+var buildCopilot = sync.OnceValues(func() (string, error) {
+	binPath := filepath.Join(binDir, "copilot")
+	if runtime.GOOS == "windows" {
+		binPath += ".exe"
+	}
+	out, err := exec.Command("go", "build", "-o", binPath, ".").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("building copilot: %v\n%s", err, out)
+	}
+	return binPath, nil
+})
+
+// runCopilot runs copilot with args in dir, feeding it stdin, and returns
+// what it printed on stdout and stderr along with its exit code.
+func runCopilot(t *testing.T, dir, stdin string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	binPath, err := buildCopilot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(binPath, args...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(stdin)
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &outBuf, &errBuf
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatal(err)
+	}
+	return outBuf.String(), errBuf.String(), cmd.ProcessState.ExitCode()
+}
+
+// writeFiles creates the files of tree in dir, along with their parent
+// directories. Keys are slash-separated paths relative to dir.
+func writeFiles(t *testing.T, dir string, tree map[string]string) {
+	t.Helper()
+	for relPath, content := range tree {
+		filePath := filepath.Join(dir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readFile returns the content of the file at filePath.
+func readFile(t *testing.T, filePath string) string {
+	t.Helper()
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// captureApplyOut records the messages printed by apply for the rest of
+// the test in the returned buffer.
+func captureApplyOut(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var out bytes.Buffer
+	previous := applyOut
+	applyOut = &out
+	t.Cleanup(func() { applyOut = previous })
+	return &out
+}
+
+// listFiles returns the slash-separated paths of the files below dir,
+// relative to it, in lexical order.
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(filePath string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, filePath)
+		files = append(files, filepath.ToSlash(relPath))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// fileContents returns the files below dir as "path=content" strings, with
+// slash-separated paths relative to dir, in lexical order.
+func fileContents(t *testing.T, dir string) []string {
+	t.Helper()
+	var contents []string
+	for _, relPath := range listFiles(t, dir) {
+		contents = append(contents, relPath+"="+readFile(t, filepath.Join(dir, filepath.FromSlash(relPath))))
+	}
+	return contents
+}
+
+// quietLogger returns a logger discarding everything but errors.
+func quietLogger() *logger {
+	return &logger{out: io.Discard, level: levelError}
+}
+
+// rootMatcher returns the matcher for the .gitignore file of dir.
+func rootMatcher(t *testing.T, dir string) *ignore.Matcher {
+	t.Helper()
+	matcher, err := ignore.New("", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matcher
+}
+
+// extractedPaths returns the paths extracted from dir with opts, as
+// listed by --dry-run.
+func extractedPaths(t *testing.T, dir string, opts extractOptions) []string {
+	t.Helper()
+	opts.listOnly = true
+	if opts.logger == nil {
+		opts.logger = quietLogger()
+	}
+	out, err := extractFileContent(context.Background(), dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Fields(out)
+}
+
+func TestExtractNestedGitignore(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore":                "*.log\n",
+		"main.go":                   "package main\n",
+		"debug.log":                 "",
+		"inner/.gitignore":          "generated.go\n!keep.log\n",
+		"inner/generated.go":        "package inner\n",
+		"inner/inner.go":            "package inner\n",
+		"inner/keep.log":            "",
+		"inner/deeper/generated.go": "package deeper\n",
+		"sibling/generated.go":      "package sibling\n",
+		"sibling/keep.log":          "",
+	})
+
+	tests := []struct {
+		name       string
+		extensions []string
+		want       []string
+	}{
+		{
+			name:       "inner gitignore excludes a file the outer one does not",
+			extensions: []string{".go"},
+			want:       []string{"inner/inner.go", "main.go", "sibling/generated.go"},
+		},
+		{
+			name:       "inner negation does not leak to siblings",
+			extensions: []string{".log"},
+			want:       []string{"inner/keep.log"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractedPaths(t, dir, extractOptions{
+				extensions:    tt.extensions,
+				ignoreMatcher: rootMatcher(t, dir),
+				maxDepth:      -1,
+			})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("extracted %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractExcludes(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore":             "!vendor/keep.js\n",
+		"app.js":                 "",
+		"app.min.js":             "",
+		"lib/util.js":            "",
+		"lib/util.min.js":        "",
+		"vendor/dep.js":          "",
+		"vendor/keep.js":         "",
+		"web/vendor/dep.js":      "",
+		"web/src/page/index.js":  "",
+		"web/src/page/index.css": "",
+	})
+
+	tests := []struct {
+		name     string
+		excludes []string
+		want     []string
+	}{
+		{
+			name: "no exclude",
+			want: []string{"app.js", "app.min.js", "lib/util.js", "lib/util.min.js", "vendor/dep.js", "vendor/keep.js", "web/src/page/index.js", "web/vendor/dep.js"},
+		},
+		{
+			name:     "multiple excludes",
+			excludes: []string{"*.min.js", "vendor/**"},
+			want:     []string{"app.js", "lib/util.js", "web/src/page/index.js", "web/vendor/dep.js"},
+		},
+		{
+			name:     "glob spanning directories",
+			excludes: []string{"web/**/index.js"},
+			want:     []string{"app.js", "app.min.js", "lib/util.js", "lib/util.min.js", "vendor/dep.js", "vendor/keep.js", "web/vendor/dep.js"},
+		},
+		{
+			name:     "unanchored directory at any depth",
+			excludes: []string{"vendor/"},
+			want:     []string{"app.js", "app.min.js", "lib/util.js", "lib/util.min.js", "web/src/page/index.js"},
+		},
+		{
+			name:     "brace expansion",
+			excludes: []string{"{lib,web/{src,vendor}}/"},
+			want:     []string{"app.js", "app.min.js", "vendor/dep.js", "vendor/keep.js"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractedPaths(t, dir, extractOptions{
+				extensions:    []string{".js"},
+				ignoreMatcher: rootMatcher(t, dir),
+				excludes:      tt.excludes,
+				maxDepth:      -1,
+			})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("extracted %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractNestedNodeModules(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore":                          "node_modules/\n",
+		"index.js":                            "",
+		"node_modules/a/index.js":             "",
+		"web/app.js":                          "",
+		"web/node_modules/b/index.js":         "",
+		"packages/ui/src/button.js":           "",
+		"packages/ui/node_modules/c/index.js": "",
+		"packages/ui/node_modules/c/node_modules/d/index.js": "",
+	})
+	got := extractedPaths(t, dir, extractOptions{
+		extensions:    []string{".js"},
+		ignoreMatcher: rootMatcher(t, dir),
+		maxDepth:      -1,
+	})
+	if want := []string{"index.js", "packages/ui/src/button.js", "web/app.js"}; !slices.Equal(got, want) {
+		t.Errorf("extracted %q, want %q", got, want)
+	}
+}
+
+func TestExtractSkipsGitDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore":         "!.git/\n",
+		".git/config":        "[core]\n",
+		".git/hooks/pre.sh":  "",
+		".github/ci.yml":     "",
+		"main.go":            "",
+		"sub/module/.git":    "gitdir: ../../.git/modules/sub\n",
+		"sub/module/mod.go":  "",
+		"sub/.git/HEAD":      "ref: refs/heads/main\n",
+		"sub/.gitattributes": "",
+	})
+	tests := []struct {
+		name       string
+		includeGit bool
+		want       []string
+	}{
+		{
+			name: "default",
+			want: []string{".github/ci.yml", ".gitignore", "main.go", "sub/.gitattributes", "sub/module/mod.go"},
+		},
+		{
+			name:       "--include-git",
+			includeGit: true,
+			want:       []string{".git/config", ".git/hooks/pre.sh", ".github/ci.yml", ".gitignore", "main.go", "sub/.git/HEAD", "sub/.gitattributes", "sub/module/.git", "sub/module/mod.go"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractedPaths(t, dir, extractOptions{
+				extensions:    []string{"*"},
+				ignoreMatcher: rootMatcher(t, dir),
+				includeGit:    tt.includeGit,
+				maxDepth:      -1,
+			})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("extracted %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractIncludes(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"Dockerfile":           "FROM scratch\n",
+		"Makefile":             "all:\n",
+		"main.go":              "package main\n",
+		"app.config.js":        "",
+		"app.js":               "",
+		"deploy/Dockerfile":    "FROM scratch\n",
+		"deploy/tsconfig.json": "{}\n",
+		"README.md":            "",
+	})
+
+	tests := []struct {
+		name       string
+		extensions []string
+		includes   []string
+		want       []string
+	}{
+		{
+			name:     "extension-less file names at any depth",
+			includes: []string{"Dockerfile", "Makefile"},
+			want:     []string{"Dockerfile", "Makefile", "deploy/Dockerfile"},
+		},
+		{
+			name:     "compound pattern",
+			includes: []string{"*.config.*"},
+			want:     []string{"app.config.js"},
+		},
+		{
+			name:       "includes or extensions",
+			extensions: []string{".go"},
+			includes:   []string{"Dockerfile", "*config.*"},
+			want:       []string{"Dockerfile", "app.config.js", "deploy/Dockerfile", "deploy/tsconfig.json", "main.go"},
+		},
+		{
+			name:     "anchored include",
+			includes: []string{"/Dockerfile", "deploy/*.json"},
+			want:     []string{"Dockerfile", "deploy/tsconfig.json"},
+		},
+		{
+			name:     "brace expansion",
+			includes: []string{"{Docker,Make}file", "deploy/*.{json,yaml}"},
+			want:     []string{"Dockerfile", "Makefile", "deploy/Dockerfile", "deploy/tsconfig.json"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractedPaths(t, dir, extractOptions{
+				extensions: tt.extensions,
+				includes:   tt.includes,
+				maxDepth:   -1,
+			})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("extracted %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractJSONRoundTrip(t *testing.T) {
+	tree := map[string]string{
+		"quotes.txt":  "She said \"hi\" and left\n",
+		"escapes.txt": "back\\slash\ttab\r\nwindows line\n",
+		"control.txt": "bell\a nul-free \x1b[0m escape\n",
+		"unicode.txt": "caf\u00e9 \u2028 \U0001F600\n",
+		"html.txt":    "<script>alert('&')</script>\n",
+		"no-eol.txt":  "last line",
+		"sub/dir.txt": "nested\n\n\nblank lines\n",
+		"empty.txt":   "",
+	}
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	writeFiles(t, srcDir, tree)
+
+	out, err := extractFileContent(context.Background(), srcDir, extractOptions{
+		extensions: []string{".txt"},
+		format:     "json",
+		maxDepth:   -1,
+		logger:     quietLogger(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changeset MdiffJSON
+	if err := json.Unmarshal([]byte(out), &changeset); err != nil {
+		t.Fatalf("extract output is not valid JSON: %v\n%s", err, out)
+	}
+	if len(changeset.Changes) != len(tree) {
+		t.Fatalf("extracted %d files, want %d", len(changeset.Changes), len(tree))
+	}
+
+	captureApplyOut(t)
+	changes := resolveChangePaths(changeset.Changes, dstDir)
+	if _, err := applyChanges(context.Background(), changes, applyOptions{jobs: 1, logger: quietLogger()}); err != nil {
+		t.Fatal(err)
+	}
+	for relPath, want := range tree {
+		if got := readFile(t, filepath.Join(dstDir, filepath.FromSlash(relPath))); got != want {
+			t.Errorf("%s = %q after the round trip, want %q", relPath, got, want)
+		}
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"", 0},
+		{"a", 1},
+		{"abcd", 1},
+		{"abcde", 2},
+		{"caf\u00e9", 1}, // Characters, not bytes
+		{strings.Repeat("x", 400), 100},
+	}
+	for _, tt := range tests {
+		if got := estimateTokens(tt.s); got != tt.want {
+			t.Errorf("estimateTokens(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestExtractMaxTokens(t *testing.T) {
+	dir := t.TempDir()
+	files := []FileChange{
+		{FilePath: "a.txt", Content: strings.Repeat("a", 100)},
+		{FilePath: "b.txt", Content: strings.Repeat("b", 100)},
+		{FilePath: "c.txt", Content: strings.Repeat("c", 100)},
+		{FilePath: "d.txt", Content: "d"},
+	}
+	tokens := make([]int, len(files))
+	for i, file := range files {
+		writeFiles(t, dir, map[string]string{file.FilePath: file.Content})
+		tokens[i] = estimateTokens(formatText([]FileChange{file}))
+	}
+
+	tests := []struct {
+		name      string
+		maxTokens int
+		want      []string
+		skipped   []string
+	}{
+		{"unlimited", 0, []string{"a.txt", "b.txt", "c.txt", "d.txt"}, nil},
+		{"exact budget", tokens[0] + tokens[1], []string{"a.txt", "b.txt"}, []string{"c.txt", "d.txt"}},
+		{"budget between files", tokens[0] + tokens[1] + 1, []string{"a.txt", "b.txt"}, []string{"c.txt", "d.txt"}},
+		{"first file over budget", tokens[0] - 1, nil, []string{"a.txt", "b.txt", "c.txt", "d.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			out, err := extractFileContent(context.Background(), dir, extractOptions{
+				extensions: []string{".txt"},
+				maxTokens:  tt.maxTokens,
+				maxDepth:   -1,
+				logger:     &logger{out: &logs, level: levelInfo},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, file := range files {
+				included := strings.Contains(out, "<file_path>"+file.FilePath+"</file_path>")
+				if want := slices.Contains(tt.want, file.FilePath); included != want {
+					t.Errorf("%s included = %v, want %v", file.FilePath, included, want)
+				}
+			}
+			if tt.skipped != nil {
+				want := fmt.Sprintf("Warning: token budget of %d reached, skipping %d file(s):%s", tt.maxTokens, len(tt.skipped), listPaths(tt.skipped))
+				if !strings.Contains(logs.String(), want) {
+					t.Errorf("logs = %q, want the warning %q", logs.String(), want)
+				}
+			}
+			want := fmt.Sprintf("Estimated tokens: %d\n", estimateTokens(out))
+			if !strings.Contains(logs.String(), want) {
+				t.Errorf("logs = %q, want %q", logs.String(), want)
+			}
+		})
+	}
+}
+
+func TestExtractTokenCountIsStable(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.go":     "package main\n\nfunc main() {}\n",
+		"pkg/util.go": "package pkg\n\n// caf\u00e9\n",
+	})
+
+	counts := map[string]bool{}
+	for range 5 {
+		var logs bytes.Buffer
+		if _, err := extractFileContent(context.Background(), dir, extractOptions{
+			extensions: []string{".go"},
+			maxDepth:   -1,
+			jobs:       4,
+			logger:     &logger{out: &logs, level: levelInfo},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		counts[regexp.MustCompile(`Estimated tokens: \d+`).FindString(logs.String())] = true
+	}
+	if len(counts) != 1 {
+		t.Errorf("token counts differ across runs: %v", counts)
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		want    bool
+	}{
+		{"empty", nil, false},
+		{"text", []byte("hello\nworld\n"), false},
+		{"utf-8", []byte("café"), false},
+		{"nul byte", []byte("a\x00b"), true},
+		{"nul byte at the sniff limit", append(bytes.Repeat([]byte("a"), binarySniffLen-1), 0), true},
+		{"nul byte past the sniff limit", append(bytes.Repeat([]byte("a"), binarySniffLen), 0), false},
+	}
+	for _, tt := range tests {
+		if got := isBinary(tt.content); got != tt.want {
+			t.Errorf("%s: isBinary = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExtractBinaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	binary := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	writeFiles(t, dir, map[string]string{
+		"image.dat": binary,
+		"notes.dat": "plain text\n",
+	})
+
+	tests := []struct {
+		binaryMode string
+		want       []FileChange
+		warning    bool
+	}{
+		{
+			binaryMode: "",
+			want:       []FileChange{{FilePath: "notes.dat", Content: "plain text\n"}},
+			warning:    true,
+		},
+		{
+			binaryMode: "base64",
+			want: []FileChange{
+				{FilePath: "image.dat", Content: "iVBORw0KGgoAAAANSUhEUg==", Encoding: "base64"},
+				{FilePath: "notes.dat", Content: "plain text\n"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run("mode "+tt.binaryMode, func(t *testing.T) {
+			var logs bytes.Buffer
+			out, err := extractFileContent(context.Background(), dir, extractOptions{
+				extensions: []string{".dat"},
+				format:     "json",
+				binaryMode: tt.binaryMode,
+				maxDepth:   -1,
+				logger:     &logger{out: &logs, level: levelWarn},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var changeset MdiffJSON
+			if err := json.Unmarshal([]byte(out), &changeset); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(changeset.Changes, tt.want) {
+				t.Errorf("extracted %+v, want %+v", changeset.Changes, tt.want)
+			}
+			if warned := strings.Contains(logs.String(), "Warning: skipping binary file image.dat."); warned != tt.warning {
+				t.Errorf("logs = %q, want warning %v", logs.String(), tt.warning)
+			}
+			for _, change := range changeset.Changes {
+				if content, err := change.decodedContent(); err != nil || change.FilePath == "image.dat" && string(content) != binary {
+					t.Errorf("decoded content of %s = %q, %v, want the original bytes", change.FilePath, content, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDryRunChanges(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"existing.txt": "old content\n",
+		"same.txt":     "same\n",
+		"doomed.txt":   "bye\n",
+		"moved.txt":    "moving\n",
+		"sub/.keep":    "",
+	})
+	changes := resolveChangePaths([]FileChange{
+		{FilePath: "new.txt", Content: "brand new\n"},
+		{FilePath: "sub/deeper/new.txt", Content: "x"},
+		{FilePath: "existing.txt", Content: "new\n"},
+		{FilePath: "same.txt", Content: "same\n"},
+		{FilePath: "doomed.txt", Delete: true},
+		{FilePath: "ghost.txt", Delete: true},
+		{From: "moved.txt", To: "renamed.txt"},
+		{FilePath: "sub", Content: "not a file"},
+	}, dir)
+	before := listFiles(t, dir)
+
+	out := captureApplyOut(t)
+	dryRunChanges(changes, applyOptions{skipUnchanged: true, logger: quietLogger()})
+
+	for _, want := range []string{
+		"Would create " + filepath.Join(dir, "new.txt") + " (10 bytes)\n",
+		"Would create " + filepath.Join(dir, "sub", "deeper", "new.txt") + " (1 bytes)\n",
+		"Would overwrite " + filepath.Join(dir, "existing.txt") + " (12 -> 4 bytes, -8)\n",
+		"Would leave " + filepath.Join(dir, "same.txt") + " unchanged\n",
+		"Would delete " + filepath.Join(dir, "doomed.txt") + " (4 bytes)\n",
+		"Would rename " + filepath.Join(dir, "moved.txt") + " to " + filepath.Join(dir, "renamed.txt") + "\n",
+		"Would fail on " + filepath.Join(dir, "sub") + ": path is a directory\n",
+		"Dry run: 2 file(s) would be created, 1 overwritten, 1 deleted, 1 renamed, 1 unchanged, 2 skipped.\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+	if after := listFiles(t, dir); !slices.Equal(after, before) {
+		t.Errorf("files after the dry run = %q, want %q", after, before)
+	}
+	if got := readFile(t, filepath.Join(dir, "existing.txt")); got != "old content\n" {
+		t.Errorf("existing.txt = %q, was modified by the dry run", got)
+	}
+}
+
+func TestApplyDeletes(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes []FileChange
+		want    []string // Files left, with their content
+		warning string
+	}{
+		{
+			name:    "existing file",
+			changes: []FileChange{{FilePath: "a.txt", Delete: true}},
+			want:    []string{"b.txt=b"},
+		},
+		{
+			name:    "missing file warns without failing",
+			changes: []FileChange{{FilePath: "ghost.txt", Delete: true}, {FilePath: "b.txt", Content: "B"}},
+			want:    []string{"a.txt=a", "b.txt=B"},
+			warning: "Warning: cannot delete '%s': file does not exist.",
+		},
+		{
+			name: "mixed with content writes",
+			changes: []FileChange{
+				{FilePath: "new.txt", Content: "new"},
+				{FilePath: "a.txt", Delete: true},
+				{FilePath: "b.txt", Content: "B"},
+				{FilePath: "new.txt", Delete: true},
+				{FilePath: "c.txt", Content: "c"},
+			},
+			want: []string{"b.txt=B", "c.txt=c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"a.txt": "a", "b.txt": "b"})
+			captureApplyOut(t)
+			var logs bytes.Buffer
+			_, err := applyChanges(context.Background(), resolveChangePaths(tt.changes, dir), applyOptions{jobs: 1, logger: &logger{out: &logs, level: levelWarn}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fileContents(t, dir); !slices.Equal(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+			if tt.warning != "" {
+				if want := fmt.Sprintf(tt.warning, filepath.Join(dir, "ghost.txt")); !strings.Contains(logs.String(), want) {
+					t.Errorf("logs = %q, want %q", logs.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyRenames(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  FileChange
+		want    []string
+		warning string
+	}{
+		{
+			name:   "same filesystem",
+			change: FileChange{From: "a.txt", To: "sub/a.txt"},
+			want:   []string{"b.txt=b", "sub/a.txt=a"},
+		},
+		{
+			name:    "overwrite conflict is skipped",
+			change:  FileChange{From: "a.txt", To: "b.txt"},
+			want:    []string{"a.txt=a", "b.txt=b"},
+			warning: "Warning: not renaming '{dir}/a.txt': '{dir}/b.txt' already exists (set \"overwrite\": true to replace it).",
+		},
+		{
+			name:   "overwrite conflict with overwrite",
+			change: FileChange{From: "a.txt", To: "b.txt", Overwrite: true},
+			want:   []string{"b.txt=a"},
+		},
+		{
+			name:    "nonexistent source",
+			change:  FileChange{From: "ghost.txt", To: "c.txt"},
+			want:    []string{"a.txt=a", "b.txt=b"},
+			warning: "Warning: cannot rename '{dir}/ghost.txt': file does not exist.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"a.txt": "a", "b.txt": "b"})
+			captureApplyOut(t)
+			var logs bytes.Buffer
+			changes := resolveChangePaths([]FileChange{tt.change}, dir)
+			if _, err := applyChanges(context.Background(), changes, applyOptions{jobs: 1, logger: &logger{out: &logs, level: levelWarn}}); err != nil {
+				t.Fatal(err)
+			}
+			if got := fileContents(t, dir); !slices.Equal(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+			warning := strings.ReplaceAll(tt.warning, "{dir}/", dir+string(filepath.Separator))
+			if !strings.Contains(logs.String(), warning) {
+				t.Errorf("logs = %q, want %q", logs.String(), warning)
+			}
+		})
+	}
+}
+
+func TestApplyAtomicRollback(t *testing.T) {
+	original := map[string]string{
+		"one.txt":   "one",
+		"two.txt":   "two",
+		"blocked/x": "", // Makes "blocked" a directory, which cannot be written as a file
+	}
+	changes := []FileChange{
+		{FilePath: "one.txt", Content: "ONE"},
+		{FilePath: "new/sub/created.txt", Content: "created"},
+		{FilePath: "blocked", Content: "fails"},
+		{FilePath: "two.txt", Content: "TWO"},
+		{FilePath: "four.txt", Content: "four"},
+	}
+
+	tests := []struct {
+		name    string
+		atomic  bool
+		backup  bool
+		want    []string
+		wantDir bool // The new/ directory is left behind
+	}{
+		{
+			name:    "without --atomic",
+			want:    []string{"blocked/x=", "new/sub/created.txt=created", "one.txt=ONE", "two.txt=two"},
+			wantDir: true,
+		},
+		{
+			name:   "with --atomic",
+			atomic: true,
+			want:   []string{"blocked/x=", "one.txt=one", "two.txt=two"},
+		},
+		{
+			name:   "with --atomic and --backup",
+			atomic: true,
+			backup: true,
+			want:   []string{"blocked/x=", "one.txt=one", "two.txt=two"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, original)
+			captureApplyOut(t)
+
+			applied, err := applyChanges(context.Background(), resolveChangePaths(changes, dir), applyOptions{
+				atomic:       tt.atomic,
+				backup:       tt.backup,
+				backupSuffix: ".bak",
+				jobs:         1,
+				logger:       quietLogger(),
+			})
+			if err == nil || !strings.Contains(err.Error(), "blocked") {
+				t.Fatalf("applyChanges() error = %v, want the failure of the third change", err)
+			}
+			if applied != 2 {
+				t.Errorf("applyChanges() applied %d change(s), want 2", applied)
+			}
+			if got := fileContents(t, dir); !slices.Equal(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "new")); (err == nil) != tt.wantDir {
+				t.Errorf("new/ directory exists = %v, want %v", err == nil, tt.wantDir)
+			}
+		})
+	}
+}
+
+func TestApplyFailFast(t *testing.T) {
+	changes := []FileChange{
+		{FilePath: "one.txt", Content: "ONE"},
+		{FilePath: "two.txt", Content: "TWO"},
+		{FilePath: "blocked", Content: "fails"},
+		{FilePath: "four.txt", Content: "FOUR"},
+		{FilePath: "five.txt", Content: "FIVE"},
+	}
+	tests := []struct {
+		name            string
+		continueOnError bool
+		jobs            int
+		wantErr         string
+		wantApplied     int
+		want            []string
+	}{
+		{
+			name:        "fail fast",
+			jobs:        1,
+			wantErr:     "blocked",
+			wantApplied: 2,
+			want:        []string{"blocked/x=", "one.txt=ONE", "two.txt=TWO"},
+		},
+		{
+			name:            "continue on error",
+			continueOnError: true,
+			jobs:            1,
+			wantErr:         "applying 1 of 5 change(s)",
+			wantApplied:     4,
+			want:            []string{"blocked/x=", "five.txt=FIVE", "four.txt=FOUR", "one.txt=ONE", "two.txt=TWO"},
+		},
+		{
+			name:            "continue on error with jobs",
+			continueOnError: true,
+			jobs:            4,
+			wantErr:         "applying 1 of 5 change(s)",
+			wantApplied:     4,
+			want:            []string{"blocked/x=", "five.txt=FIVE", "four.txt=FOUR", "one.txt=ONE", "two.txt=TWO"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"blocked/x": ""})
+			discardApplyOut(t)
+
+			var logs bytes.Buffer
+			applied, err := applyChanges(context.Background(), resolveChangePaths(changes, dir), applyOptions{
+				continueOnError: tt.continueOnError,
+				jobs:            tt.jobs,
+				logger:          &logger{out: &logs, level: levelWarn},
+			})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("applyChanges() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+			if applied != tt.wantApplied {
+				t.Errorf("applyChanges() applied %d change(s), want %d", applied, tt.wantApplied)
+			}
+			if got := fileContents(t, dir); !slices.Equal(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+			if tt.continueOnError && !strings.Contains(logs.String(), "blocked") {
+				t.Errorf("logs = %q, want the failure reported", logs.String())
+			}
+		})
+	}
+}
+
+func TestApplyFailFastFlag(t *testing.T) {
+	const changeset = `{"changes":[{"file_path":"one.txt","content":"ONE"},{"file_path":"blocked","content":"fails"},{"file_path":"three.txt","content":"THREE"}]}`
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{args: nil, want: []string{"blocked/x=", "one.txt=ONE"}},
+		{args: []string{"--fail-fast=false"}, want: []string{"blocked/x=", "one.txt=ONE", "three.txt=THREE"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(append([]string{"apply"}, tt.args...), " "), func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"blocked/x": "", "changes.json": changeset})
+			_, stderr, code := runCopilot(t, dir, "", append(append([]string{"apply"}, tt.args...), "changes.json")...)
+			if code != 1 {
+				t.Errorf("exit code = %d, want 1 (stderr %q)", code, stderr)
+			}
+			if err := os.Remove(filepath.Join(dir, "changes.json")); err != nil {
+				t.Fatal(err)
+			}
+			if got := fileContents(t, dir); !slices.Equal(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplySkipUnchanged(t *testing.T) {
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	changes := []FileChange{
+		{FilePath: "same.txt", Content: "same"},
+		{FilePath: "other.txt", Content: "after"},
+		{FilePath: "new.txt", Content: "new"},
+		{FilePath: "log.txt", Content: ""},
+	}
+	tests := []struct {
+		name          string
+		skipUnchanged bool
+		wantApplied   int
+		wantActions   []string
+		wantOld       []string // Files whose modification time is still old
+		wantUnchanged bool     // "Unchanged ... not rewritten" is printed for same.txt
+	}{
+		{
+			name:          "skips files holding their content",
+			skipUnchanged: true,
+			wantApplied:   2,
+			wantActions:   []string{"unchanged", "updated", "created", "unchanged"},
+			wantOld:       []string{"log.txt", "same.txt"},
+			wantUnchanged: true,
+		},
+		{
+			name:        "rewrites every file when off",
+			wantApplied: 4,
+			wantActions: []string{"updated", "updated", "created", "updated"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"same.txt": "same", "other.txt": "before", "log.txt": ""})
+			for _, name := range []string{"same.txt", "other.txt", "log.txt"} {
+				if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+			out := captureApplyOut(t)
+
+			report := &applyReport{}
+			applied, err := applyChanges(context.Background(), resolveChangePaths(changes, dir), applyOptions{
+				skipUnchanged: tt.skipUnchanged,
+				report:        report,
+				jobs:          1,
+				logger:        quietLogger(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if applied != tt.wantApplied {
+				t.Errorf("applyChanges() applied %d change(s), want %d", applied, tt.wantApplied)
+			}
+			var actions []string
+			for _, entry := range report.Files {
+				actions = append(actions, entry.Action)
+			}
+			if !slices.Equal(actions, tt.wantActions) {
+				t.Errorf("report actions = %q, want %q", actions, tt.wantActions)
+			}
+			want := []string{"log.txt=", "new.txt=new", "other.txt=after", "same.txt=same"}
+			if got := fileContents(t, dir); !slices.Equal(got, want) {
+				t.Errorf("files = %q, want %q", got, want)
+			}
+			var gotOld []string
+			for _, name := range []string{"log.txt", "other.txt", "same.txt"} {
+				info, err := os.Stat(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.ModTime().Equal(old) {
+					gotOld = append(gotOld, name)
+				}
+			}
+			if !slices.Equal(gotOld, tt.wantOld) {
+				t.Errorf("files left untouched = %q, want %q", gotOld, tt.wantOld)
+			}
+			unchangedMsg := "Unchanged " + filepath.Join(dir, "same.txt") + ", not rewritten"
+			if got := strings.Contains(out.String(), unchangedMsg); got != tt.wantUnchanged {
+				t.Errorf("output = %q, want %q printed: %v", out.String(), unchangedMsg, tt.wantUnchanged)
+			}
+		})
+	}
+}
+
+func TestApplyPreserveMtime(t *testing.T) {
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name          string
+		mode          string
+		path          string // Existing file "same.txt" holds "same", "other.txt" "before"
+		content       string
+		skipUnchanged bool
+		wantOld       bool // The modification time is still old
+	}{
+		{name: "off, same content", path: "same.txt", content: "same"},
+		{name: "off, skipped as unchanged", path: "same.txt", content: "same", skipUnchanged: true, wantOld: true},
+		{name: "unchanged, same content", mode: "unchanged", path: "same.txt", content: "same", wantOld: true},
+		{name: "unchanged, new content", mode: "unchanged", path: "other.txt", content: "after"},
+		{name: "always, same content", mode: "always", path: "same.txt", content: "same", wantOld: true},
+		{name: "always, new content", mode: "always", path: "other.txt", content: "after", wantOld: true},
+		{name: "always, new file", mode: "always", path: "new.txt", content: "new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"same.txt": "same", "other.txt": "before"})
+			for _, name := range []string{"same.txt", "other.txt"} {
+				if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+			captureApplyOut(t)
+
+			changes := resolveChangePaths([]FileChange{{FilePath: tt.path, Content: tt.content}}, dir)
+			if _, err := applyChanges(context.Background(), changes, applyOptions{
+				preserveMtime: tt.mode,
+				skipUnchanged: tt.skipUnchanged,
+				jobs:          1,
+				logger:        quietLogger(),
+			}); err != nil {
+				t.Fatal(err)
+			}
+			filePath := filepath.Join(dir, tt.path)
+			if got := readFile(t, filePath); got != tt.content {
+				t.Errorf("content = %q, want %q", got, tt.content)
+			}
+			info, err := os.Stat(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.ModTime().Equal(old); got != tt.wantOld {
+				t.Errorf("modification time = %v, want it kept: %v", info.ModTime(), tt.wantOld)
+			}
+		})
+	}
+}
+
+func TestApplyPreserveMtimeFlag(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"changes.json": `{"changes": [{"file_path": "a.txt", "content": "a"}]}`})
+	for _, args := range [][]string{
+		{"--preserve-mtime", "sometimes"},
+		{"--preserve-mtime", "always", "--append"},
+	} {
+		args := append(append([]string{"apply"}, args...), "changes.json")
+		if _, stderr, code := runCopilot(t, dir, "", args...); code != 1 {
+			t.Errorf("%v: exit code %d, want 1 (stderr %q)", args, code, stderr)
+		}
+	}
+	if got := listFiles(t, dir); !slices.Equal(got, []string{"changes.json"}) {
+		t.Errorf("files = %q, want nothing written", got)
+	}
+}
+
+func TestApplyBackup(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"existing.txt": "original\n"})
+	existing := filepath.Join(dir, "existing.txt")
+	if err := os.Chmod(existing, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureApplyOut(t)
+	changes := resolveChangePaths([]FileChange{
+		{FilePath: "existing.txt", Content: "modified\n"},
+		{FilePath: "new.txt", Content: "new\n"},
+	}, dir)
+	if _, err := applyChanges(context.Background(), changes, applyOptions{backup: true, backupSuffix: ".orig", jobs: 1, logger: quietLogger()}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"existing.txt=modified\n", "existing.txt.orig=original\n", "new.txt=new\n"}
+	if got := fileContents(t, dir); !slices.Equal(got, want) {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+	info, err := os.Stat(existing + ".orig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0o600 {
+		t.Errorf("backup permissions = %v, want %v", info.Mode().Perm(), os.FileMode(0o600))
+	}
+	if want := "Successfully applied changes to " + existing + " (backup: " + existing + ".orig)\n"; !strings.Contains(out.String(), want) {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
+
+func TestApplyFromStdin(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"dash argument", []string{"apply", "-"}},
+		{"no argument", []string{"apply"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"existing.txt": "old"})
+			changeset := `{"changes": [{"file_path": "existing.txt", "content": "new"}, {"file_path": "sub/created.txt", "content": "created\n"}]}`
+
+			stdout, stderr, code := runCopilot(t, dir, changeset, tt.args...)
+			if code != 0 {
+				t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+			}
+			want := []string{"existing.txt=new", "sub/created.txt=created\n"}
+			if got := fileContents(t, dir); !slices.Equal(got, want) {
+				t.Errorf("files = %q, want %q", got, want)
+			}
+			if !strings.Contains(stdout, "Successfully applied changes to sub/created.txt") {
+				t.Errorf("stdout = %q", stdout)
+			}
+		})
+	}
+
+	t.Run("file argument", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{"changes.json": `{"changes": [{"file_path": "a.txt", "content": "a"}]}`})
+		if _, stderr, code := runCopilot(t, dir, "", "apply", "changes.json"); code != 0 {
+			t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+		}
+		if got := readFile(t, filepath.Join(dir, "a.txt")); got != "a" {
+			t.Errorf("a.txt = %q, want %q", got, "a")
+		}
+	})
+}
+
+func TestApplySafeRejectsEscapes(t *testing.T) {
+	root := t.TempDir()
+	base := filepath.Join(root, "base")
+	absOutside := filepath.Join(root, "escaped-rename.txt")
+	writeFiles(t, root, map[string]string{"base/changes.json": fmt.Sprintf(`{"changes": [
+		{"file_path": "ok.txt", "content": "ok"},
+		{"file_path": "../escaped.txt", "content": "escaped"},
+		{"from": "ok.txt", "to": %q}
+	]}`, absOutside)})
+
+	_, stderr, code := runCopilot(t, base, "", "apply", "--safe", "changes.json")
+	if code != 1 {
+		t.Fatalf("exit code %d, want 1, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{"path '../escaped.txt' is outside the base directory", "path '" + absOutside + "' is outside the base directory", "no files were written"} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr does not contain %q:\n%s", want, stderr)
+		}
+	}
+	if got := listFiles(t, root); !slices.Equal(got, []string{"base/changes.json"}) {
+		t.Errorf("files = %q, want only the changeset", got)
+	}
+}
+
+// writeFixtureTree creates n small Go files spread over nested directories
+// of dir.
+func writeFixtureTree(t testing.TB, dir string, n int) {
+	t.Helper()
+	for i := range n {
+		subDir := filepath.Join(dir, fmt.Sprintf("pkg%d", i%7), fmt.Sprintf("sub%d", i%3))
+		if err := os.MkdirAll(subDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		content := fmt.Sprintf("package sub\n\n// File %d\n%s", i, strings.Repeat("var x = 1\n", i%50))
+		if err := os.WriteFile(filepath.Join(subDir, fmt.Sprintf("file%03d.go", i)), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExtractConcurrentMatchesSerial(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtureTree(t, dir, 200)
+
+	extract := func(jobs int, format string) string {
+		out, err := extractFileContent(context.Background(), dir, extractOptions{
+			extensions: []string{".go"},
+			format:     format,
+			jobs:       jobs,
+			maxDepth:   -1,
+			logger:     quietLogger(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	for _, format := range []string{"text", "json"} {
+		serial := extract(1, format)
+		for _, jobs := range []int{0, 2, 8, 64} {
+			if got := extract(jobs, format); got != serial {
+				t.Errorf("%s output with %d jobs differs from the serial output", format, jobs)
+			}
+		}
+	}
+}
+
+func BenchmarkExtract(b *testing.B) {
+	dir := b.TempDir()
+	writeFixtureTree(b, dir, 500)
+	for _, jobs := range []int{1, 4, 0} {
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			for b.Loop() {
+				if _, err := extractFileContent(context.Background(), dir, extractOptions{
+					extensions: []string{".go"},
+					jobs:       jobs,
+					maxDepth:   -1,
+					logger:     quietLogger(),
+				}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractSort(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"b.txt":      "12345",
+		"a/z.txt":    "1",
+		"a.txt":      "123",
+		"c/d/e.txt":  "1234567",
+		"same-1.txt": "12",
+		"same-2.txt": "12",
+	})
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, relPath := range []string{"c/d/e.txt", "same-2.txt", "b.txt", "a/z.txt", "a.txt", "same-1.txt"} {
+		modTime := base.Add(time.Duration(i) * time.Hour)
+		if relPath == "same-1.txt" {
+			modTime = base.Add(time.Hour) // Same as same-2.txt, ordered by path
+		}
+		if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(relPath)), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		sortBy string
+		want   []string
+	}{
+		{"", []string{"a.txt", "a/z.txt", "b.txt", "c/d/e.txt", "same-1.txt", "same-2.txt"}},
+		{"path", []string{"a.txt", "a/z.txt", "b.txt", "c/d/e.txt", "same-1.txt", "same-2.txt"}},
+		{"size", []string{"a/z.txt", "same-1.txt", "same-2.txt", "a.txt", "b.txt", "c/d/e.txt"}},
+		{"mtime", []string{"c/d/e.txt", "same-1.txt", "same-2.txt", "b.txt", "a/z.txt", "a.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run("sort "+tt.sortBy, func(t *testing.T) {
+			got := extractedPaths(t, dir, extractOptions{extensions: []string{".txt"}, sortBy: tt.sortBy, maxDepth: -1})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("extracted %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if err := sortCandidates(nil, "name"); err == nil {
+		t.Error("sortCandidates() with an unknown mode returned no error")
+	}
+}
+
+func TestExtractOutputFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"src/main.go":   "package main\n",
+		"src/util/a.go": "package util\n",
+		"src/notes.txt": "not extracted\n",
+		"out/.gitkeep":  "",
+	})
+
+	for _, format := range []string{"text", "json", "xml"} {
+		t.Run(format, func(t *testing.T) {
+			stdout, stderr, code := runCopilot(t, dir, "", "extract", "--format", format, "src", ".go")
+			if code != 0 {
+				t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+			}
+			outPath := filepath.Join("out", format+".out")
+			fileStdout, stderr, code := runCopilot(t, dir, "", "extract", "--format", format, "-o", outPath, "src", ".go")
+			if code != 0 {
+				t.Fatalf("exit code %d with -o, stderr:\n%s", code, stderr)
+			}
+			if fileStdout != "" {
+				t.Errorf("stdout with -o = %q, want nothing", fileStdout)
+			}
+			if got := readFile(t, filepath.Join(dir, outPath)); got != stdout {
+				t.Errorf("output file =\n%s\nwant the stdout output\n%s", got, stdout)
+			}
+		})
+	}
+	if got := listFiles(t, filepath.Join(dir, "out")); !slices.Equal(got, []string{".gitkeep", "json.out", "text.out", "xml.out"}) {
+		t.Errorf("output directory holds %q, want no temporary file left", got)
+	}
+}
+
+func TestExtractStdinFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"project/main.go":    "package main\n",
+		"project/util/a.go":  "package util\n",
+		"project/util/b.go":  "package util\n",
+		"project/README.md":  "# Readme\n",
+		"project/ignored.go": "package main\n",
+		"project/.gitignore": "ignored.go\n",
+	})
+
+	tests := []struct {
+		name  string
+		args  []string
+		stdin string
+		want  []string
+	}{
+		{
+			name:  "only the listed files",
+			args:  []string{"--base-dir", "project"},
+			stdin: "util/a.go\n\nREADME.md\n",
+			want:  []string{"README.md", "util/a.go"},
+		},
+		{
+			name:  "extension filter",
+			args:  []string{"--base-dir", "project", ".go"},
+			stdin: "util/a.go\nREADME.md\nmain.go\n",
+			want:  []string{"main.go", "util/a.go"},
+		},
+		{
+			name:  "listed files are not subject to ignore rules",
+			args:  []string{"--base-dir", "project"},
+			stdin: "ignored.go\n",
+			want:  []string{"ignored.go"},
+		},
+		{
+			name:  "relative to the current directory by default",
+			stdin: "project/util/b.go\n",
+			want:  []string{"project/util/b.go"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"extract", "--stdin-files", "--format", "json"}, tt.args...)
+			stdout, stderr, code := runCopilot(t, dir, tt.stdin, args...)
+			if code != 0 {
+				t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+			}
+			var changeset MdiffJSON
+			if err := json.Unmarshal([]byte(stdout), &changeset); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, change := range changeset.Changes {
+				got = append(got, change.FilePath)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("extracted %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadFileList(t *testing.T) {
+	got, err := readFileList(strings.NewReader("a.go\n\n  b/c.go  \r\n\nd.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.go", "b/c.go", "d.go"}; !slices.Equal(got, want) {
+		t.Errorf("readFileList() = %q, want %q", got, want)
+	}
+}
+
+func TestNumberLines(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"", ""},
+		{"one\n", "1 | one\n"},
+		{"one\ntwo", "1 | one\n2 | two"},
+		{"a\n\nc\n", "1 | a\n2 | \n3 | c\n"},
+		{strings.Repeat("x\n", 10), " 1 | x\n 2 | x\n 3 | x\n 4 | x\n 5 | x\n 6 | x\n 7 | x\n 8 | x\n 9 | x\n10 | x\n"},
+	}
+	for _, tt := range tests {
+		if got := numberLines(tt.content); got != tt.want {
+			t.Errorf("numberLines(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestExtractLineNumbers(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.txt": "first\nsecond\nthird\n",
+		"b.txt": "no newline\nat the end",
+	})
+	out, err := extractFileContent(context.Background(), dir, extractOptions{
+		extensions:  []string{".txt"},
+		lineNumbers: true,
+		maxDepth:    -1,
+		logger:      quietLogger(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "\n<file_path>a.txt</file_path>\n1 | first\n2 | second\n3 | third\n\n<file_path_end>a.txt</file_path_end>\n" +
+		"\n<file_path>b.txt</file_path>\n1 | no newline\n2 | at the end\n<file_path_end>b.txt</file_path_end>\n"
+	if out != want {
+		t.Errorf("extracted\n%q\nwant\n%q", out, want)
+	}
+}
+
+// errorStrings returns the messages of errs.
+func errorStrings(errs []error) []string {
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return messages
+}
+
+func TestValidateChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes []FileChange
+		want    []string
+	}{
+		{
+			name:    "valid changeset",
+			changes: []FileChange{{FilePath: "a.txt"}, {FilePath: "b.txt", Delete: true}, {From: "c.txt", To: "d.txt"}},
+		},
+		{
+			name: "every problem is reported",
+			changes: []FileChange{
+				{FilePath: "", Content: "orphan"},
+				{FilePath: "bad.bin", Content: "not base64!", Encoding: "base64"},
+				{FilePath: "odd.txt", Content: "x", Encoding: "rot13"},
+				{From: "only-from.txt"},
+				{FilePath: "ok.txt", Content: "ok"},
+			},
+			want: []string{
+				"change #1: missing 'file_path'",
+				"change #2: cannot decode content for 'bad.bin': illegal base64 data at input byte 3",
+				"change #3: cannot decode content for 'odd.txt': unsupported content encoding 'rot13'",
+				"change #4: rename is missing 'from' or 'to'",
+			},
+		},
+		{
+			name: "duplicate paths are reported once each",
+			changes: []FileChange{
+				{FilePath: "a.txt", Content: "1"},
+				{FilePath: "./a.txt", Content: "2"},
+				{From: "x.txt", To: "y.txt"},
+				{FilePath: "a.txt", Delete: true},
+				{From: "x.txt", To: "z.txt"},
+			},
+			want: []string{
+				"'file_path' path 'a.txt' appears 3 times, in changes #1, #2 and #4",
+				"'from' path 'x.txt' appears 2 times, in changes #3 and #5",
+			},
+		},
+		{
+			name:    "a file may be written then renamed",
+			changes: []FileChange{{FilePath: "a.txt", Content: "a"}, {From: "a.txt", To: "b.txt"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorStrings(validateChanges(tt.changes)); !slices.Equal(got, tt.want) {
+				t.Errorf("validateChanges() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyValidationWritesNothing(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"changes.json": `{"changes": [
+		{"file_path": "first.txt", "content": "valid"},
+		{"content": "no path"},
+		{"file_path": "bad.bin", "content": "%%%", "encoding": "base64"},
+		{"from": "first.txt"},
+		{"file_path": "last.txt", "content": "valid"}
+	]}`})
+
+	_, stderr, code := runCopilot(t, dir, "", "apply", "changes.json")
+	if code != 1 {
+		t.Fatalf("exit code %d, want 1, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"Error: change #2: missing 'file_path'",
+		"Error: change #3: cannot decode content for 'bad.bin'",
+		"Error: change #4: rename is missing 'from' or 'to'",
+		"Error: Found 3 problem(s) in the changeset; no files were written.",
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr does not contain %q:\n%s", want, stderr)
+		}
+	}
+	if got := listFiles(t, dir); !slices.Equal(got, []string{"changes.json"}) {
+		t.Errorf("files = %q, want nothing written", got)
+	}
+}
+
+func TestApplyDuplicatePaths(t *testing.T) {
+	const changeset = `{"changes": [
+		{"file_path": "a.txt", "content": "first"},
+		{"file_path": "b.txt", "content": "b"},
+		{"file_path": "./a.txt", "content": "second"},
+		{"file_path": "a.txt", "content": "last"}
+	]}`
+	tests := []struct {
+		name       string
+		args       []string
+		stdin      string
+		wantCode   int
+		wantStderr []string
+		want       []string
+	}{
+		{
+			name:       "warns and the last entry wins",
+			args:       []string{"changes.json"},
+			wantStderr: []string{"Warning: 'file_path' path 'a.txt' appears 3 times, in changes #1, #3 and #4; they are applied in order, so the last one wins."},
+			want:       []string{"a.txt=last", "b.txt=b"},
+		},
+		{
+			name:     "fails with --strict",
+			args:     []string{"--strict", "changes.json"},
+			wantCode: 1,
+			wantStderr: []string{
+				"Error: 'file_path' path 'a.txt' appears 3 times, in changes #1, #3 and #4",
+				"Error: Found 1 problem(s) in the changeset; no files were written.",
+			},
+		},
+		{
+			name:  "warns as they are streamed with --stdin-json",
+			args:  []string{"--stdin-json"},
+			stdin: changeset,
+			wantStderr: []string{
+				"Warning: 'file_path' path './a.txt' appears 2 times, in changes #1 and #3",
+				"Warning: 'file_path' path 'a.txt' appears 3 times, in changes #1, #3 and #4",
+			},
+			want: []string{"a.txt=last", "b.txt=b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"changes.json": changeset})
+			_, stderr, code := runCopilot(t, dir, tt.stdin, append([]string{"apply"}, tt.args...)...)
+			if code != tt.wantCode {
+				t.Fatalf("exit code %d, want %d, stderr:\n%s", code, tt.wantCode, stderr)
+			}
+			for _, want := range tt.wantStderr {
+				if !strings.Contains(stderr, want) {
+					t.Errorf("stderr does not contain %q:\n%s", want, stderr)
+				}
+			}
+			if err := os.Remove(filepath.Join(dir, "changes.json")); err != nil {
+				t.Fatal(err)
+			}
+			if got := fileContents(t, dir); !slices.Equal(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyStrictCreateOnly(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		want     []string
+	}{
+		{name: "--create-only skips existing targets", args: []string{"--create-only"}, want: []string{"existing.txt=original", "new.txt=new"}},
+		{name: "--strict alone overwrites them", args: []string{"--strict"}, want: []string{"existing.txt=changed", "new.txt=new"}},
+		{name: "--strict --create-only fails", args: []string{"--strict", "--create-only"}, wantCode: 1, want: []string{"existing.txt=original"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"existing.txt": "original",
+				"changes.json": `{"changes": [{"file_path": "existing.txt", "content": "changed"}, {"file_path": "new.txt", "content": "new"}]}`,
+			})
+			_, stderr, code := runCopilot(t, dir, "", append(append([]string{"apply"}, tt.args...), "changes.json")...)
+			if code != tt.wantCode {
+				t.Fatalf("exit code %d, want %d, stderr:\n%s", code, tt.wantCode, stderr)
+			}
+			if err := os.Remove(filepath.Join(dir, "changes.json")); err != nil {
+				t.Fatal(err)
+			}
+			if got := fileContents(t, dir); !slices.Equal(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyCreateOnly(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"existing.txt": "original", "doomed.txt": "bye"})
+	changes := []FileChange{
+		{FilePath: "existing.txt", Content: "overwritten"},
+		{FilePath: "new.txt", Content: "created"},
+		{FilePath: "sub/new.txt", Content: "created too"},
+		{FilePath: "doomed.txt", Delete: true},
+	}
+
+	if got, want := errorStrings(checkCreateOnly(changes, dir)), []string{"file 'existing.txt' already exists (--create-only)"}; !slices.Equal(got, want) {
+		t.Errorf("checkCreateOnly() = %q, want %q", got, want)
+	}
+
+	captureApplyOut(t)
+	var logs bytes.Buffer
+	applied, err := applyChanges(context.Background(), resolveChangePaths(changes, dir), applyOptions{createOnly: true, jobs: 1, logger: &logger{out: &logs, level: levelWarn}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != 3 {
+		t.Errorf("applied %d change(s), want 3", applied)
+	}
+	want := []string{"existing.txt=original", "new.txt=created", "sub/new.txt=created too"}
+	if got := fileContents(t, dir); !slices.Equal(got, want) {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+	if warning := "Warning: not writing '" + filepath.Join(dir, "existing.txt") + "': file already exists (--create-only)."; !strings.Contains(logs.String(), warning) {
+		t.Errorf("logs = %q, want %q", logs.String(), warning)
+	}
+}
+
+func TestApplyAppendAccumulates(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"existing.log": "start\n"})
+	changes := resolveChangePaths([]FileChange{
+		{FilePath: "existing.log", Content: "entry\n"},
+		{FilePath: "logs/new.log", Content: "entry\n"},
+	}, dir)
+
+	captureApplyOut(t)
+	for range 2 {
+		if _, err := applyChanges(context.Background(), changes, applyOptions{append: true, skipUnchanged: true, jobs: 1, logger: quietLogger()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"existing.log=start\nentry\nentry\n", "logs/new.log=entry\nentry\n"}
+	if got := fileContents(t, dir); !slices.Equal(got, want) {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+}
+
+func TestDryRunDiff(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"existing.txt": "old\n"})
+	t.Chdir(dir)
+	changes := []FileChange{
+		{FilePath: "new.txt", Content: "created\n"},
+		{FilePath: "existing.txt", Content: "appended\n"},
+	}
+
+	tests := []struct {
+		name   string
+		append bool
+		want   string
+	}{
+		{
+			name: "overwrite",
+			want: "Would create new.txt (8 bytes)\n" +
+				"--- /dev/null\n+++ b/new.txt\n@@ -0,0 +1 @@\n+created\n" +
+				"Would overwrite existing.txt (4 -> 9 bytes, +5)\n" +
+				"--- a/existing.txt\n+++ b/existing.txt\n@@ -1 +1 @@\n-old\n+appended\n",
+		},
+		{
+			name:   "append",
+			append: true,
+			want: "Would create new.txt (8 bytes)\n" +
+				"--- /dev/null\n+++ b/new.txt\n@@ -0,0 +1 @@\n+created\n" +
+				"Would append to existing.txt (4 -> 13 bytes, +9)\n" +
+				"--- a/existing.txt\n+++ b/existing.txt\n@@ -1 +1,2 @@\n old\n+appended\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := captureApplyOut(t)
+			var logs bytes.Buffer
+			dryRunChanges(changes, applyOptions{showDiff: true, append: tt.append, logger: &logger{out: &logs, level: levelWarn}})
+			want := tt.want + "Dry run: 1 file(s) would be created, 1 overwritten, 0 deleted, 0 renamed, 0 unchanged, 0 skipped.\n"
+			if out.String() != want {
+				t.Errorf("output =\n%s\nwant\n%s", out, want)
+			}
+			if logs.Len() > 0 {
+				t.Errorf("unexpected warnings: %q", logs.String())
+			}
+		})
+	}
+}
+
+func TestVerbosityFlags(t *testing.T) {
+	const changeset = `{"changes": [{"file_path": "a.txt", "content": "new\n"}, {"file_path": "missing.txt", "delete": true}]}`
+	tests := []struct {
+		flags   []string
+		args    []string
+		want    []string
+		notWant []string
+	}{
+		{
+			flags:   []string{"--quiet"},
+			args:    []string{"extract", ".", ".txt,.bin"},
+			notWant: []string{"Warning:", "Extracted"},
+		},
+		{
+			args:    []string{"extract", ".", ".txt,.bin"},
+			want:    []string{"Warning: skipping binary file b.bin.", "Extracted 1 file(s)"},
+			notWant: []string{"Extracted a.txt"},
+		},
+		{
+			flags: []string{"--verbose"},
+			args:  []string{"extract", ".", ".txt,.bin"},
+			want:  []string{"Extracted a.txt (4 bytes)", "Warning: skipping binary file b.bin."},
+		},
+		{
+			flags:   []string{"--quiet"},
+			args:    []string{"apply", "-"},
+			notWant: []string{"Warning:", "Applying change"},
+		},
+		{
+			args:    []string{"apply", "-"},
+			want:    []string{"Warning: cannot delete 'missing.txt': file does not exist."},
+			notWant: []string{"Applying change"},
+		},
+		{
+			flags: []string{"--verbose"},
+			args:  []string{"apply", "-"},
+			want:  []string{"Applying change 1/2: a.txt", "Applying change 2/2: missing.txt", "Warning: cannot delete 'missing.txt'"},
+		},
+	}
+	for _, tt := range tests {
+		name := strings.Join(append(slices.Clone(tt.flags), tt.args[0]), " ")
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"a.txt": "old\n", "b.bin": "x\x00y"})
+			_, stderr, code := runCopilot(t, dir, changeset, append(slices.Clone(tt.flags), tt.args...)...)
+			if code != 0 {
+				t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(stderr, want) {
+					t.Errorf("stderr = %q, want it to contain %q", stderr, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(stderr, notWant) {
+					t.Errorf("stderr = %q, want it not to contain %q", stderr, notWant)
+				}
+			}
+		})
+	}
+}
+
+func TestVerbosityFlagsConflict(t *testing.T) {
+	_, stderr, code := runCopilot(t, t.TempDir(), "", "--quiet", "--verbose", "extract", ".", ".go")
+	if code == 0 || !strings.Contains(stderr, "--quiet and --verbose cannot be used together") {
+		t.Errorf("exit code = %d, stderr = %q, want a usage error", code, stderr)
+	}
+}
+
+func TestExtractNoCopilotignore(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".copilotignore": "secret.txt\n",
+		"notes.txt":      "",
+		"secret.txt":     "",
+	})
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"extract", "--list", ".", ".txt"}, "notes.txt\n"},
+		{[]string{"extract", "--list", "--no-copilotignore", ".", ".txt"}, "notes.txt\nsecret.txt\n"},
+	}
+	for _, tt := range tests {
+		stdout, stderr, code := runCopilot(t, dir, "", tt.args...)
+		if code != 0 {
+			t.Fatalf("%v: exit code = %d, stderr: %s", tt.args, code, stderr)
+		}
+		if stdout != tt.want {
+			t.Errorf("%v: stdout = %q, want %q", tt.args, stdout, tt.want)
+		}
+	}
+}
+
+func TestIgnoreFlagsGitExcludesDefault(t *testing.T) {
+	repo := t.TempDir()
+	writeFiles(t, repo, map[string]string{".git/HEAD": "", "sub/a.go": ""})
+	plain := t.TempDir()
+	tests := []struct {
+		name string
+		args []string
+		dir  string
+		want bool
+	}{
+		{"scan root holding .git", nil, repo, true},
+		{"scan root without .git", nil, plain, false},
+		{"subdirectory of a repository", nil, filepath.Join(repo, "sub"), false},
+		{"subdirectory with --follow-gitignore-from-root", []string{"--follow-gitignore-from-root"}, filepath.Join(repo, "sub"), true},
+		{"disabled explicitly", []string{"--git-excludes=false"}, repo, false},
+		{"enabled explicitly", []string{"--git-excludes"}, plain, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("extract", flag.ContinueOnError)
+			f := addIgnoreFlags(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if got := f.options(tt.dir).GitExcludes; got != tt.want {
+				t.Errorf("GitExcludes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractIgnoreCase(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore":      "*.LOG\n",
+		"app.log":         "",
+		"Notes.TXT":       "",
+		"Vendor/lib.txt":  "",
+		"keep/readme.txt": "",
+	})
+	for _, ignoreCase := range []bool{false, true} {
+		matcher := rootMatcher(t, dir)
+		matcher.IgnoreCase = ignoreCase
+		got := extractedPaths(t, dir, extractOptions{
+			extensions:    []string{".txt", ".log"},
+			excludes:      []string{"vendor/"},
+			includes:      []string{"*.txt"},
+			ignoreMatcher: matcher,
+			maxDepth:      -1,
+		})
+		want := []string{"Vendor/lib.txt", "app.log", "keep/readme.txt"}
+		if ignoreCase {
+			want = []string{"Notes.TXT", "keep/readme.txt"}
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("ignoreCase=%v: extracted %q, want %q", ignoreCase, got, want)
+		}
+	}
+}
+
+func TestHasExtension(t *testing.T) {
+	tests := []struct {
+		path       string
+		extensions []string
+		ignoreCase bool
+		want       bool
+	}{
+		{"photo.jpg", []string{".jpg"}, false, true},
+		{"photo.JPG", []string{".jpg"}, false, false},
+		{"photo.JPG", []string{".jpg"}, true, true},
+		{"photo.jpg", []string{".JPG"}, false, false},
+		{"photo.jpg", []string{".JPG"}, true, true},
+		{"Main.Go", []string{".md", ".go"}, true, true},
+		{"photo.jpeg", []string{".jpg"}, true, false},
+		{"Makefile", []string{".go"}, true, false},
+	}
+	for _, tt := range tests {
+		opts := extractOptions{extensions: tt.extensions, extIgnoreCase: tt.ignoreCase}
+		if got := opts.hasExtension(tt.path); got != tt.want {
+			t.Errorf("hasExtension(%q) with %q, ignoreCase=%v = %v, want %v", tt.path, tt.extensions, tt.ignoreCase, got, tt.want)
+		}
+	}
+}
+
+func TestExcludesExtension(t *testing.T) {
+	tests := []struct {
+		path       string
+		excluded   []string
+		ignoreCase bool
+		want       bool
+	}{
+		{"yarn.lock", []string{".lock", ".snap"}, false, true},
+		{"ui.test.js.snap", []string{".lock", ".snap"}, false, true},
+		{"main.go", []string{".lock", ".snap"}, false, false},
+		{"Cargo.LOCK", []string{".lock"}, false, false},
+		{"Cargo.LOCK", []string{".lock"}, true, true},
+		{"lock", []string{".lock"}, false, false},
+		{"main.go", nil, false, false},
+	}
+	for _, tt := range tests {
+		opts := extractOptions{excludeExtensions: tt.excluded, extIgnoreCase: tt.ignoreCase}
+		if got := opts.excludesExtension(tt.path); got != tt.want {
+			t.Errorf("excludesExtension(%q) with %q, ignoreCase=%v = %v, want %v", tt.path, tt.excluded, tt.ignoreCase, got, tt.want)
+		}
+	}
+}
+
+func TestExtractExcludeExt(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.go":                 "",
+		"yarn.lock":               "",
+		"sub/Cargo.lock":          "",
+		"__snapshots__/ui.snap":   "",
+		"README.md":               "",
+		"Dockerfile":              "",
+		"config.lock.json":        "",
+		"__snapshots__/notes.txt": "",
+	})
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{
+			args: []string{"--exclude-ext", ".lock,.snap", ".", "*"},
+			want: "Dockerfile\nREADME.md\n__snapshots__/notes.txt\nconfig.lock.json\nmain.go\n",
+		},
+		{
+			args: []string{"--exclude-ext", "lock", "--exclude-ext", "snap", "--exclude-ext", "md", ".", "*"},
+			want: "Dockerfile\n__snapshots__/notes.txt\nconfig.lock.json\nmain.go\n",
+		},
+		{
+			// Excluded extensions win over --include
+			args: []string{"--include", "*.lock", "--exclude-ext", ".lock", ".", ".go"},
+			want: "main.go\n",
+		},
+	}
+	for _, tt := range tests {
+		args := append([]string{"extract", "--list"}, tt.args...)
+		stdout, stderr, code := runCopilot(t, dir, "", args...)
+		if code != 0 {
+			t.Fatalf("%v: exit code = %d, stderr: %s", args, code, stderr)
+		}
+		if stdout != tt.want {
+			t.Errorf("%v: stdout = %q, want %q", args, stdout, tt.want)
+		}
+	}
+}
+
+func TestExtractExtIgnoreCase(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "", "B.TXT": "", "c.Txt": "", "d.md": ""})
+	tests := []struct {
+		extension  string
+		ignoreCase bool
+		want       []string
+	}{
+		{".txt", false, []string{"a.txt"}},
+		{".TXT", false, []string{"B.TXT"}},
+		{".txt", true, []string{"B.TXT", "a.txt", "c.Txt"}},
+		{".TXT", true, []string{"B.TXT", "a.txt", "c.Txt"}},
+	}
+	for _, tt := range tests {
+		got := extractedPaths(t, dir, extractOptions{extensions: []string{tt.extension}, extIgnoreCase: tt.ignoreCase, maxDepth: -1})
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s, ignoreCase=%v: extracted %q, want %q", tt.extension, tt.ignoreCase, got, tt.want)
+		}
+	}
+}
+
+func TestParseExtensions(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{".go", []string{".go"}},
+		{"go,md", []string{".go", ".md"}},
+		{" .go , md ,, ", []string{".go", ".md"}},
+		{"*", []string{"*"}},
+		{"tar.gz", []string{".tar.gz"}},
+	}
+	for _, tt := range tests {
+		if got := parseExtensions(tt.in); !slices.Equal(got, tt.want) {
+			t.Errorf("parseExtensions(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExtractExtFlag(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"main.go": "", "README.md": "", "notes.txt": "", "data.json": ""})
+	tests := []struct {
+		args     []string
+		want     string
+		wantCode int
+	}{
+		{args: []string{".", ".go"}, want: "main.go\n"},
+		{args: []string{"--ext", ".go", "."}, want: "main.go\n"},
+		{args: []string{"--ext", ".go", "--ext", "md", "."}, want: "README.md\nmain.go\n"},
+		{args: []string{"--ext", "go,md", "."}, want: "README.md\nmain.go\n"},
+		{args: []string{"--ext", "md", ".", ".go,.txt"}, want: "README.md\nmain.go\nnotes.txt\n"},
+		{args: []string{"--ext", ",", "."}, wantCode: 1},
+		{args: []string{"."}, wantCode: 1},
+	}
+	for _, tt := range tests {
+		args := append([]string{"extract", "--list"}, tt.args...)
+		stdout, stderr, code := runCopilot(t, dir, "", args...)
+		if code != tt.wantCode {
+			t.Fatalf("%v: exit code = %d, want %d, stderr: %s", args, code, tt.wantCode, stderr)
+		}
+		if code == 0 && stdout != tt.want {
+			t.Errorf("%v: stdout = %q, want %q", args, stdout, tt.want)
+		}
+	}
+}
+
+func TestExtractAllExtensions(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore":   "*.log\n",
+		"Makefile":     "all:\n",
+		"main.go":      "package main\n",
+		"docs/a.md":    "# A\n",
+		"debug.log":    "ignored\n",
+		"image.png":    "\x89PNG\r\n\x1a\n\x00\x00",
+		"noext/README": "readme\n",
+	})
+	out, err := extractFileContent(context.Background(), dir, extractOptions{
+		extensions:    []string{"*"},
+		ignoreMatcher: rootMatcher(t, dir),
+		format:        "json",
+		maxDepth:      -1,
+		logger:        quietLogger(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changeset MdiffJSON
+	if err := json.Unmarshal([]byte(out), &changeset); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, change := range changeset.Changes {
+		got = append(got, change.FilePath)
+	}
+	// Binary files are still skipped, and ignored files left out
+	want := []string{".gitignore", "Makefile", "docs/a.md", "main.go", "noext/README"}
+	if !slices.Equal(got, want) {
+		t.Errorf("extracted %q, want %q", got, want)
+	}
+}
+
+func TestParseTimeBound(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "24h", want: now.Add(-24 * time.Hour)},
+		{value: "90m", want: now.Add(-90 * time.Minute)},
+		{value: "7d", want: time.Date(2024, 6, 8, 12, 0, 0, 0, time.UTC)},
+		{value: "0d", want: now},
+		{value: "2024-01-02T03:04:05Z", want: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{value: "2024-01-02T03:04:05+02:00", want: time.Date(2024, 1, 2, 1, 4, 5, 0, time.UTC)},
+		{value: "2024-01-02", want: time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local)},
+		{value: "-3d", wantErr: true},
+		{value: "yesterday", wantErr: true},
+		{value: "2024-13-01", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeBound(tt.value, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTimeBound(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !got.Equal(tt.want) {
+			t.Errorf("parseTimeBound(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestExtractMtimeWindow(t *testing.T) {
+	dir := t.TempDir()
+	day := func(d int) time.Time { return time.Date(2024, 6, d, 12, 0, 0, 0, time.UTC) }
+	mtimes := map[string]time.Time{"day1.txt": day(1), "day5.txt": day(5), "day10.txt": day(10), "sub/day7.txt": day(7)}
+	for name, mtime := range mtimes {
+		writeFiles(t, dir, map[string]string{name: ""})
+		if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(name)), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name          string
+		after, before time.Time
+		want          []string
+	}{
+		{name: "no filter", want: []string{"day1.txt", "day10.txt", "day5.txt", "sub/day7.txt"}},
+		{name: "after", after: day(5), want: []string{"day10.txt", "day5.txt", "sub/day7.txt"}},
+		{name: "before", before: day(5), want: []string{"day1.txt"}},
+		{name: "window", after: day(2), before: day(10), want: []string{"day5.txt", "sub/day7.txt"}},
+		{name: "empty window", after: day(8), before: day(9)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractedPaths(t, dir, extractOptions{extensions: []string{".txt"}, mtimeAfter: tt.after, mtimeBefore: tt.before, maxDepth: -1})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("extracted %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractMtimeFlags(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"new.txt": "", "old.txt": ""})
+	old := time.Now().Add(-72 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "old.txt"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		args     []string
+		want     string
+		wantCode int
+	}{
+		{args: []string{"--mtime-after", "24h"}, want: "new.txt\n"},
+		{args: []string{"--mtime-before", "2d"}, want: "old.txt\n"},
+		{args: []string{"--mtime-after", "7d", "--mtime-before", "1d"}, want: "old.txt\n"},
+		{args: []string{"--mtime-after", "1d", "--mtime-before", "7d"}, wantCode: 1},
+		{args: []string{"--mtime-after", "last week"}, wantCode: 1},
+	}
+	for _, tt := range tests {
+		args := append(append([]string{"extract", "--list"}, tt.args...), ".", ".txt")
+		stdout, stderr, code := runCopilot(t, dir, "", args...)
+		if code != tt.wantCode {
+			t.Fatalf("%v: exit code = %d, want %d, stderr: %s", args, code, tt.wantCode, stderr)
+		}
+		if code == 0 && stdout != tt.want {
+			t.Errorf("%v: stdout = %q, want %q", args, stdout, tt.want)
+		}
+	}
+}
+
+func TestExtractSizeBand(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"empty.txt":  "",
+		"stub.txt":   strings.Repeat("s", 10),
+		"small.txt":  strings.Repeat("s", 100),
+		"medium.txt": strings.Repeat("m", 1000),
+		"large.txt":  strings.Repeat("l", 5000),
+	})
+	tests := []struct {
+		name             string
+		minSize, maxSize int64
+		want             []string
+	}{
+		{name: "no band", want: []string{"empty.txt", "large.txt", "medium.txt", "small.txt", "stub.txt"}},
+		{name: "min only", minSize: 100, want: []string{"large.txt", "medium.txt", "small.txt"}},
+		{name: "max only", maxSize: 100, want: []string{"empty.txt", "small.txt", "stub.txt"}},
+		{name: "band", minSize: 11, maxSize: 1000, want: []string{"medium.txt", "small.txt"}},
+		{name: "single size", minSize: 1000, maxSize: 1000, want: []string{"medium.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractedPaths(t, dir, extractOptions{extensions: []string{".txt"}, minSize: tt.minSize, maxSize: tt.maxSize, maxDepth: -1})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("extracted %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractSizeFlags(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"stub.txt":  "s",
+		"keep.txt":  strings.Repeat("k", 2048),
+		"large.txt": strings.Repeat("l", 4096),
+	})
+	tests := []struct {
+		global     []string // Flags given before the command
+		args       []string
+		wantCode   int
+		wantOut    string
+		wantStderr []string
+	}{
+		{
+			args:    []string{"--list", "--min-size", "1K", "--max-size", "2K"},
+			wantOut: "keep.txt\n",
+		},
+		{
+			global: []string{"--verbose"},
+			args:   []string{"--min-size", "1K", "--max-size", "2K"},
+			wantStderr: []string{
+				"stub.txt, 1 bytes is below --min-size",
+				"large.txt, 4096 bytes is above --max-size",
+				"Skipped 2 file(s) outside the time or size limits.",
+			},
+		},
+		{args: []string{"--min-size", "2K", "--max-size", "1K"}, wantCode: 1, wantStderr: []string{"--min-size must not be larger than --max-size"}},
+		{args: []string{"--max-size", "big"}, wantCode: 2, wantStderr: []string{"invalid size 'big'"}},
+	}
+	for _, tt := range tests {
+		args := slices.Concat(tt.global, []string{"extract"}, tt.args, []string{".", ".txt"})
+		stdout, stderr, code := runCopilot(t, dir, "", args...)
+		if code != tt.wantCode {
+			t.Fatalf("%v: exit code = %d, want %d, stderr: %s", args, code, tt.wantCode, stderr)
+		}
+		if tt.wantOut != "" && stdout != tt.wantOut {
+			t.Errorf("%v: stdout = %q, want %q", args, stdout, tt.wantOut)
+		}
+		for _, want := range tt.wantStderr {
+			if !strings.Contains(stderr, want) {
+				t.Errorf("%v: stderr does not contain %q:\n%s", args, want, stderr)
+			}
+		}
+	}
+}
+
+func TestExtractGitignoreDebug(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore":        "build/\n*.log\n!keep.log\n",
+		"build/out.o":       "",
+		"debug.log":         "",
+		"keep.log":          "",
+		"src/main.go":       "",
+		"src/.gitignore":    "*.tmp\n",
+		"src/scratch.tmp":   "",
+		"src/sub/x.go":      "",
+		"src/sub/deeper.go": "",
+	})
+	matcher, err := ignore.New("", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	if _, err := extractFileContent(context.Background(), dir, extractOptions{
+		extensions:    []string{"*"},
+		ignoreMatcher: matcher,
+		excludes:      []string{"src/sub"},
+		listOnly:      true,
+		maxDepth:      -1,
+		ignoreDebug:   true,
+		logger:        &logger{out: &logs, level: levelInfo},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(logs.String(), "\n"), "\n")
+	want := []string{
+		"gitignore-debug: build/: ignored by 'build/' (.gitignore:1)",
+		"gitignore-debug: debug.log: ignored by '*.log' (.gitignore:2)",
+		"gitignore-debug: keep.log: not ignored, re-included by '!keep.log' (.gitignore:3)",
+		"gitignore-debug: src/: not ignored",
+		"gitignore-debug: src/main.go: not ignored",
+		"gitignore-debug: src/scratch.tmp: ignored by '*.tmp' (" + filepath.Join("src", ".gitignore") + ":1)",
+		"gitignore-debug: src/sub/: excluded by --exclude 'src/sub'",
+	}
+	for _, line := range want {
+		if !slices.Contains(lines, line) {
+			t.Errorf("logs do not contain %q:\n%s", line, logs.String())
+		}
+	}
+	// Paths inside an ignored or excluded directory are not walked
+	for _, path := range []string{"build/out.o", "src/sub/x.go"} {
+		if strings.Contains(logs.String(), path) {
+			t.Errorf("logs mention %s, inside a skipped directory:\n%s", path, logs.String())
+		}
+	}
+}
+
+func TestExtractGitignoreDebugFlag(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{".gitignore": "*.log\n", "debug.log": "", "main.go": ""})
+	tests := []struct {
+		name       string
+		args       []string
+		wantStderr bool
+	}{
+		{name: "without the flag", args: []string{"extract", "--list", ".", "*"}},
+		{name: "with the flag", args: []string{"extract", "--list", "--gitignore-debug", ".", "*"}, wantStderr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runCopilot(t, dir, "", tt.args...)
+			if code != 0 {
+				t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+			}
+			if stdout != ".gitignore\nmain.go\n" {
+				t.Errorf("stdout = %q, want the debug output kept off it", stdout)
+			}
+			if got := strings.Contains(stderr, "gitignore-debug: debug.log: ignored by '*.log' (.gitignore:1)"); got != tt.wantStderr {
+				t.Errorf("stderr = %q, want the decision for debug.log: %v", stderr, tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestExtractMaxDepth(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"root.txt":        "",
+		"a/one.txt":       "",
+		"a/b/two.txt":     "",
+		"a/b/c/three.txt": "",
+		"x/one.txt":       "",
+	})
+	tests := []struct {
+		maxDepth int
+		want     []string
+	}{
+		{0, []string{"root.txt"}},
+		{1, []string{"a/one.txt", "root.txt", "x/one.txt"}},
+		{2, []string{"a/b/two.txt", "a/one.txt", "root.txt", "x/one.txt"}},
+		{3, []string{"a/b/c/three.txt", "a/b/two.txt", "a/one.txt", "root.txt", "x/one.txt"}},
+		{-1, []string{"a/b/c/three.txt", "a/b/two.txt", "a/one.txt", "root.txt", "x/one.txt"}},
+	}
+	for _, tt := range tests {
+		got := extractedPaths(t, dir, extractOptions{extensions: []string{".txt"}, maxDepth: tt.maxDepth})
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("maxDepth=%d: extracted %q, want %q", tt.maxDepth, got, tt.want)
+		}
+	}
+}
+
+func TestExtractNoHidden(t *testing.T) {
+	base := t.TempDir()
+	// The scan root itself is hidden, and must still be walked
+	dir := filepath.Join(base, ".project")
+	writeFiles(t, dir, map[string]string{
+		".gitignore":           "*.log\n",
+		".env.txt":             "",
+		".vscode/settings.txt": "",
+		"src/.hidden.txt":      "",
+		"src/main.txt":         "",
+		"src/debug.log":        "",
+		"notes.txt":            "",
+	})
+	tests := []struct {
+		noHidden bool
+		want     []string
+	}{
+		{false, []string{".env.txt", ".vscode/settings.txt", "notes.txt", "src/.hidden.txt", "src/main.txt"}},
+		{true, []string{"notes.txt", "src/main.txt"}},
+	}
+	for _, tt := range tests {
+		got := extractedPaths(t, dir, extractOptions{
+			extensions:    []string{".txt", ".log"},
+			ignoreMatcher: rootMatcher(t, dir),
+			noHidden:      tt.noHidden,
+			maxDepth:      -1,
+		})
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("noHidden=%v: extracted %q, want %q", tt.noHidden, got, tt.want)
+		}
+	}
+}
+
+func TestExtractRelativeTo(t *testing.T) {
+	repo := t.TempDir()
+	writeFiles(t, repo, map[string]string{
+		"services/api/main.go":      "package main\n",
+		"services/api/handler/h.go": "package handler\n",
+		"services/web/web.go":       "package web\n",
+	})
+	scanDir := filepath.Join(repo, "services", "api")
+	tests := []struct {
+		name       string
+		relativeTo string
+		want       []string
+	}{
+		{"default to the scan root", "", []string{"handler/h.go", "main.go"}},
+		{"scan root", scanDir, []string{"handler/h.go", "main.go"}},
+		{"parent", filepath.Join(repo, "services"), []string{"api/handler/h.go", "api/main.go"}},
+		{"repository root", repo, []string{"services/api/handler/h.go", "services/api/main.go"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractedPaths(t, scanDir, extractOptions{extensions: []string{".go"}, relativeTo: tt.relativeTo, maxDepth: -1})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("extracted %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractRelativeToMustContainScanDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a/main.go": "package main\n", "b/other.go": "package b\n"})
+	stdout, stderr, code := runCopilot(t, dir, "", "extract", "--relative-to", "b", "a", ".go")
+	if code == 0 || !strings.Contains(stderr, "does not contain") {
+		t.Errorf("exit code = %d, stderr = %q, want an error", code, stderr)
+	}
+	if stdout != "" {
+		t.Errorf("stdout = %q, want nothing", stdout)
+	}
+
+	stdout, stderr, code = runCopilot(t, dir, "", "extract", "--relative-to", ".", "--format", "json", "a", ".go")
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, `"file_path": "a/main.go"`) {
+		t.Errorf("stdout = %s, want the path relative to the working directory", stdout)
+	}
+}
+
+func TestExtractPrefix(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"main.go": "package main\n", "sub/util.go": "package sub\n"})
+	tests := []struct {
+		name   string
+		prefix string
+		format string
+		want   []string
+	}{
+		{"text", "services/api", "text", []string{"<file_path>services/api/main.go</file_path>", "<file_path>services/api/sub/util.go</file_path>"}},
+		{"json", "services/api", "json", []string{`"file_path": "services/api/main.go"`, `"file_path": "services/api/sub/util.go"`}},
+		{"cleaned", "./services//api/", "json", []string{`"file_path": "services/api/main.go"`}},
+		{"parent references", "services/x/../api", "text", []string{"<file_path>services/api/main.go</file_path>"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runCopilot(t, dir, "", "extract", "--prefix", tt.prefix, "--format", tt.format, ".", ".go")
+			if code != 0 {
+				t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(stdout, want) {
+					t.Errorf("stdout = %s, want it to contain %s", stdout, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractPrefixAppliesBack(t *testing.T) {
+	project := t.TempDir()
+	writeFiles(t, project, map[string]string{"services/api/main.go": "package main\n"})
+	changeset, stderr, code := runCopilot(t, filepath.Join(project, "services", "api"), "", "extract", "--prefix", "services/api", "--format", "json", ".", ".go")
+	if code != 0 {
+		t.Fatalf("extract exit code = %d, stderr: %s", code, stderr)
+	}
+	changeset = strings.ReplaceAll(changeset, `package main\n`, `package main // changed\n`)
+	if _, stderr, code := runCopilot(t, project, changeset, "apply", "-"); code != 0 {
+		t.Fatalf("apply exit code = %d, stderr: %s", code, stderr)
+	}
+	if got := readFile(t, filepath.Join(project, "services", "api", "main.go")); got != "package main // changed\n" {
+		t.Errorf("main.go = %q, want the changed content applied from the project root", got)
+	}
+}
+
+func TestGunzipIfCompressed(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write([]byte(`{"changes": []}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{"plain", []byte(`{"changes": []}`), `{"changes": []}`, false},
+		{"compressed", compressed.Bytes(), `{"changes": []}`, false},
+		{"truncated", compressed.Bytes()[:12], "", true},
+		{"empty", nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := gunzipIfCompressed(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("gunzipIfCompressed() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != tt.want {
+				t.Errorf("gunzipIfCompressed() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractGzipRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"main.go": "package main\n", "pkg/lib.go": "package pkg\n"})
+	if _, stderr, code := runCopilot(t, src, "", "extract", "--gzip", "--format", "json", "-o", "out.json.gz", ".", ".go"); code != 0 {
+		t.Fatalf("extract exit code = %d, stderr: %s", code, stderr)
+	}
+	compressed, err := os.ReadFile(filepath.Join(src, "out.json.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("output is not gzip-compressed: %v", err)
+	}
+	decompressed, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, stderr, code := runCopilot(t, src, "", "extract", "--format", "json", ".", ".go")
+	if code != 0 {
+		t.Fatalf("extract exit code = %d, stderr: %s", code, stderr)
+	}
+	if string(decompressed) != plain {
+		t.Errorf("decompressed output =\n%s\nwant the uncompressed output\n%s", decompressed, plain)
+	}
+
+	for name, args := range map[string][]string{
+		"compressed file":    {"apply", filepath.Join(src, "out.json.gz")},
+		"decompressed stdin": {"apply", "-"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dst := t.TempDir()
+			if _, stderr, code := runCopilot(t, dst, string(decompressed), args...); code != 0 {
+				t.Fatalf("apply exit code = %d, stderr: %s", code, stderr)
+			}
+			want := []string{"main.go=package main\n", "pkg/lib.go=package pkg\n"}
+			if got := fileContents(t, dst); !slices.Equal(got, want) {
+				t.Errorf("applied files = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestExtractPrint0(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.txt":              "first\nfile\n",
+		"dir/b.txt":          "",
+		"dir/with space.txt": "tab\there\n",
+	}
+	writeFiles(t, dir, files)
+	out, err := extractFileContent(context.Background(), dir, extractOptions{
+		extensions: []string{".txt"},
+		format:     "print0",
+		maxDepth:   -1,
+		logger:     quietLogger(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out, "\x00") {
+		t.Fatalf("output %q is not NUL-terminated", out)
+	}
+	fields := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+	if len(fields)%2 != 0 {
+		t.Fatalf("output has %d fields, want path and content pairs", len(fields))
+	}
+	got := map[string]string{}
+	var paths []string
+	for i := 0; i < len(fields); i += 2 {
+		paths = append(paths, fields[i])
+		got[fields[i]] = fields[i+1]
+	}
+	if want := []string{"a.txt", "dir/b.txt", "dir/with space.txt"}; !slices.Equal(paths, want) {
+		t.Errorf("paths = %q, want %q", paths, want)
+	}
+	for path, content := range files {
+		if got[path] != content {
+			t.Errorf("content of %s = %q, want %q", path, got[path], content)
+		}
+	}
+}
+
+func TestExtractPrint0Conflicts(t *testing.T) {
+	for _, args := range [][]string{
+		{"extract", "--print0", "--format", "json", ".", ".txt"},
+		{"extract", "--print0", "--binary", "raw", ".", ".txt"},
+	} {
+		if _, stderr, code := runCopilot(t, t.TempDir(), "", args...); code == 0 || !strings.Contains(stderr, "--print0 cannot") {
+			t.Errorf("%v: exit code = %d, stderr = %q, want an error", args, code, stderr)
+		}
+	}
+}
+
+func TestExtractDedup(t *testing.T) {
+	dir := t.TempDir()
+	const license = "Copyright the authors.\n"
+	writeFiles(t, dir, map[string]string{
+		"a/LICENSE.txt": license,
+		"b/LICENSE.txt": license,
+		"c/LICENSE.txt": license,
+		"main.txt":      "main\n",
+		"empty1.txt":    "",
+		"empty2.txt":    "",
+	})
+	want := "\n<file_path>a/LICENSE.txt</file_path>\n" + license + "\n<file_path_end>a/LICENSE.txt</file_path_end>\n" +
+		"\n<file_path>b/LICENSE.txt</file_path>\n<file_path_dup_of>a/LICENSE.txt</file_path_dup_of>\n<file_path_end>b/LICENSE.txt</file_path_end>\n" +
+		"\n<file_path>c/LICENSE.txt</file_path>\n<file_path_dup_of>a/LICENSE.txt</file_path_dup_of>\n<file_path_end>c/LICENSE.txt</file_path_end>\n" +
+		"\n<file_path>empty1.txt</file_path>\n\n<file_path_end>empty1.txt</file_path_end>\n" +
+		"\n<file_path>empty2.txt</file_path>\n<file_path_dup_of>empty1.txt</file_path_dup_of>\n<file_path_end>empty2.txt</file_path_end>\n" +
+		"\n<file_path>main.txt</file_path>\nmain\n\n<file_path_end>main.txt</file_path_end>\n"
+	// The first copy in output order keeps the content, whatever the order files are read in
+	for _, jobs := range []int{1, 4} {
+		out, err := extractFileContent(context.Background(), dir, extractOptions{
+			extensions: []string{".txt"},
+			dedup:      true,
+			jobs:       jobs,
+			maxDepth:   -1,
+			logger:     quietLogger(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != want {
+			t.Errorf("jobs=%d: output =\n%s\nwant\n%s", jobs, out, want)
+		}
+		if n := strings.Count(out, license); n != 1 {
+			t.Errorf("jobs=%d: license content appears %d times, want once", jobs, n)
+		}
+	}
+}
+
+func TestWriteCDATA(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain", "<![CDATA[plain]]>"},
+		{"a < b && c > d", "<![CDATA[a < b && c > d]]>"},
+		{"</file>", "<![CDATA[</file>]]>"},
+		{"x]]>y", "<![CDATA[x]]]]><![CDATA[>y]]>"},
+		{"bell\a tab\t", "<![CDATA[bell\uFFFD tab\t]]>"},
+		{"bad \xff utf-8", "<![CDATA[bad \uFFFD utf-8]]>"},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		writeCDATA(&out, tt.in)
+		if got := out.String(); got != tt.want {
+			t.Errorf("writeCDATA(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExtractXML(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"tags.txt":    "<file_path>x</file_path>\n</file>\n</files>\n",
+		"cdata.txt":   "end ]]> of <![CDATA[ section\n",
+		"a & b's.txt": "1 < 2 && 3 > 2\n",
+		"empty.txt":   "",
+		"unicode.txt": "héllo wörld\n",
+	}
+	writeFiles(t, dir, files)
+	out, err := extractFileContent(context.Background(), dir, extractOptions{
+		extensions: []string{".txt"},
+		format:     "xml",
+		maxDepth:   -1,
+		logger:     quietLogger(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed struct {
+		Files []struct {
+			Path    string `xml:"path,attr"`
+			Size    int    `xml:"size,attr"`
+			Content string `xml:",chardata"`
+		} `xml:"file"`
+	}
+	if err := xml.Unmarshal([]byte(out), &parsed); err != nil {
+		t.Fatalf("output is not well-formed XML: %v\n%s", err, out)
+	}
+	if len(parsed.Files) != len(files) {
+		t.Fatalf("parsed %d files, want %d:\n%s", len(parsed.Files), len(files), out)
+	}
+	for _, file := range parsed.Files {
+		want, ok := files[file.Path]
+		if !ok {
+			t.Errorf("unexpected path %q", file.Path)
+			continue
+		}
+		if file.Content != want {
+			t.Errorf("content of %s = %q, want %q", file.Path, file.Content, want)
+		}
+		if file.Size != len(want) {
+			t.Errorf("size of %s = %d, want %d", file.Path, file.Size, len(want))
+		}
+	}
+}
+
+// unescapeTagLines reverses escapeTagLines, as documented for readers of the
+// text format.
+func unescapeTagLines(content string) string {
+	return regexp.MustCompile(`(?m)^\\(\\*<file_path)`).ReplaceAllString(content, "$1")
+}
+
+func TestEscapeTagLines(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"no tags\n", "no tags\n"},
+		{"<file_path_end>foo</file_path_end>\n", "\\<file_path_end>foo</file_path_end>\n"},
+		{"x\n<file_path>a</file_path>\ny", "x\n\\<file_path>a</file_path>\ny"},
+		{"\\<file_path>already escaped\n", "\\\\<file_path>already escaped\n"},
+		{"  <file_path> indented\n", "  <file_path> indented\n"},
+		{"inline <file_path> tag\n", "inline <file_path> tag\n"},
+		{"<file_path_deleted>x</file_path_deleted>", "\\<file_path_deleted>x</file_path_deleted>"},
+	}
+	for _, tt := range tests {
+		got := escapeTagLines(tt.in)
+		if got != tt.want {
+			t.Errorf("escapeTagLines(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if back := unescapeTagLines(got); back != tt.in {
+			t.Errorf("unescaping %q = %q, want the original %q", got, back, tt.in)
+		}
+	}
+}
+
+func TestExtractTextDelimiterCollision(t *testing.T) {
+	dir := t.TempDir()
+	tricky := "before\n<file_path_end>tricky.txt</file_path_end>\n\n<file_path>other.txt</file_path>\n\\<file_path>\nafter\n"
+	writeFiles(t, dir, map[string]string{"tricky.txt": tricky, "z.txt": "z\n"})
+	out, err := extractFileContent(context.Background(), dir, extractOptions{extensions: []string{".txt"}, maxDepth: -1, logger: quietLogger()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Split the output on the lines starting with a tag, as a reader would
+	tagRe := regexp.MustCompile(`(?m)^<file_path(_end)?>([^<]*)</file_path(_end)?>\n`)
+	matches := tagRe.FindAllStringSubmatchIndex(out, -1)
+	var paths []string
+	contents := map[string]string{}
+	for i := 0; i+1 < len(matches); i += 2 {
+		start, end := matches[i], matches[i+1]
+		path := out[start[4]:start[5]]
+		if out[end[4]:end[5]] != path {
+			t.Fatalf("tag for %s closed by the end tag of %s in\n%s", path, out[end[4]:end[5]], out)
+		}
+		paths = append(paths, path)
+		contents[path] = unescapeTagLines(strings.TrimSuffix(out[start[1]:end[0]], "\n"))
+	}
+	if want := []string{"tricky.txt", "z.txt"}; !slices.Equal(paths, want) {
+		t.Fatalf("parsed paths %q, want %q from\n%s", paths, want, out)
+	}
+	if contents["tricky.txt"] != tricky {
+		t.Errorf("parsed content = %q, want %q", contents["tricky.txt"], tricky)
+	}
+}
+
+func TestCommonDir(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+	tests := []struct {
+		files []string
+		want  string
+	}{
+		{[]string{"a/x.go"}, "a"},
+		{[]string{"a/x.go", "a/y.go"}, "a"},
+		{[]string{"a/x.go", "b/y.go"}, "."},
+		{[]string{"a/b/c/x.go", "a/b/y.go", "a/b/d/z.go"}, "a/b"},
+		{[]string{"ab/x.go", "a/y.go"}, "."},
+	}
+	for _, tt := range tests {
+		var files []string
+		for _, file := range tt.files {
+			files = append(files, filepath.Join(root, filepath.FromSlash(file)))
+		}
+		if got, want := commonDir(files), filepath.Join(root, filepath.FromSlash(tt.want)); got != want {
+			t.Errorf("commonDir(%q) = %q, want %q", tt.files, got, want)
+		}
+	}
+}
+
+func TestExtractFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"src/a/x.go": "package a\n",
+		"src/b/y.md": "# B\n",
+		"src/c/z.go": "package c\n",
+	})
+	tests := []struct {
+		name        string
+		args        []string
+		wantPaths   []string
+		wantWarning string
+	}{
+		{
+			name:        "missing file",
+			args:        []string{"extract", "--format", "json", "--files", "src/a/x.go, src/missing.go,src/b/y.md"},
+			wantPaths:   []string{"a/x.go", "b/y.md"},
+			wantWarning: "missing.go",
+		},
+		{
+			name:      "extension filter",
+			args:      []string{"extract", "--format", "json", "--files", "src/a/x.go,src/b/y.md,src/c/z.go", ".go"},
+			wantPaths: []string{"a/x.go", "c/z.go"},
+		},
+		{
+			name:      "single directory",
+			args:      []string{"extract", "--format", "json", "--files", "src/a/x.go"},
+			wantPaths: []string{"x.go"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runCopilot(t, dir, "", tt.args...)
+			if code != 0 {
+				t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+			}
+			var changeset MdiffJSON
+			if err := json.Unmarshal([]byte(stdout), &changeset); err != nil {
+				t.Fatal(err)
+			}
+			var paths []string
+			for _, change := range changeset.Changes {
+				paths = append(paths, change.FilePath)
+			}
+			if !slices.Equal(paths, tt.wantPaths) {
+				t.Errorf("extracted %q, want %q", paths, tt.wantPaths)
+			}
+			if tt.wantWarning != "" && (!strings.Contains(stderr, "Warning: ") || !strings.Contains(stderr, tt.wantWarning)) {
+				t.Errorf("stderr = %q, want a warning about %q", stderr, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestExtractListMatchesExtraction(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore":        "build/\n*.gen.go\n",
+		"main.go":           "package main\n",
+		"main.gen.go":       "package main\n",
+		"build/out.go":      "package build\n",
+		"pkg/lib.go":        "package pkg\n",
+		"pkg/lib_test.go":   "package pkg\n",
+		"docs/README.md":    "# Docs\n",
+		"vendor/dep/dep.go": "package dep\n",
+	})
+	opts := extractOptions{
+		extensions:    []string{".go", ".md"},
+		excludes:      []string{"vendor/", "*_test.go"},
+		ignoreMatcher: rootMatcher(t, dir),
+		maxDepth:      -1,
+		logger:        quietLogger(),
+	}
+	listed := extractedPaths(t, dir, opts)
+
+	opts.format = "json"
+	out, err := extractFileContent(context.Background(), dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changeset MdiffJSON
+	if err := json.Unmarshal([]byte(out), &changeset); err != nil {
+		t.Fatal(err)
+	}
+	var extracted []string
+	for _, change := range changeset.Changes {
+		extracted = append(extracted, change.FilePath)
+	}
+	if !slices.Equal(listed, extracted) {
+		t.Errorf("listed %q, want the extracted files %q", listed, extracted)
+	}
+	if want := []string{"docs/README.md", "main.go", "pkg/lib.go"}; !slices.Equal(listed, want) {
+		t.Errorf("listed %q, want %q", listed, want)
+	}
+}
+
+func TestExtractListDoesNotReadFiles(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("needs permissions to make a file unreadable")
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a\n", "secret.txt": "secret\n"})
+	if err := os.Chmod(filepath.Join(dir, "secret.txt"), 0); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, code := runCopilot(t, dir, "", "extract", "--list", ".", ".txt")
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if stdout != "a.txt\nsecret.txt\n" {
+		t.Errorf("stdout = %q, want both files listed", stdout)
+	}
+}
+
+func TestCheckChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		changes []FileChange
+		want    []string // Out of date lines
+	}{
+		{
+			name:  "fully applied",
+			files: map[string]string{"a.txt": "a\n", "new/b.txt": "b\n", "moved.txt": "m\n"},
+			changes: []FileChange{
+				{FilePath: "a.txt", Content: "a\n"},
+				{FilePath: "new/b.txt", Content: "Ygo=", Encoding: "base64"},
+				{FilePath: "gone.txt", Delete: true},
+				{From: "old.txt", To: "moved.txt"},
+			},
+		},
+		{
+			name:  "partially applied",
+			files: map[string]string{"a.txt": "a\n", "b.txt": "old\n", "gone.txt": "", "old.txt": "m\n"},
+			changes: []FileChange{
+				{FilePath: "a.txt", Content: "a\n"},
+				{FilePath: "b.txt", Content: "new\n"},
+				{FilePath: "gone.txt", Delete: true},
+				{From: "old.txt", To: "moved.txt"},
+			},
+			want: []string{
+				"Out of date: b.txt (content differs)",
+				"Out of date: gone.txt (not deleted)",
+				"Out of date: old.txt (not renamed to moved.txt)",
+			},
+		},
+		{
+			name:    "missing files",
+			changes: []FileChange{{FilePath: "a.txt", Content: "a\n"}, {From: "old.txt", To: "moved.txt"}},
+			want: []string{
+				"Out of date: a.txt (missing)",
+				"Out of date: old.txt (not renamed to moved.txt)",
+			},
+		},
+		{
+			name:  "written then renamed",
+			files: map[string]string{"b.txt": "a\n"},
+			changes: []FileChange{
+				{FilePath: "a.txt", Content: "a\n"},
+				{From: "a.txt", To: "b.txt"},
+			},
+		},
+		{
+			name:  "written then renamed twice, with other content",
+			files: map[string]string{"c.txt": "other\n"},
+			changes: []FileChange{
+				{FilePath: "a.txt", Content: "a\n"},
+				{From: "a.txt", To: "b.txt"},
+				{From: "b.txt", To: "c.txt"},
+			},
+			want: []string{"Out of date: c.txt (content differs)"},
+		},
+		{
+			name:  "renamed then written over",
+			files: map[string]string{"a.txt": "recreated\n", "b.txt": "moved\n", "c.txt": "new\n"},
+			changes: []FileChange{
+				{From: "a.txt", To: "b.txt"},
+				{FilePath: "a.txt", Content: "recreated\n"},
+				{From: "x.txt", To: "c.txt"},
+				{FilePath: "c.txt", Content: "new\n"},
+			},
+		},
+		{
+			name:  "renamed then deleted",
+			files: map[string]string{},
+			changes: []FileChange{
+				{From: "a.txt", To: "b.txt"},
+				{FilePath: "b.txt", Delete: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			t.Chdir(dir)
+			out := captureApplyOut(t)
+
+			outdated := checkChanges(tt.changes, applyOptions{logger: quietLogger()})
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				if line != "" {
+					got = append(got, line)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+			if outdated != len(tt.want) {
+				t.Errorf("checkChanges() = %d, want %d", outdated, len(tt.want))
+			}
+			if files := fileContents(t, dir); len(files) != len(tt.files) {
+				t.Errorf("files = %q after a check, want them untouched", files)
+			}
+		})
+	}
+}
+
+func TestApplyCheckExitCode(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a\n"})
+	tests := []struct {
+		changeset string
+		wantCode  int
+	}{
+		{`{"changes": [{"file_path": "a.txt", "content": "a\n"}]}`, 0},
+		{`{"changes": [{"file_path": "a.txt", "content": "b\n"}]}`, 1},
+		{`{"changes": [{"file_path": "b.txt", "content": "a\n"}]}`, 1},
+	}
+	for _, tt := range tests {
+		stdout, stderr, code := runCopilot(t, dir, tt.changeset, "apply", "--check", "-")
+		if code != tt.wantCode {
+			t.Errorf("%s: exit code = %d, want %d\nstdout: %s\nstderr: %s", tt.changeset, code, tt.wantCode, stdout, stderr)
+		}
+	}
+	if got := fileContents(t, dir); !slices.Equal(got, []string{"a.txt=a\n"}) {
+		t.Errorf("files = %q, want them untouched", got)
+	}
+}
+
+func TestTrimTrailingWhitespace(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"no trailing\n", "no trailing\n"},
+		{"spaces   \ntabs\t\t\nmixed \t \n", "spaces\ntabs\nmixed\n"},
+		{"crlf  \r\nkept\r\n", "crlf\r\nkept\r\n"},
+		{"last line  ", "last line"},
+		{"   \n\n", "\n\n"},
+		{"  leading kept\n", "  leading kept\n"},
+		{"nbsp\u00a0\n", "nbsp\n"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := trimTrailingWhitespace(tt.in); got != tt.want {
+			t.Errorf("trimTrailingWhitespace(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSqueezeBlankLines(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"a\nb\n", "a\nb\n"},
+		{"a\n\n\n\nb\n", "a\n\nb\n"},
+		{"a\n \n\t\n\nb\n", "a\n \nb\n"},
+		{"\n\n\na\n\n", "\na\n\n"},
+		{"a\r\n\r\n\r\nb\r\n", "a\r\n\r\nb\r\n"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := squeezeBlankLines(tt.in); got != tt.want {
+			t.Errorf("squeezeBlankLines(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExtractWhitespaceOptions(t *testing.T) {
+	dir := t.TempDir()
+	const content = "func main() {  \n\n\n\treturn\t\n}   \n\n\n"
+	writeFiles(t, dir, map[string]string{"main.go": content})
+	tests := []struct {
+		name          string
+		trim, squeeze bool
+		want          string
+	}{
+		{"flags off", false, false, content},
+		{"trim", true, false, "func main() {\n\n\n\treturn\n}\n\n\n"},
+		{"squeeze", false, true, "func main() {  \n\n\treturn\t\n}   \n\n"},
+		{"both", true, true, "func main() {\n\n\treturn\n}\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := extractFileContent(context.Background(), dir, extractOptions{
+				extensions:        []string{".go"},
+				format:            "json",
+				trimTrailingSpace: tt.trim,
+				squeezeBlank:      tt.squeeze,
+				maxDepth:          -1,
+				logger:            quietLogger(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var changeset MdiffJSON
+			if err := json.Unmarshal([]byte(out), &changeset); err != nil {
+				t.Fatal(err)
+			}
+			if len(changeset.Changes) != 1 || changeset.Changes[0].Content != tt.want {
+				t.Errorf("extracted %+v, want content %q", changeset.Changes, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyMultipleFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.json":    `{"changes": [{"file_path": "shared.txt", "content": "from a\n"}, {"file_path": "only-a.txt", "content": "a\n"}, {"file_path": "gone.txt", "content": "written by a\n"}]}`,
+		"b.json":    `{"changes": [{"file_path": "only-b.txt", "content": "b\n"}, {"file_path": "shared.txt", "content": "from b\n"}]}`,
+		"c.json":    `{"changes": [{"file_path": "gone.txt", "delete": true}]}`,
+		"gone.txt":  "",
+		"other.txt": "untouched\n",
+	})
+	_, stderr, code := runCopilot(t, dir, "", "--verbose", "apply", "a.json", "b.json", "c.json")
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	var got []string
+	for _, file := range fileContents(t, dir) {
+		if !strings.HasSuffix(strings.SplitN(file, "=", 2)[0], ".json") {
+			got = append(got, file)
+		}
+	}
+	want := []string{"only-a.txt=a\n", "only-b.txt=b\n", "other.txt=untouched\n", "shared.txt=from b\n"}
+	if !slices.Equal(got, want) {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+	if !strings.Contains(stderr, "Change to 'shared.txt' from 'a.json' overridden by 'b.json'") {
+		t.Errorf("stderr = %q, want the override reported", stderr)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// manifestEntry describes an extracted file in a manifest.
+type manifestEntry struct {
+	SHA256 string `json:"sha256"` // Hex SHA-256 of the file as read from disk
+	Size   int64  `json:"size"`   // Size in bytes of the file as read from disk
+}
+
+// extractManifest is the manifest written by extract --manifest, keyed by
+// the output path of each extracted file.
+type extractManifest struct {
+	Files map[string]manifestEntry `json:"files"`
+}
+
+// loadManifest reads the manifest written by a previous extract --manifest.
+func loadManifest(filePath string) (*extractManifest, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest '%s': %w", filePath, err)
+	}
+	var m extractManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing manifest '%s': %w", filePath, err)
+	}
+	return &m, nil
+}
+
+// unchanged reports whether file has the same content as when m was
+// written.
+func (m *extractManifest) unchanged(file FileChange) bool {
+	entry, ok := m.Files[file.FilePath]
+	return ok && entry.SHA256 == file.sha256
+}
+
+// deleted returns the files of m missing from seen, keyed by output path,
+// as deletions sorted by path.
+func (m *extractManifest) deleted(seen map[string]bool) []FileChange {
+	var deleted []FileChange
+	for filePath := range m.Files {
+		if !seen[filePath] {
+			deleted = append(deleted, FileChange{FilePath: filePath, Delete: true})
+		}
+	}
+	sort.Slice(deleted, func(i, j int) bool { return deleted[i].FilePath < deleted[j].FilePath })
+	return deleted
+}
+
+// add records the extracted files. A nil manifest records nothing.
+func (m *extractManifest) add(files ...FileChange) {
+	if m == nil {
+		return
+	}
+	if m.Files == nil {
+		m.Files = map[string]manifestEntry{}
+	}
+	for _, file := range files {
+		m.Files[file.FilePath] = manifestEntry{SHA256: file.sha256, Size: file.size}
+	}
+}
+
+// marshal returns the manifest as indented JSON.
+func (m *extractManifest) marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
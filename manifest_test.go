@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestExtractManifest(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.go":      "package main\n",
+		"lib/util.go":  "package lib\n\n// Util is a helper.\nfunc Util() {}\n",
+		"empty.go":     "",
+		"data/blob.go": "\x00\x01\x02binary",
+		"ignored.md":   "# Not extracted\n",
+	}
+	writeFiles(t, dir, files)
+
+	manifest := &extractManifest{}
+	if _, err := extractFileContent(context.Background(), dir, extractOptions{
+		extensions: []string{".go"},
+		binaryMode: "base64",
+		// The hash is the one of the file on disk, not of the transformed output
+		stripComments: true,
+		manifest:      manifest,
+		maxDepth:      -1,
+		logger:        quietLogger(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]manifestEntry{}
+	for path, content := range files {
+		if filepath.Ext(path) != ".go" {
+			continue
+		}
+		sum := sha256.Sum256([]byte(content))
+		want[path] = manifestEntry{SHA256: hex.EncodeToString(sum[:]), Size: int64(len(content))}
+	}
+	if !reflect.DeepEqual(manifest.Files, want) {
+		t.Errorf("manifest = %v, want %v", manifest.Files, want)
+	}
+
+	// The manifest reads back as written
+	data, err := manifest.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+	if err := os.WriteFile(manifestPath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, manifest) {
+		t.Errorf("loaded manifest = %v, want %v", loaded, manifest)
+	}
+}
+
+func TestLoadManifestErrors(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{filepath.Join(dir, "missing.json"), invalid} {
+		if _, err := loadManifest(path); err == nil {
+			t.Errorf("loadManifest(%q) returned no error", path)
+		}
+	}
+}
+
+func TestExtractSinceManifest(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"unchanged.go": "package main\n",
+		"modified.go":  "package main\n\nvar x = 1\n",
+		"deleted.go":   "package main\n\nvar y = 2\n",
+	})
+	previous := &extractManifest{}
+	if _, err := extractFileContent(context.Background(), dir, extractOptions{extensions: []string{".go"}, manifest: previous, maxDepth: -1, logger: quietLogger()}); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, dir, map[string]string{
+		"modified.go": "package main\n\nvar x = 2\n",
+		"new.go":      "package main\n\nvar z = 3\n",
+	})
+	if err := os.Remove(filepath.Join(dir, "deleted.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	current := &extractManifest{}
+	out, err := extractFileContent(context.Background(), dir, extractOptions{
+		extensions:    []string{".go"},
+		sinceManifest: previous,
+		manifest:      current,
+		maxDepth:      -1,
+		logger:        quietLogger(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "\n<file_path>modified.go</file_path>\npackage main\n\nvar x = 2\n\n<file_path_end>modified.go</file_path_end>\n" +
+		"\n<file_path>new.go</file_path>\npackage main\n\nvar z = 3\n\n<file_path_end>new.go</file_path_end>\n" +
+		"\n<file_path_deleted>deleted.go</file_path_deleted>\n"
+	if out != want {
+		t.Errorf("output =\n%s\nwant\n%s", out, want)
+	}
+
+	// The new manifest still lists the unchanged files, so it can be used for the next run
+	var paths []string
+	for path := range current.Files {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	if want := []string{"modified.go", "new.go", "unchanged.go"}; !slices.Equal(paths, want) {
+		t.Errorf("new manifest lists %q, want %q", paths, want)
+	}
+}
+
+func TestExtractSinceManifestJSON(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.go": "package a\n"})
+	previous := &extractManifest{Files: map[string]manifestEntry{"gone.go": {SHA256: "0", Size: 1}}}
+	out, err := extractFileContent(context.Background(), dir, extractOptions{
+		extensions:    []string{".go"},
+		format:        "json",
+		sinceManifest: previous,
+		maxDepth:      -1,
+		logger:        quietLogger(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changeset MdiffJSON
+	if err := json.Unmarshal([]byte(out), &changeset); err != nil {
+		t.Fatal(err)
+	}
+	want := []FileChange{{FilePath: "a.go", Content: "package a\n"}, {FilePath: "gone.go", Delete: true}}
+	if !reflect.DeepEqual(changeset.Changes, want) {
+		t.Errorf("changes = %+v, want %+v", changeset.Changes, want)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import "fmt"
+
+// namedChangeset is the list of changes read from a changeset file.
+type namedChangeset struct {
+	name    string // File the changes were read from, for error messages
+	changes []FileChange
+}
+
+// mergeConflict records two differing changes to the same path.
+type mergeConflict struct {
+	path     string
+	previous string // Changeset holding the overridden change
+	current  string // Changeset holding the overriding change
+}
+
+func (c mergeConflict) Error() string {
+	return fmt.Sprintf("conflicting changes for '%s': '%s' overrides '%s'", c.path, c.current, c.previous)
+}
+
+// changeKey returns the path identifying change when merging changesets: the
+// source path for renames, the file path otherwise.
+func changeKey(change FileChange) string {
+	if change.isRename() {
+		return change.From
+	}
+	return change.FilePath
+}
+
+// mergeChangesets combines changesets in order. A change to a path already
+// changed by an earlier one replaces it in place, so the merged changes keep
+// the order in which paths first appear. Identical duplicates are dropped
+// silently; differing ones are also returned as conflicts.
+func mergeChangesets(changesets []namedChangeset) ([]FileChange, []mergeConflict) {
+	merged := []FileChange{}
+	indexes := map[string]int{}    // Index in merged of each path
+	origins := map[string]string{} // Changeset the merged change of each path comes from
+	var conflicts []mergeConflict
+
+	for _, changeset := range changesets {
+		for _, change := range changeset.changes {
+			key := changeKey(change)
+			i, ok := indexes[key]
+			if !ok {
+				indexes[key] = len(merged)
+				origins[key] = changeset.name
+				merged = append(merged, change)
+				continue
+			}
+			if merged[i] != change {
+				conflicts = append(conflicts, mergeConflict{path: key, previous: origins[key], current: changeset.name})
+				merged[i] = change
+				origins[key] = changeset.name
+			}
+		}
+	}
+	return merged, conflicts
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMergeChangesets(t *testing.T) {
+	tests := []struct {
+		name       string
+		changesets []namedChangeset
+		want       []FileChange
+		conflicts  []mergeConflict
+	}{
+		{
+			name: "disjoint changesets are concatenated",
+			changesets: []namedChangeset{
+				{name: "a.json", changes: []FileChange{{FilePath: "a.txt", Content: "a"}}},
+				{name: "b.json", changes: []FileChange{{FilePath: "b.txt", Content: "b"}}},
+			},
+			want: []FileChange{{FilePath: "a.txt", Content: "a"}, {FilePath: "b.txt", Content: "b"}},
+		},
+		{
+			name: "later changeset overrides in place",
+			changesets: []namedChangeset{
+				{name: "a.json", changes: []FileChange{{FilePath: "x.txt", Content: "old"}, {FilePath: "y.txt", Content: "y"}}},
+				{name: "b.json", changes: []FileChange{{FilePath: "z.txt", Content: "z"}, {FilePath: "x.txt", Content: "new"}}},
+			},
+			want: []FileChange{{FilePath: "x.txt", Content: "new"}, {FilePath: "y.txt", Content: "y"}, {FilePath: "z.txt", Content: "z"}},
+			conflicts: []mergeConflict{
+				{path: "x.txt", previous: "a.json", current: "b.json"},
+			},
+		},
+		{
+			name: "identical duplicates are deduplicated silently",
+			changesets: []namedChangeset{
+				{name: "a.json", changes: []FileChange{{FilePath: "x.txt", Content: "same"}}},
+				{name: "b.json", changes: []FileChange{{FilePath: "x.txt", Content: "same"}}},
+				{name: "c.json", changes: []FileChange{{FilePath: "x.txt", Content: "same"}}},
+			},
+			want: []FileChange{{FilePath: "x.txt", Content: "same"}},
+		},
+		{
+			name: "a deletion conflicts with a write",
+			changesets: []namedChangeset{
+				{name: "a.json", changes: []FileChange{{FilePath: "x.txt", Content: "x"}}},
+				{name: "b.json", changes: []FileChange{{FilePath: "x.txt", Delete: true}}},
+			},
+			want:      []FileChange{{FilePath: "x.txt", Delete: true}},
+			conflicts: []mergeConflict{{path: "x.txt", previous: "a.json", current: "b.json"}},
+		},
+		{
+			name: "renames are keyed by their source",
+			changesets: []namedChangeset{
+				{name: "a.json", changes: []FileChange{{From: "old.txt", To: "a.txt"}}},
+				{name: "b.json", changes: []FileChange{{From: "old.txt", To: "b.txt"}}},
+			},
+			want:      []FileChange{{From: "old.txt", To: "b.txt"}},
+			conflicts: []mergeConflict{{path: "old.txt", previous: "a.json", current: "b.json"}},
+		},
+		{
+			name: "three-way override reports each conflict",
+			changesets: []namedChangeset{
+				{name: "a.json", changes: []FileChange{{FilePath: "x.txt", Content: "a"}}},
+				{name: "b.json", changes: []FileChange{{FilePath: "x.txt", Content: "b"}}},
+				{name: "c.json", changes: []FileChange{{FilePath: "x.txt", Content: "c"}}},
+			},
+			want: []FileChange{{FilePath: "x.txt", Content: "c"}},
+			conflicts: []mergeConflict{
+				{path: "x.txt", previous: "a.json", current: "b.json"},
+				{path: "x.txt", previous: "b.json", current: "c.json"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, conflicts := mergeChangesets(tt.changesets)
+			if !slices.Equal(merged, tt.want) {
+				t.Errorf("merged = %+v, want %+v", merged, tt.want)
+			}
+			if !slices.Equal(conflicts, tt.conflicts) {
+				t.Errorf("conflicts = %+v, want %+v", conflicts, tt.conflicts)
+			}
+		})
+	}
+}
+
+func TestMergeConflictError(t *testing.T) {
+	err := mergeConflict{path: "x.txt", previous: "a.json", current: "b.json"}
+	if want := "conflicting changes for 'x.txt': 'b.json' overrides 'a.json'"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// fileWriter renders extracted files to an io.Writer one at a time,
+// according to the output format of extract, so that the output can be
+// streamed as files are read. Only a --document-template needs all the files
+// before writing anything.
+type fileWriter struct {
+	w        io.Writer
+	format   string
+	template *outputTemplate
+	count    int             // Files written so far
+	rendered strings.Builder // Files rendered by the file template, for the document template
+	bytes    int64           // Bytes written to w so far
+	runes    int             // Characters written to w so far, for the token estimate
+}
+
+// newFileWriter returns a fileWriter writing to w in the format of opts,
+// and writes the beginning of the output, such as the XML header.
+func newFileWriter(w io.Writer, opts extractOptions) (*fileWriter, error) {
+	fw := &fileWriter{w: w, format: opts.format, template: opts.template}
+	switch fw.format {
+	case "", "text", "print0", "template":
+		return fw, nil
+	case "json":
+		return fw, fw.emit("{\n  \"changes\": [")
+	case "xml":
+		return fw, fw.emit(xml.Header + "<files>\n")
+	default:
+		return nil, fmt.Errorf("unknown output format '%s'", opts.format)
+	}
+}
+
+// emit writes s to the underlying writer.
+func (fw *fileWriter) emit(s string) error {
+	n, err := io.WriteString(fw.w, s)
+	fw.bytes += int64(n)
+	fw.runes += utf8.RuneCountInString(s[:n])
+	return err
+}
+
+// write renders file.
+func (fw *fileWriter) write(file FileChange) error {
+	fw.count++
+	switch fw.format {
+	case "", "text":
+		return fw.emit(formatText([]FileChange{file}))
+	case "print0":
+		return fw.emit(formatPrint0([]FileChange{file}))
+	case "json":
+		var out strings.Builder
+		encoder := json.NewEncoder(&out)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("    ", "  ")
+		if err := encoder.Encode(file); err != nil {
+			return fmt.Errorf("failed to encode JSON output: %w", err)
+		}
+		separator := ",\n    "
+		if fw.count == 1 {
+			separator = "\n    "
+		}
+		return fw.emit(separator + strings.TrimSuffix(out.String(), "\n"))
+	case "xml":
+		var out strings.Builder
+		writeXMLFile(&out, file)
+		return fw.emit(out.String())
+	default: // "template"
+		if fw.template.document != nil {
+			return fw.template.renderFile(&fw.rendered, file)
+		}
+		var out strings.Builder
+		if err := fw.template.renderFile(&out, file); err != nil {
+			return err
+		}
+		return fw.emit(out.String())
+	}
+}
+
+// close writes the end of the output, such as the closing tag of the XML
+// format. It does not close the underlying writer.
+func (fw *fileWriter) close() error {
+	switch fw.format {
+	case "json":
+		if fw.count == 0 {
+			return fw.emit("]\n}\n")
+		}
+		return fw.emit("\n  ]\n}\n")
+	case "xml":
+		return fw.emit("</files>\n")
+	case "template":
+		if fw.template.document == nil {
+			return nil
+		}
+		var out strings.Builder
+		if err := fw.template.renderDocument(&out, fw.rendered.String(), fw.count); err != nil {
+			return err
+		}
+		return fw.emit(out.String())
+	}
+	return nil
+}
+
+// wrappedWriter writes a header, then the content written to it, then a
+// footer once closed, see --header and --footer. The header and the footer
+// each end with a newline, and the footer starts on its own line.
+type wrappedWriter struct {
+	w        io.Writer
+	footer   string
+	written  bool // Some content was written
+	endsLine bool // The content written so far ends with a newline
+}
+
+// newWrappedWriter writes header to w, and returns a wrappedWriter writing
+// the content and then footer to w.
+func newWrappedWriter(w io.Writer, header, footer string) (*wrappedWriter, error) {
+	if header != "" {
+		if !strings.HasSuffix(header, "\n") {
+			header += "\n"
+		}
+		if _, err := io.WriteString(w, header); err != nil {
+			return nil, err
+		}
+	}
+	return &wrappedWriter{w: w, footer: footer}, nil
+}
+
+func (ww *wrappedWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		ww.written = true
+		ww.endsLine = p[len(p)-1] == '\n'
+	}
+	return ww.w.Write(p)
+}
+
+// Close writes the footer. It does not close the underlying writer.
+func (ww *wrappedWriter) Close() error {
+	if ww.footer == "" {
+		return nil
+	}
+	footer := ww.footer
+	if ww.written && !ww.endsLine {
+		footer = "\n" + footer
+	}
+	if !strings.HasSuffix(footer, "\n") {
+		footer += "\n"
+	}
+	_, err := io.WriteString(ww.w, footer)
+	return err
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWrappedWriter(t *testing.T) {
+	tests := []struct {
+		name           string
+		header, footer string
+		content        []string // Successive writes
+		want           string
+	}{
+		{name: "no header or footer", content: []string{"body\n"}, want: "body\n"},
+		{name: "header and footer", header: "Review:\n", footer: "Any bugs?\n", content: []string{"body\n"}, want: "Review:\nbody\nAny bugs?\n"},
+		{name: "newlines are added", header: "Review:", footer: "Any bugs?", content: []string{"body\n"}, want: "Review:\nbody\nAny bugs?\n"},
+		{name: "footer starts on its own line", footer: "Any bugs?", content: []string{"bo", "dy"}, want: "body\nAny bugs?\n"},
+		{name: "no content", header: "Review:", footer: "Any bugs?", want: "Review:\nAny bugs?\n"},
+		{name: "empty writes", footer: "end", content: []string{"body", ""}, want: "body\nend\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			ww, err := newWrappedWriter(&out, tt.header, tt.footer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.content {
+				if _, err := ww.Write([]byte(s)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := ww.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadTextArg(t *testing.T) {
+	dir := t.TempDir()
+	notes := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(notes, []byte("from a file\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: ""},
+		{value: "literal text", want: "literal text"},
+		{value: "mail me@example.com", want: "mail me@example.com"},
+		{value: "@" + notes, want: "from a file\n"},
+		{value: "@" + filepath.Join(dir, "missing.txt"), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := readTextArg(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("readTextArg(%q) = %q, %v, want %q (error %v)", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestExtractHeaderFooter(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.go":         "package main\n",
+		"prompt/head.txt": "Review this code:\n",
+	})
+	body, stderr, code := runCopilot(t, dir, "", "extract", ".", ".go")
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"--header", "Review this code:"}, want: "Review this code:\n" + body},
+		{args: []string{"--footer", "Any bugs?"}, want: body + "Any bugs?\n"},
+		{args: []string{"--header", "@prompt/head.txt", "--footer", "Any bugs?"}, want: "Review this code:\n" + body + "Any bugs?\n"},
+	}
+	for _, tt := range tests {
+		args := append(append([]string{"extract"}, tt.args...), ".", ".go")
+		stdout, stderr, code := runCopilot(t, dir, "", args...)
+		if code != 0 {
+			t.Fatalf("%v: exit code = %d, stderr: %s", args, code, stderr)
+		}
+		if stdout != tt.want {
+			t.Errorf("%v: stdout = %q, want %q", args, stdout, tt.want)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"extract", "--header", "@prompt/missing.txt", ".", ".go"},
+		{"extract", "--format", "json", "--header", "x", ".", ".go"},
+	} {
+		if _, stderr, code := runCopilot(t, dir, "", args...); code == 0 {
+			t.Errorf("%v: exit code 0, want an error (stderr %q)", args, stderr)
+		}
+	}
+}
+
+// chunkWriter records each write it receives, along with the number of
+// files read when the first one arrived.
+type chunkWriter struct {
+	chunks       []string
+	readsAtFirst int
+	reads        *atomic.Int32
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	if len(w.chunks) == 0 {
+		w.readsAtFirst = int(w.reads.Load())
+	}
+	w.chunks = append(w.chunks, string(p))
+	return len(p), nil
+}
+
+// TestExtractStreamMatchesBuffered checks that the output extractTo streams
+// is byte for byte what formatFiles renders from the same files at once,
+// and that it starts before every file is read.
+func TestExtractStreamMatchesBuffered(t *testing.T) {
+	const n = 40
+	dir := t.TempDir()
+	writeFixtureTree(t, dir, n)
+	tmpl, err := parseOutputTemplate("== {{.Path}} ==\n{{.Content}}", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reads := new(atomic.Int32)
+	previous := readExtractedFile
+	readExtractedFile = func(filePath string) ([]byte, error) {
+		reads.Add(1)
+		return previous(filePath)
+	}
+	t.Cleanup(func() { readExtractedFile = previous })
+
+	tests := []struct {
+		name string
+		opts extractOptions
+	}{
+		{name: "text"},
+		{name: "json", opts: extractOptions{format: "json"}},
+		{name: "xml", opts: extractOptions{format: "xml"}},
+		{name: "print0", opts: extractOptions{format: "print0"}},
+		{name: "template", opts: extractOptions{format: "template", template: tmpl}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.extensions, opts.jobs, opts.maxDepth, opts.logger = []string{".go"}, 1, -1, quietLogger()
+			reads.Store(0)
+			w := &chunkWriter{reads: reads}
+			if err := extractTo(context.Background(), w, dir, opts); err != nil {
+				t.Fatal(err)
+			}
+			if got := int(reads.Load()); got != n {
+				t.Fatalf("read %d file(s), want %d", got, n)
+			}
+			if w.readsAtFirst >= n {
+				t.Errorf("first write after reading %d file(s), want it before all %d are read", w.readsAtFirst, n)
+			}
+
+			var files []FileChange
+			for _, path := range listFiles(t, dir) {
+				content := readFile(t, filepath.Join(dir, path))
+				files = append(files, FileChange{FilePath: path, Content: content, size: int64(len(content))})
+			}
+			want, err := formatFiles(files, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(w.chunks, ""); got != want {
+				t.Errorf("streamed output differs from the buffered one:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// applyChangesConcurrently applies changes like applyChanges, running up to
+// opts.jobs of them at a time. Changes touching the same path, or a path and
+// one of its parent directories, are applied in order by the same worker.
+// After the first error no new change is started, unless
+// opts.continueOnError is set, nor once ctx is canceled. It returns the
+// errors of the failed changes, in the order of changes. journal, when not
+// nil, must be rolled back by the caller on error.
+func applyChangesConcurrently(ctx context.Context, changes []FileChange, opts applyOptions, journal *applyJournal) (int, []error) {
+	type outcome struct {
+		existed, done bool
+		result        changeOutcome
+		err           error
+	}
+	outcomes := make([]outcome, len(changes))
+	groups := groupDependentChanges(changes, opts)
+
+	var failed atomic.Bool
+	var appliedCount atomic.Int64
+	indexes := make(chan []int)
+	var wg sync.WaitGroup
+	for range min(opts.jobs, len(groups)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range indexes {
+				for _, i := range group {
+					if failed.Load() || ctx.Err() != nil {
+						break
+					}
+					change := changes[i]
+					opts.logger.debugf("Applying change %d/%d: %s", i+1, len(changes), changeTarget(change))
+					if opts.report != nil {
+						outcomes[i].existed = targetExists(change)
+					}
+					result, err := applyChange(change, opts, journal)
+					outcomes[i].result, outcomes[i].err, outcomes[i].done = result, err, true
+					if err != nil && opts.continueOnError {
+						continue
+					}
+					if err != nil {
+						failed.Store(true)
+						break
+					}
+					if result == changeApplied {
+						appliedCount.Add(1)
+					}
+				}
+			}
+		}()
+	}
+	for _, group := range groups {
+		if failed.Load() || ctx.Err() != nil {
+			break
+		}
+		indexes <- group
+	}
+	close(indexes)
+	wg.Wait()
+
+	var errs []error
+	for i, outcome := range outcomes {
+		if !outcome.done {
+			continue
+		}
+		if opts.report != nil {
+			opts.report.add(changes[i], outcome.existed, outcome.result, outcome.err)
+		}
+		if outcome.err != nil {
+			errs = append(errs, outcome.err)
+		}
+	}
+	return int(appliedCount.Load()), errs
+}
+
+// groupDependentChanges splits the indexes of changes into groups that can
+// be applied independently of each other. Two changes are dependent when
+// they touch the same path, including backups made with opts.backup, or when
+// a path touched by one is a parent directory of a path touched by the
+// other. Groups, and the indexes in each group, are in the order of changes.
+func groupDependentChanges(changes []FileChange, opts applyOptions) [][]int {
+	// Union-find over the change indexes
+	parents := make([]int, len(changes))
+	for i := range parents {
+		parents[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+	union := func(i, j int) {
+		i, j = find(i), find(j)
+		// The smallest index stays the root, so that groups are ordered
+		if i < j {
+			parents[j] = i
+		} else if j < i {
+			parents[i] = j
+		}
+	}
+
+	owners := map[string]int{} // First change touching each path
+	touched := make([][]string, len(changes))
+	for i, change := range changes {
+		paths := []string{change.FilePath}
+		if change.isRename() {
+			paths = []string{change.From, change.To}
+		}
+		for _, p := range paths {
+			if p == "" {
+				continue
+			}
+			p = filepath.Clean(p)
+			touched[i] = append(touched[i], p)
+			if opts.backup {
+				touched[i] = append(touched[i], p+opts.backupSuffix)
+			}
+		}
+		for _, p := range touched[i] {
+			if owner, ok := owners[p]; ok {
+				union(owner, i)
+			} else {
+				owners[p] = i
+			}
+		}
+	}
+	for i := range changes {
+		for _, p := range touched[i] {
+			for dir := filepath.Dir(p); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+				if owner, ok := owners[dir]; ok {
+					union(owner, i)
+				}
+			}
+		}
+	}
+
+	var groups [][]int
+	groupIndexes := map[int]int{} // Index in groups of the group of each root
+	for i := range changes {
+		root := find(i)
+		g, ok := groupIndexes[root]
+		if !ok {
+			g = len(groups)
+			groupIndexes[root] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
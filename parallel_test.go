@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGroupDependentChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes []FileChange
+		backup  bool
+		want    [][]int
+	}{
+		{
+			name:    "independent",
+			changes: []FileChange{{FilePath: "a"}, {FilePath: "b"}, {FilePath: "c/d"}},
+			want:    [][]int{{0}, {1}, {2}},
+		},
+		{
+			name:    "same path",
+			changes: []FileChange{{FilePath: "a"}, {FilePath: "b"}, {FilePath: "./a"}},
+			want:    [][]int{{0, 2}, {1}},
+		},
+		{
+			name:    "rename chains",
+			changes: []FileChange{{FilePath: "a"}, {From: "a", To: "b"}, {FilePath: "c"}, {From: "b", To: "d"}},
+			want:    [][]int{{0, 1, 3}, {2}},
+		},
+		{
+			name:    "parent directory",
+			changes: []FileChange{{FilePath: "dir/file"}, {FilePath: "other"}, {FilePath: "dir", Delete: true}},
+			want:    [][]int{{0, 2}, {1}},
+		},
+		{
+			name:    "backups",
+			changes: []FileChange{{FilePath: "a"}, {FilePath: "a.bak"}, {FilePath: "b"}},
+			backup:  true,
+			want:    [][]int{{0, 1}, {2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := groupDependentChanges(tt.changes, applyOptions{backup: tt.backup, backupSuffix: ".bak"})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groupDependentChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// discardApplyOut silences apply messages, which concurrent changes print
+// at the same time.
+func discardApplyOut(tb testing.TB) {
+	previous := applyOut
+	applyOut = io.Discard
+	tb.Cleanup(func() { applyOut = previous })
+}
+
+// concurrentChangeset returns n independent changes writing files in
+// nested directories, followed by changes depending on each other.
+func concurrentChangeset(n int) []FileChange {
+	var changes []FileChange
+	for i := range n {
+		changes = append(changes, FileChange{
+			FilePath: filepath.Join(fmt.Sprintf("dir%d", i%7), fmt.Sprintf("sub%d", i%3), fmt.Sprintf("file%d.txt", i)),
+			Content:  strings.Repeat(fmt.Sprintf("line %d\n", i), i%50+1),
+		})
+	}
+	return append(changes,
+		FileChange{FilePath: "chain.txt", Content: "first\n"},
+		FileChange{From: "chain.txt", To: "chained/moved.txt"},
+		FileChange{FilePath: "chain.txt", Content: "second\n"},
+		FileChange{FilePath: "dir0/sub0/file0.txt", Content: "overwritten\n"},
+		FileChange{FilePath: "dir1/sub1/file1.txt", Delete: true},
+	)
+}
+
+func TestApplyChangesConcurrently(t *testing.T) {
+	changes := concurrentChangeset(300)
+	applyIn := func(jobs int) []string {
+		dir := t.TempDir()
+		t.Chdir(dir)
+		discardApplyOut(t)
+		if _, err := applyChanges(context.Background(), changes, applyOptions{jobs: jobs, logger: quietLogger()}); err != nil {
+			t.Fatalf("jobs=%d: %v", jobs, err)
+		}
+		return fileContents(t, dir)
+	}
+
+	want := applyIn(1)
+	if len(want) != 300+1 {
+		t.Fatalf("serial apply wrote %d files, want %d", len(want), 301)
+	}
+	for _, jobs := range []int{2, 8, 64} {
+		if got := applyIn(jobs); !slices.Equal(got, want) {
+			t.Errorf("jobs=%d: files differ from a serial apply", jobs)
+		}
+	}
+}
+
+func TestApplyChangesConcurrentlyAtomic(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"existing.txt": "original\n", "blocked/x": ""})
+	t.Chdir(dir)
+	discardApplyOut(t)
+
+	changes := concurrentChangeset(100)
+	changes = append(changes, FileChange{FilePath: "existing.txt", Content: "changed\n"}, FileChange{FilePath: "blocked", Content: "fails"})
+	if _, err := applyChanges(context.Background(), changes, applyOptions{jobs: 8, atomic: true, logger: quietLogger()}); err == nil {
+		t.Fatal("applyChanges() returned no error")
+	}
+	want := []string{"blocked/x=", "existing.txt=original\n"}
+	if got := fileContents(t, dir); !slices.Equal(got, want) {
+		t.Errorf("files after rollback = %q, want %q", got, want)
+	}
+	if got := listFiles(t, dir); len(got) != 2 {
+		t.Errorf("entries after rollback = %q, want the created directories removed", got)
+	}
+}
+
+func BenchmarkApplyChanges(b *testing.B) {
+	changes := concurrentChangeset(500)
+	b.Chdir(b.TempDir())
+	discardApplyOut(b)
+	for _, jobs := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			for b.Loop() {
+				if _, err := applyChanges(context.Background(), changes, applyOptions{jobs: jobs, logger: quietLogger()}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
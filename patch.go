@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/moul-dev/copilot/pkg/apply"
+)
+
+// patchHunk is a hunk of a unified diff.
+type patchHunk struct {
+	oldStart int      // 1-based first line of the hunk in the old file, 0 when the old range is empty
+	newStart int      // 1-based first line of the hunk in the new file, 0 when the new range is empty
+	oldLines []string // Context and removed lines, with their trailing newline if any
+	newLines []string // Context and added lines, with their trailing newline if any
+}
+
+// filePatch is the part of a unified diff changing a single file.
+type filePatch struct {
+	oldName string // Path from the --- header, /dev/null for created files
+	newName string // Path from the +++ header, /dev/null for deleted files
+	hunks   []patchHunk
+}
+
+// creates reports whether the patch creates its file: its old name is
+// /dev/null, or, as with diff -N, its only hunk has an empty old range at
+// line 0.
+func (fp filePatch) creates() bool {
+	return fp.oldName == "/dev/null" ||
+		len(fp.hunks) == 1 && fp.hunks[0].oldStart == 0 && len(fp.hunks[0].oldLines) == 0
+}
+
+// deletes reports whether the patch deletes its file: its new name is
+// /dev/null, or, as with diff -N, its only hunk has an empty new range at
+// line 0.
+func (fp filePatch) deletes() bool {
+	return fp.newName == "/dev/null" ||
+		len(fp.hunks) == 1 && fp.hunks[0].newStart == 0 && len(fp.hunks[0].newLines) == 0
+}
+
+var hunkHeaderRe = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// parsePatch parses a unified diff, possibly changing several files. Lines
+// outside file headers and hunks, such as "diff --git" or "index" lines, are
+// ignored.
+func parsePatch(patch string) ([]filePatch, error) {
+	lines := splitLines(patch)
+	var patches []filePatch
+	for i := 0; i < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], "--- ") || i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "+++ ") {
+			continue
+		}
+		fp := filePatch{
+			oldName: patchHeaderPath(lines[i]),
+			newName: patchHeaderPath(lines[i+1]),
+		}
+		i += 2
+
+		for i < len(lines) && strings.HasPrefix(lines[i], "@@ ") {
+			m := hunkHeaderRe.FindStringSubmatch(lines[i])
+			if m == nil {
+				return nil, fmt.Errorf("malformed hunk header on line %d: %s", i+1, strings.TrimRight(lines[i], "\n"))
+			}
+			oldStart, _ := strconv.Atoi(m[1])
+			newStart, _ := strconv.Atoi(m[3])
+			oldCount, newCount := 1, 1
+			if m[2] != "" {
+				oldCount, _ = strconv.Atoi(m[2])
+			}
+			if m[4] != "" {
+				newCount, _ = strconv.Atoi(m[4])
+			}
+			headerLine := i + 1
+			i++
+
+			hunk := patchHunk{oldStart: oldStart, newStart: newStart}
+			// Last old and new lines read, to strip their newline on "\ No newline at end of file"
+			var lastOld, lastNew *string
+			for i < len(lines) && (len(hunk.oldLines) < oldCount || len(hunk.newLines) < newCount || strings.HasPrefix(lines[i], `\`)) {
+				line := lines[i]
+				if line == "\n" {
+					// Context line whose leading space was stripped by an editor
+					line = " \n"
+				}
+				switch line[0] {
+				case ' ':
+					hunk.oldLines = append(hunk.oldLines, line[1:])
+					hunk.newLines = append(hunk.newLines, line[1:])
+					lastOld, lastNew = &hunk.oldLines[len(hunk.oldLines)-1], &hunk.newLines[len(hunk.newLines)-1]
+				case '-':
+					hunk.oldLines = append(hunk.oldLines, line[1:])
+					lastOld, lastNew = &hunk.oldLines[len(hunk.oldLines)-1], nil
+				case '+':
+					hunk.newLines = append(hunk.newLines, line[1:])
+					lastOld, lastNew = nil, &hunk.newLines[len(hunk.newLines)-1]
+				case '\\':
+					for _, last := range []*string{lastOld, lastNew} {
+						if last != nil {
+							*last = strings.TrimSuffix(*last, "\n")
+						}
+					}
+				default:
+					return nil, fmt.Errorf("unexpected line %d in hunk starting on line %d: %s", i+1, headerLine, strings.TrimRight(line, "\n"))
+				}
+				i++
+			}
+			if len(hunk.oldLines) != oldCount || len(hunk.newLines) != newCount {
+				return nil, fmt.Errorf("truncated hunk starting on line %d", headerLine)
+			}
+			fp.hunks = append(fp.hunks, hunk)
+		}
+		i-- // Let the outer loop look at the line following the last hunk
+
+		patches = append(patches, fp)
+	}
+	return patches, nil
+}
+
+// patchHeaderPath returns the path of a "---" or "+++" header line, without
+// the timestamp some tools append after a tab.
+func patchHeaderPath(line string) string {
+	name := strings.TrimRight(line[4:], "\r\n")
+	if tab := strings.IndexByte(name, '\t'); tab >= 0 {
+		name = name[:tab]
+	}
+	return name
+}
+
+// stripPathComponents removes the first n slash-separated components of
+// name, as patch -p does. /dev/null is returned as-is.
+func stripPathComponents(name string, n int) string {
+	if name == "/dev/null" {
+		return name
+	}
+	for ; n > 0; n-- {
+		slash := strings.IndexByte(name, '/')
+		if slash < 0 {
+			break
+		}
+		name = name[slash+1:]
+	}
+	return name
+}
+
+// hunkFailure records a hunk that could not be applied.
+type hunkFailure struct {
+	index    int // 1-based index of the hunk in its file patch
+	oldStart int
+}
+
+func (f hunkFailure) Error() string {
+	return fmt.Sprintf("hunk #%d FAILED at %d: context does not match", f.index, f.oldStart)
+}
+
+// applyHunks applies hunks to content in order. A hunk is applied where its
+// old lines match exactly, looking first at the position given by its header
+// (shifted by the offset of the previous hunks), then further and further
+// away from it. Hunks that match nowhere are returned as failures, and the
+// other ones are still applied. offsets holds, for each hunk, how many lines
+// away from its expected position it was applied.
+func applyHunks(content string, hunks []patchHunk) (result string, offsets []int, failures []hunkFailure) {
+	lines := splitLines(content)
+	var out []string
+	cursor := 0 // Lines before cursor were already copied to out
+	offset := 0
+	for i, hunk := range hunks {
+		expected := hunk.oldStart - 1 + offset
+		if len(hunk.oldLines) == 0 {
+			// An empty old range is numbered after the line preceding it
+			expected = hunk.oldStart + offset
+		}
+		pos := findHunk(lines, hunk.oldLines, expected, cursor)
+		if pos < 0 {
+			failures = append(failures, hunkFailure{index: i + 1, oldStart: hunk.oldStart})
+			offsets = append(offsets, 0)
+			continue
+		}
+		// Hunk headers number old lines in the original file, so only the
+		// shift of this hunk carries over, not the lines it added or removed
+		offsets = append(offsets, pos-expected)
+		offset += pos - expected
+
+		out = append(out, lines[cursor:pos]...)
+		out = append(out, hunk.newLines...)
+		cursor = pos + len(hunk.oldLines)
+	}
+	out = append(out, lines[cursor:]...)
+	return strings.Join(out, ""), offsets, failures
+}
+
+// findHunk returns the position of the first occurrence of oldLines in lines
+// closest to expected, not before from, or -1 if there is none.
+func findHunk(lines, oldLines []string, expected, from int) int {
+	maxPos := len(lines) - len(oldLines)
+	matches := func(pos int) bool {
+		if pos < from || pos > maxPos {
+			return false
+		}
+		for j, line := range oldLines {
+			if lines[pos+j] != line {
+				return false
+			}
+		}
+		return true
+	}
+	expected = max(min(expected, maxPos), from)
+	for delta := 0; expected-delta >= from || expected+delta <= maxPos; delta++ {
+		if matches(expected - delta) {
+			return expected - delta
+		}
+		if delta > 0 && matches(expected+delta) {
+			return expected + delta
+		}
+	}
+	return -1
+}
+
+// applyPatches applies patches, with stripCount leading path components
+// removed from their file names. A file with a hunk that does not apply is
+// left untouched, and its failed hunks are reported through log. It returns the
+// number of files patched and the number of files that failed.
+//
+// A patch whose old and new names differ renames its file when only the old
+// one exists, and fails when both do. Patches naming an absolute path or one
+// leading outside the current directory fail without touching anything.
+func applyPatches(patches []filePatch, stripCount int, dryRun bool, log *logger) (patched, failed int) {
+	for _, fp := range patches {
+		oldName := stripPathComponents(fp.oldName, stripCount)
+		newName := stripPathComponents(fp.newName, stripCount)
+		if name := unsafePatchPath(oldName, newName); name != "" {
+			log.errorf("Error: refusing to patch '%s': the path is absolute or leads outside the current directory.", name)
+			failed++
+			continue
+		}
+		target := newName
+		if newName == "/dev/null" {
+			target = oldName
+		}
+
+		// source is the file the hunks apply to. It is only different from
+		// target when the patch renames a file: its old and new names
+		// differ, and only the old one exists.
+		source := target
+		if oldName != newName && oldName != "/dev/null" && newName != "/dev/null" && !fp.creates() {
+			_, oldErr := os.Lstat(oldName)
+			_, newErr := os.Lstat(newName)
+			switch {
+			case oldErr == nil && newErr == nil:
+				log.errorf("Error: the patch changes '%s' into '%s', and both exist; refusing to guess which one to patch.", oldName, newName)
+				failed++
+				continue
+			case oldErr == nil:
+				source = oldName
+			}
+		}
+
+		var current []byte
+		if !fp.creates() {
+			var err error
+			current, err = os.ReadFile(source)
+			if err != nil {
+				log.errorf("Error reading file '%s': %v", source, err)
+				failed++
+				continue
+			}
+		} else if _, err := os.Lstat(target); err == nil {
+			log.errorf("Error: cannot create '%s': file already exists.", target)
+			failed++
+			continue
+		}
+
+		result, offsets, failures := applyHunks(string(current), fp.hunks)
+		if len(failures) > 0 {
+			for _, failure := range failures {
+				log.errorf("Error: %s: %v", source, failure)
+			}
+			log.errorf("Error: %d of %d hunk(s) failed for '%s'; file left untouched.", len(failures), len(fp.hunks), source)
+			failed++
+			continue
+		}
+		for i, offset := range offsets {
+			if offset != 0 {
+				fmt.Fprintf(os.Stdout, "%s: hunk #%d applied with an offset of %d line(s)\n", target, i+1, offset)
+			}
+		}
+
+		message := "Successfully patched %s\n"
+		if dryRun {
+			message = "Would patch %s\n"
+		}
+		name := target
+		switch {
+		case fp.deletes():
+			if result != "" {
+				log.errorf("Error: cannot delete '%s': content remains after applying the patch.", source)
+				failed++
+				continue
+			}
+			if !dryRun {
+				if err := apply.DeleteFile(source); err != nil {
+					log.errorf("Error deleting file '%s': %v", source, err)
+					failed++
+					continue
+				}
+			}
+			name = source
+			message = "Successfully deleted %s\n"
+			if dryRun {
+				message = "Would delete %s\n"
+			}
+		case !dryRun:
+			if err := apply.WriteInPlace(target, []byte(result)); err != nil {
+				log.errorf("Error writing file '%s': %v", target, err)
+				failed++
+				continue
+			}
+			if source != target {
+				if err := apply.DeleteFile(source); err != nil {
+					log.errorf("Error deleting file '%s' after renaming it to '%s': %v", source, target, err)
+					failed++
+					continue
+				}
+			}
+		}
+		if name != source {
+			name += " (renamed from " + source + ")"
+		}
+		fmt.Fprintf(os.Stdout, message, name)
+		patched++
+	}
+	return patched, failed
+}
+
+// unsafePatchPath returns the first of names that is absolute or leads
+// outside the current directory, such as ../x, or "" if there is none.
+// /dev/null, which marks created and deleted files, is allowed.
+func unsafePatchPath(names ...string) string {
+	for _, name := range names {
+		if name != "/dev/null" && !filepath.IsLocal(filepath.FromSlash(name)) {
+			return name
+		}
+	}
+	return ""
+}
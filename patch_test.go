@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePatch(t *testing.T) {
+	patch := "diff --git a/a.txt b/a.txt\n" +
+		"index 1234567..89abcde 100644\n" +
+		"--- a/a.txt\t2024-01-01 00:00:00\n" +
+		"+++ b/a.txt\t2024-01-02 00:00:00\n" +
+		"@@ -1,2 +1,2 @@\n" +
+		" one\n" +
+		"-two\n" +
+		"+TWO\n" +
+		"@@ -5 +5,2 @@\n" +
+		" five\n" +
+		"+six\n" +
+		"\\ No newline at end of file\n" +
+		"--- /dev/null\n" +
+		"+++ b/new.txt\n" +
+		"@@ -0,0 +1 @@\n" +
+		"+created\n"
+	got, err := parsePatch(patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []filePatch{
+		{oldName: "a/a.txt", newName: "b/a.txt", hunks: []patchHunk{
+			{oldStart: 1, newStart: 1, oldLines: []string{"one\n", "two\n"}, newLines: []string{"one\n", "TWO\n"}},
+			{oldStart: 5, newStart: 5, oldLines: []string{"five\n"}, newLines: []string{"five\n", "six"}},
+		}},
+		{oldName: "/dev/null", newName: "b/new.txt", hunks: []patchHunk{
+			{oldStart: 0, newStart: 1, newLines: []string{"created\n"}},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePatch() =\n%+v\nwant\n%+v", got, want)
+	}
+	if !got[1].creates() || got[0].creates() {
+		t.Errorf("creates() = %v, %v, want false, true", got[0].creates(), got[1].creates())
+	}
+}
+
+func TestParsePatchErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		err   string
+	}{
+		{"malformed header", "--- a\n+++ b\n@@ -1 +1 @ broken\n", "malformed hunk header on line 3"},
+		{"unexpected line", "--- a\n+++ b\n@@ -1,2 +1,2 @@\n x\n?y\n", "unexpected line 5 in hunk starting on line 3"},
+		{"truncated", "--- a\n+++ b\n@@ -1,3 +1,3 @@\n x\n", "truncated hunk starting on line 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePatch(tt.patch)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parsePatch() error = %v, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestApplyHunks(t *testing.T) {
+	const content = "a\nb\nc\nd\ne\nf\ng\nh\n"
+	tests := []struct {
+		name     string
+		content  string
+		hunks    []patchHunk
+		want     string
+		offsets  []int
+		failures []hunkFailure
+	}{
+		{
+			name:    "clean",
+			content: content,
+			hunks: []patchHunk{
+				{oldStart: 2, oldLines: []string{"b\n", "c\n"}, newLines: []string{"b\n", "C\n"}},
+				{oldStart: 7, oldLines: []string{"g\n"}, newLines: []string{"g\n", "g2\n"}},
+			},
+			want:    "a\nb\nC\nd\ne\nf\ng\ng2\nh\n",
+			offsets: []int{0, 0},
+		},
+		{
+			name:    "clean after a hunk changing the line count",
+			content: content,
+			hunks: []patchHunk{
+				{oldStart: 1, oldLines: []string{"a\n", "b\n", "c\n"}, newLines: []string{"a\n", "c\n"}},
+				{oldStart: 7, oldLines: []string{"g\n", "h\n"}, newLines: []string{"g\n", "H\n"}},
+			},
+			want:    "a\nc\nd\ne\nf\ng\nH\n",
+			offsets: []int{0, 0},
+		},
+		{
+			name:    "offset",
+			content: "x\ny\n" + content,
+			hunks: []patchHunk{
+				{oldStart: 2, oldLines: []string{"b\n", "c\n"}, newLines: []string{"B\n"}},
+				// Found 2 lines further, like the previous hunk
+				{oldStart: 7, oldLines: []string{"g\n"}, newLines: []string{"G\n"}},
+			},
+			want:    "x\ny\na\nB\nd\ne\nf\nG\nh\n",
+			offsets: []int{2, 0},
+		},
+		{
+			name:    "offset backwards",
+			content: "c\nd\ne\nf\ng\nh\n",
+			hunks:   []patchHunk{{oldStart: 5, oldLines: []string{"f\n"}, newLines: []string{"F\n"}}},
+			want:    "c\nd\ne\nF\ng\nh\n",
+			offsets: []int{-1},
+		},
+		{
+			name:    "insertion into an empty range",
+			content: "a\nb\n",
+			hunks:   []patchHunk{{oldStart: 1, newLines: []string{"between\n"}}},
+			want:    "a\nbetween\nb\n",
+			offsets: []int{0},
+		},
+		{
+			name:    "creation",
+			hunks:   []patchHunk{{newStart: 1, newLines: []string{"new\n"}}},
+			want:    "new\n",
+			offsets: []int{0},
+		},
+		{
+			name:    "rejected hunk",
+			content: content,
+			hunks: []patchHunk{
+				{oldStart: 1, oldLines: []string{"a\n"}, newLines: []string{"A\n"}},
+				{oldStart: 4, oldLines: []string{"nope\n"}, newLines: []string{"d\n"}},
+				{oldStart: 8, oldLines: []string{"h\n"}, newLines: []string{"H\n"}},
+			},
+			want:     "A\nb\nc\nd\ne\nf\ng\nH\n",
+			offsets:  []int{0, 0, 0},
+			failures: []hunkFailure{{index: 2, oldStart: 4}},
+		},
+		{
+			name:    "hunks do not overlap",
+			content: "a\nb\n",
+			hunks: []patchHunk{
+				{oldStart: 1, oldLines: []string{"a\n", "b\n"}, newLines: []string{"ab\n"}},
+				{oldStart: 2, oldLines: []string{"b\n"}, newLines: []string{"B\n"}},
+			},
+			want:     "ab\n",
+			offsets:  []int{0, 0},
+			failures: []hunkFailure{{index: 2, oldStart: 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, offsets, failures := applyHunks(tt.content, tt.hunks)
+			if got != tt.want {
+				t.Errorf("applyHunks() = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(offsets, tt.offsets) {
+				t.Errorf("offsets = %v, want %v", offsets, tt.offsets)
+			}
+			if !reflect.DeepEqual(failures, tt.failures) {
+				t.Errorf("failures = %v, want %v", failures, tt.failures)
+			}
+		})
+	}
+}
+
+func TestStripPathComponents(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		want string
+	}{
+		{"a/b/c.txt", 0, "a/b/c.txt"},
+		{"a/b/c.txt", 1, "b/c.txt"},
+		{"a/b/c.txt", 2, "c.txt"},
+		{"a/b/c.txt", 5, "c.txt"},
+		{"/dev/null", 1, "/dev/null"},
+	}
+	for _, tt := range tests {
+		if got := stripPathComponents(tt.name, tt.n); got != tt.want {
+			t.Errorf("stripPathComponents(%q, %d) = %q, want %q", tt.name, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestApplyPatches(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"clean.txt":    "one\ntwo\nthree\n",
+		"rejected.txt": "one\ntwo\nthree\n",
+		"gone.txt":     "bye\n",
+	})
+	t.Chdir(dir)
+
+	patches, err := parsePatch("--- a/clean.txt\n+++ b/clean.txt\n@@ -2 +2 @@\n-two\n+TWO\n" +
+		"--- a/rejected.txt\n+++ b/rejected.txt\n@@ -1 +1 @@\n-one\n+ONE\n@@ -3 +3 @@\n-four\n+FOUR\n" +
+		"--- a/gone.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-bye\n" +
+		"--- /dev/null\n+++ b/sub/new.txt\n@@ -0,0 +1 @@\n+hello\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	patched, failed := applyPatches(patches, 1, false, &logger{out: &logs, level: levelWarn})
+	if patched != 3 || failed != 1 {
+		t.Errorf("applyPatches() = %d patched, %d failed, want 3, 1", patched, failed)
+	}
+	want := []string{
+		"clean.txt=one\nTWO\nthree\n",
+		"rejected.txt=one\ntwo\nthree\n",
+		"sub/new.txt=hello\n",
+	}
+	if got := fileContents(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+	for _, msg := range []string{
+		"rejected.txt: hunk #2 FAILED at 3",
+		"1 of 2 hunk(s) failed for 'rejected.txt'; file left untouched.",
+	} {
+		if !strings.Contains(logs.String(), msg) {
+			t.Errorf("logs = %q, want them to contain %q", logs.String(), msg)
+		}
+	}
+	if strings.Contains(logs.String(), filepath.FromSlash("clean.txt")) {
+		t.Errorf("logs = %q, want no error for clean.txt", logs.String())
+	}
+}
+
+func TestApplyPatchesDryRun(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a\n"})
+	t.Chdir(dir)
+
+	patches, err := parsePatch("--- a.txt\n+++ a.txt\n@@ -1 +1 @@\n-a\n+b\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched, failed := applyPatches(patches, 0, true, quietLogger()); patched != 1 || failed != 0 {
+		t.Errorf("applyPatches() = %d patched, %d failed, want 1, 0", patched, failed)
+	}
+	if got := readFile(t, filepath.Join(dir, "a.txt")); got != "a\n" {
+		t.Errorf("a.txt = %q after a dry run, want it untouched", got)
+	}
+}
+
+func TestApplyPatchesRefusesToOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "existing\n"})
+	t.Chdir(dir)
+
+	patches, err := parsePatch("--- /dev/null\n+++ a.txt\n@@ -0,0 +1 @@\n+new\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	if _, failed := applyPatches(patches, 0, false, &logger{out: &logs, level: levelWarn}); failed != 1 {
+		t.Errorf("applyPatches() failed = %d, want 1", failed)
+	}
+	if !strings.Contains(logs.String(), "cannot create 'a.txt': file already exists") {
+		t.Errorf("logs = %q", logs.String())
+	}
+	if got := readFile(t, filepath.Join(dir, "a.txt")); got != "existing\n" {
+		t.Errorf("a.txt = %q, want it untouched", got)
+	}
+}
+
+func TestApplyPatchesRefusesUnsafePaths(t *testing.T) {
+	dir := t.TempDir()
+	work := filepath.Join(dir, "work")
+	writeFiles(t, dir, map[string]string{"outside.txt": "a\n", "work/a.txt": "a\n"})
+	t.Chdir(work)
+
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{"parent directory", "--- ../outside.txt\n+++ ../outside.txt\n@@ -1 +1 @@\n-a\n+b\n"},
+		{"created outside", "--- /dev/null\n+++ sub/../../created.txt\n@@ -0,0 +1 @@\n+b\n"},
+		{"absolute", "--- " + filepath.ToSlash(filepath.Join(dir, "outside.txt")) + "\n+++ a.txt\n@@ -1 +1 @@\n-a\n+b\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patches, err := parsePatch(tt.patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var logs bytes.Buffer
+			if patched, failed := applyPatches(patches, 0, false, &logger{out: &logs, level: levelWarn}); patched != 0 || failed != 1 {
+				t.Errorf("applyPatches() = %d patched, %d failed, want 0, 1", patched, failed)
+			}
+			if !strings.Contains(logs.String(), "the path is absolute or leads outside the current directory") {
+				t.Errorf("logs = %q", logs.String())
+			}
+		})
+	}
+	want := []string{"outside.txt=a\n", "work/a.txt=a\n"}
+	if got := fileContents(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+}
+
+func TestApplyPatchesRename(t *testing.T) {
+	const patch = "--- a/old.txt\n+++ b/new.txt\n@@ -1,2 +1,2 @@\n one\n-two\n+TWO\n"
+	tests := []struct {
+		name   string
+		files  map[string]string
+		failed int
+		want   []string
+	}{
+		{
+			name:  "only the old file exists",
+			files: map[string]string{"old.txt": "one\ntwo\n"},
+			want:  []string{"new.txt=one\nTWO\n"},
+		},
+		{
+			name:  "only the new file exists",
+			files: map[string]string{"new.txt": "one\ntwo\n"},
+			want:  []string{"new.txt=one\nTWO\n"},
+		},
+		{
+			name:   "both exist",
+			files:  map[string]string{"old.txt": "one\ntwo\n", "new.txt": "one\ntwo\n"},
+			failed: 1,
+			want:   []string{"new.txt=one\ntwo\n", "old.txt=one\ntwo\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			t.Chdir(dir)
+
+			patches, err := parsePatch(patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var logs bytes.Buffer
+			if _, failed := applyPatches(patches, 1, false, &logger{out: &logs, level: levelWarn}); failed != tt.failed {
+				t.Errorf("applyPatches() failed = %d, want %d; logs: %s", failed, tt.failed, logs.String())
+			}
+			if got := fileContents(t, dir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// expandPath expands a leading "~" to the home directory of the current
+// user, and $VAR and ${VAR} to the value of the environment variable VAR, as
+// shells do for unquoted arguments, see --expand-paths. Undefined variables
+// expand to an empty string. "~user" forms are left as is.
+func expandPath(p string) (string, error) {
+	home := ""
+	if p == "~" || strings.HasPrefix(p, "~/") || strings.HasPrefix(p, "~"+string(filepath.Separator)) {
+		var err error
+		if home, err = os.UserHomeDir(); err != nil {
+			return "", fmt.Errorf("expanding '%s': %w", p, err)
+		}
+		p = p[1:]
+	}
+	return home + os.ExpandEnv(p), nil
+}
+
+// expandPaths replaces each of paths with its expandPath expansion, leaving
+// empty ones and "-", which stands for standard input or output, as is.
+func expandPaths(paths ...*string) error {
+	for _, p := range paths {
+		if *p == "" || *p == "-" {
+			continue
+		}
+		expanded, err := expandPath(*p)
+		if err != nil {
+			return err
+		}
+		*p = expanded
+	}
+	return nil
+}
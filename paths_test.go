@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// setHome makes home the home directory of the current user for the rest
+// of the test. copilot is built beforehand, since the default Go build cache
+// lives in the home directory.
+func setHome(t *testing.T, home string) {
+	t.Helper()
+	if _, err := buildCopilot(); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+}
+
+func TestExpandPath(t *testing.T) {
+	home := filepath.FromSlash("/home/tester")
+	setHome(t, home)
+	t.Setenv("PROJECT", "copilot")
+	t.Setenv("WORK", filepath.FromSlash("/srv/work"))
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "~", want: home},
+		{path: "~/src", want: home + "/src"},
+		{path: "$WORK/src", want: filepath.FromSlash("/srv/work") + "/src"},
+		{path: "${WORK}/${PROJECT}.json", want: filepath.FromSlash("/srv/work") + "/copilot.json"},
+		{path: "~/src/$PROJECT", want: home + "/src/copilot"},
+		{path: "out/$UNDEFINED_COPILOT_VAR/x", want: "out//x"},
+		{path: "~other/src", want: "~other/src"},
+		{path: "src/~", want: "src/~"},
+		{path: "relative/path", want: "relative/path"},
+	}
+	for _, tt := range tests {
+		got, err := expandPath(tt.path)
+		if err != nil {
+			t.Errorf("expandPath(%q) error = %v", tt.path, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("expandPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestExpandPaths(t *testing.T) {
+	t.Setenv("PROJECT", "copilot")
+	paths := []string{"", "-", "$PROJECT.json", "plain"}
+	if err := expandPaths(&paths[0], &paths[1], &paths[2], &paths[3]); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"", "-", "copilot.json", "plain"}; !slices.Equal(paths, want) {
+		t.Errorf("expandPaths() = %q, want %q", paths, want)
+	}
+}
+
+func TestExtractExpandPaths(t *testing.T) {
+	home := t.TempDir()
+	writeFiles(t, home, map[string]string{"project/main.go": "package main\n", "project/skip.go": "package main\n", "ignore-list": "skip.go\n"})
+	setHome(t, home)
+	t.Setenv("PROJECT", "project")
+	work := t.TempDir()
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		wantOut  string
+	}{
+		{name: "home directory", args: []string{"extract", "--list", "--expand-paths", "~/project", ".go"}, wantOut: "main.go\nskip.go\n"},
+		{name: "variable", args: []string{"extract", "--list", "--expand-paths", "~/$PROJECT", ".go"}, wantOut: "main.go\nskip.go\n"},
+		{name: "path flag", args: []string{"extract", "--list", "--expand-paths", "--gitignore", "${HOME}/ignore-list", "~/project", ".go"}, wantOut: "main.go\n"},
+		{name: "relative to", args: []string{"extract", "--list", "--expand-paths", "--relative-to", "~", "~/project", ".go"}, wantOut: "project/main.go\nproject/skip.go\n"},
+		{name: "not expanded without the flag", args: []string{"extract", "--list", "~/project", ".go"}, wantCode: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runCopilot(t, work, "", tt.args...)
+			if code != tt.wantCode {
+				t.Fatalf("exit code = %d, want %d, stderr: %s", code, tt.wantCode, stderr)
+			}
+			if code == 0 && stdout != tt.wantOut {
+				t.Errorf("stdout = %q, want %q", stdout, tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestApplyExpandPaths(t *testing.T) {
+	home := t.TempDir()
+	writeFiles(t, home, map[string]string{"changes.json": `{"changes": [{"file_path": "$NAME.txt", "content": "hello"}]}`})
+	setHome(t, home)
+	t.Setenv("NAME", "expanded")
+	out := filepath.Join(home, "out")
+	if err := os.Mkdir(out, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	_, stderr, code := runCopilot(t, t.TempDir(), "", "apply", "--expand-paths", "--base-dir", "~/out", "$HOME/changes.json")
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	// Paths in the changeset are never expanded
+	if got := fileContents(t, out); !slices.Equal(got, []string{"$NAME.txt=hello"}) {
+		t.Errorf("files = %q, want the changeset path kept as is", got)
+	}
+	if strings.Contains(stderr, "Warning") {
+		t.Errorf("stderr = %q, want no warning", stderr)
+	}
+}
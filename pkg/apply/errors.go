@@ -0,0 +1,34 @@
+package apply
+
+import "fmt"
+
+// PathTraversalError is returned by CheckPathWithin when a changeset path
+// escapes the base directory, see the --safe flag of the
+// apply command.
+type PathTraversalError struct {
+	Path     string // Path as given in the changeset
+	BaseDir  string // Directory the path must stay within
+	Resolved string // Path it resolves to through a symlink, empty if it escapes lexically
+}
+
+func (e *PathTraversalError) Error() string {
+	if e.Resolved != "" {
+		return fmt.Sprintf("path '%s' resolves to '%s' through a symlink, outside the base directory '%s'", e.Path, e.Resolved, e.BaseDir)
+	}
+	return fmt.Sprintf("path '%s' is outside the base directory '%s'", e.Path, e.BaseDir)
+}
+
+// WriteError is returned by WriteInPlace when a file cannot be written. It
+// wraps the error of the step that failed.
+type WriteError struct {
+	Path string // File being written
+	Err  error
+}
+
+func (e *WriteError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,78 @@
+package apply
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestPathTraversalError(t *testing.T) {
+	tests := []struct {
+		err  *PathTraversalError
+		want string
+	}{
+		{
+			err:  &PathTraversalError{Path: "../x", BaseDir: "base"},
+			want: "path '../x' is outside the base directory 'base'",
+		},
+		{
+			err:  &PathTraversalError{Path: "link/x", BaseDir: "base", Resolved: "/elsewhere/x"},
+			want: "path 'link/x' resolves to '/elsewhere/x' through a symlink, outside the base directory 'base'",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+		// The details survive wrapping, as callers adding context do
+		var traversal *PathTraversalError
+		if !errors.As(fmt.Errorf("change 1: %w", tt.err), &traversal) || *traversal != *tt.err {
+			t.Errorf("errors.As() = %+v, want %+v", traversal, tt.err)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	dir := t.TempDir()
+	notDir := filepath.Join(dir, "file.txt")
+	writeTestFile(t, notDir, "not a directory")
+	tests := []struct {
+		name     string
+		filePath string
+		wantErr  error
+	}{
+		{
+			name:     "below a file",
+			filePath: filepath.Join(notDir, "x.txt"),
+			wantErr:  syscall.ENOTDIR,
+		},
+		{
+			name:     "over a directory",
+			filePath: dir,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := WriteInPlace(tt.filePath, []byte("x"))
+			var writeErr *WriteError
+			if !errors.As(err, &writeErr) {
+				t.Fatalf("error = %#v, want a *WriteError", err)
+			}
+			if writeErr.Path != tt.filePath {
+				t.Errorf("Path = %q, want %q", writeErr.Path, tt.filePath)
+			}
+			if writeErr.Error() != writeErr.Err.Error() {
+				t.Errorf("Error() = %q, want the message of the wrapped error %q", writeErr.Error(), writeErr.Err.Error())
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want it to wrap %v", err, tt.wantErr)
+			}
+			if _, err := os.Stat(notDir); err != nil {
+				t.Errorf("stat %s: %v", notDir, err)
+			}
+		})
+	}
+}
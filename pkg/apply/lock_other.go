@@ -0,0 +1,10 @@
+//go:build !unix
+
+package apply
+
+import "os"
+
+// lockFile is a no-op on platforms without advisory file locks.
+func lockFile(f *os.File) error {
+	return nil
+}
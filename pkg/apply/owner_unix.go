@@ -0,0 +1,31 @@
+//go:build unix
+
+package apply
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// preserveOwner gives f the owner and group recorded in info, the original
+// file f is about to replace. Nothing is done when they already match the
+// current process, and a lack of permission to change them is not an error:
+// the file then keeps the process's own uid and gid, as before.
+func preserveOwner(f *os.File, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	uid, gid := int(stat.Uid), int(stat.Gid)
+	if uid == os.Getuid() && gid == os.Getgid() {
+		return nil
+	}
+
+	err := f.Chown(uid, gid)
+	if errors.Is(err, syscall.EPERM) {
+		return nil
+	}
+	return err
+}
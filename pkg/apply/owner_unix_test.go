@@ -0,0 +1,62 @@
+//go:build unix
+
+package apply
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+)
+
+func TestWriteInPlacePreservesOwner(t *testing.T) {
+	// Without root, a file can only be given another of the groups of the
+	// process
+	uid, gid := os.Getuid(), -1
+	if uid == 0 {
+		uid, gid = 4242, 4242
+	} else if groups, err := os.Getgroups(); err == nil {
+		if i := slices.IndexFunc(groups, func(g int) bool { return g != os.Getgid() }); i >= 0 {
+			gid = groups[i]
+		}
+	}
+	if gid == -1 {
+		t.Skip("needs root or a supplementary group to change the owner of a file")
+	}
+
+	filePath := filepath.Join(t.TempDir(), "owned.txt")
+	writeTestFile(t, filePath, "original")
+	if err := os.Chown(filePath, uid, gid); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteInPlace(filePath, []byte("replaced")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, filePath); got != "replaced" {
+		t.Errorf("content = %q, want %q", got, "replaced")
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+	if int(stat.Uid) != uid || int(stat.Gid) != gid {
+		t.Errorf("owner = %d:%d, want %d:%d", stat.Uid, stat.Gid, uid, gid)
+	}
+}
+
+func TestWriteInPlaceNewFileOwner(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "new.txt")
+	if err := WriteInPlace(filePath, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat := info.Sys().(*syscall.Stat_t); int(stat.Uid) != os.Getuid() {
+		t.Errorf("owner of a new file = %d, want the process uid %d", stat.Uid, os.Getuid())
+	}
+}
@@ -0,0 +1,77 @@
+package apply
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CheckPathWithin returns an error if filePath, relative to baseDir unless
+// absolute, escapes baseDir, either lexically (through ".." segments or an
+// absolute path) or through a symlink in the part of the path that already
+// exists.
+func CheckPathWithin(baseDir, filePath string) error {
+	baseAbs, err := filepath.Abs(baseDir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path for base directory '%s': %w", baseDir, err)
+	}
+	target := filePath
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(baseAbs, target)
+	}
+	pathAbs, err := filepath.Abs(target)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path for '%s': %w", filePath, err)
+	}
+	if !IsWithin(baseAbs, pathAbs) {
+		return &PathTraversalError{Path: filePath, BaseDir: baseDir}
+	}
+
+	baseReal, err := filepath.EvalSymlinks(baseAbs)
+	if err != nil {
+		return fmt.Errorf("failed to resolve base directory '%s': %w", baseDir, err)
+	}
+	pathReal, err := evalExistingSymlinks(pathAbs)
+	if err != nil {
+		return fmt.Errorf("failed to resolve '%s': %w", filePath, err)
+	}
+	if !IsWithin(baseReal, pathReal) {
+		return &PathTraversalError{Path: filePath, BaseDir: baseDir, Resolved: pathReal}
+	}
+	return nil
+}
+
+// evalExistingSymlinks resolves symlinks in the longest existing prefix of
+// the absolute path pathAbs and appends the remaining, not yet created part.
+func evalExistingSymlinks(pathAbs string) (string, error) {
+	existing, rest := pathAbs, ""
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolved, rest), nil
+}
+
+// IsWithin reports whether the absolute path target is baseAbs or lies below it.
+func IsWithin(baseAbs, target string) bool {
+	rel, err := filepath.Rel(baseAbs, target)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
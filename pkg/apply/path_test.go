@@ -0,0 +1,83 @@
+package apply
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckPathWithin(t *testing.T) {
+	root := t.TempDir()
+	base := filepath.Join(root, "base")
+	outside := filepath.Join(root, "outside")
+	for _, dir := range []string{filepath.Join(base, "sub"), outside} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	symlinks := true
+	if err := os.Symlink(outside, filepath.Join(base, "escape")); err != nil {
+		symlinks = false
+	} else if err := os.Symlink(filepath.Join(base, "sub"), filepath.Join(base, "inside")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		symlink      bool // Needs symlink support
+		wantEscape   bool
+		wantResolved bool // The escape goes through a symlink
+	}{
+		{name: "relative path", path: "sub/file.txt"},
+		{name: "new directories", path: "new/dir/file.txt"},
+		{name: "dot-dot staying inside", path: "sub/../file.txt"},
+		{name: "dot-dot escaping", path: "../outside/file.txt", wantEscape: true},
+		{name: "dot-dot escaping from a subdirectory", path: "sub/../../file.txt", wantEscape: true},
+		{name: "absolute path inside", path: filepath.Join(base, "sub", "file.txt")},
+		{name: "absolute path outside", path: filepath.Join(outside, "file.txt"), wantEscape: true},
+		{name: "symlink escaping", path: "escape/file.txt", symlink: true, wantEscape: true, wantResolved: true},
+		{name: "symlink escaping in a new subdirectory", path: "escape/new/file.txt", symlink: true, wantEscape: true, wantResolved: true},
+		{name: "symlink staying inside", path: "inside/file.txt", symlink: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.symlink && !symlinks {
+				t.Skip("symlinks are not supported")
+			}
+			err := CheckPathWithin(base, filepath.FromSlash(tt.path))
+			var traversal *PathTraversalError
+			if escaped := errors.As(err, &traversal); escaped != tt.wantEscape {
+				t.Fatalf("CheckPathWithin(%q) = %v, want escape %v", tt.path, err, tt.wantEscape)
+			}
+			if !tt.wantEscape && err != nil {
+				t.Fatalf("CheckPathWithin(%q) = %v", tt.path, err)
+			}
+			if traversal != nil && (traversal.Resolved != "") != tt.wantResolved {
+				t.Errorf("CheckPathWithin(%q) resolved = %q, want through a symlink %v", tt.path, traversal.Resolved, tt.wantResolved)
+			}
+		})
+	}
+}
+
+func TestIsWithin(t *testing.T) {
+	base := filepath.FromSlash("/base")
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"/base", true},
+		{"/base/file", true},
+		{"/base/a/b", true},
+		{"/base/..file", true},
+		{"/", false},
+		{"/other", false},
+		{"/base-other/file", false},
+	}
+	for _, tt := range tests {
+		if got := IsWithin(base, filepath.FromSlash(tt.target)); got != tt.want {
+			t.Errorf("IsWithin(%q, %q) = %v, want %v", base, tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package apply
+
+import (
+	"os"
+	"sync"
+)
+
+// pendingTemps holds the names of the temporary files of the PendingFiles
+// that are neither committed nor aborted yet, see RemovePendingTemps.
+var pendingTemps = struct {
+	sync.Mutex
+	names map[string]bool
+}{names: map[string]bool{}}
+
+// trackTemp records name as the temporary file of an in-flight PendingFile.
+func trackTemp(name string) {
+	pendingTemps.Lock()
+	defer pendingTemps.Unlock()
+	pendingTemps.names[name] = true
+}
+
+// untrackTemp forgets name once its PendingFile is committed or aborted.
+func untrackTemp(name string) {
+	pendingTemps.Lock()
+	defer pendingTemps.Unlock()
+	delete(pendingTemps.names, name)
+}
+
+// RemovePendingTemps removes the temporary files of the PendingFiles, and so
+// of the WriteInPlace calls, still in flight, leaving their target files
+// untouched. It is meant to be called when the process is interrupted, right
+// before exiting, since deferred cleanups do not run on os.Exit; the
+// interrupted writes then fail or, if they were being renamed, complete.
+func RemovePendingTemps() {
+	pendingTemps.Lock()
+	defer pendingTemps.Unlock()
+	for name := range pendingTemps.names {
+		os.Remove(name)
+		delete(pendingTemps.names, name)
+	}
+}
@@ -0,0 +1,77 @@
+package apply
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestRemovePendingTemps interrupts writes in progress as the interrupt
+// handler of the CLI does, and checks that no temporary file remains.
+func TestRemovePendingTemps(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "existing.txt"), "old")
+	writeTestFile(t, filepath.Join(dir, "committed.txt"), "old")
+
+	var inFlight []*PendingFile
+	for _, name := range []string{"existing.txt", "new.txt", "sub/new.txt"} {
+		f, err := Create(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte("partial")); err != nil {
+			t.Fatal(err)
+		}
+		inFlight = append(inFlight, f)
+	}
+	committed, err := Create(filepath.Join(dir, "committed.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := committed.Write([]byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := committed.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	aborted, err := Create(filepath.Join(dir, "aborted.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aborted.Abort()
+
+	RemovePendingTemps()
+
+	tests := []struct {
+		dir  string
+		want []string
+	}{
+		{dir: dir, want: []string{"committed.txt", "existing.txt", "sub"}},
+		{dir: filepath.Join(dir, "sub")},
+	}
+	for _, tt := range tests {
+		if names := dirEntries(t, tt.dir); !slices.Equal(names, tt.want) {
+			t.Errorf("%s holds %q, want %q", tt.dir, names, tt.want)
+		}
+	}
+	for file, want := range map[string]string{"existing.txt": "old", "committed.txt": "new"} {
+		if got := readTestFile(t, filepath.Join(dir, file)); got != want {
+			t.Errorf("%s = %q, want %q", file, got, want)
+		}
+	}
+
+	// The interrupted writes fail without touching their targets
+	for _, f := range inFlight {
+		if err := f.Commit(); err == nil {
+			t.Errorf("Commit() of %s after RemovePendingTemps succeeded, want an error", f.path)
+		}
+	}
+	if got := readTestFile(t, filepath.Join(dir, "existing.txt")); got != "old" {
+		t.Errorf("existing.txt = %q, want %q", got, "old")
+	}
+	pendingTemps.Lock()
+	defer pendingTemps.Unlock()
+	if len(pendingTemps.names) != 0 {
+		t.Errorf("%d temporary file(s) still tracked, want none", len(pendingTemps.names))
+	}
+}
@@ -0,0 +1,43 @@
+package apply
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+// defaultRetryDelay is the delay before the first retry of a Writer whose
+// RetryDelay is zero.
+const defaultRetryDelay = 50 * time.Millisecond
+
+// withRetries runs op, running it again up to w.Retries times, with an
+// exponential backoff, as long as it fails with a transient error. Other
+// errors are returned right away.
+func (w Writer) withRetries(op func() error) error {
+	delay := w.RetryDelay
+	if delay == 0 {
+		delay = defaultRetryDelay
+	}
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= w.Retries || !isTransientError(err) {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// isTransientError reports whether err is likely to go away by itself, such
+// as a file being temporarily locked by another process.
+func isTransientError(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	switch errno {
+	case syscall.EBUSY, syscall.EAGAIN, syscall.EINTR, syscall.ETXTBSY:
+		return true
+	}
+	return isTransientErrno(errno)
+}
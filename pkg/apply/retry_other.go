@@ -0,0 +1,11 @@
+//go:build !windows
+
+package apply
+
+import "syscall"
+
+// isTransientErrno reports whether errno is transient on this platform
+// only. No error besides those of isTransientError is.
+func isTransientErrno(errno syscall.Errno) bool {
+	return false
+}
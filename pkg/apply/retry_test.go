@@ -0,0 +1,144 @@
+package apply
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// retryingWriter returns a Writer retrying up to retries times, with a
+// short delay.
+func retryingWriter(retries int) Writer {
+	return Writer{Retries: retries, RetryDelay: time.Millisecond}
+}
+
+// failingOp returns an operation failing with err the first failures times
+// it runs, and succeeding afterwards, along with the number of runs so far.
+func failingOp(failures int, err error) (op func() error, calls *int) {
+	calls = new(int)
+	return func() error {
+		*calls++
+		if *calls <= failures {
+			return err
+		}
+		return nil
+	}, calls
+}
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{syscall.EBUSY, true},
+		{syscall.EAGAIN, true},
+		{syscall.EINTR, true},
+		{syscall.ETXTBSY, true},
+		{&os.LinkError{Op: "rename", Old: "a", New: "b", Err: syscall.EBUSY}, true},
+		{fmt.Errorf("wrapped: %w", &os.PathError{Op: "open", Path: "a", Err: syscall.EAGAIN}), true},
+		{syscall.EACCES, false},
+		{syscall.ENOENT, false},
+		{errors.New("busy"), false},
+	}
+	for _, tt := range tests {
+		if got := isTransientError(tt.err); got != tt.want {
+			t.Errorf("isTransientError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestWithRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		retries   int
+		failures  int
+		err       error
+		wantErr   bool
+		wantCalls int
+	}{
+		{name: "success", retries: 3, wantCalls: 1},
+		{name: "no retries by default", failures: 1, err: syscall.EBUSY, wantErr: true, wantCalls: 1},
+		{name: "fails twice then succeeds", retries: 2, failures: 2, err: syscall.EBUSY, wantCalls: 3},
+		{name: "more failures than retries", retries: 2, failures: 3, err: syscall.EBUSY, wantErr: true, wantCalls: 3},
+		{name: "non-transient errors fail right away", retries: 5, failures: 2, err: syscall.EACCES, wantErr: true, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op, calls := failingOp(tt.failures, tt.err)
+			err := retryingWriter(tt.retries).withRetries(op)
+			if (err != nil) != tt.wantErr || err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("withRetries() error = %v, want %v: %v", err, tt.wantErr, tt.err)
+			}
+			if *calls != tt.wantCalls {
+				t.Errorf("op ran %d time(s), want %d", *calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestWithRetriesBackoff(t *testing.T) {
+	w := Writer{Retries: 3, RetryDelay: 10 * time.Millisecond}
+	op, _ := failingOp(3, syscall.EBUSY)
+	start := time.Now()
+	if err := w.withRetries(op); err != nil {
+		t.Fatal(err)
+	}
+	// 10ms, then 20ms, then 40ms
+	if elapsed := time.Since(start); elapsed < 70*time.Millisecond {
+		t.Errorf("withRetries() took %v, want at least 70ms of backoff", elapsed)
+	}
+}
+
+// TestWriteInPlaceRetries makes the rename of WriteInPlace fail with EBUSY
+// twice before succeeding, as a file briefly locked by another process would.
+func TestWriteInPlaceRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		retries     int
+		wantErr     bool
+		wantContent string
+	}{
+		{name: "enough retries", retries: 2, wantContent: "new"},
+		{name: "too few retries", retries: 1, wantErr: true, wantContent: "old"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filePath := filepath.Join(dir, "file.txt")
+			writeTestFile(t, filePath, "old")
+
+			renames := 0
+			previous := renameFunc
+			renameFunc = func(from, to string) error {
+				renames++
+				if renames <= 2 {
+					return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EBUSY}
+				}
+				return previous(from, to)
+			}
+			t.Cleanup(func() { renameFunc = previous })
+
+			err := retryingWriter(tt.retries).WriteInPlace(filePath, []byte("new"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WriteInPlace() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, syscall.EBUSY) {
+				t.Errorf("WriteInPlace() error = %v, want it to wrap EBUSY", err)
+			}
+			if renames != tt.retries+1 {
+				t.Errorf("rename ran %d time(s), want %d", renames, tt.retries+1)
+			}
+			if got := readTestFile(t, filePath); got != tt.wantContent {
+				t.Errorf("content = %q, want %q", got, tt.wantContent)
+			}
+			if names := dirEntries(t, dir); !slices.Equal(names, []string{"file.txt"}) {
+				t.Errorf("directory holds %q, want the temporary file removed", names)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package apply
+
+import "syscall"
+
+// Windows error codes reported while another process, such as an antivirus
+// scanner, holds the file open.
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// isTransientErrno reports whether errno is a Windows error caused by a
+// file being in use, which access denied errors on rename often are.
+func isTransientErrno(errno syscall.Errno) bool {
+	switch errno {
+	case syscall.ERROR_ACCESS_DENIED, errorSharingViolation, errorLockViolation:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,372 @@
+// Package apply writes, renames and deletes files the way the apply command
+// of copilot does: atomically where possible, preserving permissions and
+// ownership, and without letting paths escape a base directory.
+package apply
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// Writer writes files with the durability and retry settings of the apply
+// command. The zero Writer, which the package-level functions use, neither
+// syncs nor retries.
+type Writer struct {
+	// Sync makes WriteInPlace, AppendFile and PendingFile.Commit flush file
+	// contents and the rename to disk before returning, so that written files
+	// survive a crash or power loss. It is off by default for performance,
+	// see the --fsync flag of the apply command.
+	Sync bool
+
+	// Retries is how many times WriteInPlace retries creating its temporary
+	// file or renaming it over the target after a transient error, see
+	// isTransientError. See the --retries flag of the apply command.
+	Retries int
+
+	// RetryDelay is the delay before the first retry, doubled before each
+	// following one. Zero means 50ms.
+	RetryDelay time.Duration
+}
+
+// WriteInPlace writes content to filePath with the zero Writer, see
+// Writer.WriteInPlace.
+func WriteInPlace(filePath string, content []byte) error {
+	return Writer{}.WriteInPlace(filePath, content)
+}
+
+// WriteInPlace safely writes content to a file by using a temporary file
+// and an atomic rename operation. It also preserves original file permissions
+// and, where supported, ownership. Creating the temporary file and renaming it
+// are retried after transient errors, see withRetries. Errors are returned
+// as a *WriteError.
+func (w Writer) WriteInPlace(filePath string, content []byte) error {
+	f, err := w.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Abort()
+		return err
+	}
+	return f.Commit()
+}
+
+// PendingFile is a file written through a temporary file, see Create. Its
+// content replaces the file at its path once committed, and is discarded
+// if aborted.
+type PendingFile struct {
+	path     string
+	info     os.FileInfo // Original file, nil if it does not exist
+	mode     os.FileMode // Permissions given to the written file
+	tempFile *os.File
+	writer   Writer // Settings of the Writer that created it
+}
+
+// Create starts writing the file at filePath with the zero Writer, see
+// Writer.Create.
+func Create(filePath string) (*PendingFile, error) {
+	return Writer{}.Create(filePath)
+}
+
+// Create starts writing the file at filePath, creating its parent
+// directories as needed. The content written to the returned PendingFile
+// goes to a temporary file next to filePath, so that it can be streamed
+// without replacing filePath until Commit, which does so as WriteInPlace
+// does. Errors of Create and of the PendingFile methods are returned as a
+// *WriteError.
+func (w Writer) Create(filePath string) (f *PendingFile, err error) {
+	defer func() {
+		if err != nil {
+			err = &WriteError{Path: filePath, Err: err}
+		}
+	}()
+
+	info, err := os.Stat(filePath)
+	var originalMode os.FileMode = 0644 // Default permissions if file doesn't exist
+	if err == nil {
+		originalMode = info.Mode()
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not stat target file path '%s': %w", filePath, err)
+	}
+	// If file does not exist, os.Stat returns an error. We proceed to create it.
+
+	// Ensure directory exists
+	dir := filepath.Dir(filePath)
+	if dir != "" && dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil { // 0755 for directories
+			return nil, fmt.Errorf("could not create directory %s: %w", dir, err)
+		}
+	}
+
+	var tempFile *os.File
+	err = w.withRetries(func() (err error) {
+		tempFile, err = os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporary file in %s: %w", filepath.Dir(filePath), err)
+	}
+	trackTemp(tempFile.Name())
+	return &PendingFile{path: filePath, info: info, mode: originalMode, tempFile: tempFile, writer: w}, nil
+}
+
+// Write appends p to the temporary file.
+func (f *PendingFile) Write(p []byte) (int, error) {
+	n, err := f.tempFile.Write(p)
+	if err != nil {
+		return n, &WriteError{Path: f.path, Err: fmt.Errorf("could not write to temporary file '%s': %w", f.tempFile.Name(), err)}
+	}
+	return n, nil
+}
+
+// Abort discards the content written so far, removing the temporary file.
+// The file at the path given to Create is left untouched.
+func (f *PendingFile) Abort() {
+	f.tempFile.Close()
+	os.Remove(f.tempFile.Name())
+	untrackTemp(f.tempFile.Name())
+}
+
+// Commit replaces the file at the path given to Create with the content
+// written so far, with the permissions and, where supported, the ownership of
+// the file it replaces.
+func (f *PendingFile) Commit() (err error) {
+	defer func() {
+		if err != nil {
+			err = &WriteError{Path: f.path, Err: err}
+		}
+	}()
+
+	tempFile, filePath := f.tempFile, f.path
+	// Remove the temporary file in case of errors before rename
+	renamed := false
+	defer func() {
+		untrackTemp(tempFile.Name())
+		if !renamed {
+			_, statErr := os.Stat(tempFile.Name())
+			if statErr == nil { // if temp file still exists
+				os.Remove(tempFile.Name())
+			}
+		}
+	}()
+
+	if err := tempFile.Chmod(f.mode); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("could not set permissions on temporary file '%s': %w", tempFile.Name(), err)
+	}
+
+	if f.info != nil {
+		if err := preserveOwner(tempFile, f.info); err != nil {
+			tempFile.Close()
+			return fmt.Errorf("could not set ownership on temporary file '%s': %w", tempFile.Name(), err)
+		}
+	}
+
+	if f.writer.Sync {
+		if err := tempFile.Sync(); err != nil {
+			tempFile.Close()
+			return fmt.Errorf("could not sync temporary file '%s': %w", tempFile.Name(), err)
+		}
+	}
+
+	if err := tempFile.Close(); err != nil { // Close before rename
+		return fmt.Errorf("could not close temporary file '%s': %w", tempFile.Name(), err)
+	}
+
+	if err := f.writer.withRetries(func() error { return renameFunc(tempFile.Name(), filePath) }); err != nil {
+		if !errors.Is(err, syscall.EXDEV) {
+			return fmt.Errorf("could not rename temporary file '%s' to '%s': %w", tempFile.Name(), filePath, err)
+		}
+		// The temporary file sits next to the target, but bind mounts can
+		// still make them different devices: copy the content over instead.
+		// The deferred cleanup removes the temporary file.
+		if err := copyFile(tempFile.Name(), filePath, f.mode); err != nil {
+			return fmt.Errorf("could not copy temporary file '%s' to '%s' after cross-device rename failure: %w", tempFile.Name(), filePath, err)
+		}
+		return nil
+	}
+
+	renamed = true
+
+	if f.writer.Sync {
+		if err := syncDir(filepath.Dir(filePath)); err != nil {
+			return fmt.Errorf("could not sync directory of '%s': %w", filePath, err)
+		}
+	}
+	return nil
+}
+
+// syncDir flushes the directory entries of dir to disk. Directories cannot
+// be synced on Windows, where this is a no-op.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// renameFunc renames files for PendingFile.Commit and RenameFile. It is a
+// variable so that rename failures, such as cross-device errors, can be
+// simulated.
+var renameFunc = os.Rename
+
+// copyFile copies the content of the file at from to the file at to in
+// place, truncating it, and sets its permissions to mode. It is the
+// non-atomic fallback used when the atomic rename of Commit is not possible;
+// it fsyncs the file before closing so that it is at least complete once
+// this returns.
+func copyFile(from, to string, mode os.FileMode) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	f, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// AppendFile appends content to filePath with the zero Writer, see
+// Writer.AppendFile.
+func AppendFile(filePath string, content []byte) error {
+	return Writer{}.AppendFile(filePath, content)
+}
+
+// AppendFile appends content to filePath, creating it and its parent
+// directories if needed. Unlike WriteInPlace, the file is modified in place:
+// the file is locked while writing so that concurrent appends do not
+// interleave, and synced before returning so that the data is on disk once
+// this succeeds. A crash during the write may still leave a partial append.
+func (w Writer) AppendFile(filePath string, content []byte) error {
+	dir := filepath.Dir(filePath)
+	if dir != "" && dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("could not create directory %s: %w", dir, err)
+		}
+	}
+
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open '%s' for appending: %w", filePath, err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return fmt.Errorf("could not lock '%s': %w", filePath, err)
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return fmt.Errorf("could not append to '%s': %w", filePath, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("could not sync '%s': %w", filePath, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("could not close '%s': %w", filePath, err)
+	}
+
+	if w.Sync {
+		if err := syncDir(dir); err != nil {
+			return fmt.Errorf("could not sync directory '%s': %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// DeleteFile removes the regular file at filePath. It refuses to remove
+// directories. A missing file is reported with an error satisfying os.IsNotExist.
+func DeleteFile(filePath string) error {
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("'%s' is a directory", filePath)
+	}
+	return os.Remove(filePath)
+}
+
+// ErrRenameTargetExists is returned by RenameFile when the destination
+// exists and overwriting was not requested.
+var ErrRenameTargetExists = errors.New("rename target already exists")
+
+// RenameFile moves the file at from to to with the zero Writer, see
+// Writer.RenameFile.
+func RenameFile(from, to string, overwrite bool) error {
+	return Writer{}.RenameFile(from, to, overwrite)
+}
+
+// RenameFile moves the file at from to to, creating the parent directories
+// of to as needed. An existing destination is only replaced when overwrite
+// is set. The move is an atomic os.Rename, falling back to a copy followed by
+// removal of from when both paths are on different devices.
+func (w Writer) RenameFile(from, to string, overwrite bool) error {
+	info, err := os.Lstat(from)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("'%s' is a directory", from)
+	}
+	if _, err := os.Lstat(to); err == nil {
+		if !overwrite {
+			return ErrRenameTargetExists
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("could not stat rename target '%s': %w", to, err)
+	}
+
+	dir := filepath.Dir(to)
+	if dir != "" && dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("could not create directory %s: %w", dir, err)
+		}
+	}
+
+	err = renameFunc(from, to)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	// Cross-device move: copy through an atomic write, then remove the source
+	content, err := os.ReadFile(from)
+	if err != nil {
+		return fmt.Errorf("could not read '%s' for cross-device rename: %w", from, err)
+	}
+	if err := w.WriteInPlace(to, content); err != nil {
+		return err
+	}
+	if err := os.Chmod(to, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("could not set permissions on '%s': %w", to, err)
+	}
+	if err := os.Remove(from); err != nil {
+		return fmt.Errorf("copied '%s' to '%s' but could not remove the source: %w", from, to, err)
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+package apply
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"syscall"
+	"testing"
+)
+
+// writeTestFile creates the file at filePath with content.
+func writeTestFile(t *testing.T, filePath, content string) {
+	t.Helper()
+	if err := os.WriteFile(filePath, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readTestFile returns the content of the file at filePath, or "<missing>"
+// if it does not exist.
+func readTestFile(t *testing.T, filePath string) string {
+	t.Helper()
+	content, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return "<missing>"
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestWriteInPlace(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		existing string // Content before the write, empty if the file does not exist
+		mode     os.FileMode
+		content  string
+		wantMode os.FileMode
+	}{
+		{name: "new file", path: "new.txt", content: "new", wantMode: 0o644},
+		{name: "new directories", path: "a/b/new.txt", content: "new", wantMode: 0o644},
+		{name: "existing file keeps its mode", path: "old.txt", existing: "old", mode: 0o600, content: "new", wantMode: 0o600},
+		{name: "empty content", path: "old.txt", existing: "old", mode: 0o644, content: "", wantMode: 0o644},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filePath := filepath.Join(dir, filepath.FromSlash(tt.path))
+			if tt.existing != "" {
+				writeTestFile(t, filePath, tt.existing)
+				if err := os.Chmod(filePath, tt.mode); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := WriteInPlace(filePath, []byte(tt.content)); err != nil {
+				t.Fatal(err)
+			}
+			if got := readTestFile(t, filePath); got != tt.content {
+				t.Errorf("content = %q, want %q", got, tt.content)
+			}
+			if info, err := os.Stat(filePath); err != nil || runtime.GOOS != "windows" && info.Mode().Perm() != tt.wantMode {
+				t.Errorf("mode = %v, %v, want %v", info.Mode().Perm(), err, tt.wantMode)
+			}
+			if names := dirEntries(t, filepath.Dir(filePath)); !slices.Equal(names, []string{filepath.Base(filePath)}) {
+				t.Errorf("directory holds %q, want only %s", names, filepath.Base(filePath))
+			}
+		})
+	}
+}
+
+func TestCreate(t *testing.T) {
+	tests := []struct {
+		name        string
+		commit      bool
+		wantContent string
+	}{
+		{name: "commit", commit: true, wantContent: "part 1, part 2"},
+		{name: "abort", wantContent: "old"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filePath := filepath.Join(dir, "file.txt")
+			writeTestFile(t, filePath, "old")
+			f, err := Create(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, part := range []string{"part 1", ", ", "part 2"} {
+				if _, err := f.Write([]byte(part)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Nothing is replaced until the file is committed
+			if got := readTestFile(t, filePath); got != "old" {
+				t.Errorf("content before Commit = %q, want %q", got, "old")
+			}
+			if tt.commit {
+				if err := f.Commit(); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				f.Abort()
+			}
+			if got := readTestFile(t, filePath); got != tt.wantContent {
+				t.Errorf("content = %q, want %q", got, tt.wantContent)
+			}
+			if names := dirEntries(t, dir); !slices.Equal(names, []string{"file.txt"}) {
+				t.Errorf("directory holds %q, want the temporary file removed", names)
+			}
+		})
+	}
+}
+
+func TestAppendFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		existing string // Content before the append, empty if the file does not exist
+		want     string
+	}{
+		{name: "existing file", path: "log.txt", existing: "one\n", want: "one\ntwo\n"},
+		{name: "new file", path: "log.txt", want: "two\n"},
+		{name: "new directories", path: "a/b/log.txt", want: "two\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), filepath.FromSlash(tt.path))
+			if tt.existing != "" {
+				writeTestFile(t, filePath, tt.existing)
+			}
+			if err := AppendFile(filePath, []byte("two\n")); err != nil {
+				t.Fatal(err)
+			}
+			if got := readTestFile(t, filePath); got != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "file.txt"), "content")
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		path        string
+		wantErr     bool
+		wantMissing bool // The error satisfies os.IsNotExist
+	}{
+		{name: "file", path: "file.txt"},
+		{name: "missing file", path: "missing.txt", wantErr: true, wantMissing: true},
+		{name: "directory", path: "sub", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := DeleteFile(filepath.Join(dir, tt.path))
+			if (err != nil) != tt.wantErr || os.IsNotExist(err) != tt.wantMissing {
+				t.Errorf("DeleteFile(%q) error = %v, want error %v, missing %v", tt.path, err, tt.wantErr, tt.wantMissing)
+			}
+		})
+	}
+	if names := dirEntries(t, dir); !slices.Equal(names, []string{"sub"}) {
+		t.Errorf("directory holds %q, want only sub", names)
+	}
+}
+
+func TestRenameFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		from, to  string
+		overwrite bool
+		wantErr   func(error) bool
+		wantFrom  string
+		wantTo    string
+	}{
+		{
+			name: "same filesystem",
+			from: "a.txt", to: "moved.txt",
+			wantFrom: "<missing>", wantTo: "a",
+		},
+		{
+			name: "creates parent directories",
+			from: "a.txt", to: "sub/dir/moved.txt",
+			wantFrom: "<missing>", wantTo: "a",
+		},
+		{
+			name: "existing target without overwrite",
+			from: "a.txt", to: "b.txt",
+			wantErr:  func(err error) bool { return errors.Is(err, ErrRenameTargetExists) },
+			wantFrom: "a", wantTo: "b",
+		},
+		{
+			name: "existing target with overwrite",
+			from: "a.txt", to: "b.txt", overwrite: true,
+			wantFrom: "<missing>", wantTo: "a",
+		},
+		{
+			name: "nonexistent source",
+			from: "ghost.txt", to: "moved.txt",
+			wantErr:  os.IsNotExist,
+			wantFrom: "<missing>", wantTo: "<missing>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFile(t, filepath.Join(dir, "a.txt"), "a")
+			writeTestFile(t, filepath.Join(dir, "b.txt"), "b")
+			from, to := filepath.Join(dir, tt.from), filepath.Join(dir, filepath.FromSlash(tt.to))
+
+			err := RenameFile(from, to, tt.overwrite)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !tt.wantErr(err) {
+				t.Fatalf("RenameFile() error = %v", err)
+			}
+			if got := readTestFile(t, from); got != tt.wantFrom {
+				t.Errorf("source = %q, want %q", got, tt.wantFrom)
+			}
+			if got := readTestFile(t, to); got != tt.wantTo {
+				t.Errorf("target = %q, want %q", got, tt.wantTo)
+			}
+		})
+	}
+}
+
+func TestRenameFileRefusesDirectories(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := RenameFile(filepath.Join(dir, "sub"), filepath.Join(dir, "moved"), false); err == nil {
+		t.Error("RenameFile() of a directory returned no error")
+	}
+}
+
+// stubRename makes renameFunc fail with err for the rest of the test.
+func stubRename(t *testing.T, err error) {
+	t.Helper()
+	previous := renameFunc
+	renameFunc = func(from, to string) error {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+	t.Cleanup(func() { renameFunc = previous })
+}
+
+// dirEntries returns the names of the entries of dir.
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestWriteInPlaceRenameFailure(t *testing.T) {
+	tests := []struct {
+		name        string
+		renameErr   error
+		wantErr     bool
+		wantContent string
+	}{
+		{name: "cross-device falls back to a copy", renameErr: syscall.EXDEV, wantContent: "new"},
+		{name: "other errors fail", renameErr: syscall.EACCES, wantErr: true, wantContent: "old"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filePath := filepath.Join(dir, "file.txt")
+			writeTestFile(t, filePath, "old")
+			if err := os.Chmod(filePath, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			stubRename(t, tt.renameErr)
+
+			err := WriteInPlace(filePath, []byte("new"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WriteInPlace() error = %v, want error %v", err, tt.wantErr)
+			}
+			var writeErr *WriteError
+			if err != nil && (!errors.As(err, &writeErr) || !errors.Is(err, tt.renameErr)) {
+				t.Errorf("WriteInPlace() error = %#v, want a *WriteError wrapping %v", err, tt.renameErr)
+			}
+			if got := readTestFile(t, filePath); got != tt.wantContent {
+				t.Errorf("content = %q, want %q", got, tt.wantContent)
+			}
+			if info, err := os.Stat(filePath); err != nil || runtime.GOOS != "windows" && info.Mode().Perm() != 0o600 {
+				t.Errorf("mode = %v, %v, want %v", info.Mode().Perm(), err, os.FileMode(0o600))
+			}
+			if names := dirEntries(t, dir); !slices.Equal(names, []string{"file.txt"}) {
+				t.Errorf("directory holds %q, want the temporary file removed", names)
+			}
+		})
+	}
+}
+
+func TestRenameFileCrossDevice(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "from.txt"), filepath.Join(dir, "sub", "to.txt")
+	writeTestFile(t, from, "content")
+	if err := os.Chmod(from, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	stubRename(t, syscall.EXDEV)
+
+	// The copy goes through WriteInPlace, whose own rename falls back too
+	if err := RenameFile(from, to, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, from); got != "<missing>" {
+		t.Errorf("source = %q, want it removed", got)
+	}
+	if got := readTestFile(t, to); got != "content" {
+		t.Errorf("target = %q, want %q", got, "content")
+	}
+	if info, err := os.Stat(to); err != nil || runtime.GOOS != "windows" && info.Mode().Perm() != 0o640 {
+		t.Errorf("target mode = %v, %v, want %v", info.Mode().Perm(), err, os.FileMode(0o640))
+	}
+}
+
+func TestSyncWrites(t *testing.T) {
+	w := Writer{Sync: true}
+	tests := []struct {
+		name  string
+		write func(filePath string) error
+		want  string
+	}{
+		{
+			name:  "write in place",
+			write: func(filePath string) error { return w.WriteInPlace(filePath, []byte("synced")) },
+			want:  "synced",
+		},
+		{
+			name:  "append",
+			write: func(filePath string) error { return w.AppendFile(filePath, []byte(" and appended")) },
+			want:  "synced and appended",
+		},
+	}
+	filePath := filepath.Join(t.TempDir(), "sub", "file.txt")
+	for _, tt := range tests {
+		if err := tt.write(filePath); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := readTestFile(t, filePath); got != tt.want {
+			t.Errorf("%s: content = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package ignore
+
+import (
+	"path"
+	"strings"
+)
+
+// matchGlob reports whether a slash-separated path matches a glob pattern.
+// In addition to the path.Match syntax, a "**" segment matches zero or more
+// whole path segments, so "a/**/b" matches "a/b", "a/x/b" and "a/x/y/b", and
+// a trailing "/**" matches everything inside a directory but not the
+// directory itself.
+func matchGlob(pattern, name string) (bool, error) {
+	return matchGlobSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchGlobSegments(patternSegs, nameSegs []string) (bool, error) {
+	for len(patternSegs) > 0 {
+		if patternSegs[0] == "**" {
+			rest := patternSegs[1:]
+			if len(rest) == 0 {
+				return len(nameSegs) > 0, nil
+			}
+			for i := 0; i <= len(nameSegs); i++ {
+				matched, err := matchGlobSegments(rest, nameSegs[i:])
+				if matched || err != nil {
+					return matched, err
+				}
+			}
+			return false, nil
+		}
+
+		if len(nameSegs) == 0 {
+			return false, nil
+		}
+		matched, err := path.Match(patternSegs[0], nameSegs[0])
+		if err != nil || !matched {
+			return false, err
+		}
+		patternSegs, nameSegs = patternSegs[1:], nameSegs[1:]
+	}
+	return len(nameSegs) == 0, nil
+}
+
+// expandBraces expands the brace groups of pattern, as shells do, into the
+// patterns they stand for: "*.{js,ts}" gives "*.js" and "*.ts", and groups
+// can be nested, as in "{src,lib/{a,b}}/*.go". A group without a top-level
+// comma, an unbalanced brace, and a brace escaped with a backslash or inside
+// a character class are kept as literal characters.
+func expandBraces(pattern string) []string {
+	depth, open := 0, -1
+	var commas []int
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '[':
+			// Braces and commas of a character class are literal
+			if end := strings.IndexByte(pattern[i+1:], ']'); end >= 0 {
+				i += end + 1
+			}
+		case '{':
+			if depth == 0 {
+				open, commas = i, nil
+			}
+			depth++
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		case '}':
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+			var alternatives []string
+			if len(commas) == 0 {
+				for _, inner := range expandBraces(pattern[open+1 : i]) {
+					alternatives = append(alternatives, "{"+inner+"}")
+				}
+			} else {
+				start := open + 1
+				for _, end := range append(commas, i) {
+					alternatives = append(alternatives, expandBraces(pattern[start:end])...)
+					start = end + 1
+				}
+			}
+			var expanded []string
+			for _, alternative := range alternatives {
+				for _, suffix := range expandBraces(pattern[i+1:]) {
+					expanded = append(expanded, pattern[:open]+alternative+suffix)
+				}
+			}
+			return expanded
+		}
+	}
+	return []string{pattern}
+}
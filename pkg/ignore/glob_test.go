@@ -0,0 +1,110 @@
+package ignore
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMatchGlobGlobstar(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"a/**/b", "a/x/y/c", false},
+		{"a/**/b", "b/x/b", false},
+		{"**/node_modules", "node_modules", true},
+		{"**/node_modules", "web/node_modules", true},
+		{"**/node_modules", "web/app/node_modules", true},
+		{"**/node_modules", "web/node_modules_old", false},
+		{"src/**/*.test.js", "src/a.test.js", true},
+		{"src/**/*.test.js", "src/ui/button/a.test.js", true},
+		{"src/**/*.test.js", "lib/a.test.js", false},
+		{"src/**/*.test.js", "src/a.js", false},
+		{"build/**", "build/out.o", true},
+		{"build/**", "build/out/x.o", true},
+		{"build/**", "build", false},
+		{"*.go", "main.go", true},
+		{"*.go", "pkg/main.go", false},
+	}
+
+	for _, tt := range tests {
+		got, err := matchGlob(tt.pattern, tt.name)
+		if err != nil {
+			t.Errorf("matchGlob(%q, %q) returned error: %v", tt.pattern, tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchGlobMalformed(t *testing.T) {
+	if _, err := matchGlob("src/[a", "src/a"); err == nil {
+		t.Error("matchGlob with an unterminated character class returned no error")
+	}
+}
+
+func TestMatchGlobstarPatterns(t *testing.T) {
+	m := FromPatterns([]string{"**/node_modules", "src/**/*.test.js"}, t.TempDir())
+	checkMatches(t, m, []matchCase{
+		{path: "node_modules", isDir: true, ignored: true},
+		{path: "web/app/node_modules", isDir: true, ignored: true},
+		{path: "web/app/node_modules/pkg/index.js", ignored: true},
+		{path: "src/ui/a.test.js", ignored: true},
+		{path: "src/ui/a.js", ignored: false},
+		{path: "test/a.test.js", ignored: false},
+	})
+}
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"*.go", []string{"*.go"}},
+		{"*.{js,ts}", []string{"*.js", "*.ts"}},
+		{"{a,b,c}.txt", []string{"a.txt", "b.txt", "c.txt"}},
+		{"{src,lib}/*.{js,ts}", []string{"src/*.js", "src/*.ts", "lib/*.js", "lib/*.ts"}},
+		{"{src,lib/{a,b}}/*.go", []string{"src/*.go", "lib/a/*.go", "lib/b/*.go"}},
+		{"*.{js,{c,m}js}", []string{"*.js", "*.cjs", "*.mjs"}},
+		{"file{,.bak}", []string{"file", "file.bak"}},
+		{"{single}.txt", []string{"{single}.txt"}},
+		{"{outer,{inner}}", []string{"outer", "{inner}"}},
+		{"*.{js", []string{"*.{js"}},
+		{"*.js}", []string{"*.js}"}},
+		{`\{a,b}.txt`, []string{`\{a,b}.txt`}},
+		{"[{,]x", []string{"[{,]x"}},
+	}
+	for _, tt := range tests {
+		if got := expandBraces(tt.pattern); !slices.Equal(got, tt.want) {
+			t.Errorf("expandBraces(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestMatchBracePatterns(t *testing.T) {
+	m := FromPatterns([]string{"*.{js,ts}", "{build,dist/{cjs,esm}}/", "!keep.{js,ts}", "[ab].{md,txt}"}, t.TempDir())
+	checkMatches(t, m, []matchCase{
+		{path: "app.js", ignored: true},
+		{path: "src/app.ts", ignored: true},
+		{path: "app.go", ignored: false},
+		{path: "app.{js,ts}", ignored: false},
+		{path: "keep.ts", ignored: false},
+		{path: "build", isDir: true, ignored: true},
+		{path: "dist/cjs", isDir: true, ignored: true},
+		{path: "dist/esm/index.mjs", ignored: true},
+		{path: "dist/types", isDir: true, ignored: false},
+		{path: "a.md", ignored: true},
+		{path: "b.txt", ignored: true},
+		{path: "c.md", ignored: false},
+	})
+	// The rule reported is the pattern as written, before expansion
+	if _, rule := m.MatchRule("src/app.ts", false); rule == nil || rule.Pattern != "*.{js,ts}" || rule.Line != 1 {
+		t.Errorf("MatchRule(src/app.ts) rule = %v, want *.{js,ts} on line 1", rule)
+	}
+}
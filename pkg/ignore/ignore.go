@@ -0,0 +1,387 @@
+// Package ignore matches paths against gitignore-style patterns, as the
+// extract, stats and tree commands of copilot do when scanning a directory.
+package ignore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrGitignoreIsDir is returned, wrapped with the offending path, when the
+// gitignore file to load is a directory.
+var ErrGitignoreIsDir = errors.New("gitignore path is a directory, not a file")
+
+// pattern is a single parsed line of a gitignore file.
+type pattern struct {
+	raw      string // Original line as written in the gitignore file
+	pattern  string // Slash-separated glob with "!", leading "/" and trailing "/" removed
+	negate   bool   // Line started with "!" and re-includes matching paths
+	dirOnly  bool   // Line ended with "/" and only matches directories
+	anchored bool   // Matched against the full relative path instead of the base name
+	line     int    // 1-based line number in the gitignore file
+}
+
+// Rule is the pattern that decided whether a path is ignored, see MatchRule.
+type Rule struct {
+	Pattern string // As written in its gitignore file, including any leading "!"
+	Source  string // Path of the gitignore file, empty for patterns given to FromPatterns
+	Line    int    // 1-based line number in Source, or in the patterns given to FromPatterns
+	Negate  bool   // The pattern is a negation, re-including the path
+}
+
+// String formats r as "source:line:pattern", as git check-ignore -v does,
+// or as the pattern alone when it does not come from a file.
+func (r Rule) String() string {
+	if r.Source == "" {
+		return r.Pattern
+	}
+	return fmt.Sprintf("%s:%d:%s", r.Source, r.Line, r.Pattern)
+}
+
+// parsePattern parses a gitignore line. It returns false for blank lines and
+// comments.
+func parsePattern(line string) (pattern, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern{}, false
+	}
+
+	p := pattern{raw: line}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		// A leading backslash escapes a literal "!" or "#"
+		line = line[1:]
+	}
+
+	p.dirOnly = strings.HasSuffix(line, "/")
+	line = strings.TrimSuffix(line, "/")
+
+	cleanPattern := filepath.ToSlash(line)
+	// A leading or middle slash anchors the pattern to the .gitignore directory
+	p.anchored = strings.Contains(cleanPattern, "/")
+	p.pattern = strings.TrimPrefix(cleanPattern, "/")
+	if p.pattern == "" {
+		return pattern{}, false
+	}
+	return p, true
+}
+
+// source is the ordered list of patterns read from one gitignore file,
+// scoped to the directory the file applies to.
+type source struct {
+	path     string    // Path of the gitignore file, empty for in-memory patterns
+	dirAbs   string    // Absolute path of the directory the patterns are relative to
+	patterns []pattern // In file order, including negations; the last match wins
+}
+
+// loadSource reads the gitignore file at gitignorePathAbs, scoping its
+// patterns to dirAbs. A missing file yields an empty source.
+func loadSource(gitignorePathAbs, dirAbs string) (source, error) {
+	lines, err := readIgnoreFile(gitignorePathAbs)
+	if err != nil {
+		return source{path: gitignorePathAbs, dirAbs: dirAbs}, err
+	}
+	return newSource(lines, gitignorePathAbs, dirAbs), nil
+}
+
+// newSource parses the gitignore lines read from the file at sourcePath,
+// scoping their patterns to dirAbs. Blank lines and comments are skipped.
+// A line with brace groups, such as "*.{js,ts}", gives one pattern per
+// expansion (see expandBraces), each anchored or not on its own, and all
+// reported as the original line.
+func newSource(lines []string, sourcePath, dirAbs string) source {
+	s := source{path: sourcePath, dirAbs: dirAbs}
+	for i, line := range lines {
+		for _, expanded := range expandBraces(line) {
+			if p, ok := parsePattern(expanded); ok {
+				p.raw, p.line = strings.TrimSpace(line), i+1
+				s.patterns = append(s.patterns, p)
+			}
+		}
+	}
+	return s
+}
+
+// readIgnoreFile returns the lines of the gitignore file at gitignorePathAbs.
+// A missing file has no lines.
+func readIgnoreFile(gitignorePathAbs string) ([]string, error) {
+	fileInfo, err := os.Stat(gitignorePathAbs)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to stat gitignore file '%s': %w", gitignorePathAbs, err)
+	}
+
+	if fileInfo.IsDir() {
+		return nil, fmt.Errorf("%w: '%s'", ErrGitignoreIsDir, gitignorePathAbs)
+	}
+
+	file, err := os.Open(gitignorePathAbs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gitignore file '%s': %w", gitignorePathAbs, err)
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read gitignore file '%s': %w", gitignorePathAbs, err)
+	}
+
+	return lines, nil
+}
+
+// relPath returns absPath relative to the source directory in slash form.
+// It returns false when absPath is the directory itself or lies outside it,
+// since patterns only apply below the directory holding the gitignore file.
+func (s source) relPath(absPath string) (string, bool) {
+	rel, err := filepath.Rel(s.dirAbs, absPath)
+	if err != nil {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	return rel, true
+}
+
+// match evaluates the patterns against relPath, starting from the outcome
+// decided by lower-precedence sources and the rule by that decided it. The
+// last matching pattern decides, so a negation can re-include a path excluded
+// by an earlier pattern and vice versa; it is returned along with the
+// outcome. The patterns are tried from the last one, so that an earlier
+// pattern agreeing with it is not reported instead. Matching follows the case
+// sensitivity of m, which also reports malformed patterns.
+func (s source) match(relPath string, isDir bool, ignored bool, by *Rule, m *Matcher) (bool, *Rule) {
+	for i := len(s.patterns) - 1; i >= 0; i-- {
+		p := s.patterns[i]
+		if p.dirOnly && !isDir {
+			continue
+		}
+
+		target := relPath
+		if !p.anchored {
+			// Pattern does not contain a directory separator, match against any path component
+			target = path.Base(relPath)
+		}
+
+		glob := p.pattern
+		if m.IgnoreCase {
+			glob, target = strings.ToLower(glob), strings.ToLower(target)
+		}
+
+		matched, matchErr := matchGlob(glob, target)
+		if matchErr != nil {
+			m.warnf("malformed gitignore pattern '%s' (processed as '%s'): %v", p.raw, p.pattern, matchErr)
+			continue
+		}
+
+		if matched {
+			return !p.negate, &Rule{Pattern: p.raw, Source: s.path, Line: p.line, Negate: p.negate}
+		}
+	}
+	return ignored, by
+}
+
+// Matcher holds gitignore patterns and logic.
+type Matcher struct {
+	sources          []source // Ordered by increasing precedence; nested .gitignore files come last
+	gitignoreRootAbs string   // Absolute path to the directory containing the .gitignore file
+
+	// Warnf reports malformed patterns. When nil, they are printed to
+	// stderr with a "Warning: " prefix.
+	Warnf func(format string, args ...any)
+
+	// IgnoreCase makes patterns match case-insensitively; Git's default is
+	// case-sensitive.
+	IgnoreCase bool
+}
+
+// New creates a new Matcher.
+// customGitignorePath is the user-provided path to a .gitignore file (can be empty).
+// scanDirAbs is the absolute path to the root directory being scanned.
+// Patterns are relative to the directory holding the .gitignore file, or to
+// scanDirAbs when a custom file lies outside of it.
+func New(customGitignorePath, scanDirAbs string) (*Matcher, error) {
+	effectiveGitignorePath := customGitignorePath
+	if effectiveGitignorePath == "" {
+		effectiveGitignorePath = filepath.Join(scanDirAbs, ".gitignore")
+	} else {
+		absPath, err := filepath.Abs(effectiveGitignorePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for custom gitignore '%s': %w", effectiveGitignorePath, err)
+		}
+		effectiveGitignorePath = absPath
+	}
+
+	// Only paths inside scanDirAbs are checked, which patterns relative to
+	// the directory of a custom file outside of it would never match.
+	rootAbs := filepath.Dir(effectiveGitignorePath)
+	if !isWithin(scanDirAbs, rootAbs) {
+		rootAbs = scanDirAbs
+	}
+
+	lines, err := readIgnoreFile(effectiveGitignorePath)
+	if err != nil {
+		return nil, err
+	}
+	return fromSource(newSource(lines, effectiveGitignorePath, rootAbs)), nil
+}
+
+// FromPatterns creates a Matcher from in-memory patterns, one gitignore line
+// each, without reading any file. root is the directory the patterns are
+// relative to, as the directory holding a .gitignore file would be; a
+// relative root is resolved against the current working directory. Blank
+// lines and comments are skipped.
+func FromPatterns(patterns []string, root string) *Matcher {
+	if rootAbs, err := filepath.Abs(root); err == nil {
+		root = rootAbs
+	}
+	return fromSource(newSource(patterns, "", root))
+}
+
+// fromSource creates a Matcher rooted at the directory of s, with s as its
+// only source unless it has no patterns.
+func fromSource(s source) *Matcher {
+	matcher := &Matcher{gitignoreRootAbs: s.dirAbs}
+	if len(s.patterns) > 0 {
+		matcher.sources = append(matcher.sources, s)
+	}
+	return matcher
+}
+
+// Root returns the absolute path of the directory the paths given to Match
+// are relative to.
+func (m *Matcher) Root() string {
+	return m.gitignoreRootAbs
+}
+
+// warnf reports a malformed pattern through m.Warnf.
+func (m *Matcher) warnf(format string, args ...any) {
+	if m.Warnf == nil {
+		fmt.Fprintf(os.Stderr, "Warning: "+format+"\n", args...)
+		return
+	}
+	m.Warnf(format, args...)
+}
+
+// extend returns a copy of m with s added as its highest-precedence source.
+func (m *Matcher) extend(s source) *Matcher {
+	extended := &Matcher{
+		sources:          make([]source, 0, len(m.sources)+1),
+		gitignoreRootAbs: m.gitignoreRootAbs,
+		Warnf:            m.Warnf,
+		IgnoreCase:       m.IgnoreCase,
+	}
+	extended.sources = append(extended.sources, m.sources...)
+	extended.sources = append(extended.sources, s)
+	return extended
+}
+
+// withIgnoreFile returns a matcher extending m with the patterns of the
+// gitignore-style file at ignorePathAbs, relative to dirAbs. They take
+// precedence over those of m. m itself is left unchanged.
+func (m *Matcher) withIgnoreFile(ignorePathAbs, dirAbs string) (*Matcher, error) {
+	s, err := loadSource(ignorePathAbs, dirAbs)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.patterns) == 0 {
+		return m, nil
+	}
+	return m.extend(s), nil
+}
+
+// WithNestedGitignore returns a matcher extending m with the .gitignore file
+// found in dirAbs, if any. The patterns of the nested file take precedence
+// over those of m but only apply inside dirAbs. m itself is left unchanged,
+// so the nested rules never leak to sibling directories.
+func (m *Matcher) WithNestedGitignore(dirAbs string) (*Matcher, error) {
+	return m.withIgnoreFile(filepath.Join(dirAbs, ".gitignore"), dirAbs)
+}
+
+// IsIgnored checks if a given path should be ignored based on the loaded patterns.
+// absItemPath is the absolute path to the item (file or directory).
+// itemIsDir indicates if the item is a directory.
+// It is Match with absItemPath made relative to the gitignore root.
+func (m *Matcher) IsIgnored(absItemPath string, itemIsDir bool) (bool, error) {
+	ignored, _, err := m.IsIgnoredRule(absItemPath, itemIsDir)
+	return ignored, err
+}
+
+// IsIgnoredRule is IsIgnored, also returning the rule that decided, as
+// MatchRule does.
+func (m *Matcher) IsIgnoredRule(absItemPath string, itemIsDir bool) (ignored bool, rule *Rule, err error) {
+	relPath, err := filepath.Rel(m.gitignoreRootAbs, absItemPath)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to make '%s' relative to '%s': %w", absItemPath, m.gitignoreRootAbs, err)
+	}
+	ignored, rule = m.MatchRule(filepath.ToSlash(relPath), itemIsDir)
+	return ignored, rule, nil
+}
+
+// Match reports whether the path relPath, relative to the directory holding
+// the gitignore file (the scan root when it was not given explicitly), is
+// ignored. relPath uses forward slashes, as gitignore patterns do; isDir
+// tells whether it is a directory, for patterns ending with "/".
+// As in Git, a path inside an ignored directory is ignored even if a later
+// negation pattern matches it; the directory itself must be re-included first.
+func (m *Matcher) Match(relPath string, isDir bool) bool {
+	ignored, _ := m.MatchRule(relPath, isDir)
+	return ignored
+}
+
+// MatchRule is Match, also returning the rule that decided: the last pattern
+// matching relPath, in the highest-precedence source, which is a negation
+// when relPath is re-included; or the pattern ignoring a parent directory of
+// relPath, since a negation cannot re-include a path inside it. rule is nil
+// when no pattern matches.
+func (m *Matcher) MatchRule(relPath string, isDir bool) (ignored bool, rule *Rule) {
+	if len(m.sources) == 0 {
+		return false, nil
+	}
+
+	absItemPath := filepath.Join(m.gitignoreRootAbs, filepath.FromSlash(relPath))
+	for dir := filepath.Dir(absItemPath); m.covers(dir); dir = filepath.Dir(dir) {
+		if ignored, rule := m.matchPath(dir, true); ignored {
+			return true, rule
+		}
+	}
+	return m.matchPath(absItemPath, isDir)
+}
+
+// covers reports whether absPath lies strictly inside the directory of at
+// least one source, i.e. whether any pattern could apply to it.
+func (m *Matcher) covers(absPath string) bool {
+	for _, s := range m.sources {
+		if _, ok := s.relPath(absPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath evaluates every source in precedence order against absPath,
+// without looking at its parent directories, and returns the outcome along
+// with the rule that decided it.
+func (m *Matcher) matchPath(absPath string, isDir bool) (ignored bool, by *Rule) {
+	for _, s := range m.sources {
+		if relPath, ok := s.relPath(absPath); ok {
+			ignored, by = s.match(relPath, isDir, ignored, by, m)
+		}
+	}
+	return ignored, by
+}
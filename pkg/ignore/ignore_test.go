@@ -0,0 +1,647 @@
+package ignore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// matchCase is a path checked against a matcher, with the expected outcome.
+type matchCase struct {
+	path    string
+	isDir   bool
+	ignored bool
+}
+
+// checkMatches runs each case against a matcher built from patterns.
+func checkMatches(t *testing.T, m *Matcher, cases []matchCase) {
+	t.Helper()
+	for _, c := range cases {
+		if got := m.Match(c.path, c.isDir); got != c.ignored {
+			t.Errorf("Match(%q, %v) = %v, want %v", c.path, c.isDir, got, c.ignored)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	patterns := []string{"*.log", "/dist", "tmp/", "docs/**/*.bak", "a/*/c.txt", "!important.log"}
+	cases := []matchCase{
+		{path: "debug.log", ignored: true},
+		{path: "sub/debug.log", ignored: true},
+		{path: "important.log", ignored: false},
+		{path: "dist", isDir: true, ignored: true},
+		{path: "dist/app.js", ignored: true},
+		{path: "sub/dist", isDir: true, ignored: false},
+		{path: "tmp", isDir: true, ignored: true},
+		{path: "tmp", ignored: false},
+		{path: "sub/tmp/x", ignored: true},
+		{path: "docs/a/b/old.bak", ignored: true},
+		{path: "docs/old.bak", ignored: true},
+		{path: "old.bak", ignored: false},
+		{path: "a/b/c.txt", ignored: true},
+		{path: "a/b/d/c.txt", ignored: false},
+		{path: "main.go", ignored: false},
+	}
+	root := t.TempDir()
+	m := FromPatterns(patterns, root)
+	checkMatches(t, m, cases)
+
+	// IsIgnored is Match with the path made relative to the root.
+	for _, c := range cases {
+		got, err := m.IsIgnored(filepath.Join(root, filepath.FromSlash(c.path)), c.isDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.ignored {
+			t.Errorf("IsIgnored(%q, %v) = %v, want %v", c.path, c.isDir, got, c.ignored)
+		}
+	}
+}
+
+func TestMatchRule(t *testing.T) {
+	m := FromPatterns([]string{
+		"*.log",     // 1
+		"# comment", // 2
+		"debug/",    // 3
+		"!keep.log", // 4
+		"*.log",     // 5, overrides the negation above
+		"!important.log",
+		"build/",
+		"!build/keep.txt",
+	}, t.TempDir())
+	tests := []struct {
+		path        string
+		isDir       bool
+		wantIgnored bool
+		wantRule    string // Empty when no pattern matches
+		wantLine    int
+	}{
+		{path: "main.go"},
+		{path: "a.log", wantIgnored: true, wantRule: "*.log", wantLine: 5},
+		{path: "keep.log", wantIgnored: true, wantRule: "*.log", wantLine: 5},
+		{path: "important.log", wantRule: "!important.log", wantLine: 6},
+		{path: "debug", isDir: true, wantIgnored: true, wantRule: "debug/", wantLine: 3},
+		{path: "debug", wantIgnored: false},
+		{path: "debug/important.log", wantIgnored: true, wantRule: "debug/", wantLine: 3},
+		{path: "build/keep.txt", wantIgnored: true, wantRule: "build/", wantLine: 7},
+	}
+	for _, tt := range tests {
+		ignored, rule := m.MatchRule(tt.path, tt.isDir)
+		if ignored != tt.wantIgnored {
+			t.Errorf("MatchRule(%q, %v) ignored = %v, want %v", tt.path, tt.isDir, ignored, tt.wantIgnored)
+		}
+		if ignored != m.Match(tt.path, tt.isDir) {
+			t.Errorf("Match(%q, %v) disagrees with MatchRule", tt.path, tt.isDir)
+		}
+		switch {
+		case tt.wantRule == "" && rule != nil:
+			t.Errorf("MatchRule(%q, %v) rule = %+v, want none", tt.path, tt.isDir, *rule)
+		case tt.wantRule != "" && (rule == nil || rule.Pattern != tt.wantRule || rule.Line != tt.wantLine || rule.Negate != strings.HasPrefix(tt.wantRule, "!")):
+			t.Errorf("MatchRule(%q, %v) rule = %+v, want %q on line %d", tt.path, tt.isDir, rule, tt.wantRule, tt.wantLine)
+		}
+	}
+}
+
+func TestIsIgnoredRuleSources(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".gitignore":     "*.tmp\n!*.keep.tmp\n",
+		"sub/.gitignore": "*.keep.tmp\n",
+	})
+	m, err := New("", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested, err := m.WithNestedGitignore(filepath.Join(root, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path        string
+		wantIgnored bool
+		wantRule    string // As formatted by Rule.String
+	}{
+		{path: "a.tmp", wantIgnored: true, wantRule: filepath.Join(root, ".gitignore") + ":1:*.tmp"},
+		{path: "a.keep.tmp", wantRule: filepath.Join(root, ".gitignore") + ":2:!*.keep.tmp"},
+		// The nested file takes precedence over the root one
+		{path: "sub/a.keep.tmp", wantIgnored: true, wantRule: filepath.Join(root, "sub", ".gitignore") + ":1:*.keep.tmp"},
+		{path: "sub/a.tmp", wantIgnored: true, wantRule: filepath.Join(root, ".gitignore") + ":1:*.tmp"},
+	}
+	for _, tt := range tests {
+		ignored, rule, err := nested.IsIgnoredRule(filepath.Join(root, filepath.FromSlash(tt.path)), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ignored != tt.wantIgnored || rule == nil || rule.String() != tt.wantRule {
+			t.Errorf("IsIgnoredRule(%q) = %v, %v, want %v, %s", tt.path, ignored, rule, tt.wantIgnored, tt.wantRule)
+		}
+	}
+}
+
+func TestFromPatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		cases    []matchCase
+	}{
+		{
+			name:     "no patterns",
+			patterns: nil,
+			cases:    []matchCase{{path: "a.log", ignored: false}, {path: "dir", isDir: true, ignored: false}},
+		},
+		{
+			name:     "blank lines and comments are skipped",
+			patterns: []string{"", "# *.go", "   ", "*.log"},
+			cases:    []matchCase{{path: "main.go", ignored: false}, {path: "a.log", ignored: true}, {path: "# *.go", ignored: false}},
+		},
+		{
+			name:     "escaped hash and trailing spaces",
+			patterns: []string{`\#notes`, "trail   "},
+			cases:    []matchCase{{path: "#notes", ignored: true}, {path: "trail", ignored: true}, {path: "trail   ", ignored: false}},
+		},
+		{
+			name:     "later patterns override earlier ones",
+			patterns: []string{"*.txt", "!keep.txt", "keep.txt"},
+			cases:    []matchCase{{path: "a.txt", ignored: true}, {path: "keep.txt", ignored: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkMatches(t, FromPatterns(tt.patterns, t.TempDir()), tt.cases)
+		})
+	}
+}
+
+func TestFromPatternsRoot(t *testing.T) {
+	t.Chdir(t.TempDir())
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := FromPatterns([]string{"/build"}, "sub")
+	if got, want := m.Root(), filepath.Join(dir, "sub"); got != want {
+		t.Errorf("Root() = %q, want %q", got, want)
+	}
+	for _, tc := range []struct {
+		path    string
+		ignored bool
+	}{
+		{filepath.Join(dir, "sub", "build"), true},
+		{filepath.Join(dir, "build"), false},
+	} {
+		got, err := m.IsIgnored(tc.path, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.ignored {
+			t.Errorf("IsIgnored(%q) = %v, want %v", tc.path, got, tc.ignored)
+		}
+	}
+}
+
+// TestNewMatchesFromPatterns checks that New, reading a .gitignore file,
+// matches as FromPatterns does given the lines of that file.
+func TestNewMatchesFromPatterns(t *testing.T) {
+	patterns := []string{"*.log", "!keep.log", "/out/", "docs/**/*.tmp"}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".gitignore"), []byte(strings.Join(patterns, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fromFile, err := New("", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromPatterns := FromPatterns(patterns, dir)
+	for _, c := range []matchCase{
+		{path: "a.log"}, {path: "keep.log"}, {path: "out", isDir: true}, {path: "sub/out", isDir: true},
+		{path: "docs/x/y.tmp"}, {path: "main.go"},
+	} {
+		if got, want := fromFile.Match(c.path, c.isDir), fromPatterns.Match(c.path, c.isDir); got != want {
+			t.Errorf("Match(%q, %v) = %v from the file, %v from the patterns", c.path, c.isDir, got, want)
+		}
+	}
+}
+
+func TestMatchNegation(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		cases    []matchCase
+	}{
+		{
+			name:     "negation re-includes a file",
+			patterns: []string{"*.log", "!keep.log"},
+			cases: []matchCase{
+				{path: "debug.log", ignored: true},
+				{path: "keep.log", ignored: false},
+				{path: "sub/keep.log", ignored: false},
+				{path: "main.go", ignored: false},
+			},
+		},
+		{
+			name:     "order matters, a later pattern overrides the negation",
+			patterns: []string{"!keep.log", "*.log"},
+			cases: []matchCase{
+				{path: "keep.log", ignored: true},
+				{path: "debug.log", ignored: true},
+			},
+		},
+		{
+			name:     "multiple overriding negations, the last match wins",
+			patterns: []string{"*.log", "!*.log", "debug.log", "!debug.log", "trace.log"},
+			cases: []matchCase{
+				{path: "debug.log", ignored: false},
+				{path: "trace.log", ignored: true},
+				{path: "info.log", ignored: false},
+			},
+		},
+		{
+			name:     "files inside an ignored directory cannot be re-included",
+			patterns: []string{"build/", "!build/keep.txt"},
+			cases: []matchCase{
+				{path: "build", isDir: true, ignored: true},
+				{path: "build/keep.txt", ignored: true},
+				{path: "build/out.o", ignored: true},
+			},
+		},
+		{
+			name:     "re-including the directory contents instead of the directory",
+			patterns: []string{"build/*", "!build/keep.txt"},
+			cases: []matchCase{
+				{path: "build", isDir: true, ignored: false},
+				{path: "build/keep.txt", ignored: false},
+				{path: "build/out.o", ignored: true},
+			},
+		},
+		{
+			name:     "re-included directory",
+			patterns: []string{"vendor/", "!vendor/"},
+			cases: []matchCase{
+				{path: "vendor", isDir: true, ignored: false},
+				{path: "vendor/lib.go", ignored: false},
+			},
+		},
+		{
+			name:     "escaped exclamation mark is a literal pattern",
+			patterns: []string{`\!important.txt`},
+			cases: []matchCase{
+				{path: "!important.txt", ignored: true},
+				{path: "important.txt", ignored: false},
+			},
+		},
+		{
+			name:     "negation without a prior match changes nothing",
+			patterns: []string{"!main.go"},
+			cases: []matchCase{
+				{path: "main.go", ignored: false},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkMatches(t, FromPatterns(tt.patterns, t.TempDir()), tt.cases)
+		})
+	}
+}
+
+func TestMatchIgnoreCase(t *testing.T) {
+	patterns := []string{"*.PNG", "Build/", "/README.md", "docs/**/*.Tmp"}
+	tests := []struct {
+		name       string
+		ignoreCase bool
+		cases      []matchCase
+	}{
+		{
+			name: "case-sensitive by default",
+			cases: []matchCase{
+				{path: "logo.PNG", ignored: true},
+				{path: "logo.png", ignored: false},
+				{path: "Build", isDir: true, ignored: true},
+				{path: "build", isDir: true, ignored: false},
+				{path: "README.md", ignored: true},
+				{path: "readme.MD", ignored: false},
+				{path: "docs/a/b.Tmp", ignored: true},
+				{path: "DOCS/a/b.tmp", ignored: false},
+			},
+		},
+		{
+			name:       "case-insensitive",
+			ignoreCase: true,
+			cases: []matchCase{
+				{path: "logo.PNG", ignored: true},
+				{path: "logo.png", ignored: true},
+				{path: "Logo.Png", ignored: true},
+				{path: "build", isDir: true, ignored: true},
+				{path: "BUILD/out.o", ignored: true},
+				{path: "readme.MD", ignored: true},
+				{path: "sub/README.md", ignored: false},
+				{path: "DOCS/a/b.tmp", ignored: true},
+				{path: "main.go", ignored: false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := FromPatterns(patterns, t.TempDir())
+			m.IgnoreCase = tt.ignoreCase
+			checkMatches(t, m, tt.cases)
+		})
+	}
+}
+
+func TestNestedMatcherKeepsIgnoreCase(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"sub/.gitignore": "*.LOG\n"})
+	m := FromPatterns([]string{"*.TMP"}, root)
+	m.IgnoreCase = true
+	nested, err := m.WithNestedGitignore(filepath.Join(root, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMatches(t, nested, []matchCase{
+		{path: "sub/debug.log", ignored: true},
+		{path: "sub/a.tmp", ignored: true},
+		{path: "debug.log", ignored: false},
+	})
+}
+
+func TestNew(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".gitignore":         "*.log\n/build/\n",
+		"shared/ignore-list": "*.tmp\n",
+	})
+	tests := []struct {
+		name       string
+		customPath string
+		wantRoot   string
+		cases      []matchCase
+	}{
+		{
+			name:     "gitignore of the scan directory",
+			wantRoot: root,
+			cases: []matchCase{
+				{path: "debug.log", ignored: true},
+				{path: "build", isDir: true, ignored: true},
+				{path: "src/build", isDir: true, ignored: false},
+				{path: "a.tmp", ignored: false},
+			},
+		},
+		{
+			name:       "custom file, relative to its own directory",
+			customPath: filepath.Join(root, "shared", "ignore-list"),
+			wantRoot:   filepath.Join(root, "shared"),
+			cases: []matchCase{
+				{path: "a.tmp", ignored: true},
+				{path: "debug.log", ignored: false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := New(tt.customPath, root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Root() != tt.wantRoot {
+				t.Errorf("Root() = %q, want %q", m.Root(), tt.wantRoot)
+			}
+			checkMatches(t, m, tt.cases)
+			for _, c := range tt.cases {
+				got, err := m.IsIgnored(filepath.Join(m.Root(), filepath.FromSlash(c.path)), c.isDir)
+				if err != nil || got != c.ignored {
+					t.Errorf("IsIgnored(%q) = %v, %v, want %v", c.path, got, err, c.ignored)
+				}
+			}
+		})
+	}
+}
+
+func TestWithNestedGitignore(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a/.gitignore": "!keep.log\n*.tmp\n",
+		"b/file.txt":   "",
+	})
+	m := FromPatterns([]string{"*.log"}, root)
+	nested, err := m.WithNestedGitignore(filepath.Join(root, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMatches(t, nested, []matchCase{
+		{path: "a/keep.log", ignored: false},
+		{path: "a/other.log", ignored: true},
+		{path: "a/x.tmp", ignored: true},
+		{path: "b/x.tmp", ignored: false},
+		{path: "b/keep.log", ignored: true},
+	})
+	// m itself is unchanged
+	checkMatches(t, m, []matchCase{{path: "a/keep.log", ignored: true}, {path: "a/x.tmp", ignored: false}})
+
+	without, err := m.WithNestedGitignore(filepath.Join(root, "b"))
+	if err != nil || without != m {
+		t.Errorf("WithNestedGitignore() without a .gitignore = %p, %v, want m itself", without, err)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "dir.gitignore"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		customPath string
+		wantIsDir  bool
+	}{
+		{name: "missing file", customPath: filepath.Join(dir, "missing.gitignore")},
+		{name: "directory", customPath: filepath.Join(dir, "dir.gitignore"), wantIsDir: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := New(tt.customPath, dir)
+			if !tt.wantIsDir {
+				if err != nil || m == nil {
+					t.Fatalf("New() = %v, %v, want an empty matcher", m, err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrGitignoreIsDir) {
+				t.Fatalf("New() error = %v, want ErrGitignoreIsDir", err)
+			}
+			if !strings.Contains(err.Error(), tt.customPath) {
+				t.Errorf("New() error = %q, want it to name %s", err, tt.customPath)
+			}
+		})
+	}
+}
+
+// TestMatchAnchoredDirectory checks that an anchored pattern matching a
+// directory ignores everything below it, as in Git, while leaving
+// directories of the same name elsewhere alone.
+func TestMatchAnchoredDirectory(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		cases    []matchCase
+	}{
+		{
+			name:     "/build",
+			patterns: []string{"/build"},
+			cases: []matchCase{
+				{path: "build", isDir: true, ignored: true},
+				{path: "build/out.o", ignored: true},
+				{path: "build/out/x.o", ignored: true},
+				{path: "build/out/deep/er/x.o", ignored: true},
+				{path: "src/build", isDir: true, ignored: false},
+				{path: "src/build/out/x.o", ignored: false},
+				{path: "builder/x.o", ignored: false},
+			},
+		},
+		{
+			name:     "/build/ only matches the directory",
+			patterns: []string{"/build/"},
+			cases: []matchCase{
+				{path: "build", ignored: false},
+				{path: "build/out/x.o", ignored: true},
+				{path: "src/build/out/x.o", ignored: false},
+			},
+		},
+		{
+			name:     "/build/out",
+			patterns: []string{"/build/out"},
+			cases: []matchCase{
+				{path: "build/out/x.o", ignored: true},
+				{path: "build/other/x.o", ignored: false},
+				{path: "src/build/out/x.o", ignored: false},
+			},
+		},
+		{
+			name:     "/build/* with a negation",
+			patterns: []string{"/build/*", "!/build/keep"},
+			cases: []matchCase{
+				{path: "build/out/x.o", ignored: true},
+				{path: "build/keep/x.o", ignored: false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			m := FromPatterns(tt.patterns, root)
+			checkMatches(t, m, tt.cases)
+			for _, c := range tt.cases {
+				got, err := m.IsIgnored(filepath.Join(root, filepath.FromSlash(c.path)), c.isDir)
+				if err != nil || got != c.ignored {
+					t.Errorf("IsIgnored(%q) = %v, %v, want %v", c.path, got, err, c.ignored)
+				}
+			}
+		})
+	}
+}
+
+// TestMatchUnanchoredDirectory checks that a directory pattern without a
+// leading or inner slash ignores directories of that name at any depth.
+func TestMatchUnanchoredDirectory(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		cases    []matchCase
+	}{
+		{
+			name:     "node_modules/",
+			patterns: []string{"node_modules/"},
+			cases: []matchCase{
+				{path: "node_modules", isDir: true, ignored: true},
+				{path: "node_modules/react/index.js", ignored: true},
+				{path: "a/node_modules", isDir: true, ignored: true},
+				{path: "a/node_modules/react/index.js", ignored: true},
+				{path: "a/b/node_modules", isDir: true, ignored: true},
+				{path: "packages/web/app/node_modules/lodash/lodash.js", ignored: true},
+				{path: "node_modules/pkg/node_modules/dep/index.js", ignored: true},
+				{path: "a/node_modules", isDir: false, ignored: false},
+				{path: "a/node_modules_old", isDir: true, ignored: false},
+				{path: "a/my_node_modules/x.js", ignored: false},
+			},
+		},
+		{
+			name:     "node_modules, files too",
+			patterns: []string{"node_modules"},
+			cases: []matchCase{
+				{path: "a/b/node_modules", isDir: false, ignored: true},
+				{path: "a/b/node_modules/x.js", ignored: true},
+			},
+		},
+		{
+			name:     "re-included at one depth only",
+			patterns: []string{"node_modules/", "!/tools/node_modules/"},
+			cases: []matchCase{
+				{path: "tools/node_modules/x.js", ignored: false},
+				{path: "tools/sub/node_modules/x.js", ignored: true},
+				{path: "web/node_modules/x.js", ignored: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkMatches(t, FromPatterns(tt.patterns, t.TempDir()), tt.cases)
+		})
+	}
+}
+
+func TestNestedNodeModulesAcrossFiles(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".gitignore":          "node_modules/\n",
+		"web/.gitignore":      "*.log\n",
+		"web/app/placeholder": "",
+	})
+	m, err := New("", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested, err := m.WithNestedGitignore(filepath.Join(root, "web"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMatches(t, nested, []matchCase{
+		{path: "web/node_modules", isDir: true, ignored: true},
+		{path: "web/app/node_modules/dep/index.js", ignored: true},
+		{path: "web/app/index.js", ignored: false},
+	})
+}
+
+// TestNewOutsideScanDir checks that the patterns of a custom
+// gitignore file lying outside the scanned directory apply relative to the
+// scanned directory.
+func TestNewOutsideScanDir(t *testing.T) {
+	dir := t.TempDir()
+	scanDir := filepath.Join(dir, "project")
+	customPath := filepath.Join(dir, "config", "ignore-list")
+	if err := os.MkdirAll(filepath.Dir(customPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(customPath, []byte("*.log\n!keep.log\n/build\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(customPath, scanDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []matchCase{
+		{path: "debug.log", ignored: true},
+		{path: "src/debug.log", ignored: true},
+		{path: "keep.log", ignored: false},
+		{path: "build", isDir: true, ignored: true},
+		{path: "src/build", isDir: true, ignored: false},
+	}
+	for _, c := range cases {
+		got, err := m.IsIgnored(filepath.Join(scanDir, filepath.FromSlash(c.path)), c.isDir)
+		if err != nil || got != c.ignored {
+			t.Errorf("IsIgnored(%q, %v) = %v, %v, want %v", c.path, c.isDir, got, err, c.ignored)
+		}
+	}
+}
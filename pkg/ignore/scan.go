@@ -0,0 +1,143 @@
+package ignore
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// CopilotignoreName is the name of the tool-specific ignore file read from
+// the scan root, in the same format as .gitignore.
+const CopilotignoreName = ".copilotignore"
+
+// Options selects the ignore files used to scan a directory.
+type Options struct {
+	GitignorePath   string   // Custom .gitignore file, see New
+	ExtraGitignores []string // Files whose patterns are added on top, see --gitignore-extra
+	Copilotignore   bool     // Read the .copilotignore file of the scan root
+	GitExcludes     bool     // Read .git/info/exclude and the global excludes file
+	IgnoreCase      bool     // Match patterns case-insensitively
+	FromRoot        bool     // Also read the .gitignore files of the ancestors of the scan root, up to the repository root
+}
+
+// NewScanMatcher creates the Matcher used to scan scanDirAbs. In increasing
+// order of precedence, its sources are: with opts.GitExcludes, the global
+// excludes file and .git/info/exclude, both relative to the repository root
+// as in Git; with opts.FromRoot, the .gitignore files of the ancestors of
+// scanDirAbs, from the repository root down; the .gitignore selected by
+// opts.GitignorePath (see New); with opts.Copilotignore, the .copilotignore
+// file of scanDirAbs; and the opts.ExtraGitignores files in order. Patterns
+// of an extra file are relative to its own directory when it lies inside
+// scanDirAbs, and to scanDirAbs otherwise. The repository root is scanDirAbs
+// unless opts.FromRoot is set, in which case it is found with FindRepoRoot.
+func NewScanMatcher(opts Options, scanDirAbs string) (*Matcher, error) {
+	matcher, err := New(opts.GitignorePath, scanDirAbs)
+	if err != nil {
+		return nil, err
+	}
+	matcher.IgnoreCase = opts.IgnoreCase
+
+	repoRootAbs := scanDirAbs
+	if opts.FromRoot {
+		var ancestorSources []source
+		repoRootAbs = FindRepoRoot(scanDirAbs)
+		for dirAbs := scanDirAbs; dirAbs != repoRootAbs; {
+			dirAbs = filepath.Dir(dirAbs)
+			s, err := loadSource(filepath.Join(dirAbs, ".gitignore"), dirAbs)
+			if err != nil {
+				return nil, err
+			}
+			if len(s.patterns) > 0 {
+				// Ancestors closer to the scan root take precedence
+				ancestorSources = append([]source{s}, ancestorSources...)
+			}
+		}
+		matcher.sources = append(ancestorSources, matcher.sources...)
+	}
+
+	if opts.GitExcludes {
+		var excludeSources []source
+		for _, excludesPath := range []string{globalExcludesFile(), filepath.Join(repoRootAbs, ".git", "info", "exclude")} {
+			if excludesPath == "" {
+				continue
+			}
+			s, err := loadSource(excludesPath, repoRootAbs)
+			if err != nil {
+				return nil, err
+			}
+			if len(s.patterns) > 0 {
+				excludeSources = append(excludeSources, s)
+			}
+		}
+		matcher.sources = append(excludeSources, matcher.sources...)
+	}
+
+	if opts.Copilotignore {
+		if matcher, err = matcher.withIgnoreFile(filepath.Join(scanDirAbs, CopilotignoreName), scanDirAbs); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, extraPath := range opts.ExtraGitignores {
+		extraPathAbs, err := filepath.Abs(extraPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for extra gitignore '%s': %w", extraPath, err)
+		}
+		if _, err := os.Stat(extraPathAbs); err != nil {
+			return nil, fmt.Errorf("failed to stat extra gitignore file '%s': %w", extraPath, err)
+		}
+		dirAbs := filepath.Dir(extraPathAbs)
+		if !isWithin(scanDirAbs, dirAbs) {
+			dirAbs = scanDirAbs
+		}
+		if matcher, err = matcher.withIgnoreFile(extraPathAbs, dirAbs); err != nil {
+			return nil, err
+		}
+	}
+	return matcher, nil
+}
+
+// FindRepoRoot returns the closest directory holding a .git entry among
+// dirAbs and its ancestors, or the filesystem root if there is none.
+func FindRepoRoot(dirAbs string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dirAbs, ".git")); err == nil {
+			return dirAbs
+		}
+		parent := filepath.Dir(dirAbs)
+		if parent == dirAbs {
+			return dirAbs
+		}
+		dirAbs = parent
+	}
+}
+
+// globalExcludesFile returns the path of the user's global Git excludes
+// file: core.excludesFile if set, $XDG_CONFIG_HOME/git/ignore or
+// ~/.config/git/ignore otherwise. It returns an empty string when none can be
+// determined.
+func globalExcludesFile() string {
+	out, err := exec.Command("git", "config", "--path", "--get", "core.excludesFile").Output()
+	if err == nil && strings.TrimSpace(string(out)) != "" {
+		return strings.TrimSpace(string(out))
+	}
+	if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+		return filepath.Join(configHome, "git", "ignore")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "git", "ignore")
+}
+
+// isWithin reports whether the absolute path target is baseAbs or lies below it.
+func isWithin(baseAbs, target string) bool {
+	rel, err := filepath.Rel(baseAbs, target)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,340 @@
+package ignore
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// writeTree creates files under dir, keyed by slash-separated paths.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkIgnored runs each case, relative to root, against IsIgnored.
+func checkIgnored(t *testing.T, m *Matcher, root string, cases []matchCase) {
+	t.Helper()
+	for _, c := range cases {
+		got, err := m.IsIgnored(filepath.Join(root, filepath.FromSlash(c.path)), c.isDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.ignored {
+			t.Errorf("IsIgnored(%q, %v) = %v, want %v", c.path, c.isDir, got, c.ignored)
+		}
+	}
+}
+
+func TestNewScanMatcherExtraGitignores(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "project")
+	writeTree(t, base, map[string]string{
+		"project/.gitignore":       "*.log\n",
+		"project/sub/extra.ignore": "*.tmp\n/local.txt\n",
+		"outside.ignore":           "*.bak\n/top.txt\n!keep.log\n",
+		"project/custom.gitignore": "*.out\n",
+	})
+
+	tests := []struct {
+		name  string
+		opts  Options
+		cases []matchCase
+	}{
+		{
+			name: "extra files add to the scan root .gitignore",
+			opts: Options{ExtraGitignores: []string{
+				filepath.Join(root, "sub", "extra.ignore"),
+				filepath.Join(base, "outside.ignore"),
+			}},
+			cases: []matchCase{
+				{path: "debug.log", ignored: true},
+				{path: "sub/debug.log", ignored: true},
+				// The extra file inside the scan root is relative to its directory
+				{path: "sub/a.tmp", ignored: true},
+				{path: "a.tmp", ignored: false},
+				{path: "sub/local.txt", ignored: true},
+				{path: "local.txt", ignored: false},
+				// The one outside of it is relative to the scan root
+				{path: "top.txt", ignored: true},
+				{path: "sub/top.txt", ignored: false},
+				{path: "deep/x.bak", ignored: true},
+				// Extra files take precedence over .gitignore
+				{path: "keep.log", ignored: false},
+				{path: "main.go", ignored: false},
+			},
+		},
+		{
+			name: "extra files add to a custom gitignore",
+			opts: Options{
+				GitignorePath:   filepath.Join(root, "custom.gitignore"),
+				ExtraGitignores: []string{filepath.Join(base, "outside.ignore")},
+			},
+			cases: []matchCase{
+				{path: "a.out", ignored: true},
+				{path: "a.bak", ignored: true},
+				// The custom file replaces .gitignore
+				{path: "debug.log", ignored: false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewScanMatcher(tt.opts, root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkIgnored(t, m, root, tt.cases)
+		})
+	}
+}
+
+func TestNewScanMatcherMissingExtraGitignore(t *testing.T) {
+	root := t.TempDir()
+	if _, err := NewScanMatcher(Options{ExtraGitignores: []string{filepath.Join(root, "missing")}}, root); err == nil {
+		t.Error("NewScanMatcher() returned no error for a missing extra gitignore")
+	}
+}
+
+func TestNewScanMatcherCopilotignore(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".gitignore":     "*.log\n!keep.log\n",
+		".copilotignore": "keep.log\nfixtures/\n",
+	})
+
+	tests := []struct {
+		name  string
+		opts  Options
+		cases []matchCase
+	}{
+		{
+			name: "enabled",
+			opts: Options{Copilotignore: true},
+			cases: []matchCase{
+				// .copilotignore excludes files .gitignore includes
+				{path: "keep.log", ignored: true},
+				{path: "fixtures", isDir: true, ignored: true},
+				{path: "sub/fixtures/data.json", ignored: true},
+				{path: "debug.log", ignored: true},
+				{path: "main.go", ignored: false},
+			},
+		},
+		{
+			name: "disabled",
+			opts: Options{},
+			cases: []matchCase{
+				{path: "keep.log", ignored: false},
+				{path: "fixtures", isDir: true, ignored: false},
+				{path: "debug.log", ignored: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewScanMatcher(tt.opts, root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkIgnored(t, m, root, tt.cases)
+		})
+	}
+}
+
+// isolateGitConfig points Git and the global excludes file lookup at an
+// empty configuration, and returns the directory standing for
+// $XDG_CONFIG_HOME.
+func isolateGitConfig(t *testing.T) string {
+	t.Helper()
+	configHome := t.TempDir()
+	globalConfig := filepath.Join(configHome, "gitconfig")
+	if err := os.WriteFile(globalConfig, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", globalConfig)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	return configHome
+}
+
+func TestNewScanMatcherGitExcludes(t *testing.T) {
+	configHome := isolateGitConfig(t)
+	writeTree(t, configHome, map[string]string{"git/ignore": "*.swp\n"})
+	repo := t.TempDir()
+	writeTree(t, repo, map[string]string{
+		".git/info/exclude": "/local/\nscratch.txt\n",
+		".gitignore":        "*.log\n!keep.swp\n",
+	})
+
+	tests := []struct {
+		name  string
+		opts  Options
+		root  string
+		cases []matchCase
+	}{
+		{
+			name: "enabled",
+			opts: Options{GitExcludes: true},
+			root: repo,
+			cases: []matchCase{
+				{path: "local", isDir: true, ignored: true},
+				{path: "local/a.go", ignored: true},
+				{path: "sub/local", isDir: true, ignored: false},
+				{path: "scratch.txt", ignored: true},
+				{path: "sub/scratch.txt", ignored: true},
+				{path: "a.swp", ignored: true},
+				// .gitignore takes precedence over the excludes files
+				{path: "keep.swp", ignored: false},
+				{path: "debug.log", ignored: true},
+			},
+		},
+		{
+			name: "disabled",
+			opts: Options{},
+			root: repo,
+			cases: []matchCase{
+				{path: "local", isDir: true, ignored: false},
+				{path: "scratch.txt", ignored: false},
+				{path: "a.swp", ignored: false},
+				{path: "debug.log", ignored: true},
+			},
+		},
+		{
+			name: "relative to the repository root from a subdirectory",
+			opts: Options{GitExcludes: true, FromRoot: true},
+			root: filepath.Join(repo, "sub"),
+			cases: []matchCase{
+				// /local/ is anchored at the repository root, not at sub
+				{path: "local", isDir: true, ignored: false},
+				{path: "scratch.txt", ignored: true},
+				{path: "a.swp", ignored: true},
+				{path: "debug.log", ignored: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.MkdirAll(tt.root, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			m, err := NewScanMatcher(tt.opts, tt.root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkIgnored(t, m, tt.root, tt.cases)
+		})
+	}
+}
+
+func TestGlobalExcludesFile(t *testing.T) {
+	configHome := isolateGitConfig(t)
+	if got, want := globalExcludesFile(), filepath.Join(configHome, "git", "ignore"); got != want {
+		t.Errorf("globalExcludesFile() = %q, want %q", got, want)
+	}
+
+	custom := filepath.Join(t.TempDir(), "excludes")
+	if err := os.WriteFile(filepath.Join(configHome, "gitconfig"), []byte("[core]\n\texcludesFile = "+filepath.ToSlash(custom)+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	if got := globalExcludesFile(); filepath.Clean(got) != custom {
+		t.Errorf("globalExcludesFile() = %q with core.excludesFile set, want %q", got, custom)
+	}
+}
+
+func TestNewScanMatcherFromRoot(t *testing.T) {
+	base := t.TempDir()
+	repo := filepath.Join(base, "repo")
+	writeTree(t, base, map[string]string{
+		// Above the repository, never read
+		".gitignore":                     "*.go\n",
+		"repo/.git/HEAD":                 "",
+		"repo/.gitignore":                "*.log\n/top.txt\ngen/\n",
+		"repo/services/.gitignore":       "/local.txt\n!keep.log\n",
+		"repo/services/api/.gitignore":   "*.tmp\n",
+		"repo/services/api/handler/h.go": "",
+	})
+	scanDir := filepath.Join(repo, "services", "api")
+
+	tests := []struct {
+		name  string
+		opts  Options
+		cases []matchCase
+	}{
+		{
+			name: "ancestors up to the repository root",
+			opts: Options{FromRoot: true},
+			cases: []matchCase{
+				{path: "debug.log", ignored: true},
+				{path: "gen", isDir: true, ignored: true},
+				{path: "handler/gen/x.txt", ignored: true},
+				// Anchored patterns stay relative to their own directory
+				{path: "top.txt", ignored: false},
+				{path: "local.txt", ignored: false},
+				// Closer ancestors take precedence
+				{path: "keep.log", ignored: false},
+				{path: "a.tmp", ignored: true},
+				{path: "handler/h.go", ignored: false},
+			},
+		},
+		{
+			name: "scan root only",
+			opts: Options{},
+			cases: []matchCase{
+				{path: "debug.log", ignored: false},
+				{path: "gen", isDir: true, ignored: false},
+				{path: "a.tmp", ignored: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewScanMatcher(tt.opts, scanDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkIgnored(t, m, scanDir, tt.cases)
+		})
+	}
+}
+
+func TestFindRepoRoot(t *testing.T) {
+	base := t.TempDir()
+	writeTree(t, base, map[string]string{
+		"repo/.git/HEAD":  "",
+		"repo/a/b/file":   "",
+		"repo/sub/.git":   "gitdir: ../.git/modules/sub\n",
+		"repo/sub/c/file": "",
+		"norepo/d/file":   "",
+	})
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{"repo", "repo"},
+		{"repo/a/b", "repo"},
+		// A .git file, as in submodules and worktrees, marks a repository too
+		{"repo/sub/c", "repo/sub"},
+	}
+	for _, tt := range tests {
+		if got, want := FindRepoRoot(filepath.Join(base, tt.dir)), filepath.Join(base, tt.want); got != want {
+			t.Errorf("FindRepoRoot(%q) = %q, want %q", tt.dir, got, want)
+		}
+	}
+	// Without a repository, the search stops at the filesystem root, unless
+	// the temporary directory happens to be inside one
+	if got := FindRepoRoot(filepath.Join(base, "norepo", "d")); isWithin(base, got) {
+		t.Errorf("FindRepoRoot() outside of a repository = %q, want a directory above %q", got, base)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// changePrompt asks for a confirmation before each change applied with
+// apply --interactive.
+type changePrompt struct {
+	in  *bufio.Reader
+	out io.Writer
+	all bool // The user answered "a": apply the remaining changes without asking
+}
+
+// newChangePrompt returns a changePrompt reading answers from in and writing
+// questions to out.
+func newChangePrompt(in io.Reader, out io.Writer) *changePrompt {
+	return &changePrompt{in: bufio.NewReader(in), out: out}
+}
+
+// ask asks whether change must be applied, until a valid answer is given:
+// "y" applies it, "n" or an empty answer skips it, "a" applies it along with
+// all the following changes, and "q" skips it and stops. The end of the input
+// counts as "q".
+func (p *changePrompt) ask(change FileChange) (apply, quit bool, err error) {
+	if p.all {
+		return true, false, nil
+	}
+
+	var question string
+	switch {
+	case change.isRename():
+		question = fmt.Sprintf("rename %s to %s?", change.From, change.To)
+	case change.Delete:
+		question = fmt.Sprintf("delete %s?", change.FilePath)
+	default:
+		question = fmt.Sprintf("create %s?", change.FilePath)
+		if _, err := os.Lstat(change.FilePath); err == nil {
+			question = fmt.Sprintf("overwrite %s?", change.FilePath)
+		}
+	}
+
+	for {
+		fmt.Fprintf(p.out, "%s [y/N/a/q] ", question)
+		line, err := p.in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return false, false, err
+		}
+		if err == io.EOF && line == "" {
+			fmt.Fprintln(p.out)
+			return false, true, nil
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return true, false, nil
+		case "", "n", "no":
+			return false, false, nil
+		case "a", "all":
+			p.all = true
+			return true, false, nil
+		case "q", "quit":
+			return false, true, nil
+		}
+		fmt.Fprintln(p.out, "Please answer y (yes), n (no), a (all) or q (quit).")
+	}
+}
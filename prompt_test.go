@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestChangePromptAsk(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		wantApply bool
+		wantQuit  bool
+		wantAll   bool
+		retries   int
+	}{
+		{"yes", "y\n", true, false, false, 0},
+		{"yes in full, upper case", " YES \n", true, false, false, 0},
+		{"no", "n\n", false, false, false, 0},
+		{"empty answer defaults to no", "\n", false, false, false, 0},
+		{"all", "a\n", true, false, true, 0},
+		{"quit", "q\n", false, true, false, 0},
+		{"end of input quits", "", false, true, false, 0},
+		{"answer without newline", "y", true, false, false, 0},
+		{"invalid answers ask again", "maybe\nok\ny\n", true, false, false, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			var out bytes.Buffer
+			p := newChangePrompt(strings.NewReader(tt.input), &out)
+			apply, quit, err := p.ask(FileChange{FilePath: "a.txt", Content: "a"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if apply != tt.wantApply || quit != tt.wantQuit || p.all != tt.wantAll {
+				t.Errorf("ask() = apply %v, quit %v, all %v, want %v, %v, %v", apply, quit, p.all, tt.wantApply, tt.wantQuit, tt.wantAll)
+			}
+			if !strings.HasPrefix(out.String(), "create a.txt? [y/N/a/q] ") {
+				t.Errorf("prompt = %q", out.String())
+			}
+			if retries := strings.Count(out.String(), "Please answer"); retries != tt.retries {
+				t.Errorf("asked again %d time(s), want %d", retries, tt.retries)
+			}
+		})
+	}
+}
+
+func TestChangePromptQuestions(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"existing.txt": ""})
+	t.Chdir(dir)
+	tests := []struct {
+		change FileChange
+		want   string
+	}{
+		{FileChange{FilePath: "new.txt", Content: "x"}, "create new.txt? [y/N/a/q] "},
+		{FileChange{FilePath: "existing.txt", Content: "x"}, "overwrite existing.txt? [y/N/a/q] "},
+		{FileChange{FilePath: "existing.txt", Delete: true}, "delete existing.txt? [y/N/a/q] "},
+		{FileChange{From: "existing.txt", To: "moved.txt"}, "rename existing.txt to moved.txt? [y/N/a/q] "},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if _, _, err := newChangePrompt(strings.NewReader("n\n"), &out).ask(tt.change); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("prompt = %q, want %q", out.String(), tt.want)
+		}
+	}
+}
+
+func TestChangePromptReadError(t *testing.T) {
+	p := newChangePrompt(iotest.ErrReader(errors.New("boom")), &bytes.Buffer{})
+	if _, _, err := p.ask(FileChange{FilePath: "a.txt"}); err == nil {
+		t.Error("ask() returned no error for a failing reader")
+	}
+}
+
+func TestApplyInteractive(t *testing.T) {
+	changes := []FileChange{
+		{FilePath: "1.txt", Content: "1"},
+		{FilePath: "2.txt", Content: "2"},
+		{FilePath: "3.txt", Content: "3"},
+		{FilePath: "4.txt", Content: "4"},
+	}
+	tests := []struct {
+		name    string
+		answers string
+		want    []string
+	}{
+		{"per-change answers", "y\nn\ny\n\n", []string{"1.txt=1", "3.txt=3"}},
+		{"all applies the remaining changes", "n\na\n", []string{"2.txt=2", "3.txt=3", "4.txt=4"}},
+		{"quit stops", "y\nq\n", []string{"1.txt=1"}},
+		{"end of input stops", "y\ny\n", []string{"1.txt=1", "2.txt=2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Chdir(dir)
+			captureApplyOut(t)
+			var questions bytes.Buffer
+			_, err := applyChanges(context.Background(), changes, applyOptions{
+				prompt: newChangePrompt(strings.NewReader(tt.answers), &questions),
+				jobs:   1,
+				logger: quietLogger(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fileContents(t, dir); !slices.Equal(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyInteractiveRequiresTerminal(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"changes.json": `{"changes": [{"file_path": "a.txt", "content": "a"}]}`})
+	_, stderr, code := runCopilot(t, dir, "y\n", "apply", "-i", "changes.json")
+	if code == 0 || !strings.Contains(stderr, "--interactive") {
+		t.Errorf("exit code = %d, stderr = %q, want an error about --interactive", code, stderr)
+	}
+	if got := listFiles(t, dir); !slices.Equal(got, []string{"changes.json"}) {
+		t.Errorf("files = %q, want nothing written", got)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// reportEntry is the outcome of a single change in an apply report.
+type reportEntry struct {
+	FilePath string `json:"file_path"`
+	From     string `json:"from,omitempty"` // Source path of renames
+	Action   string `json:"action"`         // created, updated, deleted, renamed, unchanged, skipped or failed
+	Bytes    int    `json:"bytes"`          // Bytes written, 0 for deletions, renames, unchanged, skipped and failed changes
+	Error    string `json:"error,omitempty"`
+}
+
+// reportTotals counts the entries of an apply report per action.
+type reportTotals struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Deleted   int `json:"deleted"`
+	Renamed   int `json:"renamed"`
+	Unchanged int `json:"unchanged"`
+	Skipped   int `json:"skipped"`
+	Failed    int `json:"failed"`
+	Bytes     int `json:"bytes"`
+}
+
+// applyReport is the machine-readable summary printed by apply --report json.
+// Changes after a failed one are not attempted and do not appear in it.
+type applyReport struct {
+	Files      []reportEntry `json:"files"`
+	Totals     reportTotals  `json:"totals"`
+	RolledBack bool          `json:"rolled_back,omitempty"` // Set when --atomic restored the files after a failure
+}
+
+// targetExists reports whether the file change writes or deletes already
+// exists, before it is applied.
+func targetExists(change FileChange) bool {
+	target := change.FilePath
+	if change.isRename() {
+		target = change.To
+	}
+	if target == "" {
+		return false
+	}
+	_, err := os.Lstat(target)
+	return err == nil
+}
+
+// add records the outcome of change, given whether its target existed
+// beforehand and what applyChange returned.
+func (r *applyReport) add(change FileChange, existed bool, outcome changeOutcome, err error) {
+	entry := reportEntry{FilePath: change.FilePath}
+	if change.isRename() {
+		entry.FilePath, entry.From = change.To, change.From
+	}
+
+	switch {
+	case err != nil:
+		entry.Action = "failed"
+		entry.Error = err.Error()
+		r.Totals.Failed++
+	case outcome == changeUnchanged:
+		entry.Action = "unchanged"
+		r.Totals.Unchanged++
+	case outcome != changeApplied:
+		entry.Action = "skipped"
+		r.Totals.Skipped++
+	case change.isRename():
+		entry.Action = "renamed"
+		r.Totals.Renamed++
+	case change.Delete:
+		entry.Action = "deleted"
+		r.Totals.Deleted++
+	default:
+		// Content was decoded successfully by applyChange
+		content, _ := change.decodedContent()
+		entry.Bytes = len(content)
+		r.Totals.Bytes += entry.Bytes
+		if existed {
+			entry.Action = "updated"
+			r.Totals.Updated++
+		} else {
+			entry.Action = "created"
+			r.Totals.Created++
+		}
+	}
+	r.Files = append(r.Files, entry)
+}
+
+// write prints the report as indented JSON to w.
+func (r *applyReport) write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestApplyReport(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"updated.txt":   "old",
+		"unchanged.txt": "same",
+		"deleted.txt":   "bye",
+		"renamed.txt":   "moving",
+		"blocked/x":     "",
+	})
+	t.Chdir(dir)
+	captureApplyOut(t)
+
+	changes := []FileChange{
+		{FilePath: "created.txt", Content: "new file"},
+		{FilePath: "updated.txt", Content: "new content"},
+		{FilePath: "unchanged.txt", Content: "same"},
+		{FilePath: "deleted.txt", Delete: true},
+		{FilePath: "ghost.txt", Delete: true},
+		{From: "renamed.txt", To: "moved/renamed.txt"},
+		{FilePath: "encoded.bin", Content: "AAEC", Encoding: "base64"},
+		{FilePath: "blocked", Content: "fails"},
+	}
+	report := &applyReport{}
+	_, err := applyChanges(context.Background(), changes, applyOptions{skipUnchanged: true, continueOnError: true, report: report, jobs: 1, logger: quietLogger()})
+	if err == nil {
+		t.Fatal("applyChanges() returned no error for the failed change")
+	}
+
+	var out bytes.Buffer
+	if err := report.write(&out); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	var want map[string]any
+	if err := json.Unmarshal([]byte(`{
+		"files": [
+			{"file_path": "created.txt", "action": "created", "bytes": 8},
+			{"file_path": "updated.txt", "action": "updated", "bytes": 11},
+			{"file_path": "unchanged.txt", "action": "unchanged", "bytes": 0},
+			{"file_path": "deleted.txt", "action": "deleted", "bytes": 0},
+			{"file_path": "ghost.txt", "action": "skipped", "bytes": 0},
+			{"file_path": "moved/renamed.txt", "from": "renamed.txt", "action": "renamed", "bytes": 0},
+			{"file_path": "encoded.bin", "action": "created", "bytes": 3},
+			{"file_path": "blocked", "action": "failed", "bytes": 0, "error": "`+jsonErrorPlaceholder+`"}
+		],
+		"totals": {"created": 2, "updated": 1, "deleted": 1, "renamed": 1, "unchanged": 1, "skipped": 1, "failed": 1, "bytes": 22}
+	}`), &want); err != nil {
+		t.Fatal(err)
+	}
+	// The error message depends on the platform
+	files := got["files"].([]any)
+	failed := files[len(files)-1].(map[string]any)
+	if failed["error"] == "" {
+		t.Error("failed entry has no error")
+	}
+	failed["error"] = jsonErrorPlaceholder
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("report =\n%s\nwant the same structure as\n%v", out.String(), want)
+	}
+}
+
+// jsonErrorPlaceholder replaces platform-dependent error messages in the
+// expected report.
+const jsonErrorPlaceholder = "<error>"
+
+func TestApplyReportRolledBack(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"blocked/x": ""})
+	t.Chdir(dir)
+	captureApplyOut(t)
+
+	report := &applyReport{}
+	changes := []FileChange{{FilePath: "a.txt", Content: "a"}, {FilePath: "blocked", Content: "fails"}, {FilePath: "b.txt", Content: "b"}}
+	if _, err := applyChanges(context.Background(), changes, applyOptions{atomic: true, report: report, jobs: 1, logger: quietLogger()}); err == nil {
+		t.Fatal("applyChanges() returned no error")
+	}
+	if !report.RolledBack {
+		t.Error("report is not marked as rolled back")
+	}
+	if len(report.Files) != 2 {
+		t.Errorf("report lists %d change(s), want 2 as changes after the failure are not attempted", len(report.Files))
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// byteSizeFlag is a flag.Value holding a number of bytes, given as an
+// integer with an optional K, M or G suffix for multiples of 1024, e.g. 512K.
+type byteSizeFlag int64
+
+func (f *byteSizeFlag) String() string {
+	return strconv.FormatInt(int64(*f), 10)
+}
+
+func (f *byteSizeFlag) Set(value string) error {
+	number, multiplier := strings.ToUpper(strings.TrimSpace(value)), int64(1)
+	number = strings.TrimSuffix(strings.TrimSuffix(number, "B"), "I")
+	for i, suffix := range []string{"K", "M", "G"} {
+		if trimmed, ok := strings.CutSuffix(number, suffix); ok {
+			number, multiplier = trimmed, int64(1)<<(10*(i+1))
+			break
+		}
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid size '%s', expected a number of bytes such as 4096, 512K or 10M", value)
+	}
+	*f = byteSizeFlag(size * multiplier)
+	return nil
+}
+
+// outputBudget enforces --max-tokens and --max-output-size on the files
+// extract outputs, in order. Once a file does not fit, it and all following
+// files are skipped, so that the output holds the files up to that point.
+type outputBudget struct {
+	maxTokens  int      // Estimated token budget, 0 for unlimited
+	maxSize    int64    // Output size budget in bytes, 0 for unlimited
+	tokens     int      // Estimated tokens of the files that fit so far
+	size       int64    // Output size in bytes of the files that fit so far
+	overTokens []string // Paths of the files skipped by maxTokens
+	overSize   []string // Paths of the files skipped by maxSize
+}
+
+// fits reports whether file fits in what remains of the budget, and
+// records it as skipped otherwise. Tokens are estimated on the text format
+// of the file. Its size is that of the file rendered alone according to
+// opts, so the headers of formats such as JSON or XML count for each file
+// and the output of the files that fit never exceeds maxSize.
+func (b *outputBudget) fits(file FileChange, opts extractOptions) (bool, error) {
+	if b.maxTokens > 0 {
+		tokens := estimateTokens(formatText([]FileChange{file}))
+		if len(b.overTokens) > 0 || b.tokens+tokens > b.maxTokens {
+			b.overTokens = append(b.overTokens, file.FilePath)
+			return false, nil
+		}
+		b.tokens += tokens
+	}
+	if b.maxSize > 0 {
+		output, err := formatFiles([]FileChange{file}, opts)
+		if err != nil {
+			return false, err
+		}
+		if len(b.overSize) > 0 || b.size+int64(len(output)) > b.maxSize {
+			b.overSize = append(b.overSize, file.FilePath)
+			return false, nil
+		}
+		b.size += int64(len(output))
+	}
+	return true, nil
+}
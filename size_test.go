@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestByteSizeFlag(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "0", want: 0},
+		{value: "4096", want: 4096},
+		{value: "512K", want: 512 << 10},
+		{value: "10m", want: 10 << 20},
+		{value: "1G", want: 1 << 30},
+		{value: "2KB", want: 2 << 10},
+		{value: "3MiB", want: 3 << 20},
+		{value: " 7K ", want: 7 << 10},
+		{value: "-1", wantErr: true},
+		{value: "1T", wantErr: true},
+		{value: "K", wantErr: true},
+		{value: "1.5M", wantErr: true},
+	}
+	for _, tt := range tests {
+		var f byteSizeFlag
+		err := f.Set(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Set(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && int64(f) != tt.want {
+			t.Errorf("Set(%q) = %d, want %d", tt.value, f, tt.want)
+		}
+	}
+}
+
+// TestExtractOutputSize extracts three files of the same size, a.txt, b.txt
+// and c.txt, with --warn-size and --max-output-size set in multiples of the
+// size of one of them in the output.
+func TestExtractOutputSize(t *testing.T) {
+	content := strings.Repeat("x", 99) + "\n"
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": content, "b.txt": content, "c.txt": content})
+	one, err := formatFiles([]FileChange{{FilePath: "a.txt", Content: content}}, extractOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(one))
+
+	tests := []struct {
+		name          string
+		warnSize      int64
+		maxOutputSize int64
+		wantFiles     []string
+		wantLogs      []string
+	}{
+		{name: "no limits", wantFiles: []string{"a.txt", "b.txt", "c.txt"}},
+		{name: "under the warning threshold", warnSize: 3 * size, wantFiles: []string{"a.txt", "b.txt", "c.txt"}},
+		{
+			name:      "over the warning threshold",
+			warnSize:  2 * size,
+			wantFiles: []string{"a.txt", "b.txt", "c.txt"},
+			wantLogs:  []string{fmt.Sprintf("Warning: output is %d bytes, over the --warn-size of %d bytes.", 3*size, 2*size)},
+		},
+		{
+			name:          "hard cap",
+			maxOutputSize: 2*size + 1,
+			wantFiles:     []string{"a.txt", "b.txt"},
+			wantLogs:      []string{fmt.Sprintf("Warning: output size limit of %d bytes reached, skipping 1 file(s):\n  c.txt", 2*size+1)},
+		},
+		{
+			name:          "cap smaller than the first file",
+			maxOutputSize: size - 1,
+			wantLogs:      []string{"skipping 3 file(s):\n  a.txt\n  b.txt\n  c.txt"},
+		},
+		{
+			name:          "warning and cap",
+			warnSize:      size,
+			maxOutputSize: 2 * size,
+			wantFiles:     []string{"a.txt", "b.txt"},
+			wantLogs:      []string{"skipping 1 file(s)", fmt.Sprintf("output is %d bytes, over the --warn-size", 2*size)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			out, err := extractFileContent(context.Background(), dir, extractOptions{
+				extensions:    []string{".txt"},
+				warnSize:      tt.warnSize,
+				maxOutputSize: tt.maxOutputSize,
+				maxDepth:      -1,
+				logger:        &logger{out: &logs, level: levelWarn},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.maxOutputSize > 0 && int64(len(out)) > tt.maxOutputSize {
+				t.Errorf("output is %d bytes, over the cap of %d", len(out), tt.maxOutputSize)
+			}
+			for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+				want := slices.Contains(tt.wantFiles, name)
+				if got := strings.Contains(out, "<file_path>"+name+"</file_path>"); got != want {
+					t.Errorf("%s extracted: %v, want %v", name, got, want)
+				}
+			}
+			for _, want := range tt.wantLogs {
+				if !strings.Contains(logs.String(), want) {
+					t.Errorf("logs = %q, want %q", logs.String(), want)
+				}
+			}
+			if len(tt.wantLogs) == 0 && logs.Len() > 0 {
+				t.Errorf("logs = %q, want no warning", logs.String())
+			}
+		})
+	}
+}
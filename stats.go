@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+)
+
+// ExtensionStats aggregates the files sharing an extension.
+type ExtensionStats struct {
+	Extension string `json:"extension"`
+	Files     int    `json:"files"`
+	Bytes     int64  `json:"bytes"`
+	Lines     int    `json:"lines"`
+}
+
+// StatsReport is the result of the stats command.
+type StatsReport struct {
+	Extensions []ExtensionStats `json:"extensions"`
+	Total      ExtensionStats   `json:"total"`
+}
+
+// computeStats walks scanDirAbs like extractFileContent and aggregates the
+// selected files per extension, sorted by extension.
+func computeStats(scanDirAbs string, opts extractOptions) (StatsReport, error) {
+	candidates, err := walkCandidates(context.Background(), scanDirAbs, opts)
+	if err != nil {
+		return StatsReport{}, err
+	}
+
+	byExt := map[string]*ExtensionStats{}
+	report := StatsReport{Extensions: []ExtensionStats{}, Total: ExtensionStats{Extension: "total"}}
+	for _, candidate := range candidates {
+		content, err := os.ReadFile(candidate.absPath)
+		if err != nil {
+			opts.logger.warnf("failed to read file %s: %v. Skipping.", candidate.absPath, err)
+			continue
+		}
+
+		ext := filepath.Ext(candidate.relPath)
+		if ext == "" {
+			ext = "(none)"
+		}
+		stats, ok := byExt[ext]
+		if !ok {
+			stats = &ExtensionStats{Extension: ext}
+			byExt[ext] = stats
+		}
+		lines := countLines(content)
+		stats.Files++
+		stats.Bytes += int64(len(content))
+		stats.Lines += lines
+		report.Total.Files++
+		report.Total.Bytes += int64(len(content))
+		report.Total.Lines += lines
+	}
+
+	for _, stats := range byExt {
+		report.Extensions = append(report.Extensions, *stats)
+	}
+	sort.Slice(report.Extensions, func(i, j int) bool {
+		return report.Extensions[i].Extension < report.Extensions[j].Extension
+	})
+	return report, nil
+}
+
+// countLines counts the lines of content, including a last line without a
+// trailing newline.
+func countLines(content []byte) int {
+	lines := bytes.Count(content, []byte("\n"))
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		lines++
+	}
+	return lines
+}
+
+// printStatsTable prints report as an aligned table on stdout.
+func printStatsTable(report StatsReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "EXTENSION\tFILES\tBYTES\tLINES\t")
+	for _, stats := range append(report.Extensions, report.Total) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t\n", stats.Extension, stats.Files, stats.Bytes, stats.Lines)
+	}
+	w.Flush()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestComputeStats(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore":      "build/\n*.gen.go\n",
+		"main.go":         "package main\n\nfunc main() {}\n",
+		"util/util.go":    "package util", // No trailing newline
+		"util/api.gen.go": "package util\n",
+		"build/out.go":    "package build\n",
+		"README.md":       "# Title\n\nText\n",
+		"docs/guide.md":   "guide\n",
+		"Makefile":        "all:\n",
+		"empty.go":        "",
+	})
+
+	tests := []struct {
+		name       string
+		extensions []string
+		includes   []string
+		want       StatsReport
+	}{
+		{
+			name:       "per-extension aggregation, ignored files left out",
+			extensions: []string{".go", ".md"},
+			want: StatsReport{
+				Extensions: []ExtensionStats{
+					{Extension: ".go", Files: 3, Bytes: 41, Lines: 4},
+					{Extension: ".md", Files: 2, Bytes: 20, Lines: 4},
+				},
+				Total: ExtensionStats{Extension: "total", Files: 5, Bytes: 61, Lines: 8},
+			},
+		},
+		{
+			name:     "files without extension",
+			includes: []string{"Makefile"},
+			want: StatsReport{
+				Extensions: []ExtensionStats{{Extension: "(none)", Files: 1, Bytes: 5, Lines: 1}},
+				Total:      ExtensionStats{Extension: "total", Files: 1, Bytes: 5, Lines: 1},
+			},
+		},
+		{
+			name:       "no match",
+			extensions: []string{".rs"},
+			want: StatsReport{
+				Extensions: []ExtensionStats{},
+				Total:      ExtensionStats{Extension: "total"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := computeStats(dir, extractOptions{
+				extensions:    tt.extensions,
+				includes:      tt.includes,
+				ignoreMatcher: rootMatcher(t, dir),
+				maxDepth:      -1,
+				logger:        quietLogger(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got.Extensions, tt.want.Extensions) || got.Total != tt.want.Total {
+				t.Errorf("computeStats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountLines(t *testing.T) {
+	tests := []struct {
+		content string
+		want    int
+	}{
+		{"", 0},
+		{"a", 1},
+		{"a\n", 1},
+		{"a\nb", 2},
+		{"\n\n", 2},
+	}
+	for _, tt := range tests {
+		if got := countLines([]byte(tt.content)); got != tt.want {
+			t.Errorf("countLines(%q) = %d, want %d", tt.content, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// changeStream decodes the changes of a changeset one at a time, so that
+// apply --stdin-json never holds the whole changeset in memory.
+type changeStream struct {
+	decoder   *json.Decoder
+	started   bool // The opening brace of the changeset was read
+	inChanges bool // The decoder is inside the "changes" array
+	done      bool // The closing brace of the changeset was read
+}
+
+// newChangeStream returns a changeStream reading the changeset from r,
+// decompressing it if it is gzip-compressed.
+func newChangeStream(r io.Reader) (*changeStream, error) {
+	buffered := bufio.NewReader(r)
+	if magic, _ := buffered.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return &changeStream{decoder: json.NewDecoder(zr)}, nil
+	}
+	return &changeStream{decoder: json.NewDecoder(buffered)}, nil
+}
+
+// next returns the next change of the stream. It returns false once the
+// changeset has been read entirely. Members of the changeset other than
+// "changes" are skipped.
+func (s *changeStream) next() (FileChange, bool, error) {
+	var change FileChange
+	if s.done {
+		return change, false, nil
+	}
+	if !s.started {
+		if err := s.expectDelim('{'); err != nil {
+			return change, false, err
+		}
+		s.started = true
+	}
+
+	for {
+		if s.inChanges {
+			if s.decoder.More() {
+				if err := s.decoder.Decode(&change); err != nil {
+					return change, false, err
+				}
+				return change, true, nil
+			}
+			if err := s.expectDelim(']'); err != nil {
+				return change, false, err
+			}
+			s.inChanges = false
+		}
+
+		token, err := s.decoder.Token()
+		if err != nil {
+			return change, false, err
+		}
+		if token == json.Delim('}') {
+			s.done = true
+			return change, false, nil
+		}
+		if token != "changes" {
+			// Skip the value of another member
+			var value json.RawMessage
+			if err := s.decoder.Decode(&value); err != nil {
+				return change, false, err
+			}
+			continue
+		}
+		token, err = s.decoder.Token()
+		if err != nil {
+			return change, false, err
+		}
+		switch token {
+		case json.Delim('['):
+			s.inChanges = true
+		case nil:
+			// "changes": null holds no change
+		default:
+			return change, false, fmt.Errorf("expected an array for \"changes\", got %v", token)
+		}
+	}
+}
+
+// expectDelim reads the next token, which must be delim.
+func (s *changeStream) expectDelim(delim json.Delim) error {
+	token, err := s.decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected '%v', got %v", delim, token)
+	}
+	return nil
+}
+
+// applyChangeStream applies the changes of the changeset read from r as
+// they are decoded, like applyChanges. prepare is called on each change,
+// with its 0-based index, before it is applied; it can check it, returning an
+// error that stops the stream, adjust it, or return false to skip it. ctx
+// cancels the stream as it does applyChanges.
+func applyChangeStream(ctx context.Context, r io.Reader, opts applyOptions, prepare func(i int, change FileChange) (FileChange, bool, error)) (int, error) {
+	stream, err := newChangeStream(r)
+	if err != nil {
+		return 0, fmt.Errorf("reading JSON from standard input: %w", err)
+	}
+	i := 0
+	next := func() (FileChange, bool, error) {
+		for {
+			change, ok, err := stream.next()
+			if err != nil {
+				return change, false, fmt.Errorf("parsing JSON from standard input: %w", err)
+			}
+			if !ok {
+				return change, false, nil
+			}
+			change, ok, err = prepare(i, change)
+			i++
+			if err != nil || ok {
+				return change, err == nil, err
+			}
+		}
+	}
+
+	var journal *applyJournal
+	if opts.atomic {
+		journal = &applyJournal{}
+	}
+	return applyChangeSequence(ctx, next, -1, opts, journal)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestChangeStream(t *testing.T) {
+	gzipped := func(s string) string {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	tests := []struct {
+		name    string
+		input   string
+		want    []string // file_path of each change
+		wantErr string
+	}{
+		{name: "changes", input: `{"changes":[{"file_path":"a","content":"A"},{"file_path":"b","content":"B"}]}`, want: []string{"a", "b"}},
+		{name: "other members are skipped", input: `{"version":1,"meta":{"x":[1,2]},"changes":[{"file_path":"a"}],"after":"x"}`, want: []string{"a"}},
+		{name: "empty changes", input: `{"changes":[]}`},
+		{name: "null changes", input: `{"changes":null}`},
+		{name: "no changes member", input: `{}`},
+		{name: "gzip-compressed", input: gzipped(`{"changes":[{"file_path":"a"}]}`), want: []string{"a"}},
+		{name: "not an object", input: `[{"file_path":"a"}]`, wantErr: "expected '{'"},
+		{name: "changes not an array", input: `{"changes":{"file_path":"a"}}`, wantErr: "expected an array"},
+		{name: "truncated", input: `{"changes":[{"file_path":"a"},`, want: []string{"a"}, wantErr: "unexpected end of JSON input"},
+		{name: "invalid change", input: `{"changes":[{"file_path":1}]}`, wantErr: "cannot unmarshal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := newChangeStream(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for {
+				change, ok, err := stream.next()
+				if err != nil {
+					if tt.wantErr == "" || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("next() error = %v, want %q", err, tt.wantErr)
+					}
+					break
+				}
+				if !ok {
+					if tt.wantErr != "" {
+						t.Fatalf("next() returned no error, want %q", tt.wantErr)
+					}
+					break
+				}
+				got = append(got, change.FilePath)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("changes = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// TestApplyChangeStreamLarge streams a large changeset, generated as it is
+// read, and checks that the first change is applied long before the whole
+// changeset has been read.
+func TestApplyChangeStreamLarge(t *testing.T) {
+	const n = 2000
+	content := strings.Repeat("x", 1023) + "\n"
+
+	pr, pw := io.Pipe()
+	t.Cleanup(func() { pr.Close() }) // Unblocks the writer if applying stops early
+	var total atomic.Int64
+	go func() {
+		write := func(s string) {
+			written, _ := io.WriteString(pw, s)
+			total.Add(int64(written))
+		}
+		write(`{"changes":[`)
+		for i := range n {
+			if i > 0 {
+				write(",")
+			}
+			write(fmt.Sprintf(`{"file_path":"dir%d/file%d.txt","content":%q}`, i%10, i, content))
+		}
+		write("]}")
+		pw.Close()
+	}()
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	discardApplyOut(t)
+	r := &countingReader{r: pr}
+	var readBeforeFirst int64
+	applied, err := applyChangeStream(context.Background(), r, applyOptions{logger: quietLogger()}, func(i int, change FileChange) (FileChange, bool, error) {
+		if i == 0 {
+			readBeforeFirst = r.n.Load()
+		}
+		return change, true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != n {
+		t.Errorf("applyChangeStream() applied %d change(s), want %d", applied, n)
+	}
+	if readBeforeFirst > total.Load()/100 {
+		t.Errorf("read %d of %d bytes before the first change, want the changeset streamed", readBeforeFirst, total.Load())
+	}
+	for _, i := range []int{0, n / 2, n - 1} {
+		if got := readFile(t, filepath.Join(dir, fmt.Sprintf("dir%d/file%d.txt", i%10, i))); got != content {
+			t.Errorf("file%d.txt holds %d byte(s), want %d", i, len(got), len(content))
+		}
+	}
+}
+
+func TestApplyStdinJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		stdin    string
+		wantCode int
+		want     []string
+	}{
+		{
+			name:  "applies every change",
+			stdin: `{"changes":[{"file_path":"a.txt","content":"A"},{"file_path":"sub/b.txt","content":"B"}]}`,
+			want:  []string{"a.txt=A", "sub/b.txt=B"},
+		},
+		{
+			name:     "stops at an invalid change",
+			stdin:    `{"changes":[{"file_path":"a.txt","content":"A"},{"content":"no path"},{"file_path":"c.txt","content":"C"}]}`,
+			wantCode: 1,
+			want:     []string{"a.txt=A"},
+		},
+		{
+			name:     "malformed JSON after a change",
+			stdin:    `{"changes":[{"file_path":"a.txt","content":"A"},}`,
+			wantCode: 1,
+			want:     []string{"a.txt=A"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			_, stderr, code := runCopilot(t, dir, tt.stdin, "apply", "--stdin-json")
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d (stderr %q)", code, tt.wantCode, stderr)
+			}
+			if got := fileContents(t, dir); !slices.Equal(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyStdinJSONConflicts(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "changes.json"), []byte(`{"changes":[]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"--dry-run"}, {"--check"}, {"--jobs", "4"}, {"changes.json"}} {
+		_, stderr, code := runCopilot(t, dir, "", append([]string{"apply", "--stdin-json"}, args...)...)
+		if code == 0 || !strings.Contains(stderr, "--stdin-json cannot be used") {
+			t.Errorf("apply --stdin-json %s: exit code %d, stderr %q, want a conflict error", strings.Join(args, " "), code, stderr)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// extractSummary counts the files extract skipped while selecting and reading
+// them, for the summary reported once extraction completes. It is safe for
+// concurrent use, and a nil summary counts nothing.
+type extractSummary struct {
+	ignored    atomic.Int64 // Files and directories skipped by ignore rules, --exclude, --no-hidden, --git-tracked-only or --changed-since
+	binary     atomic.Int64 // Binary files skipped according to --binary
+	unreadable atomic.Int64 // Files that could not be read
+	filtered   atomic.Int64 // Files left out by --mtime-after, --mtime-before, --min-size or --max-size
+}
+
+func (s *extractSummary) skipIgnored() {
+	if s != nil {
+		s.ignored.Add(1)
+	}
+}
+
+func (s *extractSummary) skipBinary() {
+	if s != nil {
+		s.binary.Add(1)
+	}
+}
+
+func (s *extractSummary) skipUnreadable() {
+	if s != nil {
+		s.unreadable.Add(1)
+	}
+}
+
+func (s *extractSummary) skipFiltered() {
+	if s != nil {
+		s.filtered.Add(1)
+	}
+}
+
+// skipped describes the skipped files, along with the overBudget files left
+// out by --max-tokens or --max-output-size and the unchanged ones left out by
+// --since-manifest, e.g. "3 ignored path(s), 1 binary file(s)". It returns an
+// empty string when nothing was skipped.
+func (s *extractSummary) skipped(overBudget, unchanged int) string {
+	var parts []string
+	add := func(count int64, what string) {
+		if count > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", count, what))
+		}
+	}
+	add(s.ignored.Load(), "ignored path(s)")
+	add(s.binary.Load(), "binary file(s)")
+	add(s.unreadable.Load(), "unreadable file(s)")
+	add(s.filtered.Load(), "file(s) outside the time or size limits")
+	add(int64(overBudget), "file(s) over budget")
+	add(int64(unchanged), "unchanged file(s)")
+	return strings.Join(parts, ", ")
+}
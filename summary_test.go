@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestExtractSummarySkipped(t *testing.T) {
+	tests := []struct {
+		name                  string
+		ignored, binary       int
+		unreadable, filtered  int
+		overBudget, unchanged int
+		want                  string
+	}{
+		{name: "nothing skipped", want: ""},
+		{name: "ignored only", ignored: 3, want: "3 ignored path(s)"},
+		{
+			name:    "every kind",
+			ignored: 1, binary: 2, unreadable: 3, filtered: 4, overBudget: 5, unchanged: 6,
+			want: "1 ignored path(s), 2 binary file(s), 3 unreadable file(s), 4 file(s) outside the time or size limits, 5 file(s) over budget, 6 unchanged file(s)",
+		},
+		{name: "zero counts are left out", binary: 1, overBudget: 2, want: "1 binary file(s), 2 file(s) over budget"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &extractSummary{}
+			for range tt.ignored {
+				s.skipIgnored()
+			}
+			for range tt.binary {
+				s.skipBinary()
+			}
+			for range tt.unreadable {
+				s.skipUnreadable()
+			}
+			for range tt.filtered {
+				s.skipFiltered()
+			}
+			if got := s.skipped(tt.overBudget, tt.unchanged); got != tt.want {
+				t.Errorf("skipped() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractSummaryStderr(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore":    "*.log\nbuild/\n",
+		"a.txt":         "hello\n",   // 6 bytes
+		"b.txt":         "world!!\n", // 8 bytes
+		"debug.log":     "ignored",
+		"build/out.txt": "ignored",
+		"c.txt":         "bin\x00ary",
+		"big.txt":       strings.Repeat("z", 2000),
+	})
+	args := []string{"extract", "--max-size", "1K", ".", ".txt,.log"}
+
+	stdout, stderr, code := runCopilot(t, dir, "", args...)
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	for _, want := range []string{
+		fmt.Sprintf("Extracted 2 file(s), 14 bytes. Estimated tokens: %d\n", tokensForRunes(utf8.RuneCountInString(stdout))),
+		"Skipped 2 ignored path(s), 1 binary file(s), 1 file(s) outside the time or size limits.\n",
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr does not contain %q:\n%s", want, stderr)
+		}
+	}
+
+	quietOut, stderr, code := runCopilot(t, dir, "", append([]string{"--quiet"}, args...)...)
+	if code != 0 {
+		t.Fatalf("--quiet: exit code = %d, stderr: %s", code, stderr)
+	}
+	if stderr != "" {
+		t.Errorf("--quiet: stderr = %q, want nothing", stderr)
+	}
+	if quietOut != stdout {
+		t.Errorf("--quiet changed the output")
+	}
+}
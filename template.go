@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"text/template"
+)
+
+// templateFile is the data a --template is executed with, once per file.
+type templateFile struct {
+	Path     string // Output path of the file
+	Content  string // Content of the file, base64-encoded if Encoding is "base64"
+	Size     int64  // Size in bytes of the file as read from disk
+	Ext      string // Extension of the file, with its leading dot
+	Encoding string // "base64" if Content is base64-encoded, empty for plain text
+	Deleted  bool   // The file was deleted since --since-manifest, and has no content
+}
+
+// templateDocument is the data a --document-template is executed with.
+type templateDocument struct {
+	Files string // The files rendered with --template
+	Count int    // Number of files
+}
+
+// outputTemplate renders extracted files with user-provided templates, see
+// --template and --document-template.
+type outputTemplate struct {
+	file     *template.Template
+	document *template.Template // Wraps the rendered files (can be nil)
+}
+
+// parseOutputTemplate parses the per-file template fileText and the
+// optional documentText. Both are also executed once with empty data, so
+// that references to unknown fields are reported now rather than halfway
+// through the output.
+func parseOutputTemplate(fileText, documentText string) (*outputTemplate, error) {
+	fileTmpl, err := template.New("--template").Option("missingkey=error").Parse(fileText)
+	if err != nil {
+		return nil, err
+	}
+	if err := fileTmpl.Execute(io.Discard, templateFile{}); err != nil {
+		return nil, err
+	}
+	t := &outputTemplate{file: fileTmpl}
+	if documentText != "" {
+		t.document, err = template.New("--document-template").Option("missingkey=error").Parse(documentText)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.document.Execute(io.Discard, templateDocument{}); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// renderFile executes the file template for file, writing the result to w.
+func (t *outputTemplate) renderFile(w io.Writer, file FileChange) error {
+	data := templateFile{
+		Path:     file.FilePath,
+		Content:  file.Content,
+		Size:     file.size,
+		Ext:      filepath.Ext(file.FilePath),
+		Encoding: file.Encoding,
+		Deleted:  file.Delete,
+	}
+	if err := t.file.Execute(w, data); err != nil {
+		return fmt.Errorf("rendering '%s': %w", file.FilePath, err)
+	}
+	return nil
+}
+
+// renderDocument executes the document template with the count files
+// rendered as files, writing the result to w.
+func (t *outputTemplate) renderDocument(w io.Writer, files string, count int) error {
+	return t.document.Execute(w, templateDocument{Files: files, Count: count})
+}
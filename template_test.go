@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParseOutputTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		document string
+		wantErr  string
+	}{
+		{name: "file template", file: "{{.Path}} {{.Size}} {{.Ext}}\n{{.Content}}"},
+		{name: "with document template", file: "{{.Path}}", document: "{{.Count}} file(s)\n{{.Files}}"},
+		{name: "syntax error", file: "{{.Path", wantErr: "unclosed action"},
+		{name: "unknown field", file: "{{.Name}}", wantErr: "can't evaluate field Name"},
+		{name: "document syntax error", file: "{{.Path}}", document: "{{if}}", wantErr: "missing value for if"},
+		{name: "unknown document field", file: "{{.Path}}", document: "{{.Path}}", wantErr: "can't evaluate field Path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseOutputTemplate(tt.file, tt.document)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("parseOutputTemplate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExtractTemplate(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.go":       "package main\n",
+		"docs/intro.md": "# Intro\n",
+		"logo.bin":      "\x00\x01",
+	})
+	tests := []struct {
+		name     string
+		file     string
+		document string
+		want     string
+	}{
+		{
+			name: "per file",
+			file: `<doc path="{{.Path}}" ext="{{.Ext}}" size="{{.Size}}">{{.Content}}</doc>` + "\n",
+			want: `<doc path="docs/intro.md" ext=".md" size="8"># Intro` + "\n</doc>\n" +
+				`<doc path="main.go" ext=".go" size="13">package main` + "\n</doc>\n",
+		},
+		{
+			name:     "document",
+			file:     "- {{.Path}}\n",
+			document: "{{.Count}} file(s):\n{{.Files}}end\n",
+			want:     "2 file(s):\n- docs/intro.md\n- main.go\nend\n",
+		},
+		{
+			name: "base64-encoded binary files",
+			file: "{{.Path}} {{.Encoding}} {{.Content}}\n",
+			want: "docs/intro.md  # Intro\n\nlogo.bin base64 AAE=\nmain.go  package main\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parseOutputTemplate(tt.file, tt.document)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := extractOptions{
+				extensions: []string{".go", ".md"},
+				format:     "template",
+				template:   tmpl,
+				maxDepth:   -1,
+				logger:     quietLogger(),
+			}
+			if strings.Contains(tt.file, "Encoding") {
+				opts.extensions = append(opts.extensions, ".bin")
+				opts.binaryMode = "base64"
+			}
+			out, err := extractFileContent(context.Background(), dir, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.want {
+				t.Errorf("output = %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractTemplateErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"main.go": "package main\n"})
+	for _, args := range [][]string{
+		{"--template", "{{.Path"},
+		{"--template", "{{.Name}}"},
+		{"--template", "{{.Path}}", "--format", "json"},
+		{"--document-template", "{{.Files}}"},
+	} {
+		args := append(append([]string{"extract"}, args...), ".", ".go")
+		stdout, stderr, code := runCopilot(t, dir, "", args...)
+		if code == 0 || strings.Contains(stdout, "main.go") {
+			t.Errorf("%v: exit code %d, stdout %q, want an error before any output (stderr %q)", args, code, stdout, stderr)
+		}
+	}
+}
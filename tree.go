@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// treeNode is a file or directory of the tree printed by the tree command.
+type treeNode struct {
+	name     string
+	children map[string]*treeNode // nil for files
+	ignored  bool                 // Set on files only, see allIgnored for directories
+}
+
+// allIgnored reports whether the node is an ignored file, or a directory
+// holding only ignored files.
+func (n *treeNode) allIgnored() bool {
+	if n.children == nil {
+		return n.ignored
+	}
+	for _, child := range n.children {
+		if !child.allIgnored() {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedChildren returns the children of a directory node sorted by name.
+func (n *treeNode) sortedChildren() []*treeNode {
+	children := make([]*treeNode, 0, len(n.children))
+	for _, child := range n.children {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].name < children[j].name })
+	return children
+}
+
+// renderTree renders candidates as an indented tree rooted at rootName,
+// showing only the files and their parent directories.
+func renderTree(rootName string, candidates []fileCandidate) string {
+	root := &treeNode{name: rootName, children: map[string]*treeNode{}}
+	for _, candidate := range candidates {
+		node := root
+		parts := strings.Split(candidate.relPath, "/")
+		for i, part := range parts {
+			child, ok := node.children[part]
+			if !ok {
+				child = &treeNode{name: part}
+				if i < len(parts)-1 {
+					child.children = map[string]*treeNode{}
+				}
+				node.children[part] = child
+			}
+			node = child
+		}
+		node.ignored = candidate.ignored
+	}
+
+	var out strings.Builder
+	out.WriteString(rootName + "\n")
+	writeTreeChildren(&out, root, "")
+	return out.String()
+}
+
+// writeTreeChildren writes the children of node, each line starting with prefix.
+func writeTreeChildren(out *strings.Builder, node *treeNode, prefix string) {
+	children := node.sortedChildren()
+	for i, child := range children {
+		connector, childPrefix := "├── ", "│   "
+		if i == len(children)-1 {
+			connector, childPrefix = "└── ", "    "
+		}
+		out.WriteString(prefix + connector + child.name)
+		if child.children != nil {
+			out.WriteString("/")
+		}
+		if child.allIgnored() {
+			out.WriteString(" [ignored]")
+		}
+		out.WriteString("\n")
+		if child.children != nil {
+			writeTreeChildren(out, child, prefix+childPrefix)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTree(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore":          "vendor/\n*.gen.go\n",
+		"main.go":             "",
+		"README.md":           "",
+		"cmd/tool/main.go":    "",
+		"cmd/tool/api.gen.go": "",
+		"internal/x.go":       "",
+		"vendor/dep/dep.go":   "",
+		"docs/guide.md":       "",
+	})
+
+	tests := []struct {
+		name string
+		all  bool
+		want string
+	}{
+		{
+			name: "matched files only",
+			want: `project
+├── cmd/
+│   └── tool/
+│       └── main.go
+├── internal/
+│   └── x.go
+└── main.go
+`,
+		},
+		{
+			name: "ignored entries marked with --all",
+			all:  true,
+			want: `project
+├── cmd/
+│   └── tool/
+│       ├── api.gen.go [ignored]
+│       └── main.go
+├── internal/
+│   └── x.go
+├── main.go
+└── vendor/ [ignored]
+    └── dep/ [ignored]
+        └── dep.go [ignored]
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidates, err := walkCandidates(context.Background(), dir, extractOptions{
+				extensions:    []string{".go"},
+				ignoreMatcher: rootMatcher(t, dir),
+				keepIgnored:   tt.all,
+				maxDepth:      -1,
+				logger:        quietLogger(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := renderTree("project", candidates); got != tt.want {
+				t.Errorf("renderTree() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTreeEmpty(t *testing.T) {
+	if got := renderTree("empty", nil); got != "empty\n" {
+		t.Errorf("renderTree() of no file = %q, want the root alone", got)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Build information, set at link time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+// (see the Makefile).
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
+// versionString describes the running build. Information missing from the
+// link-time variables is taken from the module and VCS data Go embeds in the
+// binary, when available, as with go install.
+func versionString() string {
+	v, c, d := version, commit, date
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && c == "none":
+				c = setting.Value
+			case setting.Key == "vcs.time" && d == "unknown":
+				d = setting.Value
+			}
+		}
+	}
+	return fmt.Sprintf("copilot %s (commit %s, built %s)", v, c, d)
+}
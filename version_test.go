@@ -0,0 +1,29 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestVersionString(t *testing.T) {
+	savedVersion, savedCommit, savedDate := version, commit, date
+	t.Cleanup(func() { version, commit, date = savedVersion, savedCommit, savedDate })
+
+	version, commit, date = "v1.2.3", "0123abc", "2024-05-01T10:00:00Z"
+	if got, want := versionString(), "copilot v1.2.3 (commit 0123abc, built 2024-05-01T10:00:00Z)"; got != want {
+		t.Errorf("versionString() = %q, want %q", got, want)
+	}
+}
+
+func TestVersionCommand(t *testing.T) {
+	versionRe := regexp.MustCompile(`^copilot \S+ \(commit \S+, built \S+\)\n$`)
+	for _, args := range [][]string{{"version"}, {"--version"}, {"-v"}} {
+		stdout, stderr, code := runCopilot(t, t.TempDir(), "", args...)
+		if code != 0 {
+			t.Errorf("%v: exit code = %d, stderr: %s", args, code, stderr)
+		}
+		if !versionRe.MatchString(stdout) {
+			t.Errorf("%v: stdout = %q, want it to match %s", args, stdout, versionRe)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// walkFiles walks the file tree rooted at root in lexical order, calling fn
+// for each file or directory, as filepath.Walk does. When followSymlinks is
+// set, symlinks are resolved: fn gets the info of their target and symlinked
+// directories are walked too, except those leading back to one of their
+// ancestors, which would loop forever. fn gets an error for these instead.
+func walkFiles(root string, followSymlinks bool, fn filepath.WalkFunc) error {
+	if !followSymlinks {
+		return filepath.Walk(root, fn)
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkFollowing(root, info, map[string]bool{}, fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walkFollowing walks path for walkFiles, following symlinks. ancestors holds
+// the real paths of the directories being walked, to detect cycles.
+func walkFollowing(path string, info os.FileInfo, ancestors map[string]bool, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	realPath, err := filepath.EvalSymlinks(path)
+	if err == nil && ancestors[realPath] {
+		err = fmt.Errorf("symlink cycle: %s is already being walked", realPath)
+	}
+	if err != nil {
+		return fn(path, info, err)
+	}
+	ancestors[realPath] = true
+	defer delete(ancestors, realPath)
+
+	entries, readErr := os.ReadDir(path)
+	if err := fn(path, info, readErr); err != nil || readErr != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entryPath := filepath.Join(path, entry.Name())
+		entryInfo, err := os.Stat(entryPath)
+		if err != nil {
+			if err := fn(entryPath, nil, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		if err := walkFollowing(entryPath, entryInfo, ancestors, fn); err != nil {
+			if !entryInfo.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
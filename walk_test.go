@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// symlinkTree creates, on top of a few regular files, a symlinked file, a
+// symlinked directory, a symlink cycle and a dangling symlink in dir.
+func symlinkTree(t *testing.T, dir string) {
+	t.Helper()
+	writeFiles(t, dir, map[string]string{
+		"real/a.txt":      "a\n",
+		"real/sub/b.txt":  "b\n",
+		"shared/c.txt":    "c\n",
+		"outside/target":  "target\n",
+		"top.txt":         "top\n",
+		"real/sub/d.data": "",
+	})
+	for link, target := range map[string]string{
+		"file-link.txt": filepath.Join("outside", "target"),
+		"dir-link":      "shared",
+		"real/sub/loop": "..",
+		"dangling.txt":  "missing.txt",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Skipf("cannot create symlinks: %v", err)
+		}
+	}
+}
+
+func TestWalkFiles(t *testing.T) {
+	dir := t.TempDir()
+	symlinkTree(t, dir)
+
+	tests := []struct {
+		name           string
+		followSymlinks bool
+		wantFiles      []string
+		wantErrors     []string
+	}{
+		{
+			name:      "not following",
+			wantFiles: []string{"dangling.txt", "dir-link", "file-link.txt", "outside/target", "real/a.txt", "real/sub/b.txt", "real/sub/d.data", "real/sub/loop", "shared/c.txt", "top.txt"},
+		},
+		{
+			name:           "following",
+			followSymlinks: true,
+			wantFiles:      []string{"dir-link/c.txt", "file-link.txt", "outside/target", "real/a.txt", "real/sub/b.txt", "real/sub/d.data", "shared/c.txt", "top.txt"},
+			wantErrors:     []string{"dangling.txt", "real/sub/loop"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var files, errs []string
+			err := walkFiles(dir, tt.followSymlinks, func(path string, info os.FileInfo, err error) error {
+				rel, relErr := filepath.Rel(dir, path)
+				if relErr != nil {
+					t.Fatal(relErr)
+				}
+				rel = filepath.ToSlash(rel)
+				switch {
+				case err != nil:
+					errs = append(errs, rel)
+					if info != nil && info.IsDir() {
+						return filepath.SkipDir
+					}
+				case !info.IsDir():
+					files = append(files, rel)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(files, tt.wantFiles) {
+				t.Errorf("walked files %q, want %q", files, tt.wantFiles)
+			}
+			if !slices.Equal(errs, tt.wantErrors) {
+				t.Errorf("got errors for %q, want %q", errs, tt.wantErrors)
+			}
+		})
+	}
+}
+
+func TestWalkFilesCycleError(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a/b.txt": ""})
+	if err := os.Symlink("..", filepath.Join(dir, "a", "up")); err != nil {
+		t.Skipf("cannot create symlinks: %v", err)
+	}
+	var cycleErr error
+	err := walkFiles(dir, true, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			cycleErr = err
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cycleErr == nil || !strings.Contains(cycleErr.Error(), "symlink cycle") {
+		t.Errorf("walk error = %v, want a symlink cycle", cycleErr)
+	}
+}
+
+func TestExtractFollowSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	symlinkTree(t, dir)
+	tests := []struct {
+		followSymlinks bool
+		want           []string
+	}{
+		{false, []string{"dangling.txt", "file-link.txt", "real/a.txt", "real/sub/b.txt", "shared/c.txt", "top.txt"}},
+		// The dangling symlink and the cycle are skipped with a warning
+		{true, []string{"dir-link/c.txt", "file-link.txt", "real/a.txt", "real/sub/b.txt", "shared/c.txt", "top.txt"}},
+	}
+	for _, tt := range tests {
+		got := extractedPaths(t, dir, extractOptions{extensions: []string{".txt"}, followSymlinks: tt.followSymlinks, maxDepth: -1})
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("followSymlinks=%v: extracted %q, want %q", tt.followSymlinks, got, tt.want)
+		}
+	}
+}
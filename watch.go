@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io"
+	"maps"
+	"time"
+)
+
+// fileState is what watchExtract compares to detect that a file changed.
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// watchExtract calls extract, then calls it again each time the files
+// selected by opts change, until ctx is canceled and ctx.Err() is returned.
+// Changes are
+// detected by polling the size and modification time of the files every
+// interval, so ignored files, such as editor temporary files, never trigger
+// a run. extract only runs again once the files have stopped changing for a
+// whole interval, so that a burst of changes, such as a checkout, triggers a
+// single run. The file at skipAbs, usually the output file, is not watched so
+// that writing it does not trigger a run either.
+func watchExtract(ctx context.Context, scanDirAbs string, opts extractOptions, interval time.Duration, skipAbs string, extract func() error) error {
+	run := func() {
+		if err := extract(); err != nil && ctx.Err() == nil {
+			opts.logger.errorf("Error %v", err)
+		}
+	}
+	// wait sleeps for interval, and reports false once ctx is canceled
+	wait := func() bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(interval):
+			return true
+		}
+	}
+
+	last := snapshotFiles(ctx, scanDirAbs, opts, skipAbs)
+	run()
+	opts.logger.infof("Watching %s for changes. Press Ctrl-C to stop.", scanDirAbs)
+	for wait() {
+		current := snapshotFiles(ctx, scanDirAbs, opts, skipAbs)
+		if maps.Equal(current, last) {
+			continue
+		}
+		// Wait for the files to settle down
+		for {
+			if !wait() {
+				return ctx.Err()
+			}
+			settled := snapshotFiles(ctx, scanDirAbs, opts, skipAbs)
+			if maps.Equal(settled, current) {
+				break
+			}
+			current = settled
+		}
+		opts.logger.infof("Change detected, extracting again.")
+		last = current
+		run()
+	}
+	return ctx.Err()
+}
+
+// snapshotFiles returns the state of the files selected by opts, keyed by
+// absolute path, leaving out skipAbs. Errors are reported through
+// opts.logger and yield an empty snapshot.
+func snapshotFiles(ctx context.Context, scanDirAbs string, opts extractOptions, skipAbs string) map[string]fileState {
+	// Warnings and progress messages of the walk would be repeated at every
+	// poll; extract reports them when it runs.
+	pollOpts := opts
+	pollOpts.logger = &logger{out: io.Discard}
+	candidates, err := selectCandidates(ctx, scanDirAbs, pollOpts)
+	if err != nil && ctx.Err() == nil {
+		opts.logger.errorf("Error scanning %s: %v", scanDirAbs, err)
+	}
+	states := make(map[string]fileState, len(candidates))
+	for _, candidate := range candidates {
+		if candidate.absPath != skipAbs {
+			states[candidate.absPath] = fileState{size: candidate.size, modTime: candidate.modTime}
+		}
+	}
+	return states
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchExtract(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore": "*.swp\n",
+		"main.go":    "package main\n",
+		"out.txt":    "",
+	})
+	const interval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runs := make(chan struct{}, 10)
+	done := make(chan error, 1)
+	opts := extractOptions{extensions: []string{".go", ".txt", ".swp"}, ignoreMatcher: rootMatcher(t, dir), maxDepth: -1, logger: quietLogger()}
+	go func() {
+		done <- watchExtract(ctx, dir, opts, interval, filepath.Join(dir, "out.txt"), func() error {
+			runs <- struct{}{}
+			return nil
+		})
+	}()
+
+	expectRun := func(what string) {
+		t.Helper()
+		select {
+		case <-runs:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no extraction after %s", what)
+		}
+	}
+	expectNoRun := func(what string) {
+		t.Helper()
+		select {
+		case <-runs:
+			t.Fatalf("extraction after %s", what)
+		case <-time.After(20 * interval):
+		}
+	}
+
+	expectRun("starting")
+	expectNoRun("no change")
+
+	// Ignored files and the output file do not trigger a run
+	writeFiles(t, dir, map[string]string{".main.go.swp": "swap", "out.txt": "output"})
+	expectNoRun("changing ignored files")
+
+	// Sizes change along with the content, whatever the mtime granularity
+	writeFiles(t, dir, map[string]string{"main.go": "package main\n\nfunc main() {}\n"})
+	expectRun("modifying a file")
+
+	writeFiles(t, dir, map[string]string{"new.go": "package main\n"})
+	expectRun("creating a file")
+
+	if err := os.Remove(filepath.Join(dir, "new.go")); err != nil {
+		t.Fatal(err)
+	}
+	expectRun("removing a file")
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("watchExtract() = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchExtract() did not return once canceled")
+	}
+}
+
+func TestWatchExtractDebounces(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.go": ""})
+	const interval = 100 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runs := make(chan struct{}, 10)
+	go watchExtract(ctx, dir, extractOptions{extensions: []string{".go"}, maxDepth: -1, logger: quietLogger()}, interval, "", func() error {
+		runs <- struct{}{}
+		return nil
+	})
+	<-runs
+
+	// A burst of changes faster than the interval triggers a single run
+	for i := range 8 {
+		writeFiles(t, dir, map[string]string{"a.go": string(make([]byte, i+1))})
+		time.Sleep(interval / 10)
+	}
+	select {
+	case <-runs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no extraction after the burst of changes")
+	}
+	select {
+	case <-runs:
+		t.Error("several extractions for a single burst of changes")
+	case <-time.After(5 * interval):
+	}
+}